@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func newAuditCmd(a *app) *cobra.Command {
+	var dbPath, since, until string
+	var filter state.AuditFilter
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "audit",
+		Short: "Print the processing audit log",
+		Long: "Print the audit log that runs with audit_log keep in the state database: every S3\n" +
+			"object downloaded, its version, ETag and SHA-256, when and by which run, and the\n" +
+			"events and partition dirs produced from it. --json prints one JSON object per line.\n" +
+			"The state database is taken from --db, or from state_db in --config.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			if filter.Since, filter.Until, err = appConfig.ParseTimeRange(since, until); err != nil {
+				return err
+			}
+			if rest, ok := strings.CutPrefix(filter.Prefix, "s3://"); ok {
+				filter.Bucket, filter.Prefix, _ = strings.Cut(rest, "/")
+			}
+
+			stateDB, err := a.openExistingStateDB(dbPath)
+			if err != nil {
+				return err
+			}
+			defer stateDB.Close()
+
+			records, err := stateDB.ListAudit(filter)
+			if err != nil {
+				return fmt.Errorf("read audit log: %w", err)
+			}
+			return printAudit(cmd.OutOrStdout(), records, asJSON)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db", "", "Path to the state database (overrides --config)")
+	cmd.Flags().Int64Var(&filter.RunID, "run", 0, "Only entries from this run ID (see stats --runs)")
+	cmd.Flags().StringVar(&filter.Prefix, "key", "", "Only objects under this key prefix, or s3://bucket/prefix")
+	cmd.Flags().StringVar(&since, "since", "", "Only objects finished at or after this time, YYYY-MM-DD or RFC3339")
+	cmd.Flags().StringVar(&until, "until", "", "Only objects finished before this time (a date includes that day)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON lines instead of a table")
+
+	return cmd
+}
+
+func printAudit(w io.Writer, records []state.AuditRecord, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tFINISHED\tSTATUS\tBUCKET\tKEY\tSIZE\tSHA256\tRECORDS\tWRITTEN\tDUP\tFILTERED\tINVALID\tOUTPUTS\tERROR")
+	for _, r := range records {
+		sum := r.SHA256
+		if len(sum) > 16 {
+			sum = sum[:16]
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%d\t%d\t%d\t%d\t%d\t%d\t%s\n",
+			r.RunID, r.FinishedAt.Format(time.RFC3339), r.Status, r.Bucket, r.Key, r.Size, sum,
+			r.Records, r.Written, r.Duplicate, r.Filtered, r.Invalid, len(r.Outputs), r.Error)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func newBackfillCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backfill",
+		Short: "Process a historical range in restartable day or week units",
+		Long:  "Split a time range into day or week units per account/region, track each unit in\nthe state database and process them with bounded parallelism. Completed units are\nskipped when the backfill is run again, and --retry-failed runs only failed ones.",
+	}
+
+	cmd.AddCommand(
+		newBackfillRunCmd(a),
+		newBackfillStatusCmd(a),
+		newBackfillResetCmd(a),
+	)
+	return cmd
+}
+
+func newBackfillRunCmd(a *app) *cobra.Command {
+	var start, end, unit string
+	var opts processor.BackfillOptions
+	var live bool
+	var liveOpts processor.LiveOptions
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Plan and process backfill units",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch unit {
+			case "day":
+				opts.UnitDays = 1
+			case "week":
+				opts.UnitDays = 7
+			default:
+				return fmt.Errorf("invalid --unit %q (want day or week)", unit)
+			}
+			if !live {
+				return a.runBackfill(cmd.Context(), start, end, opts, nil)
+			}
+			return a.runBackfill(cmd.Context(), start, end, opts, &liveOpts)
+		},
+	}
+
+	cmd.Flags().StringVar(&start, "start", "", "Start of the range (YYYY-MM-DD or RFC3339, default start_time)")
+	cmd.Flags().StringVar(&end, "end", "", "End of the range, a date includes the whole day (default end_time, or now)")
+	cmd.Flags().StringVar(&unit, "unit", "day", "Unit size: day or week")
+	cmd.Flags().IntVar(&opts.Parallel, "parallel", 4, "Units processed at once")
+	cmd.Flags().BoolVar(&opts.RetryFailed, "retry-failed", false, "Only run units that failed before")
+	cmd.Flags().BoolVar(&live, "live", false, "Also tail new files from the end of the range until interrupted")
+	cmd.Flags().DurationVar(&liveOpts.Interval, "live-interval", time.Minute, "Wait between live passes")
+
+	return cmd
+}
+
+// runBackfill processes the range, tailing new files alongside it until ctx
+// is done when live is set
+func (a *app) runBackfill(ctx context.Context, start, end string, opts processor.BackfillOptions, live *processor.LiveOptions) error {
+	appCfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	if start != "" {
+		appCfg.StartTime = start
+	}
+	if end != "" {
+		appCfg.EndTime = end
+	}
+	opts.Start, opts.End, err = appCfg.TimeRange()
+	if err != nil {
+		return err
+	}
+	if opts.Start.IsZero() {
+		return fmt.Errorf("--start or start_time is required")
+	}
+	if opts.End.IsZero() {
+		opts.End = time.Now().UTC()
+	}
+
+	proc, err := a.newProcessor(ctx, appCfg)
+	if err != nil {
+		return err
+	}
+
+	progressInterval := time.Duration(appCfg.ProgressInterval) * time.Second
+	flushInterval := time.Duration(appCfg.JSONLFlushInterval) * time.Second
+	bloomSaveInterval := time.Duration(appCfg.StateSaveInterval) * time.Second
+	var runErr error
+	if live != nil {
+		runErr = proc.BackfillLive(ctx, progressInterval, flushInterval, bloomSaveInterval, opts, *live)
+	} else {
+		runErr = proc.Backfill(ctx, progressInterval, flushInterval, bloomSaveInterval, opts)
+	}
+	proc.Stats().PrintProgress(a.logger)
+
+	switch {
+	case runErr == context.Canceled && live != nil:
+		a.logger.Info("received interrupt signal, live tail stopped, unfinished units run again next time")
+		return nil
+	case runErr == context.Canceled:
+		a.logger.Info("received interrupt signal, unfinished units run again next time")
+		return nil
+	case runErr != nil:
+		return fmt.Errorf("backfill failed: %w", runErr)
+	}
+	a.logger.Info("backfill complete")
+	return nil
+}
+
+func newBackfillStatusCmd(a *app) *cobra.Command {
+	var dbPath string
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show backfill progress per account/region and any failed units",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stateDB, err := a.openExistingStateDB(dbPath)
+			if err != nil {
+				return err
+			}
+			defer stateDB.Close()
+
+			units, err := stateDB.ListBackfillUnits()
+			if err != nil {
+				return err
+			}
+			return printBackfillStatus(cmd.OutOrStdout(), units, asJSON)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db", "", "Path to the state database (overrides --config)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print every unit as JSON instead of a table")
+
+	return cmd
+}
+
+func newBackfillResetCmd(a *app) *cobra.Command {
+	var dbPath string
+
+	cmd := &cobra.Command{
+		Use:   "reset",
+		Short: "Forget every planned backfill unit",
+		Long:  "Delete the backfill plan so the next backfill run replans from scratch, for example\nwith a different --unit. Output already written and the run checkpoints are kept.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stateDB, err := a.openExistingStateDB(dbPath)
+			if err != nil {
+				return err
+			}
+			defer stateDB.Close()
+
+			return stateDB.ResetBackfill()
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db", "", "Path to the state database (overrides --config)")
+
+	return cmd
+}
+
+// backfillTotals sums the units of one bucket/account/region
+type backfillTotals struct {
+	bucket, account, region string
+	byStatus                map[string]int
+	units                   int
+	files, events           int64
+	first, last             time.Time
+}
+
+func printBackfillStatus(w io.Writer, units []state.BackfillUnit, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(units)
+	}
+
+	totals := make(map[string]*backfillTotals)
+	var failed []state.BackfillUnit
+	for _, u := range units {
+		key := u.Bucket + "\x00" + u.AccountID + "\x00" + u.Region
+		t, ok := totals[key]
+		if !ok {
+			t = &backfillTotals{bucket: u.Bucket, account: u.AccountID, region: u.Region, byStatus: make(map[string]int), first: u.Start}
+			totals[key] = t
+		}
+		t.units++
+		t.byStatus[u.Status]++
+		t.files += u.Files
+		t.events += u.Events
+		if u.Start.Before(t.first) {
+			t.first = u.Start
+		}
+		if u.End.After(t.last) {
+			t.last = u.End
+		}
+		if u.Status == state.UnitFailed {
+			failed = append(failed, u)
+		}
+	}
+
+	rows := make([]*backfillTotals, 0, len(totals))
+	for _, t := range totals {
+		rows = append(rows, t)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].bucket != rows[j].bucket {
+			return rows[i].bucket < rows[j].bucket
+		}
+		if rows[i].account != rows[j].account {
+			return rows[i].account < rows[j].account
+		}
+		return rows[i].region < rows[j].region
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUCKET\tACCOUNT\tREGION\tRANGE\tUNITS\tDONE\tFAILED\tPENDING\tRUNNING\tFILES\tEVENTS")
+	for _, t := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s to %s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n",
+			t.bucket, t.account, t.region,
+			t.first.Format(time.DateOnly), t.last.Format(time.DateOnly),
+			t.units, t.byStatus[state.UnitDone], t.byStatus[state.UnitFailed],
+			t.byStatus[state.UnitPending], t.byStatus[state.UnitRunning],
+			t.files, t.events)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FAILED UNIT\tSTART\tEND\tATTEMPTS\tERROR")
+	for _, u := range failed {
+		fmt.Fprintf(tw, "%s/%s/%s\t%s\t%s\t%d\t%s\n",
+			u.Bucket, u.AccountID, u.Region,
+			u.Start.Format(time.DateOnly), u.End.Format(time.DateOnly),
+			u.Attempts, u.Error)
+	}
+	return tw.Flush()
+}
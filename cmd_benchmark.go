@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+func newBenchmarkCmd(a *app) *cobra.Command {
+	var opts processor.BenchmarkOptions
+	var asJSON bool
+
+	cmd := &cobra.Command{
+		Use:   "benchmark",
+		Short: "Measure download throughput and recommend worker and queue settings",
+		Long: "Download a sample of recent log files from one trail at several concurrency levels,\n" +
+			"report throughput, latency and errors for each, time decoding them on one core, and\n" +
+			"recommend download_workers, process_workers and queue sizes for this host and bucket.\n" +
+			"Nothing is written and checkpoints are left alone.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			proc, err := a.newProcessor(cmd.Context(), appCfg)
+			if err != nil {
+				return err
+			}
+
+			report, err := proc.Benchmark(cmd.Context(), opts)
+			if err != nil {
+				return fmt.Errorf("benchmark failed: %w", err)
+			}
+			return printBenchmark(cmd.OutOrStdout(), report, asJSON)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.Trail, "trail", "", "Trail to sample (default: the first enabled trail)")
+	cmd.Flags().IntVar(&opts.Sample, "sample", 200, "Log files downloaded at each concurrency level")
+	cmd.Flags().IntSliceVar(&opts.Concurrency, "concurrency", []int{4, 8, 16, 32, 64}, "Download concurrency levels to try")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON instead of a table")
+
+	return cmd
+}
+
+func printBenchmark(w io.Writer, r *processor.BenchmarkReport, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+
+	fmt.Fprintf(w, "trail %s (s3://%s): %d log files, %.1f KB each, %.0f events each, %.2f ms to decode on one of %d cores\n\n",
+		r.Trail, r.Bucket, r.Objects, float64(r.AvgObjectBytes)/1024, r.EventsPerObject, r.DecodeMs, r.CPUs)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CONCURRENCY\tFILES/S\tMB/S\tP50 MS\tP95 MS\tP99 MS\tERRORS")
+	for _, l := range r.Levels {
+		fmt.Fprintf(tw, "%d\t%.1f\t%.2f\t%.0f\t%.0f\t%.0f\t%d\n",
+			l.Concurrency, l.ObjectsPerSec, l.MBPerSec, l.LatencyP50Ms, l.LatencyP95Ms, l.LatencyP99Ms, l.Errors)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	for _, note := range r.Notes {
+		fmt.Fprintf(w, "note: %s\n", note)
+	}
+	rec := r.Recommended
+	fmt.Fprintf(w, "recommended:\n  \"download_workers\": %d,\n  \"process_workers\": %d,\n  \"download_queue_size\": %d,\n  \"process_queue_size\": %d\n",
+		rec.DownloadWorkers, rec.ProcessWorkers, rec.DownloadQueueSize, rec.ProcessQueueSize)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+// missing files logged individually; the rest are only in --output
+const maxLoggedMissing = 100
+
+func newCheckCompletenessCmd(a *app) *cobra.Command {
+	var start, end, output string
+	var fetch bool
+
+	cmd := &cobra.Command{
+		Use:   "check-completeness",
+		Short: "Check processed log files against CloudTrail digest inventories",
+		Long: "Read the digest files covering a time range and confirm every log file they name was\n" +
+			"processed, reporting files lost to errors and files the S3 listing skipped. Skipped\n" +
+			"files can only be told apart with record_objects enabled. Exits non-zero when files\n" +
+			"are missing, unless --fetch processes them successfully.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if start != "" {
+				appCfg.StartTime = start
+			}
+			if end != "" {
+				appCfg.EndTime = end
+			}
+			var opts processor.CompletenessOptions
+			opts.Start, opts.End, err = appCfg.TimeRange()
+			if err != nil {
+				return err
+			}
+			if opts.Start.IsZero() {
+				return fmt.Errorf("--start or start_time is required")
+			}
+			if opts.End.IsZero() {
+				opts.End = time.Now().UTC()
+			}
+
+			proc, err := a.newProcessor(ctx, appCfg)
+			if err != nil {
+				return err
+			}
+
+			report, err := proc.CheckCompleteness(ctx, opts)
+			if report == nil || (err != nil && err != context.Canceled) {
+				return fmt.Errorf("completeness check failed: %w", err)
+			}
+
+			a.logger.Info("completeness check complete",
+				slog.Int("digests", report.Digests),
+				slog.Int("digest_errors", report.DigestErrors),
+				slog.Int("log_files", report.LogFiles),
+				slog.Int(processor.FileProcessed, report.ByStatus[processor.FileProcessed]),
+				slog.Int(processor.FileFailed, report.ByStatus[processor.FileFailed]),
+				slog.Int(processor.FileMissed, report.ByStatus[processor.FileMissed]),
+				slog.Int(processor.FileUnconfirmed, report.ByStatus[processor.FileUnconfirmed]),
+				slog.Int(processor.FilePending, report.ByStatus[processor.FilePending]))
+			for i, f := range report.Missing {
+				if i == maxLoggedMissing {
+					a.logger.Warn("more missing log files not logged", slog.Int("count", len(report.Missing)-i))
+					break
+				}
+				a.logger.Warn("missing log file",
+					slog.String("bucket", f.Bucket),
+					slog.String("key", f.Key),
+					slog.String("status", f.Status),
+					slog.String("error", f.Error))
+			}
+
+			if output != "" {
+				if err := writeJSONFile(output, report); err != nil {
+					return err
+				}
+			}
+			if err == context.Canceled || len(report.Missing) == 0 {
+				return nil
+			}
+
+			if !fetch {
+				return fmt.Errorf("%d log files missing", len(report.Missing))
+			}
+			a.logger.Info("fetching missing log files", slog.Int("count", len(report.Missing)))
+			fetchErr := proc.FetchObjects(ctx,
+				time.Duration(appCfg.ProgressInterval)*time.Second,
+				time.Duration(appCfg.JSONLFlushInterval)*time.Second,
+				time.Duration(appCfg.StateSaveInterval)*time.Second,
+				report.Missing)
+			proc.Stats().PrintProgress(a.logger)
+			if fetchErr != nil && fetchErr != context.Canceled {
+				return fmt.Errorf("fetch failed: %w", fetchErr)
+			}
+			if errs := proc.Stats().Errors.Load(); errs > 0 {
+				return fmt.Errorf("%d errors while fetching missing log files", errs)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&start, "start", "", "Start of the range (YYYY-MM-DD or RFC3339, default start_time)")
+	cmd.Flags().StringVar(&end, "end", "", "End of the range, a date includes the whole day (default end_time, or now)")
+	cmd.Flags().StringVar(&output, "output", "", "Also write the report, with every missing file, to this JSON file")
+	cmd.Flags().BoolVar(&fetch, "fetch", false, "Download and process the missing log files")
+
+	return cmd
+}
+
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode %s: %w", path, err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/convert"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+func newConvertCmd(a *app) *cobra.Command {
+	var opts convert.Options
+	var formatName string
+
+	cmd := &cobra.Command{
+		Use:   "convert",
+		Short: "Convert an events directory to another format",
+		Long:  "Convert an events directory to another format, keeping the same partition layout.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// encrypted output needs the config's encryption settings, and
+			// csv output its columns
+			if a.configPath != "" {
+				appCfg, err := a.loadConfig()
+				if err != nil {
+					return err
+				}
+				opts.CSVColumns = appCfg.CSVColumns
+				if opts.Cipher, err = a.outputCipher(appCfg); err != nil {
+					return err
+				}
+			}
+			format, err := writer.ParseFormat(formatName)
+			if err != nil {
+				return err
+			}
+			opts.Format = format
+
+			res, err := convert.Run(opts, a.logger)
+			if err != nil {
+				return fmt.Errorf("conversion failed: %w", err)
+			}
+
+			a.logger.Info("conversion complete",
+				slog.Int("files_converted", res.FilesConverted),
+				slog.Int("files_skipped", res.FilesSkipped),
+				slog.Int("events", res.Events))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.InputDir, "input", "", "Events directory to convert")
+	cmd.Flags().StringVar(&opts.OutputDir, "output", "", "Destination directory (default: convert in place, replacing each source file)")
+	cmd.Flags().StringVar(&formatName, "format", "", "Target format: jsonl, jsonl.gz, jsonl.zst, parquet or csv")
+	cmd.Flags().BoolVar(&opts.RemoveSource, "remove-source", false, "Delete each source file once it has been converted (always done in place)")
+	_ = cmd.MarkFlagRequired("input")
+	_ = cmd.MarkFlagRequired("format")
+
+	return cmd
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/deceptiq/gocloudtrail/internal/bloom"
+	"github.com/deceptiq/gocloudtrail/internal/dedupsvc"
+)
+
+func newDedupServerCmd(a *app) *cobra.Command {
+	var listen, store, tlsCert, tlsKey string
+
+	cmd := &cobra.Command{
+		Use:   "dedup-server",
+		Short: "Serve a dedup store shared by several collectors over gRPC",
+		Long: "Host the event ID dedup store for collectors whose dedup_service.addr points here, so\n" +
+			"an event collected by one isn't written again by another. The store is the config's\n" +
+			"bloom filter (bloom_file, bloom_expected_items, bloom_false_positive), or with\n" +
+			"--store exact a set without false positives kept in bloom_file.exact. Both honour\n" +
+			"dedup_retention_days and are saved every state_save_interval and on shutdown.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			retention := time.Duration(appCfg.DedupRetentionDays) * 24 * time.Hour
+
+			var st dedupsvc.Store
+			switch store {
+			case "bloom":
+				st, err = bloom.Load(appCfg.BloomFile, uint(appCfg.BloomExpectedItems), appCfg.BloomFalsePositive, retention, a.logger)
+			case "exact":
+				st, err = dedupsvc.LoadExact(appCfg.BloomFile+".exact", retention, a.logger)
+			default:
+				return fmt.Errorf("invalid --store %q (want bloom or exact)", store)
+			}
+			if err != nil {
+				return fmt.Errorf("load dedup store: %w", err)
+			}
+
+			srv := dedupsvc.NewServer(st, appCfg.DedupService.Token, a.logger)
+			opts := []grpc.ServerOption{grpc.UnaryInterceptor(srv.Interceptor)}
+			if tlsCert != "" || tlsKey != "" {
+				creds, err := credentials.NewServerTLSFromFile(tlsCert, tlsKey)
+				if err != nil {
+					return fmt.Errorf("load TLS certificate: %w", err)
+				}
+				opts = append(opts, grpc.Creds(creds))
+			}
+			g := grpc.NewServer(opts...)
+			srv.Register(g)
+
+			ln, err := net.Listen("tcp", listen)
+			if err != nil {
+				return fmt.Errorf("dedup server listen: %w", err)
+			}
+			serveErr := make(chan error, 1)
+			go func() { serveErr <- g.Serve(ln) }()
+			a.logger.Info("dedup server listening",
+				slog.String("addr", ln.Addr().String()),
+				slog.String("store", store),
+				slog.Bool("tls", tlsCert != ""))
+
+			ctx := cmd.Context()
+			ticker := time.NewTicker(time.Duration(max(appCfg.StateSaveInterval, 1)) * time.Second)
+			defer ticker.Stop()
+			for running := true; running; {
+				select {
+				case <-ctx.Done():
+					running = false
+				case err = <-serveErr:
+					running = false
+				case <-ticker.C:
+					if err := st.Save(); err != nil {
+						a.logger.Error("failed to save dedup store", slog.String("error", err.Error()))
+					}
+					tested, seen, added := srv.Counts()
+					a.logger.Info("dedup server progress",
+						slog.Int64("tested", tested),
+						slog.Int64("seen", seen),
+						slog.Int64("added", added))
+				}
+			}
+			g.GracefulStop()
+
+			if saveErr := st.Save(); saveErr != nil {
+				return fmt.Errorf("save dedup store: %w", saveErr)
+			}
+			if err != nil {
+				return fmt.Errorf("dedup server: %w", err)
+			}
+			a.logger.Info("dedup server stopped, store saved")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", ":7443", "Address to serve on")
+	cmd.Flags().StringVar(&store, "store", "bloom", "Store: bloom or exact")
+	cmd.Flags().StringVar(&tlsCert, "tls-cert", "", "TLS certificate file (with --tls-key, serve TLS)")
+	cmd.Flags().StringVar(&tlsKey, "tls-key", "", "TLS key file")
+
+	return cmd
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/dedupe"
+)
+
+func newDedupeCmd(a *app) *cobra.Command {
+	var opts dedupe.Options
+
+	cmd := &cobra.Command{
+		Use:   "dedupe",
+		Short: "Remove duplicate events from an events directory",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// encrypted output needs the config's encryption settings
+			if a.configPath != "" {
+				appCfg, err := a.loadConfig()
+				if err != nil {
+					return err
+				}
+				if opts.Cipher, err = a.outputCipher(appCfg); err != nil {
+					return err
+				}
+			}
+			results, err := dedupe.Run(opts, a.logger)
+			if err != nil {
+				return fmt.Errorf("dedupe failed: %w", err)
+			}
+
+			var events, duplicates, affected int
+			for _, res := range results {
+				events += res.Events
+				duplicates += res.Duplicates
+				if res.Duplicates > 0 {
+					affected++
+				}
+			}
+
+			a.logger.Info("dedupe complete",
+				slog.Int("partitions", len(results)),
+				slog.Int("partitions_with_duplicates", affected),
+				slog.Int("events", events),
+				slog.Int("duplicates", duplicates),
+				slog.Bool("dry_run", opts.DryRun))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.EventsDir, "dir", "", "Events directory to scan")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Report duplicates without rewriting any files")
+	_ = cmd.MarkFlagRequired("dir")
+
+	return cmd
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+)
+
+func newGenerateConfigCmd(a *app) *cobra.Command {
+	var opts appConfig.GenerateOptions
+
+	cmd := &cobra.Command{
+		Use:   "generate-config <output-path>",
+		Short: "Generate config.json from the CloudTrail API",
+		Long: "Generate config.json from the CloudTrail API.\n" +
+			"With --bucket, the given buckets are scanned for AWSLogs/ instead, which needs no CloudTrail permissions,\n" +
+			"and --all-buckets scans every bucket in the account.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := opts.Tags.Validate(); err != nil {
+				return err
+			}
+			cfg, err := a.awsConfig(cmd.Context(), nil)
+			if err != nil {
+				return err
+			}
+
+			logger := a.logger
+			if opts.Interactive {
+				// keep the terminal for prompts
+				opts.Input = cmd.InOrStdin()
+				opts.Output = cmd.OutOrStdout()
+				logger = slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+			}
+			return appConfig.Generate(cmd.Context(), cfg, args[0], opts, logger)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.Interactive, "interactive", false, "Walk through trail selection, time range, worker sizing, filters and output settings")
+	cmd.Flags().BoolVar(&opts.AllBuckets, "all-buckets", false, "Scan every bucket in the account for CloudTrail logs instead of calling DescribeTrails")
+	cmd.Flags().StringSliceVar(&opts.Buckets, "bucket", nil, "Scan this bucket for CloudTrail logs instead of calling DescribeTrails (repeatable)")
+
+	cmd.Flags().StringSliceVar(&opts.Tags.Include, "tag", nil, "Only include trails with this tag, as key=value (* wildcards) or key (repeatable, all must match)")
+	cmd.Flags().StringSliceVar(&opts.Tags.Exclude, "exclude-tag", nil, "Leave out trails with this tag, as key=value or key (repeatable)")
+
+	cmd.MarkFlagsMutuallyExclusive("all-buckets", "bucket")
+	for _, tagFlag := range []string{"tag", "exclude-tag"} {
+		// buckets scanned directly have no trail to read tags from
+		cmd.MarkFlagsMutuallyExclusive("all-buckets", tagFlag)
+		cmd.MarkFlagsMutuallyExclusive("bucket", tagFlag)
+	}
+
+	return cmd
+}
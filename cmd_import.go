@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/lake"
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+func newImportCmd(a *app) *cobra.Command {
+	var formatName string
+	var opts processor.ImportOptions
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Process events from CloudTrail Lake or Security Lake Parquet exports",
+		Long: "Read Parquet files exported from CloudTrail Lake, Security Lake OCSF API activity or\n" +
+			"the parquet output format, convert each row back into a CloudTrail record and run it\n" +
+			"through the same dedup, filters, output, detections and sinks as a normal run.\n" +
+			"Inputs are local files or directories, or s3://bucket/prefix.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			format, err := lake.ParseFormat(formatName)
+			if err != nil {
+				return err
+			}
+			opts.Format = format
+
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			proc, err := a.newProcessor(ctx, appCfg)
+			if err != nil {
+				return err
+			}
+
+			runErr := proc.Import(ctx,
+				time.Duration(appCfg.ProgressInterval)*time.Second,
+				time.Duration(appCfg.JSONLFlushInterval)*time.Second,
+				time.Duration(appCfg.StateSaveInterval)*time.Second,
+				opts)
+			proc.Stats().PrintProgress(a.logger)
+			if runErr != nil && runErr != context.Canceled {
+				return fmt.Errorf("import failed: %w", runErr)
+			}
+			a.logger.Info("import complete")
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&opts.Inputs, "input", nil, "Parquet file, directory or s3://bucket/prefix (repeatable)")
+	cmd.Flags().StringVar(&formatName, "format", "auto", "Input layout: auto, cloudtrail (Lake), ocsf (Security Lake) or raw (parquet output)")
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/prune"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func newPruneCmd(a *app) *cobra.Command {
+	var days int
+	var dir, archiveBucket, archivePrefix string
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Delete or archive output older than the retention period",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			// every dir output is written to is pruned unless one is given
+			dirs := appCfg.OutputDirs()
+			if dir != "" {
+				dirs = []string{dir}
+			}
+			if days > 0 {
+				appCfg.RetentionDays = days
+			}
+			if archiveBucket != "" {
+				appCfg.ArchiveBucket = archiveBucket
+			}
+			if archivePrefix != "" {
+				appCfg.ArchivePrefix = archivePrefix
+			}
+
+			if appCfg.RetentionDays <= 0 {
+				return fmt.Errorf("no retention period configured, set retention_days or --days")
+			}
+
+			opts := prune.Options{
+				Cutoff:        time.Now().UTC().AddDate(0, 0, -appCfg.RetentionDays),
+				ArchiveBucket: appCfg.ArchiveBucket,
+				ArchivePrefix: appCfg.ArchivePrefix,
+				DryRun:        dryRun,
+			}
+
+			if opts.ArchiveBucket != "" {
+				cfg, err := a.awsConfig(ctx, appCfg)
+				if err != nil {
+					return err
+				}
+				opts.S3Client = s3.NewFromConfig(cfg)
+				if !dryRun {
+					stateDB, err := state.Open(appCfg.StateDB, a.logger)
+					if err != nil {
+						return fmt.Errorf("open state database: %w", err)
+					}
+					defer stateDB.Close()
+					opts.StateDB = stateDB
+				}
+			}
+
+			var res prune.Result
+			for _, dir := range dirs {
+				// a configured dir nothing has been written to yet
+				if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) && len(dirs) > 1 {
+					continue
+				}
+				opts.EventsDir = dir
+				r, err := prune.Run(ctx, opts, a.logger)
+				res.Partitions += r.Partitions
+				res.FilesRemoved += r.FilesRemoved
+				res.FilesArchived += r.FilesArchived
+				res.BytesRemoved += r.BytesRemoved
+				if err != nil {
+					return fmt.Errorf("prune %s failed: %w", dir, err)
+				}
+			}
+
+			a.logger.Info("prune complete",
+				slog.Time("cutoff", opts.Cutoff),
+				slog.Int("partitions", res.Partitions),
+				slog.Int("files_removed", res.FilesRemoved),
+				slog.Int("files_archived", res.FilesArchived),
+				slog.Int64("bytes_removed", res.BytesRemoved),
+				slog.Bool("dry_run", dryRun))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&dir, "dir", "", "Prune only this events directory (default: events_dir and every trail, log group and category dir)")
+	cmd.Flags().IntVar(&days, "days", 0, "Retention in days (overrides retention_days)")
+	cmd.Flags().StringVar(&archiveBucket, "archive-bucket", "", "Upload partitions to this S3 bucket before deleting (overrides archive_bucket)")
+	cmd.Flags().StringVar(&archivePrefix, "archive-prefix", "", "Key prefix for archived files (overrides archive_prefix)")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Report what would be pruned without deleting anything")
+
+	return cmd
+}
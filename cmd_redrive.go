@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+)
+
+func newRedriveCmd(a *app) *cobra.Command {
+	var sinkNames []string
+	var list bool
+
+	cmd := &cobra.Command{
+		Use:   "redrive",
+		Short: "Re-send batches saved in the dead-letter dir to their sinks",
+		Long:  "Send the batches each sink failed to take, saved under dead_letter_dir, back to that\nsink oldest first, deleting each once delivered. A sink's redrive stops at its first\nbatch that still fails, which stays saved for next time.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if appCfg.DeadLetterDir == "" {
+				return fmt.Errorf("no dead_letter_dir configured")
+			}
+
+			cipher, err := a.outputCipher(appCfg)
+			if err != nil {
+				return err
+			}
+			sinks, err := a.newSinks(ctx, appCfg, cipher, sinkNames)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				for _, s := range sinks {
+					if err := s.Close(); err != nil {
+						a.logger.Error("failed to close sink", slog.String("sink", s.Name), slog.String("error", err.Error()))
+					}
+				}
+			}()
+
+			var errs []error
+			for _, s := range sinks {
+				if list {
+					files, err := s.DeadLetters()
+					if err != nil {
+						return err
+					}
+					fmt.Printf("%s\t%d batches\n", s.Name, len(files))
+					for _, f := range files {
+						fmt.Printf("  %s\n", f)
+					}
+					continue
+				}
+
+				batches, events, err := s.Redrive(ctx)
+				if batches > 0 || err != nil {
+					a.logger.Info("redrove dead letters",
+						slog.String("sink", s.Name),
+						slog.Int("batches", batches),
+						slog.Int("events", events))
+				}
+				if err != nil {
+					errs = append(errs, err)
+				}
+			}
+			if err := errors.Join(errs...); err != nil {
+				return fmt.Errorf("redrive failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&sinkNames, "sink", nil, "Only redrive this configured sink (repeatable, default all)")
+	cmd.Flags().BoolVar(&list, "list", false, "List the saved batches instead of sending them")
+
+	return cmd
+}
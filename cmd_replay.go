@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/replay"
+)
+
+func newReplayCmd(a *app) *cobra.Command {
+	var sinkNames []string
+	var start, end string
+	var filters appConfig.Filters
+	var opts replay.Options
+
+	cmd := &cobra.Command{
+		Use:   "replay",
+		Short: "Re-send events from the local output to the configured sinks",
+		Long:  "Read events from every events directory in the config, in event-time order, and send\nthem to the configured sinks (or those named with --sink), optionally at their\noriginal pace, for testing downstream detections against historical data.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx := cmd.Context()
+
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if len(appCfg.Sinks) == 0 {
+				return fmt.Errorf("no sinks configured")
+			}
+
+			opts.Start, opts.End, err = appConfig.ParseTimeRange(start, end)
+			if err != nil {
+				return err
+			}
+			opts.EventsDirs = eventsDirs(appCfg)
+			opts.Filters = filters
+			opts.Cipher, err = a.outputCipher(appCfg)
+			if err != nil {
+				return err
+			}
+
+			sinks, err := a.newSinks(ctx, appCfg, opts.Cipher, sinkNames)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				for _, s := range sinks {
+					if err := s.Close(); err != nil {
+						a.logger.Error("failed to close sink", slog.String("sink", s.Name), slog.String("error", err.Error()))
+					}
+				}
+			}()
+
+			started := time.Now()
+			res, err := replay.Run(ctx, opts, sinks, a.logger)
+			a.logger.Info("replay finished",
+				slog.Int("files", res.Files),
+				slog.Int64("events_read", res.Events),
+				slog.Int64("events_sent", res.Sent),
+				slog.Duration("elapsed", time.Since(started).Round(time.Second)))
+			if err != nil && !errors.Is(err, ctx.Err()) {
+				return fmt.Errorf("replay failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&sinkNames, "sink", nil, "Only send to this configured sink (repeatable, default all)")
+	cmd.Flags().StringVar(&start, "start", "", "Skip events before this (YYYY-MM-DD or RFC3339)")
+	cmd.Flags().StringVar(&end, "end", "", "Skip events at or after this (a date includes the whole day)")
+	cmd.Flags().StringSliceVar(&filters.IncludeEventSources, "event-source", nil, "Only replay these event sources, * wildcards allowed (repeatable)")
+	cmd.Flags().StringSliceVar(&filters.IncludeEventNames, "event-name", nil, "Only replay these event names, * wildcards allowed (repeatable)")
+	cmd.Flags().StringSliceVar(&filters.ExcludeEventNames, "exclude-event-name", nil, "Skip these event names, * wildcards allowed (repeatable)")
+	cmd.Flags().BoolVar(&opts.Pace, "pace", false, "Wait between events for their original gap in event time")
+	cmd.Flags().Float64Var(&opts.Speed, "speed", 1, "With --pace, replay this many times faster than real time")
+	cmd.Flags().DurationVar(&opts.MaxGap, "max-gap", 0, "With --pace, never wait longer than this between events (e.g. 1m; 0 = no cap)")
+
+	return cmd
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/report"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func newReportCmd(a *app) *cobra.Command {
+	var output string
+	var maxFindings int
+
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Render a static HTML report of the output, findings and ingestion health",
+		Long:  "Scan every events directory in the config, the findings file and the state database\nand write a self-contained HTML page with per-account activity timelines, error\nsummaries, detection findings and checkpoint lag.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			cipher, err := a.outputCipher(appCfg)
+			if err != nil {
+				return err
+			}
+
+			var checkpoints []state.Checkpoint
+			var denied []state.DeniedPrefix
+			if _, err := os.Stat(appCfg.StateDB); err == nil {
+				stateDB, err := state.Open(appCfg.StateDB, a.logger)
+				if err != nil {
+					return fmt.Errorf("open state database: %w", err)
+				}
+				checkpoints, err = stateDB.ListCheckpoints()
+				if err == nil {
+					denied, err = stateDB.ListDenied()
+				}
+				_ = stateDB.Close()
+				if err != nil {
+					return fmt.Errorf("read checkpoints: %w", err)
+				}
+			}
+
+			r, err := report.Build(report.Options{
+				EventsDirs:   eventsDirs(appCfg),
+				Cipher:       cipher,
+				Checkpoints:  checkpoints,
+				Denied:       denied,
+				FindingsFile: appCfg.FindingsFile,
+				MaxFindings:  maxFindings,
+				Now:          time.Now(),
+			}, a.logger)
+			if err != nil {
+				return fmt.Errorf("build report: %w", err)
+			}
+
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("create report: %w", err)
+			}
+			if err := report.Render(f, r); err != nil {
+				_ = f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return fmt.Errorf("write report: %w", err)
+			}
+
+			a.logger.Info("report written",
+				slog.String("path", output),
+				slog.Int64("events", r.Events),
+				slog.Int("accounts", len(r.Accounts)),
+				slog.Int("findings", r.Findings.Total),
+				slog.Int("checkpoints", len(r.Health)))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&output, "output", "report.html", "Path to write the HTML report to")
+	cmd.Flags().IntVar(&maxFindings, "max-findings", 200, "Most recent findings to list individually (0 = all)")
+
+	return cmd
+}
+
+// eventsDirs lists every directory the config writes events to
+func eventsDirs(appCfg *appConfig.Config) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		if dir != "" && !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	add(appCfg.EventsDir)
+	for _, dir := range appCfg.CategoryDirs {
+		add(dir)
+	}
+	for _, trail := range appCfg.Trails {
+		add(trail.EventsDir)
+	}
+	for _, group := range appCfg.LogGroups {
+		add(group.EventsDir)
+	}
+	return dirs
+}
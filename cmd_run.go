@@ -0,0 +1,916 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/event"
+	"github.com/deceptiq/gocloudtrail/internal/admin"
+	"github.com/deceptiq/gocloudtrail/internal/alert"
+	"github.com/deceptiq/gocloudtrail/internal/bloom"
+	"github.com/deceptiq/gocloudtrail/internal/catalog"
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/dedupsvc"
+	"github.com/deceptiq/gocloudtrail/internal/derive"
+	"github.com/deceptiq/gocloudtrail/internal/detect"
+	"github.com/deceptiq/gocloudtrail/internal/hub"
+	"github.com/deceptiq/gocloudtrail/internal/logsample"
+	"github.com/deceptiq/gocloudtrail/internal/notify"
+	"github.com/deceptiq/gocloudtrail/internal/oversize"
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+	"github.com/deceptiq/gocloudtrail/internal/sink"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/systemd"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type runOptions struct {
+	Strict     bool
+	Detections []string
+	// process only the objects listed here, skipping discovery
+	KeysFile string
+	// write events to stdout in place of files and sinks, logging to stderr
+	Stdout bool
+	// run one pass and exit even when a schedule is configured
+	Once bool
+	// ignore cached discovery results and discover afresh
+	RefreshDiscovery bool
+}
+
+func newRunCmd(a *app) *cobra.Command {
+	var opts runOptions
+
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Run the CloudTrail processor",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return a.runProcessor(cmd.Context(), opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.Strict, "strict", false, "Exit non-zero when failures exceed the strict thresholds (enables strict.enabled)")
+	cmd.Flags().StringSliceVar(&opts.Detections, "detect", nil,
+		fmt.Sprintf("Enable built-in detections (repeatable, adds to detections): all, %s", strings.Join(detect.PresetNames(), ", ")))
+	cmd.Flags().StringVar(&opts.KeysFile, "keys-file", "", "Process only the S3 objects listed in this file (local path or s3://bucket/key), one per line")
+	cmd.Flags().BoolVar(&opts.Once, "once", false, "Run a single pass and exit, ignoring schedule")
+	cmd.Flags().BoolVar(&opts.RefreshDiscovery, "refresh-discovery", false, "Discover each trail's accounts and regions afresh instead of using discovery_cache_ttl's cached ones")
+	cmd.Flags().BoolVar(&opts.Stdout, "stdout", false, "Write new events to stdout as NDJSON instead of to files and sinks, with logs on stderr (implies stream_only)")
+
+	return cmd
+}
+
+func (a *app) runProcessor(ctx context.Context, opts runOptions) error {
+	if opts.Stdout {
+		// stdout carries the events
+		a.logOutput = os.Stderr
+		if err := a.setupLogger(); err != nil {
+			return err
+		}
+	}
+	appCfg, err := a.loadConfig()
+	if err != nil {
+		return err
+	}
+	if opts.Stdout {
+		appCfg.StreamOnly = true
+		appCfg.SpillUpload = false
+		appCfg.GlueCatalog = appConfig.GlueCatalog{}
+		appCfg.Sinks = []appConfig.Sink{{
+			Name: "stdout",
+			Type: "stdout",
+			// keep a pipeline moving rather than batching up for long
+			SinkTuning: appConfig.SinkTuning{FlushInterval: 1},
+			// stdout stands in for the files
+			Derive: &appCfg.Derive,
+		}}
+	}
+	if opts.Strict {
+		appCfg.Strict.Enabled = true
+	}
+	appCfg.RefreshDiscovery = opts.RefreshDiscovery
+	appCfg.Detections = append(appCfg.Detections, opts.Detections...)
+
+	if opts.KeysFile == "" && !opts.Once {
+		passes, err := schedulePasses(appCfg)
+		if err != nil {
+			return err
+		}
+		if len(passes) > 0 {
+			return a.runSchedule(ctx, appCfg, opts, passes)
+		}
+	}
+	return a.runPass(ctx, appCfg, opts)
+}
+
+// runPass processes the configured sources once
+func (a *app) runPass(ctx context.Context, appCfg *appConfig.Config, opts runOptions) error {
+	// the admin API and signal handlers live as long as the pass
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logger := a.logger
+	defer func() { a.logger = logger }()
+	if s := appCfg.LogSampling; s.First > 0 && s.Interval > 0 {
+		sampler := logsample.New(a.logger.Handler(), s.First, time.Duration(s.Interval)*time.Second)
+		a.logger = slog.New(sampler)
+		go sampler.Run(ctx)
+		defer sampler.Flush()
+	}
+	// keep the pass's errors for the emailed report, sampled or not
+	var errorLog *notify.ErrorLog
+	if len(appCfg.Notifications.EmailTo) > 0 {
+		errorLog = notify.NewErrorLog(a.logger.Handler(), maxReportErrors)
+		a.logger = slog.New(errorLog)
+	}
+
+	proc, err := a.newProcessor(ctx, appCfg)
+	if err != nil {
+		return err
+	}
+
+	progressInterval := time.Duration(appCfg.ProgressInterval) * time.Second
+	jsonlFlushInterval := time.Duration(appCfg.JSONLFlushInterval) * time.Second
+	stateSaveInterval := time.Duration(appCfg.StateSaveInterval) * time.Second
+
+	sources := describeSources(appCfg)
+	var keys []string
+	if opts.KeysFile != "" {
+		if keys, err = proc.LoadKeys(ctx, opts.KeysFile); err != nil {
+			return err
+		}
+		sources = fmt.Sprintf("%d keys from %s", len(keys), opts.KeysFile)
+		a.logger.Info("processing keys file", slog.String("file", opts.KeysFile), slog.Int("keys", len(keys)))
+	}
+
+	chat := newChatSender(appCfg.Notifications)
+	a.sendRunMessage(ctx, chat, notify.Message{
+		Title: "CloudTrail sync started",
+		Text:  "Processing " + sources,
+	})
+	start := time.Now()
+
+	var runErr error
+	if opts.KeysFile != "" {
+		runErr = proc.ProcessKeys(ctx, progressInterval, jsonlFlushInterval, stateSaveInterval, keys)
+	} else {
+		runErr = proc.Run(ctx, progressInterval, jsonlFlushInterval, stateSaveInterval)
+	}
+	if runErr == context.Canceled {
+		a.logger.Info("received interrupt signal, shutting down gracefully")
+	}
+
+	proc.Stats().PrintProgress(a.logger)
+
+	switch {
+	case runErr != nil && runErr != context.Canceled:
+		err = fmt.Errorf("processing failed: %w", runErr)
+	case appCfg.Strict.Enabled:
+		if thresholdErr := proc.Stats().CheckThresholds(processor.Thresholds{
+			MaxErrors:           appCfg.Strict.MaxErrors,
+			MaxSkippedObjects:   appCfg.Strict.MaxSkippedObjects,
+			MaxParseFailureRate: appCfg.Strict.MaxParseFailureRate,
+		}); thresholdErr != nil {
+			err = fmt.Errorf("strict thresholds exceeded: %w", thresholdErr)
+		}
+	}
+
+	// the run context may already be cancelled, so give the summary its own
+	summaryCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	panicked, panics := proc.PanickedFiles()
+	summary := runSummary(proc.Stats(), proc.DeniedPrefixes(), proc.UnhealthyTrails(), panicked, panics, time.Since(start), runErr, err)
+	a.sendRunMessage(summaryCtx, chat, summary)
+	if errorLog != nil {
+		awsCfg, cfgErr := a.awsConfig(summaryCtx, appCfg)
+		if cfgErr != nil {
+			a.logger.Warn("failed to send summary email", slog.String("error", cfgErr.Error()))
+		} else {
+			if errorLog.Len() > 0 {
+				summary.Attachments = append(summary.Attachments, notify.Attachment{
+					Name:        "errors.jsonl",
+					ContentType: "application/x-ndjson",
+					Data:        errorLog.Report(),
+				})
+			}
+			a.sendRunMessage(summaryCtx, &notify.SES{
+				Client: sesv2.NewFromConfig(awsCfg),
+				From:   appCfg.Notifications.EmailFrom,
+				To:     appCfg.Notifications.EmailTo,
+			}, summary)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+	a.logger.Info("processing complete")
+	return nil
+}
+
+// errors kept for the emailed report; later ones are only counted
+const maxReportErrors = 1000
+
+// newChatSender returns the configured chat destinations, or nil when there
+// are none
+func newChatSender(n appConfig.Notifications) notify.Sender {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var senders notify.Multi
+	if n.SlackWebhookURL != "" {
+		senders = append(senders, &notify.Slack{URL: n.SlackWebhookURL, Client: client})
+	}
+	if n.TeamsWebhookURL != "" {
+		senders = append(senders, &notify.Teams{URL: n.TeamsWebhookURL, Client: client})
+	}
+	if len(senders) == 0 {
+		return nil
+	}
+	return senders
+}
+
+func (a *app) sendRunMessage(ctx context.Context, chat notify.Sender, m notify.Message) {
+	if chat == nil {
+		return
+	}
+	if err := chat.Send(ctx, m); err != nil {
+		a.logger.Warn("failed to send run notification", slog.String("error", err.Error()))
+	}
+}
+
+func describeSources(appCfg *appConfig.Config) string {
+	if len(appCfg.Trails) == 0 && len(appCfg.LogGroups) == 0 {
+		return "trails discovered via the CloudTrail API"
+	}
+	desc := fmt.Sprintf("%d trails", len(appCfg.Trails))
+	if len(appCfg.LogGroups) > 0 {
+		desc += fmt.Sprintf(" and %d log groups", len(appCfg.LogGroups))
+	}
+	return desc
+}
+
+// runSummary builds the end-of-run message from the final stats
+func runSummary(stats *processor.Stats, denied []state.DeniedPrefix, unhealthy, panicked []string, panics int, elapsed time.Duration, runErr, err error) notify.Message {
+	m := notify.Message{Title: "CloudTrail sync completed", Text: "Run finished"}
+	switch {
+	case err != nil:
+		m.Title, m.Text, m.Failed = "CloudTrail sync failed", err.Error(), true
+	case runErr == context.Canceled:
+		m.Title, m.Text = "CloudTrail sync interrupted", "Stopped by signal; the next run resumes from the checkpoints"
+	}
+
+	m.Facts = []notify.Fact{
+		{Name: "Duration", Value: elapsed.Round(time.Second).String()},
+		{Name: "Files downloaded", Value: fmt.Sprint(stats.FilesDownloaded.Load())},
+		{Name: "Events written", Value: fmt.Sprint(stats.EventsWritten.Load())},
+		{Name: "Duplicates", Value: fmt.Sprint(stats.EventsDuplicate.Load())},
+		{Name: "Filtered", Value: fmt.Sprint(stats.EventsFiltered.Load())},
+		{Name: "Invalid", Value: fmt.Sprint(stats.EventsInvalid.Load())},
+		{Name: "Errors", Value: fmt.Sprint(stats.Errors.Load())},
+	}
+	if n := stats.FilesExcluded.Load(); n > 0 {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Files excluded by skip_keys", Value: fmt.Sprint(n)})
+	}
+	if n := stats.EventsNewerVersion.Load(); n > 0 {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Events newer than event version " + event.LatestVersion, Value: fmt.Sprint(n)})
+	}
+	if n := stats.EventsOversized.Load(); n > 0 {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Oversized events", Value: fmt.Sprint(n)})
+	}
+	if n := stats.EventsTimeFallback.Load(); n > 0 {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Events timed by their log file", Value: fmt.Sprint(n)})
+	}
+	if classes := stats.ErrorSummary(); classes != "" {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Errors by class", Value: classes})
+	}
+	if len(unhealthy) > 0 {
+		m.Facts = append(m.Facts, notify.Fact{Name: "Trails stopped or failing delivery", Value: strings.Join(unhealthy, ", ")})
+	}
+	if len(denied) > 0 {
+		prefixes := make([]string, 0, len(denied))
+		for _, d := range denied {
+			prefixes = append(prefixes, d.Bucket+":"+d.AccountID+":"+d.Region)
+		}
+		m.Facts = append(m.Facts, notify.Fact{Name: "Skipped for AccessDenied", Value: strings.Join(prefixes, ", ")})
+	}
+	if panics > 0 {
+		files := strings.Join(panicked, ", ")
+		if panics > len(panicked) {
+			files += fmt.Sprintf(" and %d more", panics-len(panicked))
+		}
+		m.Facts = append(m.Facts, notify.Fact{Name: "Files that panicked", Value: files})
+	}
+	if first, last := stats.SourceRange(); !first.IsZero() {
+		m.Facts = append(m.Facts, notify.Fact{
+			Name:  "Checkpoint range",
+			Value: first.Format(time.RFC3339) + " to " + last.Format(time.RFC3339),
+		})
+	}
+	return m
+}
+
+// newProcessor authenticates with AWS and opens the state needed by the
+// processor, which closes it when it finishes; on error nothing is left open
+func (a *app) newProcessor(ctx context.Context, appCfg *appConfig.Config) (proc *processor.Processor, err error) {
+	logger := a.logger
+
+	cfg, err := a.awsConfig(ctx, appCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	outputFormat, err := writer.ParseFormat(appCfg.OutputFormat)
+	if err != nil {
+		return nil, err
+	}
+	cipher, err := a.outputCipher(appCfg)
+	if err != nil {
+		return nil, err
+	}
+	layout, err := writer.ParseLayout(appCfg.PartitionLayout)
+	if err != nil {
+		return nil, err
+	}
+	granularity, err := writer.ParseGranularity(appCfg.PartitionGranularity)
+	if err != nil {
+		return nil, err
+	}
+	partitioning := writer.Partitioning{Layout: layout, Granularity: granularity}
+	accountRouting, err := processor.ParseAccountRouting(appCfg.PartitionAccount, appCfg.PartitionCrossAccount)
+	if err != nil {
+		return nil, err
+	}
+	lateDelivery, err := processor.ParseLateDelivery(appCfg.LateDeliveries)
+	if err != nil {
+		return nil, err
+	}
+	startTime, endTime, err := appCfg.TimeRange()
+	if err != nil {
+		return nil, err
+	}
+	if appCfg.StreamOnly {
+		if len(appCfg.Sinks) == 0 {
+			return nil, fmt.Errorf("stream_only requires at least one sink")
+		}
+		if appCfg.SpillUpload {
+			return nil, fmt.Errorf("stream_only writes no files for spill_upload")
+		}
+	}
+	var spill processor.SpillOptions
+	if appCfg.SpillUpload {
+		if appCfg.ArchiveBucket == "" {
+			return nil, fmt.Errorf("spill_upload requires archive_bucket")
+		}
+		spill = processor.SpillOptions{
+			Bucket: appCfg.ArchiveBucket,
+			Prefix: appCfg.ArchivePrefix,
+			Quota:  int64(max(appCfg.LocalQuotaMB, 0)) << 20,
+		}
+	}
+	if appCfg.GlueCatalog.Database != "" {
+		if spill.Bucket == "" {
+			return nil, fmt.Errorf("glue_catalog requires spill_upload")
+		}
+		if appCfg.Encryption.Mode != "" {
+			return nil, fmt.Errorf("glue_catalog can't query encrypted output")
+		}
+		for _, f := range outputFormats(appCfg) {
+			if f != outputFormat {
+				return nil, fmt.Errorf("glue_catalog needs a single output_format, found %s and %s", outputFormat, f)
+			}
+		}
+		table := appCfg.GlueCatalog.Table
+		if table == "" {
+			table = "cloudtrail"
+		}
+		location := "s3://" + path.Join(spill.Bucket, spill.Prefix)
+		spill.Catalog = catalog.NewGlue(glue.NewFromConfig(cfg), appCfg.GlueCatalog.Database, table, location, writer.Encoding{Format: outputFormat, CSVColumns: appCfg.CSVColumns}, partitioning)
+		if err := spill.Catalog.EnsureTable(ctx); err != nil {
+			return nil, err
+		}
+		logger.Info("Glue table ready",
+			slog.String("table", appCfg.GlueCatalog.Database+"."+table),
+			slog.String("location", location))
+	}
+
+	stsClient := sts.NewFromConfig(cfg)
+	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("get caller identity: %w", err)
+	}
+	logger.Info("authenticated with AWS", slog.String("account", aws.ToString(identity.Account)))
+
+	minFreeDisk := uint64(max(appCfg.MinFreeDiskMB, 0)) << 20
+	if appCfg.StreamOnly {
+		// nothing is written to the events dir, so there's no volume to watch
+		minFreeDisk = 0
+	} else if err := os.MkdirAll(appCfg.EventsDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create events directory: %w", err)
+	}
+
+	numCPU := runtime.NumCPU()
+	processConcurrency := numCPU * 2
+	if appCfg.ProcessWorkers > 0 {
+		processConcurrency = appCfg.ProcessWorkers
+	}
+
+	logger.Info("system configuration",
+		slog.Int("cpu_cores", numCPU),
+		slog.Int("download_workers", appCfg.DownloadWorkers),
+		slog.Int("process_workers", processConcurrency))
+
+	deriver, err := derive.New(appCfg.Derive.EpochTime, appCfg.Derive.Fields)
+	if err != nil {
+		return nil, fmt.Errorf("derive: %w", err)
+	}
+
+	limiter, err := oversize.New(oversize.Options{
+		MaxBytes: appCfg.OversizedRecords.MaxBytes,
+		Action:   appCfg.OversizedRecords.Action,
+		Fields:   appCfg.OversizedRecords.Fields,
+		Dir:      appCfg.OversizedRecords.Dir,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("oversized_records: %w", err)
+	}
+
+	stateDB, err := state.Open(appCfg.StateDB, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = stateDB.Close()
+		}
+	}()
+
+	dedup, err := newDedupStore(appCfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if c, ok := dedup.(io.Closer); ok && err != nil {
+			_ = c.Close()
+		}
+	}()
+
+	var detector *detect.Engine
+	var findings detect.Sink
+	if len(appCfg.RulesFiles) > 0 || len(appCfg.SigmaRules) > 0 || len(appCfg.Detections) > 0 {
+		presetRules, err := detect.PresetRules(appCfg.Detections)
+		if err != nil {
+			return nil, err
+		}
+		rules, err := detect.LoadRules(appCfg.RulesFiles)
+		if err != nil {
+			return nil, fmt.Errorf("load rules: %w", err)
+		}
+		sigmaRules, err := detect.LoadSigmaRules(appCfg.SigmaRules, logger)
+		if err != nil {
+			return nil, fmt.Errorf("load sigma rules: %w", err)
+		}
+		logger.Info("loaded detection rules",
+			slog.Int("presets", len(presetRules)),
+			slog.Int("rules", len(rules)),
+			slog.Int("sigma_rules", len(sigmaRules)))
+		rules = append(append(presetRules, rules...), sigmaRules...)
+		detector = detect.NewEngine(rules)
+		findings = detect.NewFileSink(appCfg.FindingsFile)
+		if appCfg.SecurityHub.Enabled {
+			findings = detect.MultiSink{findings, &detect.SecurityHub{
+				Client: securityhub.NewFromConfig(cfg, func(o *securityhub.Options) {
+					if appCfg.SecurityHub.Region != "" {
+						o.Region = appCfg.SecurityHub.Region
+					}
+				}),
+				AccountID: aws.ToString(identity.Account),
+			}}
+		}
+	}
+
+	sinks, err := a.newSinks(ctx, appCfg, cipher, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			for _, s := range sinks {
+				_ = s.Close()
+			}
+		}
+	}()
+
+	var signer crypt.Signer
+	if appCfg.ChecksumKMSKeyID != "" {
+		signer = crypt.NewKMSSigner(kms.NewFromConfig(cfg), appCfg.ChecksumKMSKeyID, appCfg.ChecksumSigningAlgorithm)
+	}
+
+	proc = processor.New(
+		s3.NewFromConfig(cfg),
+		cloudtrail.NewFromConfig(cfg),
+		cloudwatchlogs.NewFromConfig(cfg),
+		stateDB,
+		dedup,
+		processor.Config{
+			DownloadWorkers:        appCfg.DownloadWorkers,
+			ProcessWorkers:         processConcurrency,
+			DownloadQueueSize:      appCfg.DownloadQueueSize,
+			FairScheduling:         appCfg.FairScheduling,
+			LogFolders:             appCfg.LogFolders,
+			DiscoveryCacheTTL:      time.Duration(appCfg.DiscoveryCacheTTL) * time.Second,
+			RefreshDiscovery:       appCfg.RefreshDiscovery,
+			ProcessQueueSize:       appCfg.ProcessQueueSize,
+			ListBatchSize:          appCfg.ListBatchSize,
+			DiscoveryPageSize:      appCfg.DiscoveryPageSize,
+			EventsPerFile:          appCfg.EventsPerFile,
+			EventsDir:              appCfg.EventsDir,
+			CategoryDirs:           appCfg.CategoryDirs,
+			OutputFormat:           outputFormat,
+			CSVColumns:             appCfg.CSVColumns,
+			Cipher:                 cipher,
+			Partitioning:           partitioning,
+			ConfigHash:             appCfg.Hash(),
+			SourceFileNames:        appCfg.SourceFileNames,
+			StartTime:              startTime,
+			EndTime:                endTime,
+			Filters:                appCfg.Filters,
+			SkipKeys:               appCfg.SkipKeys,
+			Trails:                 appCfg.Trails,
+			LogGroups:              appCfg.LogGroups,
+			DiscoverBuckets:        appCfg.DiscoverBuckets,
+			ValidateEvents:         appCfg.ValidateEvents,
+			QuarantineDir:          appCfg.QuarantineDir,
+			RecordObjects:          appCfg.RecordObjects,
+			AuditLog:               appCfg.AuditLog,
+			LateDelivery:           lateDelivery,
+			LateLookback:           time.Duration(max(appCfg.LateDeliveryDays, 1)) * 24 * time.Hour,
+			SettleWindow:           time.Duration(appCfg.SettleWindowMinutes) * time.Minute,
+			GetObjectMaxAttempts:   appCfg.AWSRetry.GetObjectMaxAttempts,
+			ListObjectsMaxAttempts: appCfg.AWSRetry.ListObjectsMaxAttempts,
+			DownloadRateLimit:      appCfg.DownloadRateLimit,
+			MinFreeDisk:            minFreeDisk,
+			Spill:                  spill,
+			StreamOnly:             appCfg.StreamOnly,
+			AtLeastOnce:            appCfg.AtLeastOnce,
+			MaxUnacked:             appCfg.MaxUnackedObjects,
+			CheckpointEveryFiles:   appCfg.CheckpointEveryFiles,
+			CheckpointInterval:     time.Duration(appCfg.CheckpointInterval) * time.Second,
+			Ordered:                appCfg.OrderedPartitions,
+			Checksums:              appCfg.Checksums || signer != nil,
+			ManifestSigner:         signer,
+			CheckOutput:            appCfg.CheckOutput,
+			PartitionMarkers:       appCfg.PartitionMarkers,
+			AccountRouting:         accountRouting,
+			Alerts: processor.AlertRules{
+				Interval:          time.Duration(appCfg.Alerts.CheckInterval) * time.Second,
+				MaxErrorRate:      appCfg.Alerts.MaxErrorRate,
+				StallAfter:        time.Duration(appCfg.Alerts.StallMinutes) * time.Minute,
+				WedgedAfter:       time.Duration(appCfg.Alerts.WedgedMinutes) * time.Minute,
+				PartitionReopened: appCfg.Alerts.PartitionReopened,
+			},
+			Notifier: newNotifier(cfg, appCfg.Alerts),
+			Service: processor.ServiceOptions{
+				Notifier:    systemd.FromEnv(),
+				WedgedAfter: time.Duration(appCfg.Systemd.WedgedMinutes) * time.Minute,
+				StopTimeout: time.Duration(appCfg.Systemd.StopTimeout) * time.Second,
+			},
+			Detector:  detector,
+			Findings:  findings,
+			Analytics: analyticsOptions(appCfg.Analytics),
+			Sinks:     sinks,
+			Derive:    deriver,
+			Oversize:  limiter,
+		},
+		logger,
+	)
+
+	if appCfg.AdminAddr != "" {
+		if err := admin.Start(ctx, appCfg.AdminAddr, appCfg.AdminToken, proc, logger); err != nil {
+			return nil, err
+		}
+	}
+	a.handleControlSignals(ctx, proc)
+	return proc, nil
+}
+
+// newDedupStore connects to the dedup service when one is configured, and
+// loads the local bloom filter otherwise
+func newDedupStore(appCfg *appConfig.Config, logger *slog.Logger) (processor.DedupStore, error) {
+	svc := appCfg.DedupService
+	if svc.Addr == "" {
+		bloomFilter, err := bloom.Load(appCfg.BloomFile, uint(appCfg.BloomExpectedItems), appCfg.BloomFalsePositive,
+			time.Duration(appCfg.DedupRetentionDays)*24*time.Hour, logger)
+		if err != nil {
+			return nil, fmt.Errorf("load bloom filter: %w", err)
+		}
+		return bloomFilter, nil
+	}
+	return dedupsvc.Dial(dedupsvc.ClientOptions{
+		Addr:          svc.Addr,
+		Token:         svc.Token,
+		TLS:           svc.TLS,
+		CAFile:        svc.CAFile,
+		BatchSize:     svc.BatchSize,
+		FlushInterval: time.Duration(svc.FlushIntervalMS) * time.Millisecond,
+		CacheSize:     svc.CacheSize,
+		Timeout:       time.Duration(svc.Timeout) * time.Second,
+		// acknowledged checkpoints only remember acknowledged events
+		DeferAdds: appCfg.AtLeastOnce || appCfg.StreamOnly,
+	}, logger)
+}
+
+// outputFormats returns the formats trails and log groups override
+// output_format with
+func outputFormats(appCfg *appConfig.Config) []writer.Format {
+	var formats []writer.Format
+	for _, trail := range appCfg.Trails {
+		if trail.OutputFormat != "" {
+			formats = append(formats, writer.Format(trail.OutputFormat))
+		}
+	}
+	for _, group := range appCfg.LogGroups {
+		if group.OutputFormat != "" {
+			formats = append(formats, writer.Format(group.OutputFormat))
+		}
+	}
+	return formats
+}
+
+const (
+	// defaultSinkBatchSize is the events per request when batch_size is unset
+	defaultSinkBatchSize = 500
+	// defaultTableBatchSize is the events per table commit (iceberg, delta)
+	// when batch_size is unset; commits are costly, so they're batched far
+	// larger
+	defaultTableBatchSize = 50000
+	// defaultSinkConcurrency is the batches sent at once when concurrency is
+	// unset; tables take one commit at a time
+	defaultSinkConcurrency = 4
+
+	defaultSinkRetryAttempts = 3
+	defaultSinkBackoff       = time.Second
+	defaultSinkMaxBackoff    = 30 * time.Second
+)
+
+// sinkCodecs are the compression codecs each sink type supports
+var sinkCodecs = map[string][]string{
+	"webhook": {"none", "gzip"},
+	"splunk":  {"none", "gzip"},
+	"kafka":   {"none", "gzip", "snappy", "lz4", "zstd"},
+	"iceberg": {"none", "gzip", "snappy", "zstd"},
+	"delta":   {"none", "gzip", "snappy", "zstd"},
+	"stdout":  {"none"},
+	"grpc":    {"none"},
+}
+
+// sinkOptions validates a sink's type and tuning and fills in the defaults;
+// failed batches are saved under deadLetterDir when it's set, and ordered
+// sends batches one at a time in order
+func sinkOptions(cfg appConfig.Sink, deadLetterDir string, ordered bool) (sink.Options, error) {
+	if cfg.Name == "" || filepath.Base(cfg.Name) != cfg.Name {
+		return sink.Options{}, fmt.Errorf("sink name %q must be set and can't contain a path separator", cfg.Name)
+	}
+	codecs, ok := sinkCodecs[cfg.Type]
+	if !ok {
+		return sink.Options{}, fmt.Errorf("sink %s: unknown type %q (want webhook, splunk, kafka, iceberg, delta, stdout or grpc)", cfg.Name, cfg.Type)
+	}
+	t := cfg.SinkTuning
+	if t.BatchSize < 0 || t.MaxBatchBytes < 0 || t.FlushInterval < 0 || t.Concurrency < 0 {
+		return sink.Options{}, fmt.Errorf("sink %s: batch_size, max_batch_bytes, flush_interval and concurrency can't be negative", cfg.Name)
+	}
+	if t.Retry.Attempts < 0 || t.Retry.BackoffMS < 0 || t.Retry.MaxBackoffMS < 0 {
+		return sink.Options{}, fmt.Errorf("sink %s: retry settings can't be negative", cfg.Name)
+	}
+	if t.Compression != "" && !slices.Contains(codecs, t.Compression) {
+		return sink.Options{}, fmt.Errorf("sink %s: %s doesn't support compression %q (want %s)", cfg.Name, cfg.Type, t.Compression, strings.Join(codecs, ", "))
+	}
+
+	table := cfg.Type == "iceberg" || cfg.Type == "delta"
+	opts := sink.Options{
+		BatchSize:     t.BatchSize,
+		MaxBatchBytes: t.MaxBatchBytes,
+		FlushInterval: time.Duration(t.FlushInterval) * time.Second,
+		Concurrency:   t.Concurrency,
+	}
+	if opts.BatchSize == 0 {
+		switch {
+		case table:
+			opts.BatchSize = defaultTableBatchSize
+		case cfg.Type == "grpc":
+			// subscribers get each event as it's written
+			opts.BatchSize = 1
+		default:
+			opts.BatchSize = defaultSinkBatchSize
+		}
+	}
+	if ordered {
+		if opts.Concurrency > 1 {
+			return sink.Options{}, fmt.Errorf("sink %s: ordered_partitions sends one batch at a time, so concurrency can't be above 1", cfg.Name)
+		}
+		opts.Concurrency, opts.Ordered = 1, true
+	}
+	if opts.Concurrency == 0 {
+		opts.Concurrency = defaultSinkConcurrency
+		if table {
+			opts.Concurrency = 1
+		}
+	}
+
+	opts.Retry = sink.RetryPolicy{
+		Attempts:   t.Retry.Attempts,
+		Backoff:    time.Duration(t.Retry.BackoffMS) * time.Millisecond,
+		MaxBackoff: time.Duration(t.Retry.MaxBackoffMS) * time.Millisecond,
+	}
+	if opts.Retry.Attempts == 0 {
+		opts.Retry.Attempts = defaultSinkRetryAttempts
+	}
+	if opts.Retry.Backoff == 0 {
+		opts.Retry.Backoff = defaultSinkBackoff
+	}
+	if opts.Retry.MaxBackoff == 0 {
+		opts.Retry.MaxBackoff = defaultSinkMaxBackoff
+	}
+	if deadLetterDir != "" {
+		opts.DeadLetterDir = filepath.Join(deadLetterDir, cfg.Name)
+	}
+	if cfg.Route != "" {
+		route, err := sink.CompileRoute(cfg.Route)
+		if err != nil {
+			return sink.Options{}, fmt.Errorf("sink %s: route: %w", cfg.Name, err)
+		}
+		opts.Route = route
+	}
+	if d := cfg.Derive; d != nil {
+		deriver, err := derive.New(d.EpochTime, d.Fields)
+		if err != nil {
+			return sink.Options{}, fmt.Errorf("sink %s: derive: %w", cfg.Name, err)
+		}
+		if deriver != nil {
+			opts.Transform = deriver
+		}
+	}
+	return opts, nil
+}
+
+// newSinks builds the configured sinks; with names set, only those. Their
+// dead-letter files are encrypted with cipher.
+func (a *app) newSinks(ctx context.Context, appCfg *appConfig.Config, cipher crypt.Cipher, names []string) ([]*sink.Buffered, error) {
+	wanted := make(map[string]bool, len(names))
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	var sinks []*sink.Buffered
+	for _, cfg := range appCfg.Sinks {
+		if len(wanted) > 0 && !wanted[cfg.Name] {
+			continue
+		}
+		delete(wanted, cfg.Name)
+
+		deadLetterDir := appCfg.DeadLetterDir
+		if appCfg.StreamOnly || appCfg.AtLeastOnce {
+			// a failed batch holds back its checkpoints and is resent
+			// after a restart, so saving it too would deliver it twice
+			deadLetterDir = ""
+		}
+		opts, err := sinkOptions(cfg, deadLetterDir, appCfg.OrderedPartitions)
+		if err != nil {
+			return nil, err
+		}
+		opts.DeadLetterCipher = cipher
+		var s sink.Sink
+		switch cfg.Type {
+		case "webhook":
+			if cfg.URL == "" {
+				return nil, fmt.Errorf("sink %s: url is required", cfg.Name)
+			}
+			s = &sink.Webhook{URL: cfg.URL, Headers: cfg.Headers, Compression: cfg.Compression, Client: client}
+		case "splunk":
+			if cfg.URL == "" || cfg.Token == "" {
+				return nil, fmt.Errorf("sink %s: url and token are required", cfg.Name)
+			}
+			s = &sink.Splunk{URL: cfg.URL, Token: cfg.Token, Index: cfg.Index, SourceType: cfg.SourceType, Compression: cfg.Compression, Client: client}
+		case "kafka":
+			if len(cfg.Brokers) == 0 || cfg.Topic == "" {
+				return nil, fmt.Errorf("sink %s: brokers and topic are required", cfg.Name)
+			}
+			s = sink.NewKafka(cfg.Brokers, cfg.Topic, cfg.Compression)
+		case "iceberg":
+			if cfg.Table == "" || (cfg.Catalog == "rest" && cfg.CatalogURI == "") {
+				return nil, fmt.Errorf("sink %s: table is required, and catalog_uri for a rest catalog", cfg.Name)
+			}
+			awsCfg, err := a.awsConfig(ctx, appCfg)
+			if err != nil {
+				return nil, err
+			}
+			ice, err := sink.NewIceberg(ctx, sink.IcebergOptions{
+				Catalog:     cfg.Catalog,
+				URI:         cfg.CatalogURI,
+				Token:       cfg.Token,
+				Warehouse:   cfg.Warehouse,
+				Table:       cfg.Table,
+				Compression: cfg.Compression,
+				AWS:         awsCfg,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+			}
+			s = ice
+		case "delta":
+			if cfg.Location == "" {
+				return nil, fmt.Errorf("sink %s: location is required", cfg.Name)
+			}
+			deltaOpts := sink.DeltaOptions{Location: cfg.Location, PartitionBy: cfg.PartitionBy, Compression: cfg.Compression, Logger: a.logger}
+			if strings.HasPrefix(cfg.Location, "s3://") {
+				awsCfg, err := a.awsConfig(ctx, appCfg)
+				if err != nil {
+					return nil, err
+				}
+				deltaOpts.S3 = s3.NewFromConfig(awsCfg)
+			}
+			delta, err := sink.NewDelta(ctx, deltaOpts)
+			if err != nil {
+				return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+			}
+			s = delta
+		case "stdout":
+			s = &sink.Stdout{}
+		case "grpc":
+			if cfg.Listen == "" {
+				return nil, fmt.Errorf("sink %s: listen is required", cfg.Name)
+			}
+			h, err := hub.Listen(hub.Options{Listen: cfg.Listen, Token: cfg.Token, TLSCert: cfg.TLSCert, TLSKey: cfg.TLSKey}, a.logger)
+			if err != nil {
+				return nil, fmt.Errorf("sink %s: %w", cfg.Name, err)
+			}
+			s = h
+		}
+		sinks = append(sinks, sink.NewBuffered(cfg.Name, s, opts))
+	}
+
+	for name := range wanted {
+		return nil, fmt.Errorf("no sink named %q in the config", name)
+	}
+	return sinks, nil
+}
+
+func analyticsOptions(cfg appConfig.Analytics) processor.AnalyticsOptions {
+	if !cfg.Enabled {
+		return processor.AnalyticsOptions{}
+	}
+	topN := cfg.TopN
+	if topN <= 0 {
+		topN = 10
+	}
+	return processor.AnalyticsOptions{
+		TopN:     topN,
+		Interval: time.Duration(cfg.Interval) * time.Minute,
+		File:     cfg.File,
+	}
+}
+
+// newNotifier returns the configured alert destinations, or nil when there
+// are none
+func newNotifier(cfg aws.Config, alerts appConfig.Alerts) alert.Notifier {
+	var notifiers alert.Multi
+	if alerts.WebhookURL != "" {
+		notifiers = append(notifiers, &alert.Webhook{
+			URL:    alerts.WebhookURL,
+			Client: &http.Client{Timeout: 10 * time.Second},
+		})
+	}
+	if alerts.SNSTopicARN != "" {
+		notifiers = append(notifiers, &alert.SNS{
+			Client:   sns.NewFromConfig(cfg),
+			TopicARN: alerts.SNSTopicARN,
+		})
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	return notifiers
+}
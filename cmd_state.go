@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/bundle"
+)
+
+func newStateCmd(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "state",
+		Short: "Manage a collector's saved state",
+	}
+
+	bundleCmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move a collector's position between hosts as one archive",
+		Long: "Package the state database, the bloom filter or other dedup data, and the next\n" +
+			"output file number of every partition into a versioned archive, and restore it\n" +
+			"on another host, so a collector resumes there without reprocessing or duplicating\n" +
+			"events.",
+	}
+	bundleCmd.AddCommand(
+		newStateBundleExportCmd(a),
+		newStateBundleImportCmd(a),
+	)
+	cmd.AddCommand(bundleCmd)
+	return cmd
+}
+
+func newStateBundleExportCmd(a *app) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Write the collector's state to a bundle",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			if appCfg.DedupService.Addr != "" {
+				a.logger.Warn("dedup_service is set, so event IDs live on the dedup server and aren't in the bundle",
+					slog.String("addr", appCfg.DedupService.Addr))
+			}
+			v, _, _, _ := buildInfo()
+			m, err := bundle.Export(output, bundle.ExportOptions{
+				StateDB:    appCfg.StateDB,
+				BloomFile:  appCfg.BloomFile,
+				EventsDirs: eventsDirs(appCfg),
+				Version:    v,
+			}, a.logger)
+			if err != nil {
+				return fmt.Errorf("export failed: %w", err)
+			}
+			printBundle(cmd, m)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&output, "output", "gocloudtrail-state.tar.gz", "Path to write the bundle to")
+
+	return cmd
+}
+
+func newStateBundleImportCmd(a *app) *cobra.Command {
+	var input string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Restore the collector's state from a bundle",
+		Long: "Restore a bundle into the config's state_db and bloom_file. Every file is checked\n" +
+			"against the bundle's manifest first. An existing state database or dedup data is\n" +
+			"only replaced with --force, and is then moved into a before-import directory next\n" +
+			"to state_db rather than deleted. Stop any collector using them first.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+			m, err := bundle.Import(input, bundle.ImportOptions{
+				StateDB:   appCfg.StateDB,
+				BloomFile: appCfg.BloomFile,
+				Force:     force,
+			}, a.logger)
+			if err != nil {
+				return fmt.Errorf("import failed: %w", err)
+			}
+			if appCfg.DedupService.Addr != "" {
+				a.logger.Warn("dedup_service is set, so the imported dedup data isn't used",
+					slog.String("addr", appCfg.DedupService.Addr))
+			}
+			printBundle(cmd, m)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&input, "input", "", "Bundle to import")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing state database and dedup data")
+	_ = cmd.MarkFlagRequired("input")
+
+	return cmd
+}
+
+func printBundle(cmd *cobra.Command, m *bundle.Manifest) {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "bundle format %d, schema %d, from %s at %s\n",
+		m.FormatVersion, m.SchemaVersion, m.Host, m.CreatedAt.Format("2006-01-02T15:04:05Z"))
+	for _, f := range m.Files {
+		fmt.Fprintf(out, "  %-40s %12d bytes\n", f.Name, f.Size)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func newStatsCmd(a *app) *cobra.Command {
+	var dbPath string
+	var asJSON, lifetime bool
+	var runs int
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Summarize checkpoints in the state database",
+		Long:  "Print per bucket/account/region checkpoint positions, how far behind they are, the\nevent watermarks and the duplicate, invalid and filtered rates from the last run\nthat read them, then the prefixes runs skipped for AccessDenied. --lifetime prints\nthe counters kept across runs instead, and --runs the most recent runs.\nThe state database is taken from --db, or from state_db in --config.",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			stateDB, err := a.openExistingStateDB(dbPath)
+			if err != nil {
+				return err
+			}
+			defer stateDB.Close()
+
+			if runs > 0 {
+				history, err := stateDB.ListRuns(runs)
+				if err != nil {
+					return fmt.Errorf("read run history: %w", err)
+				}
+				return printRuns(cmd.OutOrStdout(), history, asJSON)
+			}
+			if lifetime {
+				counters, err := stateDB.ListCounters()
+				if err != nil {
+					return fmt.Errorf("read counters: %w", err)
+				}
+				return printCounters(cmd.OutOrStdout(), counters, asJSON)
+			}
+
+			checkpoints, err := stateDB.ListCheckpoints()
+			if err != nil {
+				return fmt.Errorf("read checkpoints: %w", err)
+			}
+			denied, err := stateDB.ListDenied()
+			if err != nil {
+				return fmt.Errorf("read denied prefixes: %w", err)
+			}
+
+			return printCheckpoints(cmd.OutOrStdout(), checkpoints, denied, time.Now(), asJSON)
+		},
+	}
+
+	cmd.Flags().StringVar(&dbPath, "db", "", "Path to the state database (overrides --config)")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print JSON instead of a table")
+	cmd.Flags().BoolVar(&lifetime, "lifetime", false, "Print counter totals across every run, and the last run's share, instead of checkpoints")
+	cmd.Flags().IntVar(&runs, "runs", 0, "Print the N most recent runs from the run history instead of checkpoints")
+	cmd.MarkFlagsMutuallyExclusive("lifetime", "runs")
+
+	return cmd
+}
+
+// openExistingStateDB opens the state database at dbPath, or at state_db in
+// --config, logging only warnings to stderr so stdout stays free for output
+func (a *app) openExistingStateDB(dbPath string) (*state.DB, error) {
+	path := dbPath
+	if path == "" && a.configPath != "" {
+		appCfg, err := appConfig.Load(a.configPath)
+		if err != nil {
+			return nil, fmt.Errorf("load config file: %w", err)
+		}
+		path = appCfg.StateDB
+	}
+	if path == "" {
+		return nil, fmt.Errorf("--config or --db is required")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("state database not found: %w", err)
+	}
+
+	stderrLogger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	stateDB, err := state.Open(path, stderrLogger)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	return stateDB, nil
+}
+
+// checkpointRow is a checkpoint annotated with how far behind it is
+type checkpointRow struct {
+	state.Checkpoint
+	CheckpointTime *time.Time `json:"checkpoint_time,omitempty"`
+	LagSeconds     *int64     `json:"lag_seconds,omitempty"`
+	// set while runs skip the prefix for AccessDenied
+	Denied *state.DeniedPrefix `json:"denied,omitempty"`
+}
+
+func printCheckpoints(w io.Writer, checkpoints []state.Checkpoint, denied []state.DeniedPrefix, now time.Time, asJSON bool) error {
+	deniedByKey := make(map[[3]string]*state.DeniedPrefix, len(denied))
+	for i, d := range denied {
+		deniedByKey[[3]string{d.Bucket, d.AccountID, d.Region}] = &denied[i]
+	}
+
+	rows := make([]checkpointRow, 0, len(checkpoints))
+	for _, cp := range checkpoints {
+		row := checkpointRow{Checkpoint: cp}
+		if t, ok := logkey.Time(cp.LastProcessedKey); ok {
+			lag := int64(now.Sub(t).Seconds())
+			row.CheckpointTime = &t
+			row.LagSeconds = &lag
+		}
+		key := [3]string{cp.Bucket, cp.AccountID, cp.Region}
+		row.Denied = deniedByKey[key]
+		delete(deniedByKey, key)
+		rows = append(rows, row)
+	}
+	// prefixes denied before they ever got a checkpoint
+	for _, d := range denied {
+		if deniedByKey[[3]string{d.Bucket, d.AccountID, d.Region}] != nil {
+			rows = append(rows, checkpointRow{
+				Checkpoint: state.Checkpoint{Bucket: d.Bucket, AccountID: d.AccountID, Region: d.Region},
+				Denied:     &d,
+			})
+		}
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUCKET\tACCOUNT\tREGION\tCHECKPOINT\tLAG\tWATERMARK\tPROCESSED\tEVENTS\tDUP%\tINVALID%\tFILTERED%\tLAST UPDATED\tLAST KEY")
+	for _, row := range rows {
+		if row.LastUpdated.IsZero() {
+			// no checkpoint yet, only in the denied list below
+			continue
+		}
+		checkpoint, lag := "-", "-"
+		if row.CheckpointTime != nil {
+			checkpoint = row.CheckpointTime.Format(time.RFC3339)
+			lag = (time.Duration(*row.LagSeconds) * time.Second).String()
+		}
+		watermark := "-"
+		if !row.EventWatermark.IsZero() {
+			watermark = row.EventWatermark.Format(time.RFC3339)
+		}
+		events, dup, invalid, filtered := "-", "-", "-", "-"
+		if m := row.LastRun; m != nil {
+			events = fmt.Sprint(m.Events)
+			dup = percent(m.Duplicate, m.Events)
+			invalid = percent(m.Invalid, m.Events)
+			filtered = percent(m.Filtered, m.Events)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			row.Bucket, row.AccountID, row.Region, checkpoint, lag, watermark, row.ProcessedCount,
+			events, dup, invalid, filtered,
+			row.LastUpdated.Format(time.RFC3339), row.LastProcessedKey)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(denied) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Skipped for AccessDenied, re-tested each run:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUCKET\tACCOUNT\tREGION\tRUNS\tFIRST DENIED\tLAST DENIED\tERROR")
+	for _, d := range denied {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\t%s\n",
+			d.Bucket, d.AccountID, d.Region, d.Runs,
+			d.FirstDenied.Format(time.RFC3339), d.LastDenied.Format(time.RFC3339), d.Error)
+	}
+	return tw.Flush()
+}
+
+func printCounters(w io.Writer, counters []state.Counter, asJSON bool) error {
+	if asJSON {
+		if counters == nil {
+			counters = []state.Counter{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(counters)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "COUNTER\tTOTAL\tLAST RUN\tLAST UPDATED")
+	for _, c := range counters {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%s\n", c.Name, c.Total, c.LastRun, c.UpdatedAt.Format(time.RFC3339))
+	}
+	return tw.Flush()
+}
+
+func printRuns(w io.Writer, runs []state.Run, asJSON bool) error {
+	if asJSON {
+		if runs == nil {
+			runs = []state.Run{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(runs)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tCOMMAND\tSTATUS\tSTARTED\tDURATION\tRANGE\tTRAILS\tFILES\tEVENTS\tWRITTEN\tERRORS\tCONFIG\tERROR")
+	for _, r := range runs {
+		duration := "-"
+		if !r.EndedAt.IsZero() {
+			duration = r.EndedAt.Sub(r.StartedAt).Round(time.Second).String()
+		}
+		rangeStart, rangeEnd := "*", "*"
+		if !r.RangeStart.IsZero() {
+			rangeStart = r.RangeStart.Format(time.RFC3339)
+		}
+		if !r.RangeEnd.IsZero() {
+			rangeEnd = r.RangeEnd.Format(time.RFC3339)
+		}
+		configHash := r.ConfigHash
+		if len(configHash) > 12 {
+			configHash = configHash[:12]
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n",
+			r.ID, r.Command, r.Status, r.StartedAt.Format(time.RFC3339), duration,
+			rangeStart+".."+rangeEnd, strings.Join(r.Trails, ","),
+			r.Totals["files_processed"], r.Totals["events_processed"], r.Totals["events_written"], r.Totals["errors"],
+			configHash, r.Error)
+	}
+	return tw.Flush()
+}
+
+func percent(n, total int64) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", float64(n)*100/float64(total))
+}
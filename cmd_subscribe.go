@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/hub"
+	"github.com/deceptiq/gocloudtrail/internal/rpc"
+)
+
+func newSubscribeCmd(a *app) *cobra.Command {
+	var addr, token, caFile, name string
+	var useTLS bool
+	var match, exclude []string
+
+	cmd := &cobra.Command{
+		Use:   "subscribe",
+		Short: "Stream events from a running collector's grpc sink as NDJSON",
+		Long: "Subscribe to a grpc sink and print each event it streams as a line of JSON.\n" +
+			"--match and --not take field=value like a detection rule's match and not fields:\n" +
+			"dotted names reach into objects, * and ? are wildcards, and repeating a field\n" +
+			"matches any of its values.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req := hub.SubscribeRequest{Name: name}
+			var err error
+			if req.Match, err = parseFieldValues(match); err != nil {
+				return fmt.Errorf("--match: %w", err)
+			}
+			if req.Not, err = parseFieldValues(exclude); err != nil {
+				return fmt.Errorf("--not: %w", err)
+			}
+
+			conn, err := rpc.Dial(addr, useTLS, caFile)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+
+			out := bufio.NewWriter(os.Stdout)
+			defer out.Flush()
+			err = hub.Subscribe(cmd.Context(), conn, token, req, func(event json.RawMessage) error {
+				out.Write(event)
+				out.WriteByte('\n')
+				// a consumer piping into another tool wants events as they come
+				return out.Flush()
+			})
+			if err != nil && !errors.Is(cmd.Context().Err(), context.Canceled) {
+				return fmt.Errorf("subscription failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", "", "host:port of the grpc sink")
+	cmd.Flags().StringVar(&token, "token", "", "Bearer token the sink requires")
+	cmd.Flags().BoolVar(&useTLS, "tls", false, "Connect with TLS")
+	cmd.Flags().StringVar(&caFile, "ca-file", "", "CA to verify the sink against (default system roots)")
+	cmd.Flags().StringVar(&name, "name", "", "Name shown in the collector's logs")
+	cmd.Flags().StringArrayVar(&match, "match", nil, "field=value an event must match, repeatable")
+	cmd.Flags().StringArrayVar(&exclude, "not", nil, "field=value an event must not match, repeatable")
+	_ = cmd.MarkFlagRequired("addr")
+
+	return cmd
+}
+
+// parseFieldValues turns field=value pairs into a rule's fields, gathering
+// a repeated field's values into a list
+func parseFieldValues(pairs []string) (map[string]any, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+	fields := make(map[string]any)
+	for _, pair := range pairs {
+		field, value, ok := strings.Cut(pair, "=")
+		if !ok || field == "" {
+			return nil, fmt.Errorf("%q isn't field=value", pair)
+		}
+		switch prev := fields[field].(type) {
+		case nil:
+			fields[field] = value
+		case string:
+			fields[field] = []any{prev, value}
+		case []any:
+			fields[field] = append(prev, value)
+		}
+	}
+	return fields, nil
+}
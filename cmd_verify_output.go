@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+func newVerifyOutputCmd(a *app) *cobra.Command {
+	var opts processor.VerifyOptions
+
+	cmd := &cobra.Command{
+		Use:   "verify-output",
+		Short: "Check processed S3 objects against local output",
+		Long: "Re-download a sample of checkpointed S3 objects and confirm every record is present in the local output.\n" +
+			"Exits non-zero when events are missing.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			appCfg, err := a.loadConfig()
+			if err != nil {
+				return err
+			}
+
+			for _, f := range append(outputFormats(appCfg), writer.Format(appCfg.OutputFormat)) {
+				if f == writer.FormatCSV {
+					return fmt.Errorf("verify-output can't check csv output, which doesn't keep event IDs")
+				}
+			}
+
+			// uploaded output is deleted locally, so every event would read
+			// as missing
+			if appCfg.SpillUpload {
+				return fmt.Errorf("verify-output can't check spilled output, which is uploaded and removed locally")
+			}
+			if appCfg.StreamOnly {
+				return fmt.Errorf("verify-output can't check stream_only output, which isn't written locally")
+			}
+			if appCfg.RetentionDays > 0 {
+				opts.PrunedBefore = time.Now().UTC().AddDate(0, 0, -appCfg.RetentionDays)
+			}
+
+			proc, err := a.newProcessor(cmd.Context(), appCfg)
+			if err != nil {
+				return err
+			}
+
+			report, err := proc.Verify(cmd.Context(), opts)
+			if report == nil || (err != nil && err != context.Canceled) {
+				return fmt.Errorf("verification failed: %w", err)
+			}
+
+			a.logger.Info("verification complete",
+				slog.Int64("objects_checked", report.ObjectsChecked.Load()),
+				slog.Int64("objects_failed", report.ObjectsFailed.Load()),
+				slog.Int64("objects_with_loss", report.ObjectsWithLoss.Load()),
+				slog.Int64("events_checked", report.EventsChecked.Load()),
+				slog.Int64("events_skipped", report.EventsSkipped.Load()),
+				slog.Int64("events_missing", report.EventsMissing.Load()),
+				slog.Int64("missing_in_bloom", report.MissingInBloom.Load()),
+				slog.Int64("missing_not_seen", report.MissingNotSeen.Load()))
+
+			if report.EventsMissing.Load() > 0 || report.ObjectsFailed.Load() > 0 {
+				return fmt.Errorf("%d events missing, %d objects could not be checked",
+					report.EventsMissing.Load(), report.ObjectsFailed.Load())
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Float64Var(&opts.SampleRate, "sample-rate", 1, "Fraction of processed objects to verify (1 = all)")
+	cmd.Flags().IntVar(&opts.MaxObjects, "max-objects", 0, "Stop after verifying this many objects (0 = no limit)")
+
+	return cmd
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/spf13/cobra"
+)
+
+// set at build time with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+func newVersionCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "version",
+		Short: "Print version and build information",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			out := cmd.OutOrStdout()
+			v, revision, buildTime, modified := buildInfo()
+
+			fmt.Fprintf(out, "gocloudtrail %s\n", v)
+			if revision != "" {
+				fmt.Fprintf(out, "  commit:     %s%s\n", revision, modified)
+			}
+			if buildTime != "" {
+				fmt.Fprintf(out, "  built:      %s\n", buildTime)
+			}
+			fmt.Fprintf(out, "  go version: %s\n", runtime.Version())
+			fmt.Fprintf(out, "  platform:   %s/%s\n", runtime.GOOS, runtime.GOARCH)
+		},
+	}
+}
+
+// buildInfo reads version control details embedded by the Go toolchain
+func buildInfo() (v, revision, buildTime, modified string) {
+	v = version
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v, "", "", ""
+	}
+
+	// go install pkg@version records the module version
+	if v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.time":
+			buildTime = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				modified = " (modified)"
+			}
+		}
+	}
+	return v, revision, buildTime, modified
+}
@@ -0,0 +1,146 @@
+package event
+
+import "encoding/json"
+
+// Identity types
+const (
+	IdentityRoot           = "Root"
+	IdentityIAMUser        = "IAMUser"
+	IdentityAssumedRole    = "AssumedRole"
+	IdentityRole           = "Role"
+	IdentityFederatedUser  = "FederatedUser"
+	IdentityDirectory      = "Directory"
+	IdentityIdentityCenter = "IdentityCenterUser"
+	IdentityAWSAccount     = "AWSAccount"
+	IdentityAWSService     = "AWSService"
+	IdentityUnknown        = "Unknown"
+)
+
+// UserIdentity is who made the call
+type UserIdentity struct {
+	Type             string          `json:"type,omitempty"`
+	PrincipalID      string          `json:"principalId,omitempty"`
+	ARN              string          `json:"arn,omitempty"`
+	AccountID        string          `json:"accountId,omitempty"`
+	AccessKeyID      string          `json:"accessKeyId,omitempty"`
+	UserName         string          `json:"userName,omitempty"`
+	InvokedBy        string          `json:"invokedBy,omitempty"`
+	IdentityProvider string          `json:"identityProvider,omitempty"`
+	CredentialID     string          `json:"credentialId,omitempty"`
+	SessionContext   *SessionContext `json:"sessionContext,omitempty"`
+	// the IAM Identity Center user a call was made for
+	OnBehalfOf *OnBehalfOf `json:"onBehalfOf,omitempty"`
+	// the resource a service-linked call was scoped to
+	InScopeOf *InScopeOf `json:"inScopeOf,omitempty"`
+}
+
+// SessionContext describes the temporary credentials a call used
+type SessionContext struct {
+	SessionIssuer       *SessionIssuer       `json:"sessionIssuer,omitempty"`
+	WebIDFederationData *WebIDFederationData `json:"webIdFederationData,omitempty"`
+	Attributes          *SessionAttributes   `json:"attributes,omitempty"`
+	SourceIdentity      string               `json:"sourceIdentity,omitempty"`
+	EC2RoleDelivery     string               `json:"ec2RoleDelivery,omitempty"`
+	// "true" for a root session assumed with sts:AssumeRoot
+	AssumedRoot *Bool `json:"assumedRoot,omitempty"`
+}
+
+// SessionIssuer is the role or user whose credentials the session came from
+type SessionIssuer struct {
+	Type        string `json:"type,omitempty"`
+	PrincipalID string `json:"principalId,omitempty"`
+	ARN         string `json:"arn,omitempty"`
+	AccountID   string `json:"accountId,omitempty"`
+	UserName    string `json:"userName,omitempty"`
+}
+
+// WebIDFederationData is the identity provider behind a web identity session
+type WebIDFederationData struct {
+	FederatedProvider string            `json:"federatedProvider,omitempty"`
+	Attributes        map[string]string `json:"attributes,omitempty"`
+}
+
+// SessionAttributes are when the session began and whether MFA vouched for it
+type SessionAttributes struct {
+	CreationDate     string `json:"creationDate,omitempty"`
+	MFAAuthenticated *Bool  `json:"mfaAuthenticated,omitempty"`
+}
+
+// OnBehalfOf is an IAM Identity Center user
+type OnBehalfOf struct {
+	UserID           string `json:"userId,omitempty"`
+	IdentityStoreARN string `json:"identityStoreArn,omitempty"`
+}
+
+// InScopeOf is the resource and account a service's call was scoped to
+type InScopeOf struct {
+	SourceARN           string `json:"sourceArn,omitempty"`
+	SourceAccount       string `json:"sourceAccount,omitempty"`
+	IssuerType          string `json:"issuerType,omitempty"`
+	CredentialsIssuedTo string `json:"credentialsIssuedTo,omitempty"`
+}
+
+// MFAUsed reports whether the session was MFA-authenticated
+func (u *UserIdentity) MFAUsed() bool {
+	return u != nil && u.SessionContext != nil && u.SessionContext.Attributes != nil &&
+		u.SessionContext.Attributes.MFAAuthenticated.True()
+}
+
+// Issuer returns the ARN of the role or user behind an assumed role
+// session, or the identity's own ARN
+func (u *UserIdentity) Issuer() string {
+	if u == nil {
+		return ""
+	}
+	if u.SessionContext != nil && u.SessionContext.SessionIssuer != nil && u.SessionContext.SessionIssuer.ARN != "" {
+		return u.SessionContext.SessionIssuer.ARN
+	}
+	return u.ARN
+}
+
+// Bool is a boolean CloudTrail writes either as JSON true/false or as the
+// strings "true" and "false"; it's always written back as true/false
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		switch s {
+		case "true", "True", "TRUE":
+			*b = true
+			return nil
+		case "false", "False", "FALSE", "":
+			*b = false
+			return nil
+		}
+	}
+	var v bool
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*b = Bool(v)
+	return nil
+}
+
+// True reports whether b is set and true
+func (b *Bool) True() bool {
+	return b != nil && bool(*b)
+}
+
+// the nested objects decode field by field too, so a stray value inside,
+// say, sessionContext doesn't cost the whole userIdentity
+
+func (u *UserIdentity) UnmarshalJSON(data []byte) error {
+	type plain UserIdentity
+	return tolerant(data, (*plain)(u))
+}
+
+func (c *SessionContext) UnmarshalJSON(data []byte) error {
+	type plain SessionContext
+	return tolerant(data, (*plain)(c))
+}
+
+func (a *SessionAttributes) UnmarshalJSON(data []byte) error {
+	type plain SessionAttributes
+	return tolerant(data, (*plain)(a))
+}
@@ -0,0 +1,57 @@
+package event
+
+// InsightDetails describes an Insights event: unusual API call or error
+// rates against a baseline
+type InsightDetails struct {
+	// Start or End
+	State       string `json:"state,omitempty"`
+	EventSource string `json:"eventSource,omitempty"`
+	EventName   string `json:"eventName,omitempty"`
+	ErrorCode   string `json:"errorCode,omitempty"`
+	// ApiCallRateInsight or ApiErrorRateInsight
+	InsightType    string          `json:"insightType,omitempty"`
+	InsightContext *InsightContext `json:"insightContext,omitempty"`
+}
+
+// InsightContext is the numbers behind an Insights event
+type InsightContext struct {
+	Statistics   *InsightStatistics   `json:"statistics,omitempty"`
+	Attributions []InsightAttribution `json:"attributions,omitempty"`
+}
+
+// InsightStatistics compares the unusual period's average with the baseline
+type InsightStatistics struct {
+	Baseline         *InsightAverage `json:"baseline,omitempty"`
+	Insight          *InsightAverage `json:"insight,omitempty"`
+	InsightDuration  float64         `json:"insightDuration,omitempty"`
+	BaselineDuration float64         `json:"baselineDuration,omitempty"`
+}
+
+// InsightAverage is an average rate per minute
+type InsightAverage struct {
+	Average float64 `json:"average"`
+}
+
+// InsightAttribution breaks an Insights event down by userIdentityArn,
+// userAgent or errorCode
+type InsightAttribution struct {
+	Attribute string               `json:"attribute,omitempty"`
+	Insight   []InsightContributor `json:"insight,omitempty"`
+	Baseline  []InsightContributor `json:"baseline,omitempty"`
+}
+
+// InsightContributor is one value of an attribution and its average rate
+type InsightContributor struct {
+	Value   string  `json:"value"`
+	Average float64 `json:"average"`
+}
+
+func (d *InsightDetails) UnmarshalJSON(data []byte) error {
+	type plain InsightDetails
+	return tolerant(data, (*plain)(d))
+}
+
+func (c *InsightContext) UnmarshalJSON(data []byte) error {
+	type plain InsightContext
+	return tolerant(data, (*plain)(c))
+}
@@ -0,0 +1,320 @@
+// Package event is a typed model of CloudTrail records, event versions 1.08
+// through 1.11, covering management, data, Insights and network activity
+// events. Older records decode too, with the fields they lack left empty.
+//
+// Decoding is tolerant: a field whose value doesn't have the expected type
+// is left zero rather than failing the whole record, and named in
+// Record.Malformed when it's a top-level one. Top-level fields the model
+// doesn't know, such as ones added by later event versions, are kept in
+// Record.Extra and written back out by MarshalJSON.
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is one CloudTrail event. Free-form, service-specific fields stay
+// raw JSON for the caller to decode.
+type Record struct {
+	EventVersion        string          `json:"eventVersion"`
+	EventTime           string          `json:"eventTime"`
+	EventID             string          `json:"eventID"`
+	EventName           string          `json:"eventName,omitempty"`
+	EventSource         string          `json:"eventSource,omitempty"`
+	EventType           string          `json:"eventType,omitempty"`
+	EventCategory       string          `json:"eventCategory,omitempty"`
+	AWSRegion           string          `json:"awsRegion"`
+	UserIdentity        *UserIdentity   `json:"userIdentity,omitempty"`
+	SourceIPAddress     string          `json:"sourceIPAddress,omitempty"`
+	UserAgent           string          `json:"userAgent,omitempty"`
+	ErrorCode           string          `json:"errorCode,omitempty"`
+	ErrorMessage        string          `json:"errorMessage,omitempty"`
+	RequestParameters   json.RawMessage `json:"requestParameters,omitempty"`
+	ResponseElements    json.RawMessage `json:"responseElements,omitempty"`
+	AdditionalEventData json.RawMessage `json:"additionalEventData,omitempty"`
+	ServiceEventDetails json.RawMessage `json:"serviceEventDetails,omitempty"`
+	RequestID           string          `json:"requestID,omitempty"`
+	APIVersion          string          `json:"apiVersion,omitempty"`
+	ReadOnly            *Bool           `json:"readOnly,omitempty"`
+	ManagementEvent     *Bool           `json:"managementEvent,omitempty"`
+	Resources           []Resource      `json:"resources,omitempty"`
+	RecipientAccountID  string          `json:"recipientAccountId,omitempty"`
+	SharedEventID       string          `json:"sharedEventID,omitempty"`
+	VPCEndpointID       string          `json:"vpcEndpointId,omitempty"`
+	VPCEndpointAccount  string          `json:"vpcEndpointAccountId,omitempty"`
+	Addendum            *Addendum       `json:"addendum,omitempty"`
+	// "true" when the call used credentials from a console session
+	SessionCredentialFromConsole *Bool           `json:"sessionCredentialFromConsole,omitempty"`
+	EdgeDeviceDetails            json.RawMessage `json:"edgeDeviceDetails,omitempty"`
+	TLSDetails                   *TLSDetails     `json:"tlsDetails,omitempty"`
+	InsightDetails               *InsightDetails `json:"insightDetails,omitempty"`
+
+	// fields the model doesn't know, as they were
+	Extra map[string]json.RawMessage `json:"-"`
+	// known fields whose values had the wrong type and were left zero
+	Malformed []string `json:"-"`
+}
+
+// Resource is an AWS resource the event acted on
+type Resource struct {
+	ARN       string `json:"ARN,omitempty"`
+	AccountID string `json:"accountId,omitempty"`
+	Type      string `json:"type,omitempty"`
+}
+
+// Addendum explains a record delivered late or corrected after delivery
+type Addendum struct {
+	Reason            string `json:"reason,omitempty"`
+	UpdatedFields     string `json:"updatedFields,omitempty"`
+	OriginalRequestID string `json:"originalRequestID,omitempty"`
+	OriginalEventID   string `json:"originalEventID,omitempty"`
+}
+
+// TLSDetails is the TLS a call was made over
+type TLSDetails struct {
+	TLSVersion               string `json:"tlsVersion,omitempty"`
+	CipherSuite              string `json:"cipherSuite,omitempty"`
+	ClientProvidedHostHeader string `json:"clientProvidedHostHeader,omitempty"`
+}
+
+// LatestVersion is the newest eventVersion the model knows the fields of
+const LatestVersion = "1.11"
+
+// ParseVersion splits an eventVersion into its major and minor numbers,
+// false when it isn't of the form major.minor
+func ParseVersion(version string) (major, minor int, ok bool) {
+	maj, min, found := strings.Cut(version, ".")
+	if !found {
+		return 0, 0, false
+	}
+	var err1, err2 error
+	major, err1 = strconv.Atoi(maj)
+	minor, err2 = strconv.Atoi(min)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// NewerThanKnown reports whether version is a later eventVersion than
+// LatestVersion; one that doesn't parse isn't
+func NewerThanKnown(version string) bool {
+	major, minor, ok := ParseVersion(version)
+	if !ok {
+		return false
+	}
+	latestMajor, latestMinor, _ := ParseVersion(LatestVersion)
+	return major > latestMajor || major == latestMajor && minor > latestMinor
+}
+
+// Event categories
+const (
+	CategoryManagement      = "Management"
+	CategoryData            = "Data"
+	CategoryInsight         = "Insight"
+	CategoryNetworkActivity = "NetworkActivity"
+)
+
+// Parse decodes a record, tolerating fields of the wrong type; it fails
+// only when data isn't a JSON object
+func Parse(data []byte) (*Record, error) {
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Time parses EventTime with ParseTime
+func (r *Record) Time() (time.Time, error) {
+	t, err := ParseTime(r.EventTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("eventTime: %w", err)
+	}
+	return t, nil
+}
+
+// eventTime layouts besides RFC 3339, which CloudTrail itself sends; the
+// ones without a zone are taken as UTC
+var timeLayouts = []struct {
+	layout string
+	zoned  bool
+}{
+	{"2006-01-02T15:04:05.999999999Z0700", true},
+	{"2006-01-02 15:04:05.999999999Z07:00", true},
+	{"2006-01-02T15:04:05.999999999", false},
+	{"2006-01-02 15:04:05.999999999", false},
+}
+
+// ParseTime parses an eventTime in UTC. Besides RFC 3339 it takes what
+// re-delivery pipelines and hand-built records turn up with: a missing Z, a
+// space for the T, an offset without a colon, and fractional seconds in
+// any of them.
+func ParseTime(s string) (time.Time, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	for _, l := range timeLayouts {
+		if l.zoned {
+			if t, lerr := time.Parse(l.layout, s); lerr == nil {
+				return t.UTC(), nil
+			}
+		} else if t, lerr := time.ParseInLocation(l.layout, s, time.UTC); lerr == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// Category returns EventCategory, which records before 1.08 lack:
+// Management unless it's an Insights event
+func (r *Record) Category() string {
+	switch {
+	case r.EventCategory != "":
+		return r.EventCategory
+	case r.InsightDetails != nil || r.EventType == "AwsCloudTrailInsight":
+		return CategoryInsight
+	}
+	return CategoryManagement
+}
+
+// Version returns EventVersion's major and minor numbers, false when it
+// isn't of the form major.minor
+func (r *Record) Version() (major, minor int, ok bool) {
+	return ParseVersion(r.EventVersion)
+}
+
+// NewerThanKnown reports whether the record's eventVersion is later than
+// LatestVersion, so it may carry fields the model doesn't know
+func (r *Record) NewerThanKnown() bool {
+	return NewerThanKnown(r.EventVersion)
+}
+
+// AccountID returns the account the record was delivered for, falling back
+// to the caller's
+func (r *Record) AccountID() string {
+	if r.RecipientAccountID != "" {
+		return r.RecipientAccountID
+	}
+	if r.UserIdentity != nil {
+		return r.UserIdentity.AccountID
+	}
+	return ""
+}
+
+// UnmarshalJSON decodes each known field on its own, so one of the wrong
+// type doesn't lose the rest
+func (r *Record) UnmarshalJSON(data []byte) error {
+	type plain Record
+	extra, malformed, err := decodeTolerant(data, (*plain)(r))
+	if err != nil {
+		return err
+	}
+	r.Extra, r.Malformed = extra, malformed
+	return nil
+}
+
+// MarshalJSON writes the known fields, then Extra in key order
+func (r Record) MarshalJSON() ([]byte, error) {
+	type plain Record
+	data, err := json.Marshal(plain(r))
+	if err != nil || len(r.Extra) == 0 {
+		return data, err
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:len(data)-1])
+	known := jsonNames(reflect.TypeFor[Record]())
+	for _, key := range slices.Sorted(maps.Keys(r.Extra)) {
+		if known[key] {
+			continue
+		}
+		name, _ := json.Marshal(key)
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(r.Extra[key])
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+var errNotObject = errors.New("event: value is not a JSON object")
+
+// decodeTolerant decodes a JSON object into the struct v points to one field
+// at a time by json tag, leaving values of the wrong type zero. It returns
+// the keys v has no field for, with their values, and the keys that didn't
+// decode, failing only when data isn't an object.
+func decodeTolerant(data []byte, v any) (map[string]json.RawMessage, []string, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return nil, nil, errNotObject
+	}
+	rv := reflect.ValueOf(v).Elem()
+	rv.SetZero()
+	targets := make(map[string]reflect.Value, rv.NumField())
+	for i := range rv.NumField() {
+		if name := jsonName(rv.Type().Field(i)); name != "" {
+			targets[name] = rv.Field(i)
+		}
+	}
+
+	var extra map[string]json.RawMessage
+	var malformed []string
+	for key, value := range fields {
+		target, ok := targets[key]
+		if !ok {
+			if extra == nil {
+				extra = make(map[string]json.RawMessage)
+			}
+			extra[key] = value
+			continue
+		}
+		if err := json.Unmarshal(value, target.Addr().Interface()); err != nil {
+			target.SetZero()
+			malformed = append(malformed, key)
+		}
+	}
+	slices.Sort(malformed)
+	return extra, malformed, nil
+}
+
+// tolerant is decodeTolerant for nested objects, whose unknown and
+// malformed fields are dropped
+func tolerant(data []byte, v any) error {
+	_, _, err := decodeTolerant(data, v)
+	return err
+}
+
+// jsonName returns the key a struct field is encoded under, empty when it
+// isn't
+func jsonName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	if name == "-" || !f.IsExported() {
+		return ""
+	}
+	if name == "" {
+		return f.Name
+	}
+	return name
+}
+
+func jsonNames(t reflect.Type) map[string]bool {
+	names := make(map[string]bool, t.NumField())
+	for i := range t.NumField() {
+		if name := jsonName(t.Field(i)); name != "" {
+			names[name] = true
+		}
+	}
+	return names
+}
@@ -0,0 +1,159 @@
+// Package admin serves a small HTTP API for controlling a running processor:
+// pausing and resuming, limiting workers and download rate, and reporting
+// stats, per-trail progress and per account/region event watermarks
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+// Status is the response of GET /status
+type Status struct {
+	Control processor.ControlStatus `json:"control"`
+	Stats   processor.StatsSnapshot `json:"stats"`
+	Trails  []processor.TrailStatus `json:"trails"`
+}
+
+// Start listens on addr and serves the API until ctx is done. With token
+// set, requests must carry it as a bearer token.
+func Start(ctx context.Context, addr, token string, proc *processor.Processor, logger *slog.Logger) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("admin listen: %w", err)
+	}
+
+	srv := &http.Server{
+		Handler:           Handler(proc, token, logger),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("admin server failed", slog.String("error", err.Error()))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("admin API listening", slog.String("addr", ln.Addr().String()))
+	return nil
+}
+
+// Handler returns the API's routes
+func Handler(proc *processor.Processor, token string, logger *slog.Logger) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Status{
+			Control: proc.Control(),
+			Stats:   proc.Stats().Snapshot(),
+			Trails:  proc.Trails(),
+		})
+	})
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, proc.Stats().Snapshot())
+	})
+	mux.HandleFunc("GET /trails", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, proc.Trails())
+	})
+	mux.HandleFunc("GET /watermarks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, proc.Watermarks())
+	})
+
+	mux.HandleFunc("POST /pause", func(w http.ResponseWriter, r *http.Request) {
+		proc.Pause()
+		logger.Info("paused through admin API")
+		writeJSON(w, http.StatusOK, proc.Control())
+	})
+	mux.HandleFunc("POST /resume", func(w http.ResponseWriter, r *http.Request) {
+		proc.Resume()
+		logger.Info("resumed through admin API")
+		writeJSON(w, http.StatusOK, proc.Control())
+	})
+
+	// POST /workers?download=N&process=N
+	mux.HandleFunc("POST /workers", func(w http.ResponseWriter, r *http.Request) {
+		download, err := intParam(r, "download")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		process, err := intParam(r, "process")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err := proc.SetWorkers(download, process); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.Info("worker limits changed through admin API",
+			slog.Int("download", download),
+			slog.Int("process", process))
+		writeJSON(w, http.StatusOK, proc.Control())
+	})
+
+	// POST /rate?downloads_per_second=X, 0 for no limit
+	mux.HandleFunc("POST /rate", func(w http.ResponseWriter, r *http.Request) {
+		rate, err := strconv.ParseFloat(r.URL.Query().Get("downloads_per_second"), 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("downloads_per_second: %w", err))
+			return
+		}
+		if err := proc.SetDownloadRate(rate); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		logger.Info("download rate changed through admin API", slog.Float64("downloads_per_second", rate))
+		writeJSON(w, http.StatusOK, proc.Control())
+	})
+
+	if token == "" {
+		return mux
+	}
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("missing or invalid bearer token"))
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// intParam parses an optional query parameter, zero when absent
+func intParam(r *http.Request, name string) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,104 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// Alert is one firing (or resolution) of an alert condition
+type Alert struct {
+	Condition string         `json:"condition"`
+	Message   string         `json:"message"`
+	Resolved  bool           `json:"resolved"`
+	Time      time.Time      `json:"time"`
+	Details   map[string]any `json:"details,omitempty"`
+}
+
+// Notifier delivers alerts to an external system
+type Notifier interface {
+	Notify(ctx context.Context, a Alert) error
+}
+
+// Webhook POSTs each alert as JSON to a URL
+type Webhook struct {
+	URL    string
+	Client *http.Client
+}
+
+func (w *Webhook) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post alert: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post alert: unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// SNS publishes each alert to a topic, with the JSON alert as the message
+type SNS struct {
+	Client   *sns.Client
+	TopicARN string
+}
+
+func (s *SNS) Notify(ctx context.Context, a Alert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal alert: %w", err)
+	}
+
+	subject := "gocloudtrail alert: " + a.Condition
+	if a.Resolved {
+		subject = "gocloudtrail resolved: " + a.Condition
+	}
+	_, err = s.Client.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.TopicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(string(body)),
+	})
+	if err != nil {
+		return fmt.Errorf("publish alert: %w", err)
+	}
+	return nil
+}
+
+// Multi sends each alert to every notifier
+type Multi []Notifier
+
+func (m Multi) Notify(ctx context.Context, a Alert) error {
+	var errs []error
+	for _, n := range m {
+		if err := n.Notify(ctx, a); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
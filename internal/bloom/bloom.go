@@ -4,20 +4,53 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/bits-and-blooms/bloom/v3"
 )
 
+// with a retention window the filter is split into this many generations
+// (plus the one being filled), so evicting one drops about a quarter of
+// the window at a time
+const generationsPerWindow = 4
+
 type Filter struct {
 	mu     sync.RWMutex
+	saveMu sync.Mutex
 	filter *bloom.BloomFilter
 	path   string
 	logger *slog.Logger
+
+	// set when a retention window is configured: one filter per span of
+	// event time, keyed by the span's index since the Unix epoch
+	retention     time.Duration
+	span          time.Duration
+	generations   map[int64]*generation
+	expectedItems uint
+	falsePositive float64
+	// a single-file filter from before retention was enabled, kept for
+	// lookups until everything in it has aged out
+	legacy      *bloom.BloomFilter
+	legacyUntil time.Time
+}
+
+type generation struct {
+	filter *bloom.BloomFilter
+	dirty  bool
 }
 
-// load the bloom filter from disk or create a new one
-func Load(path string, expectedItems uint, falsePositiveRate float64, logger *slog.Logger) (*Filter, error) {
+// Load reads the bloom filter from disk or creates a new one. With a
+// non-zero retention, event IDs are only remembered for events whose time is
+// within the window and each generation holds expectedItems/4 items.
+func Load(path string, expectedItems uint, falsePositiveRate float64, retention time.Duration, logger *slog.Logger) (*Filter, error) {
+	if retention > 0 {
+		return loadGenerations(path, expectedItems, falsePositiveRate, retention, logger)
+	}
+
 	file, err := os.Open(path)
 	if err == nil {
 		defer file.Close()
@@ -50,40 +83,240 @@ func Load(path string, expectedItems uint, falsePositiveRate float64, logger *sl
 	}, nil
 }
 
-func (f *Filter) Test(data []byte) bool {
+func loadGenerations(path string, expectedItems uint, falsePositiveRate float64, retention time.Duration, logger *slog.Logger) (*Filter, error) {
+	// whole days keep generation file names readable and stable
+	spanDays := max(int64((retention/generationsPerWindow+24*time.Hour-1)/(24*time.Hour)), 1)
+
+	f := &Filter{
+		path:          path,
+		logger:        logger,
+		retention:     retention,
+		span:          time.Duration(spanDays) * 24 * time.Hour,
+		generations:   make(map[int64]*generation),
+		expectedItems: max(expectedItems/generationsPerWindow, 1),
+		falsePositive: falsePositiveRate,
+	}
+
+	files, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("list bloom filter generations: %w", err)
+	}
+	for _, file := range files {
+		index, ok := f.generationIndex(file)
+		if !ok {
+			if !strings.HasSuffix(file, ".tmp") {
+				logger.Warn("ignoring bloom filter generation from a different retention setting",
+					slog.String("path", file))
+			}
+			continue
+		}
+		bf, err := readFilter(file, f.expectedItems, falsePositiveRate)
+		if err != nil {
+			logger.Warn("failed to read bloom filter generation, dropping it",
+				slog.String("path", file),
+				slog.String("error", err.Error()))
+			continue
+		}
+		f.generations[index] = &generation{filter: bf}
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if bf, err := readFilter(path, expectedItems, falsePositiveRate); err == nil {
+			f.legacy = bf
+			f.legacyUntil = info.ModTime().Add(retention)
+			logger.Info("keeping pre-retention bloom filter until it ages out",
+				slog.String("path", path),
+				slog.Time("until", f.legacyUntil))
+		}
+	}
+
+	f.evict(time.Now())
+	logger.Info("loaded bloom filter generations",
+		slog.String("path", path),
+		slog.Int("generations", len(f.generations)),
+		slog.Duration("retention", retention),
+		slog.Duration("generation_span", f.span))
+	return f, nil
+}
+
+func readFilter(path string, expectedItems uint, falsePositiveRate float64) (*bloom.BloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	bf := bloom.NewWithEstimates(expectedItems, falsePositiveRate)
+	if _, err := bf.ReadFrom(file); err != nil {
+		return nil, err
+	}
+	return bf, nil
+}
+
+// generationFile names a generation after its first day and span, e.g.
+// bloom.gob.20240101-23d
+func (f *Filter) generationFile(index int64) string {
+	start := time.Unix(0, 0).UTC().Add(time.Duration(index) * f.span)
+	return fmt.Sprintf("%s.%s-%dd", f.path, start.Format("20060102"), int64(f.span/(24*time.Hour)))
+}
+
+func (f *Filter) generationIndex(file string) (int64, bool) {
+	name := strings.TrimPrefix(file, f.path+".")
+	start, days, ok := strings.Cut(name, "-")
+	if !ok || !strings.HasSuffix(days, "d") {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSuffix(days, "d"), 10, 64)
+	if err != nil || time.Duration(n)*24*time.Hour != f.span {
+		return 0, false
+	}
+	t, err := time.Parse("20060102", start)
+	if err != nil {
+		return 0, false
+	}
+	return f.index(t), true
+}
+
+func (f *Filter) index(t time.Time) int64 {
+	return t.Unix() / int64(f.span/time.Second)
+}
+
+// expired reports whether events at t are outside the retention window
+func (f *Filter) expired(t time.Time, now time.Time) bool {
+	return f.index(t) < f.index(now.Add(-f.retention))
+}
+
+// Test reports whether the event ID may have been added. Events older than
+// the retention window are never reported as seen.
+func (f *Filter) Test(data []byte, eventTime time.Time) bool {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
-	return f.filter.Test(data)
+
+	if f.retention == 0 {
+		return f.filter.Test(data)
+	}
+	if f.legacy != nil && f.legacy.Test(data) {
+		return true
+	}
+	if f.expired(eventTime, time.Now()) {
+		return false
+	}
+	g, ok := f.generations[f.index(eventTime)]
+	return ok && g.filter.Test(data)
 }
 
-func (f *Filter) Add(data []byte) {
+func (f *Filter) Add(data []byte, eventTime time.Time) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	f.filter.Add(data)
+
+	if f.retention == 0 {
+		f.filter.Add(data)
+		return
+	}
+	if f.expired(eventTime, time.Now()) {
+		return
+	}
+
+	index := f.index(eventTime)
+	g, ok := f.generations[index]
+	if !ok {
+		g = &generation{filter: bloom.NewWithEstimates(f.expectedItems, f.falsePositive)}
+		f.generations[index] = g
+	}
+	g.filter.Add(data)
+	g.dirty = true
+}
+
+// evict drops generations (and the legacy filter) that have aged out of the
+// retention window and deletes their files
+func (f *Filter) evict(now time.Time) {
+	for index := range f.generations {
+		if index >= f.index(now.Add(-f.retention)) {
+			continue
+		}
+		delete(f.generations, index)
+		file := f.generationFile(index)
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			f.logger.Warn("failed to remove expired bloom filter generation",
+				slog.String("path", file),
+				slog.String("error", err.Error()))
+			continue
+		}
+		f.logger.Info("evicted expired bloom filter generation", slog.String("path", file))
+	}
+
+	if f.legacy != nil && now.After(f.legacyUntil) {
+		f.legacy = nil
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			f.logger.Warn("failed to remove expired bloom filter",
+				slog.String("path", f.path),
+				slog.String("error", err.Error()))
+		}
+	}
 }
 
 func (f *Filter) Save() error {
-	tmpFile := f.path + ".tmp"
-	file, err := os.Create(tmpFile)
-	if err != nil {
-		return fmt.Errorf("create temp file: %w", err)
+	if f.retention > 0 {
+		return f.saveGenerations()
 	}
 
 	f.mu.RLock()
-	_, err = f.filter.WriteTo(file)
+	err := writeFilter(f.path, f.filter)
 	f.mu.RUnlock()
+	if err != nil {
+		return err
+	}
 
+	f.logger.Debug("saved bloom filter", slog.String("path", f.path))
+	return nil
+}
+
+// saveGenerations evicts expired generations and writes the ones that
+// changed since the last save
+func (f *Filter) saveGenerations() error {
+	f.saveMu.Lock()
+	defer f.saveMu.Unlock()
+
+	f.mu.Lock()
+	f.evict(time.Now())
+	f.mu.Unlock()
+
+	// a read lock blocks Add, the only other writer of dirty, while still
+	// letting lookups through
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for index, g := range f.generations {
+		if !g.dirty {
+			continue
+		}
+		if err := writeFilter(f.generationFile(index), g.filter); err != nil {
+			return err
+		}
+		g.dirty = false
+	}
+
+	f.logger.Debug("saved bloom filter generations",
+		slog.String("path", f.path),
+		slog.Int("generations", len(f.generations)))
+	return nil
+}
+
+func writeFilter(path string, filter *bloom.BloomFilter) error {
+	tmpFile := path + ".tmp"
+	file, err := os.Create(tmpFile)
 	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+
+	if _, err := filter.WriteTo(file); err != nil {
 		file.Close()
 		return fmt.Errorf("write bloom filter: %w", err)
 	}
 
 	file.Close()
 
-	if err := os.Rename(tmpFile, f.path); err != nil {
+	if err := os.Rename(tmpFile, path); err != nil {
 		return fmt.Errorf("rename bloom filter: %w", err)
 	}
-
-	f.logger.Debug("saved bloom filter", slog.String("path", f.path))
 	return nil
 }
@@ -0,0 +1,468 @@
+// Package bundle packs a collector's position (its state database, dedup
+// data and output file counters) into one versioned archive, and unpacks it
+// on another host, so a collector can move without reprocessing or
+// duplicating anything
+package bundle
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// FormatVersion is the archive layout this build writes and the newest it
+// reads
+const FormatVersion = 1
+
+// entries of the archive; dedup files go under dedupDir, named after the
+// exporting host's bloom_file
+const (
+	manifestName = "manifest.json"
+	stateName    = "state.db"
+	countersName = "file_counters.json"
+	dedupDir     = "dedup/"
+)
+
+// Manifest describes a bundle. It's the archive's first entry.
+type Manifest struct {
+	FormatVersion int       `json:"format_version"`
+	CreatedAt     time.Time `json:"created_at"`
+	// gocloudtrail version that wrote it
+	Version string `json:"version"`
+	Host    string `json:"host"`
+	// of the state database
+	SchemaVersion int `json:"schema_version"`
+	// base name of bloom_file on the exporting host
+	BloomFile string `json:"bloom_file"`
+	// every other entry, with its size and SHA-256
+	Files []File `json:"files"`
+}
+
+// File is one entry of a bundle
+type File struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// ExportOptions says where a collector keeps its position
+type ExportOptions struct {
+	StateDB   string
+	BloomFile string
+	// the output dirs whose file counters are kept
+	EventsDirs []string
+	Version    string
+}
+
+// Export writes a bundle of the collector's position to path. The state
+// database is snapshotted, so it can be exported while a run is using it;
+// the dedup data is as of its last save.
+func Export(path string, opts ExportOptions, logger *slog.Logger) (*Manifest, error) {
+	if _, err := os.Stat(opts.StateDB); err != nil {
+		return nil, fmt.Errorf("state database: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp("", "gocloudtrail-bundle-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	db, err := state.Open(opts.StateDB, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open state database: %w", err)
+	}
+	defer db.Close()
+	snapshot := filepath.Join(tmpDir, stateName)
+	if err := db.Snapshot(snapshot); err != nil {
+		return nil, err
+	}
+	schema, err := db.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	// the files in each partition now, or a higher number recorded by an
+	// earlier import
+	counters := make(map[string]map[string]int)
+	for _, dir := range opts.EventsDirs {
+		next, err := writer.NextFileNumbers(dir)
+		if err != nil {
+			return nil, fmt.Errorf("scan %s: %w", dir, err)
+		}
+		recorded, err := db.FileCounters(dir)
+		if err != nil {
+			return nil, err
+		}
+		for partition, n := range recorded {
+			next[partition] = max(next[partition], n)
+		}
+		if len(next) > 0 {
+			counters[dir] = next
+		}
+	}
+	countersData, err := json.Marshal(counters)
+	if err != nil {
+		return nil, err
+	}
+	countersFile := filepath.Join(tmpDir, countersName)
+	if err := os.WriteFile(countersFile, countersData, 0o600); err != nil {
+		return nil, fmt.Errorf("write file counters: %w", err)
+	}
+
+	sources := map[string]string{stateName: snapshot, countersName: countersFile}
+	names := []string{stateName, countersName}
+	dedupFiles, err := dedupFiles(opts.BloomFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range dedupFiles {
+		name := dedupDir + filepath.Base(file)
+		sources[name] = file
+		names = append(names, name)
+	}
+
+	host, _ := os.Hostname()
+	m := &Manifest{
+		FormatVersion: FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Version:       opts.Version,
+		Host:          host,
+		SchemaVersion: schema,
+		BloomFile:     filepath.Base(opts.BloomFile),
+	}
+	for _, name := range names {
+		size, sum, err := hashFile(sources[name])
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, File{Name: name, Size: size, SHA256: sum})
+	}
+
+	if err := writeArchive(path, m, sources); err != nil {
+		return nil, err
+	}
+	logger.Info("exported state bundle",
+		slog.String("path", path),
+		slog.Int("files", len(m.Files)),
+		slog.Int("dedup_files", len(dedupFiles)),
+		slog.Int("events_dirs", len(counters)))
+	return m, nil
+}
+
+// dedupFiles lists bloom_file, its generations and an exact set kept next
+// to it, whichever exist
+func dedupFiles(bloomFile string) ([]string, error) {
+	var files []string
+	if _, err := os.Stat(bloomFile); err == nil {
+		files = append(files, bloomFile)
+	}
+	matches, err := filepath.Glob(bloomFile + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("list dedup files: %w", err)
+	}
+	for _, file := range matches {
+		if !strings.HasSuffix(file, ".tmp") {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+func hashFile(path string) (int64, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, "", fmt.Errorf("read %s: %w", path, err)
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeArchive writes the manifest, then each file, as a gzipped tar,
+// replacing path only once it's complete
+func writeArchive(path string, m *Manifest, sources map[string]string) error {
+	tmpPath := path + ".tmp"
+	out, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("create bundle: %w", err)
+	}
+	defer os.Remove(tmpPath)
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeEntry(tw, manifestName, int64(len(manifest)), strings.NewReader(string(manifest))); err != nil {
+		return err
+	}
+	for _, file := range m.Files {
+		f, err := os.Open(sources[file.Name])
+		if err != nil {
+			return err
+		}
+		err = writeEntry(tw, file.Name, file.Size, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("write bundle: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename bundle: %w", err)
+	}
+	return nil
+}
+
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: size, ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	// a file that grew since it was hashed is cut at the hashed size
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("write %s: %w", name, err)
+	}
+	return nil
+}
+
+// ImportOptions says where the bundle's position goes
+type ImportOptions struct {
+	StateDB   string
+	BloomFile string
+	// replace an existing state database and dedup files, which are moved
+	// into a before-import directory next to the state database
+	Force bool
+}
+
+// Import unpacks a bundle written by Export, checking every file against the
+// manifest before anything is replaced. The file counters are recorded in
+// the imported state database, so partitions keep numbering their files
+// after the ones written on the old host even when its output didn't move.
+func Import(path string, opts ImportOptions, logger *slog.Logger) (*Manifest, error) {
+	stateDir := filepath.Dir(opts.StateDB)
+	if err := os.MkdirAll(stateDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create state dir: %w", err)
+	}
+	// next to the targets, so they're moved into place by renaming
+	tmpDir, err := os.MkdirTemp(stateDir, ".bundle-import-")
+	if err != nil {
+		return nil, fmt.Errorf("create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	m, err := extract(path, tmpDir)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := state.LatestSchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	if m.SchemaVersion > latest {
+		return nil, fmt.Errorf("bundle's state database schema version %d is newer than this build supports (%d); upgrade gocloudtrail", m.SchemaVersion, latest)
+	}
+
+	// where each file goes, dedup files renamed after this host's bloom_file
+	targets := make(map[string]string, len(m.Files))
+	for _, file := range m.Files {
+		switch {
+		case file.Name == stateName:
+			targets[file.Name] = opts.StateDB
+		case strings.HasPrefix(file.Name, dedupDir):
+			suffix := strings.TrimPrefix(strings.TrimPrefix(file.Name, dedupDir), m.BloomFile)
+			targets[file.Name] = opts.BloomFile + suffix
+		}
+	}
+
+	existing, err := existingFiles(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		if !opts.Force {
+			return nil, fmt.Errorf("%s already exists; use --force to replace it", strings.Join(existing, ", "))
+		}
+		aside := filepath.Join(stateDir, "before-import-"+time.Now().UTC().Format("20060102T150405"))
+		if err := os.MkdirAll(aside, 0o755); err != nil {
+			return nil, fmt.Errorf("create %s: %w", aside, err)
+		}
+		for _, file := range existing {
+			if err := os.Rename(file, filepath.Join(aside, filepath.Base(file))); err != nil {
+				return nil, fmt.Errorf("move %s aside: %w", file, err)
+			}
+		}
+		logger.Info("moved existing state aside",
+			slog.String("dir", aside),
+			slog.Int("files", len(existing)))
+	}
+
+	for name, target := range targets {
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return nil, fmt.Errorf("create dir for %s: %w", target, err)
+		}
+		if err := os.Rename(filepath.Join(tmpDir, name), target); err != nil {
+			return nil, fmt.Errorf("move %s into place: %w", target, err)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(tmpDir, countersName))
+	if err != nil {
+		return nil, fmt.Errorf("read file counters: %w", err)
+	}
+	var counters map[string]map[string]int
+	if err := json.Unmarshal(data, &counters); err != nil {
+		return nil, fmt.Errorf("decode file counters: %w", err)
+	}
+	db, err := state.Open(opts.StateDB, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open imported state database: %w", err)
+	}
+	defer db.Close()
+	for dir, next := range counters {
+		if err := db.RaiseFileCounters(dir, next); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("imported state bundle",
+		slog.String("path", path),
+		slog.String("exported_by", m.Host),
+		slog.Time("created_at", m.CreatedAt),
+		slog.Int("files", len(m.Files)))
+	return m, nil
+}
+
+// existingFiles lists the state database, with its journal files, and the
+// dedup files an import would replace
+func existingFiles(opts ImportOptions) ([]string, error) {
+	var files []string
+	for _, file := range []string{opts.StateDB, opts.StateDB + "-wal", opts.StateDB + "-shm", opts.StateDB + "-journal"} {
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	dedup, err := dedupFiles(opts.BloomFile)
+	if err != nil {
+		return nil, err
+	}
+	return append(files, dedup...), nil
+}
+
+// extract reads a bundle into dir and checks it against its manifest
+func extract(path, dir string) (*Manifest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open bundle: %w", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("read bundle: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != manifestName {
+		return nil, errors.New("not a state bundle: no manifest")
+	}
+	var m Manifest
+	if err := json.NewDecoder(io.LimitReader(tr, 1<<20)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decode manifest: %w", err)
+	}
+	if m.FormatVersion > FormatVersion {
+		return nil, fmt.Errorf("bundle format %d is newer than this build reads (%d); upgrade gocloudtrail", m.FormatVersion, FormatVersion)
+	}
+	want := make(map[string]File, len(m.Files))
+	for _, file := range m.Files {
+		if !validName(file.Name) {
+			return nil, fmt.Errorf("bundle has an unexpected file %q", file.Name)
+		}
+		want[file.Name] = file
+	}
+
+	got := make(map[string]bool)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read bundle: %w", err)
+		}
+		file, ok := want[hdr.Name]
+		if !ok {
+			return nil, fmt.Errorf("bundle has %q, which its manifest doesn't list", hdr.Name)
+		}
+		if err := extractFile(tr, filepath.Join(dir, file.Name), file); err != nil {
+			return nil, err
+		}
+		got[file.Name] = true
+	}
+	for name := range want {
+		if !got[name] {
+			return nil, fmt.Errorf("bundle is missing %s", name)
+		}
+	}
+	if !got[stateName] || !got[countersName] {
+		return nil, errors.New("bundle has no state database")
+	}
+	return &m, nil
+}
+
+// validName accepts only the names Export writes, so an entry can't land
+// outside the import dir
+func validName(name string) bool {
+	if name == stateName || name == countersName {
+		return true
+	}
+	base, ok := strings.CutPrefix(name, dedupDir)
+	return ok && base != "" && base != "." && base != ".." && !strings.ContainsAny(base, `/\`)
+}
+
+func extractFile(r io.Reader, path string, file File) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", file.Name, err)
+	}
+	defer out.Close()
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(out, h), r)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", file.Name, err)
+	}
+	if size != file.Size || hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+		return fmt.Errorf("%s doesn't match the manifest's size and checksum; the bundle is damaged", file.Name)
+	}
+	return out.Close()
+}
@@ -0,0 +1,265 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/glue"
+	"github.com/aws/aws-sdk-go-v2/service/glue/types"
+
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// maxBatchPartitions is the most partitions BatchCreatePartition takes
+const maxBatchPartitions = 100
+
+// dateKeys are the partition columns of the date folders, of which the
+// partition granularity keeps the first few
+var dateKeys = []string{"year", "month", "day", "hour"}
+
+// jsonColumns are the top-level CloudTrail record fields; nested objects
+// read back as their JSON text
+var jsonColumns = []string{
+	"eventVersion", "userIdentity", "eventTime", "eventSource", "eventName",
+	"awsRegion", "sourceIPAddress", "userAgent", "errorCode", "errorMessage",
+	"requestParameters", "responseElements", "additionalEventData", "requestID",
+	"eventID", "readOnly", "resources", "eventType", "apiVersion", "managementEvent",
+	"recipientAccountId", "serviceEventDetails", "sharedEventID", "vpcEndpointId",
+	"eventCategory", "tlsDetails", "sessionCredentialFromConsole",
+}
+
+// Glue keeps a Glue Data Catalog table over output uploaded to S3 and
+// registers its partitions as files land, so Athena sees new data
+// without a crawler
+type Glue struct {
+	client   *glue.Client
+	database string
+	table    string
+	// s3://bucket/prefix the output is uploaded under
+	location     string
+	format       writer.Format
+	csvColumns   []string
+	partitioning writer.Partitioning
+
+	mu         sync.Mutex
+	registered map[string]bool
+	pending    []string
+}
+
+func NewGlue(client *glue.Client, database, table, location string, enc writer.Encoding, partitioning writer.Partitioning) *Glue {
+	return &Glue{
+		client:       client,
+		database:     database,
+		table:        table,
+		location:     strings.TrimSuffix(location, "/"),
+		format:       enc.Format,
+		csvColumns:   enc.Columns(),
+		partitioning: partitioning,
+		registered:   make(map[string]bool),
+	}
+}
+
+// partitionKeys match the output layout, account/region/YYYY/MM/DD/HH down
+// to the partition granularity, with eventsource=<source> after the region
+// in the event_source layout
+func (g *Glue) partitionKeys() []string {
+	keys := []string{"account_id", "region"}
+	if g.partitioning.Layout == writer.LayoutEventSource {
+		keys = append(keys, "eventsource")
+	}
+	return append(keys, dateKeys[:g.partitioning.Granularity.Depth()]...)
+}
+
+// partitionValues returns the partition column values of a partition dir
+func (g *Glue) partitionValues(dir string) []string {
+	values := strings.Split(dir, "/")
+	for i, v := range values {
+		values[i] = strings.TrimPrefix(v, writer.EventSourcePrefix)
+	}
+	return values
+}
+
+// EnsureTable creates the database and table if they don't exist, and
+// otherwise updates the table to match the output format and location
+func (g *Glue) EnsureTable(ctx context.Context) error {
+	var notFound *types.EntityNotFoundException
+	_, err := g.client.GetDatabase(ctx, &glue.GetDatabaseInput{Name: aws.String(g.database)})
+	if errors.As(err, &notFound) {
+		_, err = g.client.CreateDatabase(ctx, &glue.CreateDatabaseInput{
+			DatabaseInput: &types.DatabaseInput{Name: aws.String(g.database)},
+		})
+		var exists *types.AlreadyExistsException
+		if errors.As(err, &exists) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("glue database %s: %w", g.database, err)
+	}
+
+	input := &types.TableInput{
+		Name:              aws.String(g.table),
+		TableType:         aws.String("EXTERNAL_TABLE"),
+		Parameters:        map[string]string{"EXTERNAL": "TRUE", "classification": g.classification()},
+		StorageDescriptor: g.storageDescriptor(g.location + "/"),
+	}
+	if g.format == writer.FormatCSV {
+		input.Parameters["skip.header.line.count"] = "1"
+	}
+	for _, key := range g.partitionKeys() {
+		input.PartitionKeys = append(input.PartitionKeys, types.Column{Name: aws.String(key), Type: aws.String("string")})
+	}
+
+	_, err = g.client.GetTable(ctx, &glue.GetTableInput{DatabaseName: aws.String(g.database), Name: aws.String(g.table)})
+	switch {
+	case errors.As(err, &notFound):
+		_, err = g.client.CreateTable(ctx, &glue.CreateTableInput{DatabaseName: aws.String(g.database), TableInput: input})
+	case err == nil:
+		_, err = g.client.UpdateTable(ctx, &glue.UpdateTableInput{DatabaseName: aws.String(g.database), TableInput: input})
+	}
+	if err != nil {
+		return fmt.Errorf("glue table %s.%s: %w", g.database, g.table, err)
+	}
+	return nil
+}
+
+func (g *Glue) classification() string {
+	switch g.format {
+	case writer.FormatParquet:
+		return "parquet"
+	case writer.FormatCSV:
+		return "csv"
+	}
+	return "json"
+}
+
+func (g *Glue) storageDescriptor(location string) *types.StorageDescriptor {
+	if g.format == writer.FormatParquet {
+		var cols []types.Column
+		for _, name := range writer.ParquetColumns() {
+			cols = append(cols, types.Column{Name: aws.String(name), Type: aws.String("string")})
+		}
+		return &types.StorageDescriptor{
+			Columns:      cols,
+			Location:     aws.String(location),
+			InputFormat:  aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetInputFormat"),
+			OutputFormat: aws.String("org.apache.hadoop.hive.ql.io.parquet.MapredParquetOutputFormat"),
+			SerdeInfo: &types.SerDeInfo{
+				SerializationLibrary: aws.String("org.apache.hadoop.hive.ql.io.parquet.serde.ParquetHiveSerDe"),
+			},
+		}
+	}
+
+	if g.format == writer.FormatCSV {
+		// a column per configured field, dots in nested paths made underscores
+		var cols []types.Column
+		for _, name := range g.csvColumns {
+			name = strings.ToLower(strings.ReplaceAll(name, ".", "_"))
+			cols = append(cols, types.Column{Name: aws.String(name), Type: aws.String("string")})
+		}
+		return &types.StorageDescriptor{
+			Columns:      cols,
+			Location:     aws.String(location),
+			InputFormat:  aws.String("org.apache.hadoop.mapred.TextInputFormat"),
+			OutputFormat: aws.String("org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat"),
+			SerdeInfo: &types.SerDeInfo{
+				SerializationLibrary: aws.String("org.apache.hadoop.hive.serde2.OpenCSVSerde"),
+				Parameters:           map[string]string{"separatorChar": ",", "quoteChar": "\""},
+			},
+		}
+	}
+
+	// jsonl, jsonl.gz and jsonl.zst: text input picks the codec by extension
+	var cols []types.Column
+	for _, name := range jsonColumns {
+		cols = append(cols, types.Column{Name: aws.String(strings.ToLower(name)), Type: aws.String("string")})
+	}
+	return &types.StorageDescriptor{
+		Columns:      cols,
+		Location:     aws.String(location),
+		InputFormat:  aws.String("org.apache.hadoop.mapred.TextInputFormat"),
+		OutputFormat: aws.String("org.apache.hadoop.hive.ql.io.HiveIgnoreKeyTextOutputFormat"),
+		SerdeInfo: &types.SerDeInfo{
+			SerializationLibrary: aws.String("org.openx.data.jsonserde.JsonSerDe"),
+			Parameters:           map[string]string{"ignore.malformed.json": "true"},
+		},
+	}
+}
+
+// Add queues the partition holding a just-uploaded file, given its
+// directory relative to the upload prefix
+func (g *Glue) Add(dir string) {
+	if len(strings.Split(dir, "/")) != len(g.partitionKeys()) {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.registered[dir] {
+		return
+	}
+	g.registered[dir] = true
+	g.pending = append(g.pending, dir)
+}
+
+// Flush registers the queued partitions, returning how many were new.
+// Partitions that already exist count as registered; ones that fail are
+// queued again for the next flush.
+func (g *Glue) Flush(ctx context.Context) (int, error) {
+	g.mu.Lock()
+	pending := g.pending
+	g.pending = nil
+	g.mu.Unlock()
+
+	var created int
+	var failed []string
+	var errs []error
+	// partition values back to their dir, for requeueing failures
+	dirs := make(map[string]string, len(pending))
+	for start := 0; start < len(pending); start += maxBatchPartitions {
+		batch := pending[start:min(start+maxBatchPartitions, len(pending))]
+		input := &glue.BatchCreatePartitionInput{
+			DatabaseName: aws.String(g.database),
+			TableName:    aws.String(g.table),
+		}
+		for _, dir := range batch {
+			values := g.partitionValues(dir)
+			dirs[strings.Join(values, "/")] = dir
+			input.PartitionInputList = append(input.PartitionInputList, types.PartitionInput{
+				Values:            values,
+				StorageDescriptor: g.storageDescriptor(g.location + "/" + dir + "/"),
+			})
+		}
+
+		out, err := g.client.BatchCreatePartition(ctx, input)
+		if err != nil {
+			failed = append(failed, batch...)
+			errs = append(errs, err)
+			continue
+		}
+		created += len(batch)
+		for _, pe := range out.Errors {
+			created--
+			if pe.ErrorDetail != nil && aws.ToString(pe.ErrorDetail.ErrorCode) == "AlreadyExistsException" {
+				continue
+			}
+			failed = append(failed, dirs[strings.Join(pe.PartitionValues, "/")])
+			if pe.ErrorDetail != nil {
+				errs = append(errs, fmt.Errorf("partition %s: %s", strings.Join(pe.PartitionValues, "/"), aws.ToString(pe.ErrorDetail.ErrorMessage)))
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		g.mu.Lock()
+		g.pending = append(g.pending, failed...)
+		g.mu.Unlock()
+	}
+	if err := errors.Join(errs...); err != nil {
+		return created, fmt.Errorf("register partitions in %s.%s: %w", g.database, g.table, err)
+	}
+	return created, nil
+}
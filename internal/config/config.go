@@ -2,20 +2,356 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
 )
 
 type Trail struct {
 	Name   string `json:"name"`
 	Bucket string `json:"bucket"`
 	Prefix string `json:"prefix,omitempty"`
+	// nil means enabled, so existing configs keep working
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// Informational, filled in by generate-config
+	ARN          string `json:"arn,omitempty"`
+	HomeRegion   string `json:"home_region,omitempty"`
+	Organization bool   `json:"organization,omitempty"`
+	BucketRegion string `json:"bucket_region,omitempty"`
+	Accessible   *bool  `json:"accessible,omitempty"`
+
+	// Optional overrides of the global settings for this trail
+	DownloadWorkers int      `json:"download_workers,omitempty"`
+	ListBatchSize   int      `json:"list_batch_size,omitempty"`
+	EventsDir       string   `json:"events_dir,omitempty"`
+	OutputFormat    string   `json:"output_format,omitempty"`
+	Filters         *Filters `json:"filters,omitempty"`
+	// replace the matching global bound when set
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+	// skipped along with the global skip_keys
+	SkipKeys []string `json:"skip_keys,omitempty"`
+	// share of the shared download workers relative to other trails under
+	// fair_scheduling (0 = 1)
+	Weight int `json:"weight,omitempty"`
+	// cron expression for the trail's own passes under a schedule, in place
+	// of the schedule's passes that don't name trails
+	Schedule string `json:"schedule,omitempty"`
+	// account IDs to read, each optionally under its organization path
+	// (o-abc123/111122223333), in place of discovering the account folders
+	Accounts []string `json:"accounts,omitempty"`
+	// regions to read for each account, in place of discovering them
+	Regions []string `json:"regions,omitempty"`
+	// how many folders below an organization ID account discovery descends
+	// looking for account IDs (0 = 6)
+	DiscoveryDepth int `json:"discovery_depth,omitempty"`
+}
+
+// IsEnabled reports whether the trail should be processed
+func (t Trail) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// TimeRange parses the trail's own StartTime and EndTime, zero where unset
+func (t Trail) TimeRange() (time.Time, time.Time, error) {
+	return ParseTimeRange(t.StartTime, t.EndTime)
+}
+
+// LogPrefix returns the key prefix under which CloudTrail writes AWSLogs
+func (t Trail) LogPrefix() string {
+	basePrefix := ""
+	if t.Prefix != "" {
+		basePrefix = t.Prefix + "/"
+	}
+	return basePrefix + "AWSLogs/"
+}
+
+// LogGroup is a CloudWatch Logs log group that CloudTrail delivers events to,
+// read instead of or alongside a trail's S3 bucket
+type LogGroup struct {
+	Name string `json:"name"`
+	// region of the log group, defaults to the AWS config region
+	Region  string `json:"region,omitempty"`
+	Enabled *bool  `json:"enabled,omitempty"`
+
+	// Optional overrides of the global settings, as for trails
+	EventsDir    string   `json:"events_dir,omitempty"`
+	OutputFormat string   `json:"output_format,omitempty"`
+	Filters      *Filters `json:"filters,omitempty"`
+	StartTime    string   `json:"start_time,omitempty"`
+	EndTime      string   `json:"end_time,omitempty"`
+}
+
+// IsEnabled reports whether the log group should be processed
+func (g LogGroup) IsEnabled() bool {
+	return g.Enabled == nil || *g.Enabled
+}
+
+// Filters select which events are written. Patterns may use * wildcards and
+// an empty include list matches everything.
+type Filters struct {
+	IncludeEventSources []string `json:"include_event_sources,omitempty"`
+	ExcludeEventSources []string `json:"exclude_event_sources,omitempty"`
+	IncludeEventNames   []string `json:"include_event_names,omitempty"`
+	ExcludeEventNames   []string `json:"exclude_event_names,omitempty"`
+}
+
+// Match reports whether an event source and name pass the filters
+func (f Filters) Match(eventSource, eventName string) bool {
+	if len(f.IncludeEventSources) > 0 && !matchAny(f.IncludeEventSources, eventSource) {
+		return false
+	}
+	if matchAny(f.ExcludeEventSources, eventSource) {
+		return false
+	}
+	if len(f.IncludeEventNames) > 0 && !matchAny(f.IncludeEventNames, eventName) {
+		return false
+	}
+	return !matchAny(f.ExcludeEventNames, eventName)
+}
+
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, value); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Strict makes a run exit non-zero when failures exceed the thresholds. A zero
+// threshold tolerates no failures of that kind.
+type Strict struct {
+	Enabled             bool    `json:"enabled"`
+	MaxErrors           int64   `json:"max_errors"`
+	MaxSkippedObjects   int64   `json:"max_skipped_objects"`
+	MaxParseFailureRate float64 `json:"max_parse_failure_rate"`
+}
+
+// Alerts are conditions checked during a run that notify a webhook and/or
+// SNS topic; a zero threshold disables that condition
+type Alerts struct {
+	WebhookURL    string  `json:"webhook_url,omitempty"`
+	SNSTopicARN   string  `json:"sns_topic_arn,omitempty"`
+	CheckInterval int     `json:"check_interval"` // seconds
+	MaxErrorRate  float64 `json:"max_error_rate"`
+	StallMinutes  int     `json:"stall_minutes"`
+	WedgedMinutes int     `json:"wedged_minutes"`
+	// alert when late events are appended to a closed partition
+	PartitionReopened bool `json:"partition_reopened,omitempty"`
+}
+
+// Notifications post a run-start and run-summary message to chat, and email
+// the summary through SES
+type Notifications struct {
+	SlackWebhookURL string   `json:"slack_webhook_url,omitempty"`
+	TeamsWebhookURL string   `json:"teams_webhook_url,omitempty"`
+	EmailFrom       string   `json:"email_from,omitempty"`
+	EmailTo         []string `json:"email_to,omitempty"`
+}
+
+// Analytics summarises the events written by a run: top event names,
+// principals, source IPs and error codes, and events per account per day
+type Analytics struct {
+	Enabled  bool   `json:"enabled"`
+	TopN     int    `json:"top_n"`
+	Interval int    `json:"interval"` // minutes between summaries during a run, 0 = end only
+	File     string `json:"file,omitempty"`
+}
+
+// Sink forwards written events downstream. Type selects which of the other
+// fields apply: webhook (url, headers), splunk (url, token, index,
+// sourcetype), kafka (brokers, topic), iceberg (catalog, catalog_uri,
+// token, warehouse, table), delta (location, partition_by) or grpc (listen,
+// token, tls_cert, tls_key), which streams events to subscribed consumers.
+type Sink struct {
+	Name       string            `json:"name"`
+	Type       string            `json:"type"`
+	URL        string            `json:"url,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Token      string            `json:"token,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	SourceType string            `json:"sourcetype,omitempty"`
+	Brokers    []string          `json:"brokers,omitempty"`
+	Topic      string            `json:"topic,omitempty"`
+	Catalog    string            `json:"catalog,omitempty"` // glue or rest
+	CatalogURI string            `json:"catalog_uri,omitempty"`
+	Warehouse  string            `json:"warehouse,omitempty"`
+	Table      string            `json:"table,omitempty"`
+	Location   string            `json:"location,omitempty"` // s3://bucket/prefix or a local directory
+	// default recipient_account_id, aws_region, event_date
+	PartitionBy []string `json:"partition_by,omitempty"`
+	Listen      string   `json:"listen,omitempty"` // host:port
+	TLSCert     string   `json:"tls_cert,omitempty"`
+	TLSKey      string   `json:"tls_key,omitempty"`
+	// CEL predicate picking the events sent, every event when empty
+	Route string `json:"route,omitempty"`
+	// fields added to the events sent, none when unset
+	Derive *Derive `json:"derive,omitempty"`
+	SinkTuning
+}
+
+// Derive adds a "derived" object to each event an output gets
+type Derive struct {
+	// add event_time_ms, eventTime in milliseconds since the epoch
+	EpochTime bool `json:"epoch_time,omitempty"`
+	// any of is_assumed_role, is_root, is_cross_account, mfa_used and
+	// source_is_aws_service, or all
+	Fields []string `json:"fields,omitempty"`
+}
+
+// OversizedRecords handles events over MaxBytes, which can blow past a
+// sink's payload limit
+type OversizedRecords struct {
+	// 0 = no limit
+	MaxBytes int `json:"max_bytes"`
+	// count (only count them), truncate (replace the largest of Fields
+	// with a marker until the event fits) or externalize (move them to a
+	// side file under Dir and leave a pointer)
+	Action string `json:"action"`
+	// top-level fields that may be shrunk (empty = responseElements,
+	// requestParameters, additionalEventData and serviceEventDetails)
+	Fields []string `json:"fields,omitempty"`
+	Dir    string   `json:"dir"`
+}
+
+// SinkTuning controls how any sink batches and sends. For iceberg and
+// delta a batch is a table commit.
+type SinkTuning struct {
+	BatchSize     int `json:"batch_size,omitempty"`      // events per batch, default 500 (50000 for iceberg and delta)
+	MaxBatchBytes int `json:"max_batch_bytes,omitempty"` // send before a batch grows past this, 0 = no limit
+	FlushInterval int `json:"flush_interval,omitempty"`  // seconds between sends of partial batches, default jsonl_flush_interval
+	Concurrency   int `json:"concurrency,omitempty"`     // batches sent at once, default 4 (1 for iceberg and delta)
+	// none, gzip, snappy, lz4 or zstd, as far as the type supports them
+	Compression string    `json:"compression,omitempty"`
+	Retry       SinkRetry `json:"retry,omitempty"`
+}
+
+// SinkRetry is how a batch that fails with a retryable error is retried
+// before it's saved to the dead-letter dir
+type SinkRetry struct {
+	Attempts     int `json:"attempts,omitempty"`       // total tries, default 3
+	BackoffMS    int `json:"backoff_ms,omitempty"`     // wait before the first retry, doubling after, default 1000
+	MaxBackoffMS int `json:"max_backoff_ms,omitempty"` // default 30000
+}
+
+// Encryption encrypts output files at rest. Mode age encrypts to Recipients
+// and reads files back with IdentityFile; aes-gcm uses the key in KeyFile or
+// data keys from KMSKeyID.
+type Encryption struct {
+	Mode         string   `json:"mode,omitempty"` // age or aes-gcm, empty = off
+	Recipients   []string `json:"recipients,omitempty"`
+	IdentityFile string   `json:"identity_file,omitempty"`
+	KeyFile      string   `json:"key_file,omitempty"`
+	KMSKeyID     string   `json:"kms_key_id,omitempty"`
+}
+
+// GlueCatalog creates or updates a Glue table over the spill_upload output
+// and registers each hour partition as its files are uploaded
+type GlueCatalog struct {
+	Database string `json:"database,omitempty"` // empty = off
+	Table    string `json:"table,omitempty"`    // default cloudtrail
+}
+
+// SecurityHub imports detection findings into Security Hub
+type SecurityHub struct {
+	Enabled bool   `json:"enabled"`
+	Region  string `json:"region,omitempty"` // default: the AWS config region
+}
+
+// AWSRetry tunes how the AWS SDK retries failed requests; zero values keep
+// the SDK's defaults
+type AWSRetry struct {
+	Mode        string `json:"mode,omitempty"`         // standard (default) or adaptive, which also rate limits on throttling
+	MaxAttempts int    `json:"max_attempts,omitempty"` // tries per request, default 3
+	MaxBackoff  int    `json:"max_backoff,omitempty"`  // seconds, cap on the wait between tries, default 20
+	// override max_attempts for S3 downloads and listing pages
+	GetObjectMaxAttempts   int `json:"get_object_max_attempts,omitempty"`
+	ListObjectsMaxAttempts int `json:"list_objects_max_attempts,omitempty"`
+}
+
+// Schedule keeps run going as a daemon that starts passes on cron schedules
+type Schedule struct {
+	// time zone the cron expressions are in (default: the host's)
+	Timezone string         `json:"timezone,omitempty"`
+	Passes   []SchedulePass `json:"passes,omitempty"`
+}
+
+// SchedulePass is one recurring pass, e.g. an hourly incremental sync or a
+// nightly reconcile
+type SchedulePass struct {
+	Name string `json:"name"`
+	// minute hour day-of-month month day-of-week, or @hourly, @daily etc.
+	Cron string `json:"cron"`
+	// trails (by name) the pass covers; when empty, every trail without its
+	// own schedule, plus the log groups
+	Trails []string `json:"trails,omitempty"`
+	// replace late_deliveries and late_delivery_days for this pass, e.g.
+	// process for a reconcile that picks up late log files
+	LateDeliveries   string `json:"late_deliveries,omitempty"`
+	LateDeliveryDays int    `json:"late_delivery_days,omitempty"`
+}
+
+// Systemd tunes the sd_notify integration, used when NOTIFY_SOCKET is set
+type Systemd struct {
+	// with work queued or in flight, minutes without progress before
+	// watchdog keepalives stop so systemd restarts the run (0 = never)
+	WedgedMinutes int `json:"wedged_minutes"`
+	// seconds, at most, that a stopping run extends the unit's stop timeout
+	// by while it flushes and saves state (0 = don't extend)
+	StopTimeout int `json:"stop_timeout"`
+}
+
+// LogSampling keeps a flood of identical warnings and errors (same message
+// and error class) out of the log
+type LogSampling struct {
+	// lines of each kind logged per interval, the rest are counted and
+	// summarised (0 = log them all)
+	First int `json:"first"`
+	// seconds per interval
+	Interval int `json:"interval"`
+}
+
+// DedupService points runs at a shared dedup service (see dedup-server)
+// instead of the local bloom filter, so a fleet of collectors drops each
+// other's duplicates
+type DedupService struct {
+	// host:port of the service (empty = use bloom_file)
+	Addr string `json:"addr,omitempty"`
+	// bearer token the service requires; dedup-server requires it when set
+	Token string `json:"token,omitempty"`
+	// connect with TLS, verifying the server against CAFile or the system
+	// roots
+	TLS    bool   `json:"tls,omitempty"`
+	CAFile string `json:"ca_file,omitempty"`
+	// event IDs sent per call
+	BatchSize int `json:"batch_size"`
+	// milliseconds an added event ID waits for its batch to fill
+	FlushIntervalMS int `json:"flush_interval_ms"`
+	// event IDs known to be seen kept locally
+	CacheSize int `json:"cache_size"`
+	// seconds per call
+	Timeout int `json:"timeout"`
+}
+
+// AWSCredentials keeps the AWS credentials of long runs fresh
+type AWSCredentials struct {
+	// seconds each assumed-role or web identity session lasts, 900 to 43200
+	// and at most the role's maximum session duration (0 = the profile's
+	// duration_seconds, else the SDK's 900)
+	SessionDuration int `json:"session_duration,omitempty"`
+	// seconds before expiry that credentials are renewed
+	RefreshBefore int `json:"refresh_before"`
 }
 
 type Config struct {
@@ -25,21 +361,136 @@ type Config struct {
 	DownloadQueueSize int `json:"download_queue_size"`
 	ProcessQueueSize  int `json:"process_queue_size"`
 	ListBatchSize     int `json:"list_batch_size"`
+	DiscoveryPageSize int `json:"discovery_page_size"`
 	EventsPerFile     int `json:"events_per_file"`
+	// Downloads started per second (0 = unlimited)
+	DownloadRateLimit float64 `json:"download_rate_limit,omitempty"`
+	// Interleave the shared download workers' jobs across trails, by trail
+	// weight, and round robin across each trail's accounts, instead of in
+	// the order the listings fill the queue
+	FairScheduling bool `json:"fair_scheduling"`
+	// Folders between the account ID and the region listed for log files,
+	// each with its own checkpoints (empty = CloudTrail and
+	// CloudTrail-Insight)
+	LogFolders []string `json:"log_folders,omitempty"`
+
+	// Optional HTTP admin API for pausing, throttling and watching a run
+	// (empty = disabled); AdminToken is then required as a bearer token
+	AdminAddr  string `json:"admin_addr,omitempty"`
+	AdminToken string `json:"admin_token,omitempty"`
 
 	// Directories
 	StateDB   string `json:"state_db"`
 	BloomFile string `json:"bloom_file"`
 	EventsDir string `json:"events_dir"`
 
+	// Pause downloads while the output volume has less free space than this
+	// (0 = never check)
+	MinFreeDiskMB int `json:"min_free_disk_mb"`
+
+	// Upload each output file to ArchiveBucket/ArchivePrefix once it's
+	// written and delete it locally, pausing downloads while more than
+	// LocalQuotaMB is waiting to upload (0 = no quota)
+	SpillUpload  bool `json:"spill_upload"`
+	LocalQuotaMB int  `json:"local_quota_mb"`
+	// Keep a Glue table over the uploaded output and register its partitions
+	GlueCatalog GlueCatalog `json:"glue_catalog,omitempty"`
+
+	// Record every processed S3 object in StateDB, not only failed ones, so
+	// check-completeness can tell processed files from skipped ones
+	RecordObjects bool `json:"record_objects"`
+	// Also record each processed object's ETag, size and LastModified, and
+	// skip downloading objects already processed in the same version
+	// (implies record_objects)
+	SkipProcessed bool `json:"skip_processed,omitempty"`
+	// Append every downloaded S3 object, its SHA-256 and what was produced
+	// from it to an append-only audit log in StateDB, for chain of custody
+	AuditLog bool `json:"audit_log,omitempty"`
+	// Look for log files delivered behind a checkpoint (modified after the
+	// latest LastModified earlier runs listed) in the days before it, and
+	// flag or process them: off (default), flag or process
+	LateDeliveries   string `json:"late_deliveries,omitempty"`
+	LateDeliveryDays int    `json:"late_delivery_days"`
+	// Keep each checkpoint before log files delivered within this many
+	// minutes, which are still processed, so ones that show up out of key
+	// order near the head aren't passed by the next run (0 = off)
+	SettleWindowMinutes int `json:"settle_window_minutes,omitempty"`
+
+	// Events dir per event category (Management, Data, Insight), overriding
+	// the trail's events dir for that category
+	CategoryDirs map[string]string `json:"category_dirs,omitempty"`
+
+	// Output format: jsonl, jsonl.gz, jsonl.zst, parquet or csv
+	OutputFormat string `json:"output_format"`
+	// Fields csv output flattens into columns, as dotted paths such as
+	// userIdentity.arn; a default set of common ones when empty
+	CSVColumns []string `json:"csv_columns,omitempty"`
+	// Partition dirs: default (account/region/date) or event_source
+	// (account/region/eventsource=<source>/date)
+	PartitionLayout string `json:"partition_layout,omitempty"`
+	// Time one partition dir covers: hour (default), day or month
+	PartitionGranularity string `json:"partition_granularity,omitempty"`
+	// Event fields tried in order for the account partition dir, recipient
+	// (recipientAccountId) and principal (userIdentity.accountId); empty for
+	// recipient, then principal
+	PartitionAccount []string `json:"partition_account,omitempty"`
+	// Also write an event whose caller is from another account under that
+	// account's partitions
+	PartitionCrossAccount bool `json:"partition_cross_account,omitempty"`
+	// Name output files after the S3 log file they came from, one file per
+	// log file and partition, so reprocessing a log file replaces its output
+	// instead of duplicating it (events_per_file is then unused)
+	SourceFileNames bool `json:"source_file_names,omitempty"`
+	// Fields computed from each event and added to the output files' copy
+	Derive Derive `json:"derive,omitempty"`
+	// Shrink events bigger than a size limit before they're written or sent
+	OversizedRecords OversizedRecords `json:"oversized_records"`
+	// Encrypt output files at rest
+	Encryption Encryption `json:"encryption"`
+	// Keep a SHA256SUMS manifest of the files in each partition dir, signed
+	// with this asymmetric KMS key when set (implies checksums)
+	Checksums                bool   `json:"checksums"`
+	ChecksumKMSKeyID         string `json:"checksum_kms_key_id,omitempty"`
+	ChecksumSigningAlgorithm string `json:"checksum_signing_algorithm,omitempty"` // default ECDSA_SHA_256
+	// After a run that died part way through, check the files it wrote
+	// before writing more, truncating or quarantining incomplete ones
+	CheckOutput bool `json:"check_output"`
+	// Write a _SUCCESS marker into each hour partition once every source
+	// file that could hold its events has been processed
+	PartitionMarkers bool `json:"partition_markers"`
+
+	// Time range of events to process (RFC3339 or YYYY-MM-DD, empty = unbounded)
+	StartTime string `json:"start_time,omitempty"`
+	EndTime   string `json:"end_time,omitempty"`
+
+	// Event filters
+	Filters Filters `json:"filters"`
+	// S3 key prefixes, or globs matched against a key and its parent
+	// directories, that listing passes over
+	SkipKeys []string `json:"skip_keys,omitempty"`
+
+	// Reject events missing required CloudTrail fields, and keep rejected
+	// events and undecodable files in QuarantineDir
+	ValidateEvents bool   `json:"validate_events"`
+	QuarantineDir  string `json:"quarantine_dir"`
+
 	// Bloom filter settings
 	BloomExpectedItems uint64  `json:"bloom_expected_items"`
 	BloomFalsePositive float64 `json:"bloom_false_positive"`
+	// Only remember event IDs for events this recent (0 = forever)
+	DedupRetentionDays int `json:"dedup_retention_days"`
+	// Shared dedup service replacing the bloom filter
+	DedupService DedupService `json:"dedup_service"`
 
 	// Intervals (in seconds)
 	StateSaveInterval  int `json:"state_save_interval"`
 	ProgressInterval   int `json:"progress_interval"`
 	JSONLFlushInterval int `json:"jsonl_flush_interval"`
+	// Save each account/region's checkpoint every this many files processed
+	// and/or every CheckpointInterval seconds (0 = off); either way it's
+	// saved once its listing's files are and when the run stops
+	CheckpointEveryFiles int `json:"checkpoint_every_files"`
+	CheckpointInterval   int `json:"checkpoint_interval"`
 
 	// HTTP client settings (in seconds)
 	MaxIdleConns        int `json:"max_idle_conns"`
@@ -49,35 +500,130 @@ type Config struct {
 	DialTimeout         int `json:"dial_timeout"`
 	KeepAlive           int `json:"keep_alive"`
 	ClientTimeout       int `json:"client_timeout"`
+	// AWS profile, region and shared config/credentials files, in place of
+	// the environment's; the --profile, --region, --aws-config-file and
+	// --aws-credentials-file flags override them
+	AWSProfile          string   `json:"aws_profile,omitempty"`
+	AWSRegion           string   `json:"aws_region,omitempty"`
+	AWSConfigFiles      []string `json:"aws_config_files,omitempty"`
+	AWSCredentialsFiles []string `json:"aws_credentials_files,omitempty"`
+	// AWS SDK retry mode and attempts
+	AWSRetry AWSRetry `json:"aws_retry,omitempty"`
+	// AWS credential session length and renewal
+	AWSCredentials AWSCredentials `json:"aws_credentials"`
+
+	// Detection rules (YAML files or directories) run over new events, with
+	// matches appended to FindingsFile
+	RulesFiles   []string `json:"rules_files,omitempty"`
+	SigmaRules   []string `json:"sigma_rules,omitempty"`
+	FindingsFile string   `json:"findings_file"`
+	// Built-in detections to enable by name ("all" for every preset)
+	Detections  []string    `json:"detections,omitempty"`
+	SecurityHub SecurityHub `json:"security_hub"`
+
+	// Top-N summary logged (and written to Analytics.File) after each run
+	Analytics Analytics `json:"analytics"`
+
+	// Fail the run when these are exceeded
+	Strict Strict `json:"strict"`
+
+	// Notify when the run degrades
+	Alerts Alerts `json:"alerts"`
+
+	// Run passes on cron schedules rather than once
+	Schedule Schedule `json:"schedule"`
+
+	// Watchdog and shutdown handling when run as a systemd Type=notify unit
+	Systemd Systemd `json:"systemd"`
+
+	// Sample repeated warnings and errors during runs
+	LogSampling LogSampling `json:"log_sampling"`
+
+	// Post run start and summary messages
+	Notifications Notifications `json:"notifications"`
+
+	// Retention for the prune command (0 = keep forever)
+	RetentionDays int    `json:"retention_days"`
+	ArchiveBucket string `json:"archive_bucket,omitempty"`
+	ArchivePrefix string `json:"archive_prefix,omitempty"`
+
+	// Downstream destinations for newly written events (and replay)
+	Sinks []Sink `json:"sinks,omitempty"`
+	// Batches a sink still fails to take after retrying are saved here,
+	// under the sink's name, for the redrive command (empty = drop them)
+	DeadLetterDir string `json:"dead_letter_dir"`
+	// Send events only to Sinks, with no events dir, saving checkpoints once
+	// the sinks have acknowledged the events before them
+	StreamOnly bool `json:"stream_only"`
+	// Save checkpoints only once each object's events are written and
+	// acknowledged by every sink, as stream_only does
+	AtLeastOnce bool `json:"at_least_once"`
+	// With at_least_once or stream_only, listing waits while this many
+	// objects are unacknowledged (0 = no limit)
+	MaxUnackedObjects int `json:"max_unacked_objects"`
+	// Process each account/region's objects one at a time in key order so
+	// sinks get its events in that order; other account/regions still run
+	// in parallel
+	OrderedPartitions bool `json:"ordered_partitions,omitempty"`
 
 	// Trails to process
 	Trails []Trail `json:"trails"`
+	// also probe every bucket in the account for AWSLogs/ at run time, in
+	// place of DescribeTrails when no trails are listed
+	DiscoverBuckets bool `json:"discover_buckets,omitempty"`
+	// reuse each trail's discovered account/regions from the state DB for
+	// this many seconds (0 = discover every run)
+	DiscoveryCacheTTL int `json:"discovery_cache_ttl,omitempty"`
+	// discover afresh this run despite the cache, set by --refresh-discovery
+	RefreshDiscovery bool `json:"-"`
+
+	// CloudWatch Logs log groups to process
+	LogGroups []LogGroup `json:"log_groups,omitempty"`
 }
 
 func Default() *Config {
 	return &Config{
-		DownloadWorkers:     50,
-		ProcessWorkers:      0, // Auto-set to NumCPU * 2
-		DownloadQueueSize:   5000,
-		ProcessQueueSize:    2000,
-		ListBatchSize:       1000,
-		EventsPerFile:       10000,
-		StateDB:             "state.db",
-		BloomFile:           "bloom.gob",
-		EventsDir:           "events",
-		BloomExpectedItems:  100_000_000,
-		BloomFalsePositive:  0.001,
-		StateSaveInterval:   300, // 5 minutes
-		ProgressInterval:    10,  // 10 seconds
-		JSONLFlushInterval:  30,  // 30 seconds
-		MaxIdleConns:        500,
-		MaxIdleConnsPerHost: 500,
-		MaxConnsPerHost:     500,
-		IdleConnTimeout:     90, // seconds
-		DialTimeout:         10, // seconds
-		KeepAlive:           30, // seconds
-		ClientTimeout:       60, // seconds
-		Trails:              []Trail{},
+		DownloadWorkers:      50,
+		ProcessWorkers:       0, // Auto-set to NumCPU * 2
+		DownloadQueueSize:    5000,
+		FairScheduling:       true,
+		CheckOutput:          true,
+		ProcessQueueSize:     2000,
+		ListBatchSize:        1000,
+		DiscoveryPageSize:    1000,
+		EventsPerFile:        10000,
+		StateDB:              "state.db",
+		BloomFile:            "bloom.gob",
+		EventsDir:            "events",
+		MinFreeDiskMB:        512,
+		LateDeliveryDays:     1,
+		LocalQuotaMB:         1024,
+		OutputFormat:         "jsonl",
+		QuarantineDir:        "quarantine",
+		FindingsFile:         "findings.jsonl",
+		DeadLetterDir:        "deadletter",
+		MaxUnackedObjects:    10000,
+		Analytics:            Analytics{TopN: 10, File: "summary.json"},
+		OversizedRecords:     OversizedRecords{Action: "truncate", Dir: "oversized"},
+		AWSCredentials:       AWSCredentials{RefreshBefore: 300},
+		BloomExpectedItems:   100_000_000,
+		BloomFalsePositive:   0.001,
+		StateSaveInterval:    300, // 5 minutes
+		ProgressInterval:     10,  // 10 seconds
+		JSONLFlushInterval:   30,  // 30 seconds
+		CheckpointEveryFiles: 100,
+		MaxIdleConns:         500,
+		MaxIdleConnsPerHost:  500,
+		MaxConnsPerHost:      500,
+		IdleConnTimeout:      90, // seconds
+		DialTimeout:          10, // seconds
+		KeepAlive:            30, // seconds
+		ClientTimeout:        60, // seconds
+		Alerts:               Alerts{CheckInterval: 60},
+		Systemd:              Systemd{WedgedMinutes: 15, StopTimeout: 300},
+		LogSampling:          LogSampling{First: 10, Interval: 60},
+		DedupService:         DedupService{BatchSize: 1000, FlushIntervalMS: 1000, CacheSize: 1_000_000, Timeout: 10},
+		Trails:               []Trail{},
 	}
 }
 
@@ -91,10 +637,81 @@ func Load(path string) (*Config, error) {
 	if err := json.Unmarshal(data, cfg); err != nil {
 		return nil, fmt.Errorf("parse config: %w", err)
 	}
+	// S3 returns at most 1000 keys per page
+	if cfg.DiscoveryPageSize < 1 || cfg.DiscoveryPageSize > 1000 {
+		return nil, fmt.Errorf("discovery_page_size must be between 1 and 1000, got %d", cfg.DiscoveryPageSize)
+	}
 
 	return cfg, nil
 }
 
+// TimeRange parses StartTime and EndTime into a half-open [start, end)
+// interval. Zero values mean unbounded; a date-only end includes that day.
+func (c *Config) TimeRange() (time.Time, time.Time, error) {
+	return ParseTimeRange(c.StartTime, c.EndTime)
+}
+
+// ParseTimeRange parses start/end bounds given as YYYY-MM-DD or RFC3339; a
+// date-only end includes that whole day
+func ParseTimeRange(startTime, endTime string) (time.Time, time.Time, error) {
+	start, _, err := parseTimeBound(startTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("start_time: %w", err)
+	}
+	end, dateOnly, err := parseTimeBound(endTime)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("end_time: %w", err)
+	}
+	if dateOnly {
+		end = end.AddDate(0, 0, 1)
+	}
+	if !start.IsZero() && !end.IsZero() && !end.After(start) {
+		return time.Time{}, time.Time{}, fmt.Errorf("end_time must be after start_time")
+	}
+	return start, end, nil
+}
+
+func parseTimeBound(s string) (time.Time, bool, error) {
+	if s == "" {
+		return time.Time{}, false, nil
+	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid time %q, want YYYY-MM-DD or RFC3339", s)
+	}
+	return t.UTC(), false, nil
+}
+
+// OutputDirs returns every events dir output may be written to: events_dir,
+// the dirs trails and log groups override it with, and category_dirs
+func (c *Config) OutputDirs() []string {
+	dirs := []string{c.EventsDir}
+	for _, trail := range c.Trails {
+		dirs = append(dirs, trail.EventsDir)
+	}
+	for _, group := range c.LogGroups {
+		dirs = append(dirs, group.EventsDir)
+	}
+	for _, dir := range c.CategoryDirs {
+		dirs = append(dirs, dir)
+	}
+
+	seen := make(map[string]bool, len(dirs))
+	var out []string
+	for _, dir := range dirs {
+		clean := filepath.Clean(dir)
+		if dir == "" || seen[clean] {
+			continue
+		}
+		seen[clean] = true
+		out = append(out, dir)
+	}
+	return out
+}
+
 func (c *Config) Save(path string) error {
 	data, err := json.MarshalIndent(c, "", "  ")
 	if err != nil {
@@ -108,29 +725,88 @@ func (c *Config) Save(path string) error {
 	return nil
 }
 
-func Generate(outputPath string, logger *slog.Logger) error {
-	ctx := context.Background()
-	cfg, err := config.LoadDefaultConfig(ctx)
+// Hash returns the SHA-256 of the config with defaults applied, so runs with
+// the same effective settings share a hash however the file was formatted.
+// Tokens and webhook URLs are left out: the hash is recorded and printed,
+// and rotating a credential doesn't change the settings.
+func (c *Config) Hash() string {
+	data, err := json.Marshal(c.withoutSecrets())
 	if err != nil {
-		return fmt.Errorf("load AWS config: %w", err)
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+// withoutSecrets returns a copy of the config with its credentials cleared
+func (c *Config) withoutSecrets() *Config {
+	cp := *c
+	cp.AdminToken = ""
+	cp.DedupService.Token = ""
+	cp.Alerts.WebhookURL = ""
+	cp.Notifications.SlackWebhookURL = ""
+	cp.Notifications.TeamsWebhookURL = ""
+	cp.Sinks = make([]Sink, len(c.Sinks))
+	for i, s := range c.Sinks {
+		s.Token = ""
+		if s.Type == "webhook" {
+			s.URL = ""
+		}
+		// header values often carry credentials; which headers are sent is
+		// still a setting
+		if s.Headers != nil {
+			headers := make(map[string]string, len(s.Headers))
+			for name := range s.Headers {
+				headers[name] = ""
+			}
+			s.Headers = headers
+		}
+		cp.Sinks[i] = s
 	}
+	return &cp
+}
 
-	ctClient := cloudtrail.NewFromConfig(cfg)
+type GenerateOptions struct {
+	// scan these buckets for AWSLogs/ instead of calling the CloudTrail API
+	Buckets []string
+	// scan every bucket in the account instead
+	AllBuckets bool
+	// keep only the discovered trails whose tags pass this
+	Tags TagFilter
 
-	logger.Info("discovering CloudTrail trails")
-	resp, err := ctClient.DescribeTrails(ctx, &cloudtrail.DescribeTrailsInput{})
+	// prompt for settings on Input/Output before writing the config
+	Interactive bool
+	Input       io.Reader
+	Output      io.Writer
+}
+
+func Generate(ctx context.Context, cfg aws.Config, outputPath string, opts GenerateOptions, logger *slog.Logger) error {
+	var trails []Trail
+	var err error
+	if opts.AllBuckets {
+		trails, err = DiscoverBuckets(ctx, s3.NewFromConfig(cfg), logger)
+	} else if len(opts.Buckets) > 0 {
+		logger.Info("scanning buckets for CloudTrail logs", slog.Int("buckets", len(opts.Buckets)))
+		trails, err = ScanBuckets(ctx, cfg, opts.Buckets, logger)
+	} else {
+		logger.Info("discovering CloudTrail trails")
+		trails, err = DiscoverTrails(ctx, cfg, logger)
+		if err == nil && !opts.Tags.IsZero() {
+			trails, err = SelectTrailsByTags(ctx, cfg, trails, opts.Tags, logger)
+		}
+	}
 	if err != nil {
-		return fmt.Errorf("describe trails: %w", err)
+		return fmt.Errorf("discover trails: %w", err)
 	}
 
+	ValidateTrails(ctx, cfg, trails, logger)
+
 	appCfg := Default()
+	appCfg.Trails = trails
 
-	for _, trail := range resp.TrailList {
-		appCfg.Trails = append(appCfg.Trails, Trail{
-			Name:   aws.ToString(trail.Name),
-			Bucket: aws.ToString(trail.S3BucketName),
-			Prefix: aws.ToString(trail.S3KeyPrefix),
-		})
+	if opts.Interactive {
+		if err := runWizard(opts.Input, opts.Output, appCfg, trails); err != nil {
+			return fmt.Errorf("interactive setup: %w", err)
+		}
 	}
 
 	logger.Info("discovered trails", slog.Int("count", len(appCfg.Trails)))
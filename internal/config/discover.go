@@ -0,0 +1,293 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DiscoverTrails queries CloudTrail in every enabled region and returns each
+// trail once, from its home region. Shadow copies of multi-region trails are
+// dropped, as are trails that deliver to an already listed bucket/prefix.
+func DiscoverTrails(ctx context.Context, cfg aws.Config, logger *slog.Logger) ([]Trail, error) {
+	regions, err := enabledRegions(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("discovering trails across regions", slog.Int("regions", len(regions)))
+
+	var mu sync.Mutex
+	byARN := make(map[string]Trail)
+
+	var wg sync.WaitGroup
+	for _, region := range regions {
+		wg.Add(1)
+		go func(region string) {
+			defer wg.Done()
+
+			client := cloudtrail.NewFromConfig(cfg, func(o *cloudtrail.Options) {
+				o.Region = region
+			})
+			resp, err := client.DescribeTrails(ctx, &cloudtrail.DescribeTrailsInput{
+				IncludeShadowTrails: aws.Bool(false),
+			})
+			if err != nil {
+				logger.Warn("failed to describe trails in region",
+					slog.String("region", region),
+					slog.String("error", err.Error()))
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, trail := range resp.TrailList {
+				// only trust the copy returned by the trail's home region
+				if home := aws.ToString(trail.HomeRegion); home != "" && home != region {
+					continue
+				}
+				arn := aws.ToString(trail.TrailARN)
+				byARN[arn] = Trail{
+					Name:         aws.ToString(trail.Name),
+					Bucket:       aws.ToString(trail.S3BucketName),
+					Prefix:       aws.ToString(trail.S3KeyPrefix),
+					ARN:          arn,
+					HomeRegion:   aws.ToString(trail.HomeRegion),
+					Organization: aws.ToBool(trail.IsOrganizationTrail),
+				}
+			}
+		}(region)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	arns := make([]string, 0, len(byARN))
+	for arn := range byARN {
+		arns = append(arns, arn)
+	}
+	sort.Strings(arns)
+
+	// several trails writing to one bucket/prefix share a single AWSLogs tree
+	seen := make(map[string]string)
+	trails := make([]Trail, 0, len(arns))
+	for _, arn := range arns {
+		trail := byARN[arn]
+		dest := trail.Bucket + "/" + trail.Prefix
+		if other, dup := seen[dest]; dup {
+			logger.Info("skipping trail with duplicate destination",
+				slog.String("trail", trail.Name),
+				slog.String("same_as", other),
+				slog.String("bucket", trail.Bucket),
+				slog.String("prefix", trail.Prefix))
+			continue
+		}
+		seen[dest] = trail.Name
+		trails = append(trails, trail)
+	}
+
+	return trails, nil
+}
+
+// how many prefix levels ScanBuckets descends looking for AWSLogs/
+const scanMaxDepth = 3
+
+// ScanBuckets looks for the AWSLogs/ layout directly in the given buckets and
+// synthesizes a trail for every prefix it is found under. It needs only
+// s3:ListBucket, not CloudTrail API access.
+func ScanBuckets(ctx context.Context, cfg aws.Config, buckets []string, logger *slog.Logger) ([]Trail, error) {
+	client := s3.NewFromConfig(cfg)
+
+	var trails []Trail
+	for _, bucket := range buckets {
+		found, err := scanBucket(ctx, client, bucket, "", logger)
+		if err != nil {
+			return nil, fmt.Errorf("scan bucket %s: %w", bucket, err)
+		}
+		if len(found) == 0 {
+			logger.Warn("no AWSLogs/ prefix found in bucket", slog.String("bucket", bucket))
+		}
+		trails = append(trails, found...)
+	}
+
+	return trails, nil
+}
+
+// DiscoverBuckets lists every bucket in the account and scans each for the
+// AWSLogs/ layout, for environments where nobody knows where the trails
+// deliver. Buckets that can't be listed are logged and skipped.
+func DiscoverBuckets(ctx context.Context, client *s3.Client, logger *slog.Logger) ([]Trail, error) {
+	var buckets []types.Bucket
+	paginator := s3.NewListBucketsPaginator(client, &s3.ListBucketsInput{})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("list buckets: %w", err)
+		}
+		buckets = append(buckets, page.Buckets...)
+	}
+	logger.Info("probing buckets for CloudTrail logs", slog.Int("buckets", len(buckets)))
+
+	var trails []Trail
+	for _, b := range buckets {
+		bucket := aws.ToString(b.Name)
+		found, err := scanBucket(ctx, client, bucket, aws.ToString(b.BucketRegion), logger)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			logger.Warn("failed to scan bucket",
+				slog.String("bucket", bucket),
+				slog.String("error", err.Error()))
+			continue
+		}
+		trails = append(trails, found...)
+	}
+
+	return trails, nil
+}
+
+// scanBucket returns a trail for every AWSLogs/ prefix in a bucket, listing
+// from region when set
+func scanBucket(ctx context.Context, client *s3.Client, bucket, region string, logger *slog.Logger) ([]Trail, error) {
+	var optFns []func(*s3.Options)
+	if region != "" {
+		optFns = append(optFns, func(o *s3.Options) { o.Region = region })
+	}
+	prefixes, err := findLogPrefixes(ctx, client, bucket, "", 0, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	trails := make([]Trail, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		name := bucket
+		if prefix != "" {
+			name = bucket + "/" + prefix
+		}
+		logger.Info("found CloudTrail logs",
+			slog.String("bucket", bucket),
+			slog.String("prefix", prefix))
+		trails = append(trails, Trail{Name: name, Bucket: bucket, Prefix: prefix, BucketRegion: region})
+	}
+	return trails, nil
+}
+
+// findLogPrefixes returns the trail prefixes (without trailing slash) under
+// which an AWSLogs/ folder exists
+func findLogPrefixes(ctx context.Context, client *s3.Client, bucket, prefix string, depth int, optFns ...func(*s3.Options)) ([]string, error) {
+	var children []string
+	var found []string
+
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range page.CommonPrefixes {
+			child := aws.ToString(cp.Prefix)
+			if child == prefix+"AWSLogs/" {
+				found = append(found, strings.TrimSuffix(prefix, "/"))
+				continue
+			}
+			children = append(children, child)
+		}
+	}
+
+	if depth >= scanMaxDepth {
+		return found, nil
+	}
+	for _, child := range children {
+		nested, err := findLogPrefixes(ctx, client, bucket, child, depth+1, optFns...)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, nested...)
+	}
+
+	return found, nil
+}
+
+func enabledRegions(ctx context.Context, cfg aws.Config) ([]string, error) {
+	resp, err := ec2.NewFromConfig(cfg).DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, fmt.Errorf("describe regions: %w", err)
+	}
+
+	regions := make([]string, 0, len(resp.Regions))
+	for _, region := range resp.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	return regions, nil
+}
+
+// ValidateTrails resolves each trail's bucket region and checks that its log
+// prefix can be listed, annotating the trails in place
+func ValidateTrails(ctx context.Context, cfg aws.Config, trails []Trail, logger *slog.Logger) {
+	client := s3.NewFromConfig(cfg)
+
+	for i := range trails {
+		trail := &trails[i]
+
+		region, err := bucketRegion(ctx, client, trail.Bucket)
+		if err != nil {
+			logger.Warn("failed to resolve bucket region",
+				slog.String("trail", trail.Name),
+				slog.String("bucket", trail.Bucket),
+				slog.String("error", err.Error()))
+		}
+		trail.BucketRegion = region
+
+		_, err = client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+			Bucket:  aws.String(trail.Bucket),
+			Prefix:  aws.String(trail.LogPrefix()),
+			MaxKeys: aws.Int32(1),
+		}, func(o *s3.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+		trail.Accessible = aws.Bool(err == nil)
+		if err != nil {
+			logger.Warn("trail bucket is not accessible",
+				slog.String("trail", trail.Name),
+				slog.String("bucket", trail.Bucket),
+				slog.String("prefix", trail.LogPrefix()),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+func bucketRegion(ctx context.Context, client *s3.Client, bucket string) (string, error) {
+	resp, err := client.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(bucket),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// legacy location constraints
+	switch resp.LocationConstraint {
+	case "":
+		return "us-east-1", nil
+	case "EU":
+		return "eu-west-1", nil
+	default:
+		return string(resp.LocationConstraint), nil
+	}
+}
@@ -0,0 +1,139 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+)
+
+// ListTags takes at most this many trail ARNs per call
+const listTagsBatch = 20
+
+// TagFilter selects trails by their CloudTrail resource tags. Each entry is
+// key=value, where the value may use * wildcards, or a bare key matching any
+// value.
+type TagFilter struct {
+	// trails must carry every one of these tags
+	Include []string
+	// trails carrying any of these are dropped
+	Exclude []string
+}
+
+func (f TagFilter) IsZero() bool {
+	return len(f.Include) == 0 && len(f.Exclude) == 0
+}
+
+// Validate checks every entry has a key and a valid value pattern
+func (f TagFilter) Validate() error {
+	for _, spec := range append(append([]string{}, f.Include...), f.Exclude...) {
+		key, value, _ := strings.Cut(spec, "=")
+		if key == "" {
+			return fmt.Errorf("tag %q: want key=value or key", spec)
+		}
+		if _, err := path.Match(value, ""); err != nil {
+			return fmt.Errorf("tag %q: %w", spec, err)
+		}
+	}
+	return nil
+}
+
+// Match reports whether a trail with these tags is selected
+func (f TagFilter) Match(tags map[string]string) bool {
+	for _, spec := range f.Include {
+		if !tagMatch(spec, tags) {
+			return false
+		}
+	}
+	for _, spec := range f.Exclude {
+		if tagMatch(spec, tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func tagMatch(spec string, tags map[string]string) bool {
+	key, pattern, hasValue := strings.Cut(spec, "=")
+	value, ok := tags[key]
+	if !ok {
+		return false
+	}
+	if !hasValue {
+		return true
+	}
+	matched, _ := path.Match(pattern, value)
+	return matched
+}
+
+// SelectTrailsByTags keeps the trails whose tags pass the filter, reading
+// them with ListTags in each trail's home region. A trail whose tags can't
+// be read is kept only when the filter has no Include entries.
+func SelectTrailsByTags(ctx context.Context, cfg aws.Config, trails []Trail, filter TagFilter, logger *slog.Logger) ([]Trail, error) {
+	byRegion := make(map[string][]string)
+	for _, trail := range trails {
+		if trail.ARN == "" {
+			continue
+		}
+		byRegion[trail.HomeRegion] = append(byRegion[trail.HomeRegion], trail.ARN)
+	}
+
+	tags := make(map[string]map[string]string)
+	unread := make(map[string]error)
+	for region, arns := range byRegion {
+		client := cloudtrail.NewFromConfig(cfg, func(o *cloudtrail.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+		for batch := range slices.Chunk(arns, listTagsBatch) {
+			resp, err := client.ListTags(ctx, &cloudtrail.ListTagsInput{ResourceIdList: batch})
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, ctx.Err()
+				}
+				for _, arn := range batch {
+					unread[arn] = err
+				}
+				continue
+			}
+			for _, rt := range resp.ResourceTagList {
+				m := make(map[string]string, len(rt.TagsList))
+				for _, tag := range rt.TagsList {
+					m[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+				}
+				tags[aws.ToString(rt.ResourceId)] = m
+			}
+		}
+	}
+
+	var selected []Trail
+	for _, trail := range trails {
+		if err, ok := unread[trail.ARN]; ok || trail.ARN == "" {
+			keep := len(filter.Include) == 0
+			attrs := []any{slog.String("trail", trail.Name), slog.Bool("kept", keep)}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			logger.Warn("failed to read trail tags", attrs...)
+			if keep {
+				selected = append(selected, trail)
+			}
+			continue
+		}
+		if !filter.Match(tags[trail.ARN]) {
+			logger.Info("skipping trail not selected by tags", slog.String("trail", trail.Name))
+			continue
+		}
+		selected = append(selected, trail)
+	}
+	logger.Info("selected trails by tags",
+		slog.Int("selected", len(selected)),
+		slog.Int("discovered", len(trails)))
+	return selected, nil
+}
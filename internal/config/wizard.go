@@ -0,0 +1,236 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// wizard asks the user for generate-config settings one question at a time
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// runWizard walks the user through trail selection, time range, worker
+// sizing, filters and output settings, updating cfg in place
+func runWizard(in io.Reader, out io.Writer, cfg *Config, trails []Trail) error {
+	w := &wizard{in: bufio.NewReader(in), out: out}
+
+	selected, err := w.selectTrails(trails)
+	if err != nil {
+		return err
+	}
+	cfg.Trails = selected
+
+	fmt.Fprintln(out, "\nTime range (events outside it are skipped)")
+	if cfg.StartTime, err = w.askTime("Start (YYYY-MM-DD or RFC3339, blank for no limit)"); err != nil {
+		return err
+	}
+	if cfg.EndTime, err = w.askTime("End (YYYY-MM-DD or RFC3339, blank for no limit)"); err != nil {
+		return err
+	}
+
+	numCPU := runtime.NumCPU()
+	memBytes := detectMemory()
+	fmt.Fprintf(out, "\nWorker sizing: detected %d CPUs", numCPU)
+	if memBytes > 0 {
+		fmt.Fprintf(out, ", %.1f GiB memory", float64(memBytes)/(1<<30))
+	}
+	fmt.Fprintln(out)
+
+	downloadWorkers, processWorkers, processQueue := recommendSizing(numCPU, memBytes)
+	if cfg.DownloadWorkers, err = w.askInt("Download workers", downloadWorkers); err != nil {
+		return err
+	}
+	if cfg.ProcessWorkers, err = w.askInt("Process workers", processWorkers); err != nil {
+		return err
+	}
+	if cfg.ProcessQueueSize, err = w.askInt("Process queue size", processQueue); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "\nFilters (comma separated, * wildcards allowed, blank for none)")
+	if cfg.Filters.IncludeEventSources, err = w.askList("Include event sources (e.g. iam.amazonaws.com)"); err != nil {
+		return err
+	}
+	if cfg.Filters.ExcludeEventSources, err = w.askList("Exclude event sources"); err != nil {
+		return err
+	}
+	if cfg.Filters.IncludeEventNames, err = w.askList("Include event names"); err != nil {
+		return err
+	}
+	if cfg.Filters.ExcludeEventNames, err = w.askList("Exclude event names (e.g. Describe*,List*)"); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(out, "\nOutput")
+	for {
+		answer, err := w.ask("Format (jsonl, jsonl.gz, jsonl.zst, parquet, csv)", cfg.OutputFormat)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.ParseFormat(answer); err != nil {
+			fmt.Fprintln(out, "  ", err)
+			continue
+		}
+		cfg.OutputFormat = answer
+		break
+	}
+	if cfg.EventsDir, err = w.ask("Events directory", cfg.EventsDir); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (w *wizard) selectTrails(trails []Trail) ([]Trail, error) {
+	if len(trails) == 0 {
+		fmt.Fprintln(w.out, "No trails were discovered; add them to the config by hand.")
+		return []Trail{}, nil
+	}
+
+	fmt.Fprintf(w.out, "Discovered %d trails:\n", len(trails))
+	for i, trail := range trails {
+		var notes []string
+		if trail.Organization {
+			notes = append(notes, "organization")
+		}
+		if trail.Accessible != nil && !*trail.Accessible {
+			notes = append(notes, "NOT ACCESSIBLE")
+		}
+		note := ""
+		if len(notes) > 0 {
+			note = " [" + strings.Join(notes, ", ") + "]"
+		}
+		fmt.Fprintf(w.out, "  %d) %s  s3://%s/%s%s\n", i+1, trail.Name, trail.Bucket, trail.LogPrefix(), note)
+	}
+
+	for {
+		answer, err := w.ask("Trails to process (e.g. 1,3 or all)", "all")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "all" {
+			return trails, nil
+		}
+
+		var selected []Trail
+		valid := true
+		for _, field := range strings.Split(answer, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || n < 1 || n > len(trails) {
+				fmt.Fprintf(w.out, "   invalid selection %q\n", field)
+				valid = false
+				break
+			}
+			selected = append(selected, trails[n-1])
+		}
+		if valid {
+			return selected, nil
+		}
+	}
+}
+
+// ask prints a prompt and returns the trimmed answer, or def when blank
+func (w *wizard) ask(prompt, def string) (string, error) {
+	fmt.Fprintf(w.out, "%s [%s]: ", prompt, def)
+	line, err := w.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("read answer: %w", err)
+	}
+	if line = strings.TrimSpace(line); line == "" {
+		return def, nil
+	}
+	return line, nil
+}
+
+func (w *wizard) askInt(prompt string, def int) (int, error) {
+	for {
+		answer, err := w.ask(prompt, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		n, err := strconv.Atoi(answer)
+		if err == nil && n >= 0 {
+			return n, nil
+		}
+		fmt.Fprintln(w.out, "   enter a non-negative number")
+	}
+}
+
+func (w *wizard) askTime(prompt string) (string, error) {
+	for {
+		answer, err := w.ask(prompt, "")
+		if err != nil {
+			return "", err
+		}
+		if _, _, err := parseTimeBound(answer); err != nil {
+			fmt.Fprintln(w.out, "  ", err)
+			continue
+		}
+		return answer, nil
+	}
+}
+
+func (w *wizard) askList(prompt string) ([]string, error) {
+	answer, err := w.ask(prompt, "")
+	if err != nil || answer == "" {
+		return nil, err
+	}
+
+	var items []string
+	for _, item := range strings.Split(answer, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// recommendSizing suggests worker and queue sizes. Downloads are I/O bound so
+// scale well past the CPU count; each queued file holds its decoded records
+// (a few MB), so the process queue is bounded by available memory.
+func recommendSizing(numCPU int, memBytes uint64) (downloadWorkers, processWorkers, processQueue int) {
+	downloadWorkers = min(max(numCPU*8, 16), 200)
+	processWorkers = numCPU * 2
+
+	processQueue = Default().ProcessQueueSize
+	if memBytes > 0 {
+		const perFileBytes = 4 << 20
+		processQueue = min(max(int(memBytes/4/perFileBytes), 100), processQueue)
+	}
+	return downloadWorkers, processWorkers, processQueue
+}
+
+// detectMemory returns the container memory limit or total system memory in
+// bytes, or 0 when neither can be read
+func detectMemory() uint64 {
+	// cgroup v2 limit, "max" when unlimited
+	if data, err := os.ReadFile("/sys/fs/cgroup/memory.max"); err == nil {
+		if n, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err == nil {
+			return n
+		}
+	}
+
+	data, err := os.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err == nil {
+				return kb * 1024
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,124 @@
+package convert
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type Options struct {
+	InputDir  string
+	OutputDir string
+	Format    writer.Format
+	// fields a csv target flattens into columns
+	CSVColumns []string
+	// decrypts encrypted sources and encrypts the converted files; nil
+	// writes plaintext
+	Cipher crypt.Cipher
+	// delete each source file once converted; always done when converting
+	// in place, so a partition never holds the same events twice
+	RemoveSource bool
+}
+
+type Result struct {
+	FilesConverted int
+	FilesSkipped   int
+	Events         int
+}
+
+// Run rewrites every event file under InputDir into the requested format,
+// keeping the account/region/date partition layout intact under OutputDir,
+// which defaults to InputDir
+func Run(opts Options, logger *slog.Logger) (Result, error) {
+	var res Result
+
+	if opts.OutputDir == "" {
+		opts.OutputDir = opts.InputDir
+	}
+	if filepath.Clean(opts.OutputDir) == filepath.Clean(opts.InputDir) {
+		opts.RemoveSource = true
+	}
+
+	err := filepath.WalkDir(opts.InputDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// don't descend into the destination when it sits inside the source tree
+			if path != opts.InputDir && filepath.Clean(path) == filepath.Clean(opts.OutputDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		srcFormat, ok := writer.FormatFromPath(path)
+		if !ok {
+			return nil
+		}
+		if srcFormat == writer.FormatCSV {
+			// it holds only its columns, so there are no events to convert
+			logger.Warn("skipping csv file, which can't be converted",
+				slog.String("path", path))
+			res.FilesSkipped++
+			return nil
+		}
+
+		rel, err := filepath.Rel(opts.InputDir, path)
+		if err != nil {
+			return fmt.Errorf("relative path: %w", err)
+		}
+		enc := writer.Encoding{Format: opts.Format, CSVColumns: opts.CSVColumns, Cipher: opts.Cipher}
+		dstPath := filepath.Join(opts.OutputDir, writer.TrimExtension(rel)+enc.FileExtension())
+
+		if srcFormat == opts.Format && dstPath == path {
+			res.FilesSkipped++
+			return nil
+		}
+
+		n, err := convertFile(path, dstPath, enc)
+		if err != nil {
+			return fmt.Errorf("convert %s: %w", path, err)
+		}
+
+		if opts.RemoveSource && dstPath != path {
+			if err := os.Remove(path); err != nil {
+				return fmt.Errorf("remove source %s: %w", path, err)
+			}
+			if err := writer.UpdateManifest(path); err != nil {
+				return err
+			}
+		}
+
+		res.FilesConverted++
+		res.Events += n
+
+		logger.Debug("converted file",
+			slog.String("source", path),
+			slog.String("destination", dstPath),
+			slog.Int("events", n))
+		return nil
+	})
+	if err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func convertFile(srcPath, dstPath string, enc writer.Encoding) (int, error) {
+	events, err := writer.ReadEventsFile(srcPath, enc.Cipher)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := writer.WriteEventsFile(dstPath, enc, events); err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
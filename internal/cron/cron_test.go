@@ -0,0 +1,93 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@every",
+	} {
+		if _, err := Parse(expr); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	local := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04 MST", s, ny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"step within hour", "*/15 * * * *", utc("2024-01-01 10:07"), utc("2024-01-01 10:15")},
+		{"step rolls hour", "*/15 * * * *", utc("2024-01-01 10:45"), utc("2024-01-01 11:00")},
+		{"stepped from value", "10/20 * * * *", utc("2024-01-01 10:31"), utc("2024-01-01 10:50")},
+		{"always after from", "0 * * * *", utc("2024-01-01 10:00"), utc("2024-01-01 11:00")},
+		{"seconds dropped", "@hourly", utc("2024-01-01 10:00").Add(30 * time.Second), utc("2024-01-01 11:00")},
+		{"7 is sunday", "0 0 * * 7", utc("2024-01-03 12:00"), utc("2024-01-07 00:00")},
+		{"named sunday", "0 0 * * sun", utc("2024-01-03 12:00"), utc("2024-01-07 00:00")},
+		{"weekday range", "30 9 * * 1-5", utc("2024-01-05 10:00"), utc("2024-01-08 09:30")},
+		{"list", "0 6,18 * * *", utc("2024-01-01 07:00"), utc("2024-01-01 18:00")},
+		// with both day fields restricted either one matching is enough
+		{"dom or dow, dow first", "0 12 13 * fri", utc("2024-01-10 00:00"), utc("2024-01-12 12:00")},
+		{"dom or dow, dom first", "0 12 13 * fri", utc("2024-01-12 13:00"), utc("2024-01-13 12:00")},
+		{"dom with dow star", "0 0 13 * *", utc("2024-01-12 00:00"), utc("2024-01-13 00:00")},
+		{"dow with dom star", "0 0 * * fri", utc("2024-01-13 00:00"), utc("2024-01-19 00:00")},
+		{"month without the day", "0 0 31 * *", utc("2024-04-01 00:00"), utc("2024-05-31 00:00")},
+		{"named month", "0 0 1 jun *", utc("2024-01-01 00:00"), utc("2024-06-01 00:00")},
+		{"year rollover", "@yearly", utc("2024-12-31 23:59"), utc("2025-01-01 00:00")},
+		{"leap day", "0 0 29 2 *", utc("2025-03-01 00:00"), utc("2028-02-29 00:00")},
+		{"never", "0 0 30 2 *", utc("2024-01-01 00:00"), time.Time{}},
+		// 2024-03-10 02:00 EST jumps to 03:00 EDT
+		{"hour across spring forward", "0 3 * * *", local("2024-03-10 00:00 EST"), local("2024-03-10 03:00 EDT")},
+		// a time that doesn't exist that day waits for the next day
+		{"skipped hour", "30 2 * * *", local("2024-03-10 00:00 EST"), local("2024-03-11 02:30 EDT")},
+		// 2024-11-03 02:00 EDT falls back to 01:00 EST
+		{"hourly across fall back", "0 * * * *", local("2024-11-03 01:00 EDT"), local("2024-11-03 01:00 EST")},
+		{"daily across fall back", "0 0 * * *", local("2024-11-03 00:00 EDT"), local("2024-11-04 00:00 EST")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := s.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Parse(%q).Next(%v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package crypt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+)
+
+// Age encrypts to a set of age recipients and decrypts with the identities
+// from an identity file. Either may be missing when only the other
+// direction is needed.
+type Age struct {
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewAge parses X25519 recipients (age1...) and, when identityFile is set,
+// the identities in it
+func NewAge(recipients []string, identityFile string) (*Age, error) {
+	a := &Age{}
+	if len(recipients) > 0 {
+		rs, err := age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("parse age recipients: %w", err)
+		}
+		a.recipients = rs
+	}
+	if identityFile != "" {
+		f, err := os.Open(identityFile)
+		if err != nil {
+			return nil, fmt.Errorf("open age identity file: %w", err)
+		}
+		defer func() { _ = f.Close() }()
+		ids, err := age.ParseIdentities(f)
+		if err != nil {
+			return nil, fmt.Errorf("parse age identity file: %w", err)
+		}
+		a.identities = ids
+	}
+	if len(a.recipients) == 0 && len(a.identities) == 0 {
+		return nil, fmt.Errorf("age encryption needs recipients or an identity file")
+	}
+	return a, nil
+}
+
+func (a *Age) Encrypt(plaintext []byte) ([]byte, error) {
+	if len(a.recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients to encrypt to")
+	}
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, a.recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("age encrypt: %w", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, fmt.Errorf("age encrypt: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("age encrypt: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (a *Age) Decrypt(ciphertext []byte) ([]byte, error) {
+	if len(a.identities) == 0 {
+		return nil, fmt.Errorf("no age identity file to decrypt with")
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), a.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("age decrypt: %w", err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("age decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (a *Age) Extension() string {
+	return AgeExtension
+}
@@ -0,0 +1,30 @@
+// Package crypt encrypts output files at rest, either to age recipients or
+// with AES-256-GCM under a key read from a file or generated by KMS
+package crypt
+
+import "strings"
+
+// Cipher encrypts and decrypts whole output files
+type Cipher interface {
+	Encrypt(plaintext []byte) ([]byte, error)
+	Decrypt(ciphertext []byte) ([]byte, error)
+	// Extension is the suffix added to encrypted file names, including the
+	// leading dot
+	Extension() string
+}
+
+const (
+	AgeExtension = ".age"
+	GCMExtension = ".enc"
+)
+
+// Split separates an encrypted file's suffix from the rest of its name,
+// returning an empty suffix for plaintext files
+func Split(path string) (string, string) {
+	for _, ext := range []string{AgeExtension, GCMExtension} {
+		if strings.HasSuffix(path, ext) {
+			return strings.TrimSuffix(path, ext), ext
+		}
+	}
+	return path, ""
+}
@@ -0,0 +1,202 @@
+package crypt
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// gcmMagic starts every AES-GCM file. It's followed by the length of the
+// KMS-encrypted data key (a big-endian uint16, zero with a key file), the
+// encrypted data key, the nonce and the sealed contents, which
+// authenticate everything before them.
+var gcmMagic = []byte("GCTENC\x00\x01")
+
+const (
+	gcmKeySize = 32
+	// how long a KMS call may take
+	kmsTimeout = 30 * time.Second
+)
+
+// GCM encrypts with AES-256-GCM, under either a fixed key or a data key
+// generated by KMS once per run and stored, encrypted, in each file
+type GCM struct {
+	key []byte
+
+	kms   *kms.Client
+	keyID string
+	// the run's data key, generated on the first Encrypt
+	once    sync.Once
+	dataKey []byte
+	wrapped []byte
+	keyErr  error
+	// data keys already decrypted by KMS, by their encrypted form
+	mu        sync.Mutex
+	unwrapped map[string][]byte
+}
+
+// NewGCMKeyFile reads a 32 byte key from path, either raw or hex or base64
+// encoded
+func NewGCMKeyFile(path string) (*GCM, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file: %w", err)
+	}
+	key, err := parseKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return &GCM{key: key}, nil
+}
+
+// NewGCMKMS uses data keys from the KMS key keyID
+func NewGCMKMS(client *kms.Client, keyID string) *GCM {
+	return &GCM{kms: client, keyID: keyID, unwrapped: make(map[string][]byte)}
+}
+
+func parseKey(data []byte) ([]byte, error) {
+	if len(data) == gcmKeySize {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == gcmKeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == gcmKeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("want a %d byte key, raw or hex or base64 encoded", gcmKeySize)
+}
+
+func (g *GCM) Encrypt(plaintext []byte) ([]byte, error) {
+	key, wrapped, err := g.encryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(gcmMagic)+2+len(wrapped)+aead.NonceSize())
+	header = append(header, gcmMagic...)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(wrapped)))
+	header = append(header, wrapped...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plaintext, header), nil
+}
+
+func (g *GCM) Decrypt(ciphertext []byte) ([]byte, error) {
+	if !bytes.HasPrefix(ciphertext, gcmMagic) || len(ciphertext) < len(gcmMagic)+2 {
+		return nil, fmt.Errorf("not an AES-GCM encrypted file")
+	}
+	rest := ciphertext[len(gcmMagic):]
+	n := int(binary.BigEndian.Uint16(rest))
+	rest = rest[2:]
+	if len(rest) < n {
+		return nil, fmt.Errorf("truncated AES-GCM header")
+	}
+	wrapped := rest[:n]
+
+	key, err := g.decryptionKey(wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	headerLen := len(gcmMagic) + 2 + n + aead.NonceSize()
+	if len(ciphertext) < headerLen {
+		return nil, fmt.Errorf("truncated AES-GCM header")
+	}
+	header := ciphertext[:headerLen]
+	plaintext, err := aead.Open(nil, header[len(header)-aead.NonceSize():], ciphertext[headerLen:], header)
+	if err != nil {
+		return nil, fmt.Errorf("AES-GCM decrypt: %w", err)
+	}
+	return plaintext, nil
+}
+
+func (g *GCM) Extension() string {
+	return GCMExtension
+}
+
+// encryptionKey returns the key to encrypt with and, under KMS, its
+// encrypted form for the file header
+func (g *GCM) encryptionKey() ([]byte, []byte, error) {
+	if g.kms == nil {
+		return g.key, nil, nil
+	}
+	g.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+		defer cancel()
+		out, err := g.kms.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+			KeyId:   aws.String(g.keyID),
+			KeySpec: types.DataKeySpecAes256,
+		})
+		if err != nil {
+			g.keyErr = fmt.Errorf("generate KMS data key: %w", err)
+			return
+		}
+		g.dataKey, g.wrapped = out.Plaintext, out.CiphertextBlob
+	})
+	return g.dataKey, g.wrapped, g.keyErr
+}
+
+// decryptionKey returns the key a file was encrypted with, asking KMS to
+// decrypt its data key the first time it's seen
+func (g *GCM) decryptionKey(wrapped []byte) ([]byte, error) {
+	if g.kms == nil {
+		if len(wrapped) > 0 {
+			return nil, fmt.Errorf("file was encrypted with a KMS data key, not a key file")
+		}
+		return g.key, nil
+	}
+	if len(wrapped) == 0 {
+		return nil, fmt.Errorf("file was encrypted with a key file, not KMS")
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if key, ok := g.unwrapped[string(wrapped)]; ok {
+		return key, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), kmsTimeout)
+	defer cancel()
+	out, err := g.kms.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrapped,
+		KeyId:          aws.String(g.keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("decrypt KMS data key: %w", err)
+	}
+	g.unwrapped[string(wrapped)] = out.Plaintext
+	return out.Plaintext, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("AES key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,47 @@
+package crypt
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Signer signs SHA-256 digests
+type Signer interface {
+	Sign(ctx context.Context, digest []byte) ([]byte, error)
+}
+
+// KMSSigner signs SHA-256 digests with an asymmetric KMS key
+type KMSSigner struct {
+	client    *kms.Client
+	keyID     string
+	algorithm types.SigningAlgorithmSpec
+}
+
+// NewKMSSigner signs with keyID using algorithm, ECDSA_SHA_256 when empty.
+// The algorithm must hash with SHA-256.
+func NewKMSSigner(client *kms.Client, keyID, algorithm string) *KMSSigner {
+	if algorithm == "" {
+		algorithm = string(types.SigningAlgorithmSpecEcdsaSha256)
+	}
+	return &KMSSigner{client: client, keyID: keyID, algorithm: types.SigningAlgorithmSpec(algorithm)}
+}
+
+// Sign returns the signature over a SHA-256 digest
+func (s *KMSSigner) Sign(ctx context.Context, digest []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, kmsTimeout)
+	defer cancel()
+	out, err := s.client.Sign(ctx, &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest,
+		MessageType:      types.MessageTypeDigest,
+		SigningAlgorithm: s.algorithm,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("KMS sign: %w", err)
+	}
+	return out.Signature, nil
+}
@@ -0,0 +1,148 @@
+package dedupe
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type Options struct {
+	EventsDir string
+	// reads and rewrites encrypted files
+	Cipher crypt.Cipher
+	DryRun bool
+}
+
+// PartitionResult reports what was found in a single partition directory
+type PartitionResult struct {
+	Partition    string
+	Files        int
+	Events       int
+	Duplicates   int
+	FilesRemoved int
+}
+
+// Run removes events whose eventID was already seen earlier in the same
+// partition. Events are routed by their own account/region/time, so every
+// copy of an event lands in the same partition and a per-partition scan is
+// exact while keeping memory bounded.
+func Run(opts Options, logger *slog.Logger) ([]PartitionResult, error) {
+	partitions := make(map[string][]string)
+	err := filepath.WalkDir(opts.EventsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		format, ok := writer.FormatFromPath(path)
+		if !ok {
+			return nil
+		}
+		if format == writer.FormatCSV {
+			// it holds only its columns, so there are no eventIDs to compare
+			logger.Warn("skipping csv file, which can't be deduplicated",
+				slog.String("path", path))
+			return nil
+		}
+		dir := filepath.Dir(path)
+		partitions[dir] = append(partitions[dir], path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan events dir: %w", err)
+	}
+
+	dirs := make([]string, 0, len(partitions))
+	for dir := range partitions {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	results := make([]PartitionResult, 0, len(dirs))
+	for _, dir := range dirs {
+		res, err := dedupePartition(dir, partitions[dir], opts.Cipher, opts.DryRun)
+		if err != nil {
+			return results, fmt.Errorf("dedupe %s: %w", dir, err)
+		}
+		if rel, err := filepath.Rel(opts.EventsDir, dir); err == nil {
+			res.Partition = rel
+		}
+		results = append(results, res)
+
+		if res.Duplicates > 0 {
+			logger.Info("removed duplicates from partition",
+				slog.String("partition", res.Partition),
+				slog.Int("events", res.Events),
+				slog.Int("duplicates", res.Duplicates),
+				slog.Int("files_removed", res.FilesRemoved),
+				slog.Bool("dry_run", opts.DryRun))
+		}
+	}
+
+	return results, nil
+}
+
+func dedupePartition(dir string, files []string, c crypt.Cipher, dryRun bool) (PartitionResult, error) {
+	res := PartitionResult{Partition: dir, Files: len(files)}
+
+	// keep the earliest written copy of each event
+	sort.Strings(files)
+
+	seen := make(map[string]struct{})
+	for _, path := range files {
+		events, err := writer.ReadEventsFile(path, c)
+		if err != nil {
+			return res, err
+		}
+
+		kept := events[:0:0]
+		for _, event := range events {
+			res.Events++
+
+			var ev struct {
+				EventID string `json:"eventID"`
+			}
+			if err := json.Unmarshal(event, &ev); err != nil || ev.EventID == "" {
+				kept = append(kept, event)
+				continue
+			}
+
+			if _, dup := seen[ev.EventID]; dup {
+				res.Duplicates++
+				continue
+			}
+			seen[ev.EventID] = struct{}{}
+			kept = append(kept, event)
+		}
+
+		if len(kept) == len(events) || dryRun {
+			continue
+		}
+
+		if len(kept) == 0 {
+			if err := os.Remove(path); err != nil {
+				return res, fmt.Errorf("remove empty file: %w", err)
+			}
+			if err := writer.UpdateManifest(path); err != nil {
+				return res, err
+			}
+			res.FilesRemoved++
+			continue
+		}
+
+		format, _ := writer.FormatFromPath(path)
+		if err := writer.WriteEventsFile(path, writer.Encoding{Format: format, Cipher: c}, kept); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
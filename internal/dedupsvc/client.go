@@ -0,0 +1,301 @@
+package dedupsvc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/deceptiq/gocloudtrail/internal/rpc"
+)
+
+// how long an event ID a batch lookup found unseen is trusted for: long
+// enough for its file to be processed, short enough that another collector's
+// later add is noticed
+const unseenTTL = time.Minute
+
+// how many batches of adds are held while the service can't be reached
+// before the oldest are dropped
+const maxPendingBatches = 100
+
+// ClientOptions configures the connection to a dedup service
+type ClientOptions struct {
+	// host:port of the service
+	Addr string
+	// bearer token the service requires
+	Token string
+	// connect with TLS, verifying the server against CAFile or, when
+	// empty, the system roots
+	TLS    bool
+	CAFile string
+	// event IDs per Add call
+	BatchSize int
+	// how long an added event ID waits for its batch to fill
+	FlushInterval time.Duration
+	// event IDs known to be seen kept locally, so they aren't asked about
+	// again
+	CacheSize int
+	// per call
+	Timeout time.Duration
+	// only send adds on Save, so the service never holds events that
+	// weren't acknowledged downstream
+	DeferAdds bool
+}
+
+// Client is a dedup store backed by a dedup service. Adds are sent in
+// batches in the background, and IDs known to be seen are cached.
+type Client struct {
+	conn   *grpc.ClientConn
+	opts   ClientOptions
+	logger *slog.Logger
+
+	mu sync.Mutex
+	// event IDs seen, in two generations: once seen is full it becomes
+	// prevSeen, dropping the older one
+	seen, prevSeen map[string]struct{}
+	// event IDs a batch lookup found unseen, until when that's trusted
+	unseen  map[string]time.Time
+	pending []Item
+
+	flushNow chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	saveMu   sync.Mutex
+}
+
+// Dial connects to the service and starts sending adds
+func Dial(opts ClientOptions, logger *slog.Logger) (*Client, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1000
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+	if opts.CacheSize <= 0 {
+		opts.CacheSize = 1_000_000
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 10 * time.Second
+	}
+
+	conn, err := rpc.Dial(opts.Addr, opts.TLS, opts.CAFile)
+	if err != nil {
+		return nil, fmt.Errorf("dedup service: %w", err)
+	}
+
+	c := &Client{
+		conn:     conn,
+		opts:     opts,
+		logger:   logger,
+		seen:     make(map[string]struct{}),
+		unseen:   make(map[string]time.Time),
+		flushNow: make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go c.flusher()
+	logger.Info("using dedup service",
+		slog.String("addr", opts.Addr),
+		slog.Bool("tls", opts.TLS))
+	return c, nil
+}
+
+func (c *Client) call(method string, req, resp any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	return c.conn.Invoke(rpc.WithToken(ctx, c.opts.Token), method, req, resp)
+}
+
+// Prefetch looks up a batch of event IDs in one call, so Test answers them
+// without a call each
+func (c *Client) Prefetch(ids [][]byte, times []time.Time) {
+	req := TestRequest{Items: make([]Item, 0, len(ids))}
+	c.mu.Lock()
+	now := time.Now()
+	for i, id := range ids {
+		if c.cachedLocked(string(id), now) {
+			continue
+		}
+		req.Items = append(req.Items, Item{ID: string(id), Time: times[i].Unix()})
+	}
+	c.mu.Unlock()
+	if len(req.Items) == 0 {
+		return
+	}
+
+	var resp TestResponse
+	if err := c.call(testMethod, &req, &resp); err != nil {
+		c.logger.Warn("dedup service lookup failed",
+			slog.Int("events", len(req.Items)),
+			slog.String("error", err.Error()))
+		return
+	}
+	c.mu.Lock()
+	until := time.Now().Add(unseenTTL)
+	for i, item := range req.Items {
+		if i < len(resp.Seen) && resp.Seen[i] {
+			c.markSeenLocked(item.ID)
+		} else {
+			c.unseen[item.ID] = until
+		}
+	}
+	c.mu.Unlock()
+}
+
+// cachedLocked reports whether id's answer is known locally
+func (c *Client) cachedLocked(id string, now time.Time) bool {
+	if c.isSeenLocked(id) {
+		return true
+	}
+	until, ok := c.unseen[id]
+	return ok && now.Before(until)
+}
+
+func (c *Client) isSeenLocked(id string) bool {
+	if _, ok := c.seen[id]; ok {
+		return true
+	}
+	_, ok := c.prevSeen[id]
+	return ok
+}
+
+func (c *Client) markSeenLocked(id string) {
+	delete(c.unseen, id)
+	if len(c.seen) >= c.opts.CacheSize/2 {
+		c.prevSeen, c.seen = c.seen, make(map[string]struct{})
+	}
+	c.seen[id] = struct{}{}
+}
+
+// Test reports whether the event ID may have been added, by this or any
+// other client. When the service can't be reached the event is taken as
+// new, so it may be written twice but is never lost.
+func (c *Client) Test(id []byte, eventTime time.Time) bool {
+	key := string(id)
+	c.mu.Lock()
+	if c.isSeenLocked(key) {
+		c.mu.Unlock()
+		return true
+	}
+	if until, ok := c.unseen[key]; ok && time.Now().Before(until) {
+		c.mu.Unlock()
+		return false
+	}
+	c.mu.Unlock()
+
+	var resp TestResponse
+	err := c.call(testMethod, &TestRequest{Items: []Item{{ID: key, Time: eventTime.Unix()}}}, &resp)
+	if err != nil {
+		c.logger.Warn("dedup service lookup failed", slog.String("error", err.Error()))
+		return false
+	}
+	seen := len(resp.Seen) > 0 && resp.Seen[0]
+	if seen {
+		c.mu.Lock()
+		c.markSeenLocked(key)
+		c.mu.Unlock()
+	}
+	return seen
+}
+
+// Add queues the event ID for the next batch
+func (c *Client) Add(id []byte, eventTime time.Time) {
+	c.mu.Lock()
+	c.markSeenLocked(string(id))
+	c.pending = append(c.pending, Item{ID: string(id), Time: eventTime.Unix()})
+	full := len(c.pending) >= c.opts.BatchSize
+	c.mu.Unlock()
+	if full && !c.opts.DeferAdds {
+		select {
+		case c.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Save sends every queued add
+func (c *Client) Save() error {
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+
+	c.mu.Lock()
+	items := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+
+	for len(items) > 0 {
+		batch := items[:min(len(items), c.opts.BatchSize)]
+		if err := c.call(addMethod, &AddRequest{Items: batch}, &AddResponse{}); err != nil {
+			c.requeue(items)
+			return fmt.Errorf("dedup service add: %w", err)
+		}
+		items = items[len(batch):]
+	}
+	return nil
+}
+
+// requeue puts adds that couldn't be sent back in front of the queue,
+// dropping the oldest past maxPendingBatches
+func (c *Client) requeue(items []Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending = append(items, c.pending...)
+	if limit := maxPendingBatches * c.opts.BatchSize; len(c.pending) > limit {
+		dropped := len(c.pending) - limit
+		c.pending = c.pending[dropped:]
+		c.logger.Warn("dedup service unreachable, dropped queued event IDs",
+			slog.Int("dropped", dropped))
+	}
+}
+
+// flusher sends adds whenever a batch fills or FlushInterval passes, and
+// forgets expired unseen answers
+func (c *Client) flusher() {
+	defer close(c.done)
+	ticker := time.NewTicker(c.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.expireUnseen()
+		case <-c.flushNow:
+		}
+		if c.opts.DeferAdds {
+			continue
+		}
+		if err := c.Save(); err != nil {
+			c.logger.Warn("failed to send event IDs to dedup service", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (c *Client) expireUnseen() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, until := range c.unseen {
+		if !now.Before(until) {
+			delete(c.unseen, id)
+		}
+	}
+}
+
+// Close sends the queued adds, unless they wait for Save, and disconnects
+func (c *Client) Close() error {
+	close(c.stop)
+	<-c.done
+	var err error
+	if !c.opts.DeferAdds {
+		err = c.Save()
+	}
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
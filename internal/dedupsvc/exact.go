@@ -0,0 +1,185 @@
+package dedupsvc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Exact is a store without false positives: it keeps a 16-byte hash of every
+// event ID, per day of event time so a retention window can drop whole days.
+// It needs far more memory per ID than a bloom filter.
+type Exact struct {
+	mu        sync.RWMutex
+	saveMu    sync.Mutex
+	path      string
+	retention time.Duration
+	// keyed by day since the epoch, or all under 0 without a retention
+	days   map[int64]map[[16]byte]struct{}
+	dirty  bool
+	logger *slog.Logger
+}
+
+// LoadExact reads the set from path, or starts an empty one. With a non-zero
+// retention, IDs are only remembered for events within the window.
+func LoadExact(path string, retention time.Duration, logger *slog.Logger) (*Exact, error) {
+	e := &Exact{
+		path:      path,
+		retention: retention,
+		days:      make(map[int64]map[[16]byte]struct{}),
+		logger:    logger,
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.Info("creating new exact dedup set", slog.String("path", path))
+		return e, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open exact dedup set: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var ids int
+	for {
+		var header [16]byte
+		if _, err := io.ReadFull(r, header[:]); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("read exact dedup set: %w", err)
+		}
+		index := int64(binary.BigEndian.Uint64(header[:8]))
+		count := binary.BigEndian.Uint64(header[8:])
+		set := make(map[[16]byte]struct{}, count)
+		for range count {
+			var h [16]byte
+			if _, err := io.ReadFull(r, h[:]); err != nil {
+				return nil, fmt.Errorf("read exact dedup set: %w", err)
+			}
+			set[h] = struct{}{}
+		}
+		e.days[index] = set
+		ids += len(set)
+	}
+	e.evict(time.Now())
+	logger.Info("loaded exact dedup set",
+		slog.String("path", path),
+		slog.Int("days", len(e.days)),
+		slog.Int("ids", ids))
+	return e, nil
+}
+
+func (e *Exact) index(t time.Time) int64 {
+	if e.retention == 0 {
+		return 0
+	}
+	return t.Unix() / int64(day/time.Second)
+}
+
+func (e *Exact) expired(t time.Time, now time.Time) bool {
+	return e.retention > 0 && e.index(t) < e.index(now.Add(-e.retention))
+}
+
+func hashID(id []byte) [16]byte {
+	sum := sha256.Sum256(id)
+	return [16]byte(sum[:16])
+}
+
+// Test reports whether the event ID was added. Events older than the
+// retention window are never reported as seen.
+func (e *Exact) Test(id []byte, eventTime time.Time) bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.expired(eventTime, time.Now()) {
+		return false
+	}
+	_, ok := e.days[e.index(eventTime)][hashID(id)]
+	return ok
+}
+
+func (e *Exact) Add(id []byte, eventTime time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.expired(eventTime, time.Now()) {
+		return
+	}
+	index := e.index(eventTime)
+	set, ok := e.days[index]
+	if !ok {
+		set = make(map[[16]byte]struct{})
+		e.days[index] = set
+	}
+	set[hashID(id)] = struct{}{}
+	e.dirty = true
+}
+
+// evict drops the days that have aged out of the retention window
+func (e *Exact) evict(now time.Time) {
+	for index := range e.days {
+		if e.retention > 0 && index < e.index(now.Add(-e.retention)) {
+			delete(e.days, index)
+			e.dirty = true
+		}
+	}
+}
+
+// Save evicts expired days and writes the set when it changed
+func (e *Exact) Save() error {
+	e.saveMu.Lock()
+	defer e.saveMu.Unlock()
+
+	e.mu.Lock()
+	e.evict(time.Now())
+	dirty := e.dirty
+	e.dirty = false
+	e.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+
+	tmpFile := e.path + ".tmp"
+	file, err := os.Create(tmpFile)
+	if err != nil {
+		return fmt.Errorf("create temp file: %w", err)
+	}
+	w := bufio.NewWriter(file)
+	e.mu.RLock()
+	for index, set := range e.days {
+		var header [16]byte
+		binary.BigEndian.PutUint64(header[:8], uint64(index))
+		binary.BigEndian.PutUint64(header[8:], uint64(len(set)))
+		w.Write(header[:])
+		for h := range set {
+			w.Write(h[:])
+		}
+	}
+	e.mu.RUnlock()
+	if err := w.Flush(); err != nil {
+		file.Close()
+		e.markDirty()
+		return fmt.Errorf("write exact dedup set: %w", err)
+	}
+	file.Close()
+
+	if err := os.Rename(tmpFile, e.path); err != nil {
+		e.markDirty()
+		return fmt.Errorf("rename exact dedup set: %w", err)
+	}
+	e.logger.Debug("saved exact dedup set", slog.String("path", e.path))
+	return nil
+}
+
+func (e *Exact) markDirty() {
+	e.mu.Lock()
+	e.dirty = true
+	e.mu.Unlock()
+}
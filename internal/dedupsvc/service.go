@@ -0,0 +1,138 @@
+// Package dedupsvc serves an event ID dedup store over gRPC, so a fleet of
+// collectors share one store and drop each other's duplicates, and is the
+// client the processor uses in place of its local bloom filter.
+//
+// Messages are JSON rather than protobuf, so the service needs no generated
+// code; any gRPC client sending content-subtype "json" can call it.
+package dedupsvc
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/deceptiq/gocloudtrail/internal/rpc"
+)
+
+const (
+	serviceName = "gocloudtrail.dedup.v1.Dedup"
+	testMethod  = "/" + serviceName + "/Test"
+	addMethod   = "/" + serviceName + "/Add"
+)
+
+// Item is an event ID and the event's time, which a store with a retention
+// window needs
+type Item struct {
+	ID string `json:"id"`
+	// seconds since the epoch
+	Time int64 `json:"t"`
+}
+
+// TestRequest asks which event IDs the store may have seen
+type TestRequest struct {
+	Items []Item `json:"items"`
+}
+
+// TestResponse answers a TestRequest, item for item
+type TestResponse struct {
+	Seen []bool `json:"seen"`
+}
+
+// AddRequest adds event IDs to the store
+type AddRequest struct {
+	Items []Item `json:"items"`
+}
+
+type AddResponse struct{}
+
+// Store is what the service hosts: a bloom.Filter or an Exact set
+type Store interface {
+	Test(id []byte, eventTime time.Time) bool
+	Add(id []byte, eventTime time.Time)
+	Save() error
+}
+
+// Server answers Test and Add calls from a Store
+type Server struct {
+	store  Store
+	token  string
+	logger *slog.Logger
+
+	tested atomic.Int64
+	seen   atomic.Int64
+	added  atomic.Int64
+}
+
+// NewServer serves store. With token set, calls must carry it as a bearer
+// token.
+func NewServer(store Store, token string, logger *slog.Logger) *Server {
+	return &Server{store: store, token: token, logger: logger}
+}
+
+// Register adds the service to g
+func (s *Server) Register(g *grpc.Server) {
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*any)(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "Test", Handler: s.handleTest},
+			{MethodName: "Add", Handler: s.handleAdd},
+		},
+	}, s)
+}
+
+// Interceptor rejects calls without the server's token
+func (s *Server) Interceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := rpc.CheckToken(ctx, s.token); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// Counts returns how many event IDs were tested, found seen and added
+func (s *Server) Counts() (tested, seen, added int64) {
+	return s.tested.Load(), s.seen.Load(), s.added.Load()
+}
+
+func (s *Server) handleTest(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	var req TestRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, _ any) (any, error) {
+		resp := &TestResponse{Seen: make([]bool, len(req.Items))}
+		for i, item := range req.Items {
+			if s.store.Test([]byte(item.ID), time.Unix(item.Time, 0)) {
+				resp.Seen[i] = true
+				s.seen.Add(1)
+			}
+		}
+		s.tested.Add(int64(len(req.Items)))
+		return resp, nil
+	}
+	if interceptor == nil {
+		return call(ctx, &req)
+	}
+	return interceptor(ctx, &req, &grpc.UnaryServerInfo{Server: s, FullMethod: testMethod}, call)
+}
+
+func (s *Server) handleAdd(_ any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	var req AddRequest
+	if err := dec(&req); err != nil {
+		return nil, err
+	}
+	call := func(ctx context.Context, _ any) (any, error) {
+		for _, item := range req.Items {
+			s.store.Add([]byte(item.ID), time.Unix(item.Time, 0))
+		}
+		s.added.Add(int64(len(req.Items)))
+		return &AddResponse{}, nil
+	}
+	if interceptor == nil {
+		return call(ctx, &req)
+	}
+	return interceptor(ctx, &req, &grpc.UnaryServerInfo{Server: s, FullMethod: addMethod}, call)
+}
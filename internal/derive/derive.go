@@ -0,0 +1,138 @@
+// Package derive adds fields computed from a CloudTrail event to it, so
+// consumers of an output don't each have to work them out
+package derive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/deceptiq/gocloudtrail/event"
+)
+
+// Key is the object holding the derived fields in an event
+const Key = "derived"
+
+// The fields that can be derived
+const (
+	// eventTime in milliseconds since the epoch
+	EventTimeMS = "event_time_ms"
+	// the caller is an assumed role session
+	IsAssumedRole = "is_assumed_role"
+	// the caller is an account's root user
+	IsRoot = "is_root"
+	// the caller belongs to another account than the one the event was
+	// delivered for
+	IsCrossAccount = "is_cross_account"
+	// the session or console sign-in used MFA
+	MFAUsed = "mfa_used"
+	// an AWS service made the call, on its own or on the caller's behalf
+	SourceIsAWSService = "source_is_aws_service"
+)
+
+// Fields lists the boolean fields in the order they're added
+var Fields = []string{IsAssumedRole, IsRoot, IsCrossAccount, MFAUsed, SourceIsAWSService}
+
+// Deriver adds a set of derived fields to events. A nil Deriver adds none.
+type Deriver struct {
+	epochTime bool
+	fields    []string
+}
+
+// New derives the named fields, plus event_time_ms when epochTime is set;
+// nil when that's nothing
+func New(epochTime bool, fields []string) (*Deriver, error) {
+	d := &Deriver{epochTime: epochTime}
+	for _, name := range fields {
+		switch {
+		case name == "all":
+			d.fields = Fields
+		case name == EventTimeMS:
+			d.epochTime = true
+		case !slices.Contains(Fields, name):
+			return nil, fmt.Errorf("unknown derived field %q (want %s, %s or all)", name, strings.Join(Fields, ", "), EventTimeMS)
+		}
+	}
+	if d.fields == nil {
+		// keep the documented order whatever order they're configured in
+		for _, name := range Fields {
+			if slices.Contains(fields, name) {
+				d.fields = append(d.fields, name)
+			}
+		}
+	}
+	if !d.epochTime && len(d.fields) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// Apply returns raw with a "derived" object of the fields added, or raw
+// unchanged when it isn't a JSON object or has one already
+func (d *Deriver) Apply(raw json.RawMessage) json.RawMessage {
+	if d == nil {
+		return raw
+	}
+	body := bytes.TrimRight(raw, " \t\r\n")
+	if len(body) < 2 || body[len(body)-1] != '}' {
+		return raw
+	}
+	var ev event.Record
+	if json.Unmarshal(body, &ev) != nil || ev.Extra[Key] != nil {
+		// not an object, or derived already, e.g. imported from output
+		return raw
+	}
+
+	out := make([]byte, 0, len(body)+160)
+	out = append(out, body[:len(body)-1]...)
+	if len(bytes.TrimSpace(body[1:len(body)-1])) > 0 {
+		out = append(out, ',')
+	}
+	out = append(out, `"`+Key+`":{`...)
+	sep := ""
+	if d.epochTime {
+		if t, err := ev.Time(); err == nil {
+			out = append(out, `"`+EventTimeMS+`":`...)
+			out = strconv.AppendInt(out, t.UnixMilli(), 10)
+			sep = ","
+		}
+	}
+	for _, name := range d.fields {
+		out = append(out, sep+`"`+name+`":`...)
+		out = strconv.AppendBool(out, derive(&ev, name))
+		sep = ","
+	}
+	return append(out, "}}"...)
+}
+
+func derive(ev *event.Record, name string) bool {
+	id := ev.UserIdentity
+	if id == nil {
+		id = &event.UserIdentity{}
+	}
+	switch name {
+	case IsAssumedRole:
+		return id.Type == event.IdentityAssumedRole
+	case IsRoot:
+		return id.Type == event.IdentityRoot
+	case IsCrossAccount:
+		return id.AccountID != "" && ev.RecipientAccountID != "" && id.AccountID != ev.RecipientAccountID
+	case MFAUsed:
+		if id.MFAUsed() {
+			return true
+		}
+		// console sign-ins say so in additionalEventData instead
+		var extra struct {
+			MFAUsed string `json:"MFAUsed"`
+		}
+		_ = json.Unmarshal(ev.AdditionalEventData, &extra)
+		return extra.MFAUsed == "Yes"
+	case SourceIsAWSService:
+		return id.Type == event.IdentityAWSService || id.InvokedBy != "" ||
+			ev.SourceIPAddress == "AWS Internal" || strings.HasSuffix(ev.SourceIPAddress, ".amazonaws.com")
+	}
+	return false
+}
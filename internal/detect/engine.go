@@ -0,0 +1,128 @@
+package detect
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Finding is an event (or, for threshold rules, the event that crossed the
+// threshold) matched by a rule
+type Finding struct {
+	RuleID      string   `json:"rule_id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Severity    string   `json:"severity"`
+	Tags        []string `json:"tags,omitempty"`
+
+	EventID     string `json:"event_id"`
+	EventTime   string `json:"event_time"`
+	EventName   string `json:"event_name"`
+	EventSource string `json:"event_source"`
+	AccountID   string `json:"account_id"`
+	Region      string `json:"region"`
+
+	// set for threshold rules
+	Count       int               `json:"count,omitempty"`
+	WindowStart *time.Time        `json:"window_start,omitempty"`
+	Group       map[string]string `json:"group,omitempty"`
+
+	DetectedAt time.Time       `json:"detected_at"`
+	Event      json.RawMessage `json:"event"`
+}
+
+// Engine evaluates rules against events. Threshold windows are fixed
+// intervals of event time, so results don't depend on processing order.
+type Engine struct {
+	rules []*Rule
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func NewEngine(rules []*Rule) *Engine {
+	return &Engine{rules: rules, counts: make(map[string]int)}
+}
+
+func (e *Engine) Rules() []*Rule {
+	return e.rules
+}
+
+// Evaluate returns the findings for one decoded event
+func (e *Engine) Evaluate(ev map[string]any, raw json.RawMessage, eventTime time.Time) []Finding {
+	var findings []Finding
+	for _, rule := range e.rules {
+		if !rule.matcher.Match(ev) {
+			continue
+		}
+
+		f := newFinding(rule, ev, raw)
+		if t := rule.Threshold; t != nil {
+			count, group, windowStart, fire := e.countMatch(rule, ev, eventTime)
+			if !fire {
+				continue
+			}
+			f.Count = count
+			f.Group = group
+			f.WindowStart = &windowStart
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// countMatch counts a threshold rule match and reports whether it is the one
+// that reaches the threshold for its group and window
+func (e *Engine) countMatch(rule *Rule, ev map[string]any, eventTime time.Time) (int, map[string]string, time.Time, bool) {
+	t := rule.Threshold
+	windowStart := eventTime.UTC().Truncate(t.Window)
+
+	group := make(map[string]string, len(t.GroupBy))
+	key := []string{rule.ID, windowStart.Format(time.RFC3339)}
+	for _, field := range t.GroupBy {
+		var value string
+		if values := lookup(ev, strings.Split(field, ".")); len(values) > 0 && values[0] != nil {
+			value = stringify(values[0])
+		}
+		group[field] = value
+		key = append(key, value)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	k := strings.Join(key, "\x00")
+	e.counts[k]++
+	count := e.counts[k]
+	return count, group, windowStart, count == t.Count
+}
+
+func newFinding(rule *Rule, ev map[string]any, raw json.RawMessage) Finding {
+	field := func(path string) string {
+		if values := lookup(ev, strings.Split(path, ".")); len(values) > 0 && values[0] != nil {
+			return stringify(values[0])
+		}
+		return ""
+	}
+
+	account := field("recipientAccountId")
+	if account == "" {
+		account = field("userIdentity.accountId")
+	}
+
+	return Finding{
+		RuleID:      rule.ID,
+		Title:       rule.Title,
+		Description: rule.Description,
+		Severity:    rule.Severity,
+		Tags:        rule.Tags,
+		EventID:     field("eventID"),
+		EventTime:   field("eventTime"),
+		EventName:   field("eventName"),
+		EventSource: field("eventSource"),
+		AccountID:   account,
+		Region:      field("awsRegion"),
+		DetectedAt:  time.Now().UTC(),
+		Event:       raw,
+	}
+}
@@ -0,0 +1,158 @@
+package detect
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Matcher decides whether a decoded event matches
+type Matcher interface {
+	Match(ev map[string]any) bool
+}
+
+type allOf []Matcher
+
+func (m allOf) Match(ev map[string]any) bool {
+	for _, sub := range m {
+		if !sub.Match(ev) {
+			return false
+		}
+	}
+	return true
+}
+
+type anyOf []Matcher
+
+func (m anyOf) Match(ev map[string]any) bool {
+	for _, sub := range m {
+		if sub.Match(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+type not struct{ Matcher }
+
+func (m not) Match(ev map[string]any) bool {
+	return !m.Matcher.Match(ev)
+}
+
+// fieldMatcher matches when any value at a dotted field path satisfies any
+// of the value tests. A nil value test matches a missing or null field.
+type fieldMatcher struct {
+	path  []string
+	tests []valueTest
+}
+
+type valueTest func(v any, present bool) bool
+
+func (m fieldMatcher) Match(ev map[string]any) bool {
+	values := lookup(ev, m.path)
+	for _, test := range m.tests {
+		if len(values) == 0 {
+			if test(nil, false) {
+				return true
+			}
+			continue
+		}
+		for _, v := range values {
+			if test(v, v != nil) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lookup returns every value at a dotted path, descending into arrays so
+// that e.g. resources.ARN yields the ARN of each resource
+func lookup(v any, path []string) []any {
+	if len(path) == 0 {
+		if arr, ok := v.([]any); ok {
+			return arr
+		}
+		return []any{v}
+	}
+
+	switch t := v.(type) {
+	case map[string]any:
+		child, ok := t[path[0]]
+		if !ok {
+			return nil
+		}
+		return lookup(child, path[1:])
+	case []any:
+		var out []any
+		for _, item := range t {
+			out = append(out, lookup(item, path)...)
+		}
+		return out
+	default:
+		return nil
+	}
+}
+
+// patternTest matches a value's string form against a pattern where *
+// matches any run of characters and ? any single character
+func patternTest(pattern string, fold bool) valueTest {
+	if fold {
+		pattern = strings.ToLower(pattern)
+	}
+	return func(v any, present bool) bool {
+		if !present {
+			return false
+		}
+		s := stringify(v)
+		if fold {
+			s = strings.ToLower(s)
+		}
+		return wildcardMatch(pattern, s)
+	}
+}
+
+// absentTest matches a missing or null field
+func absentTest(_ any, present bool) bool {
+	return !present
+}
+
+func stringify(v any) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		// JSON numbers decode as float64; print integers without a fraction
+		if t == float64(int64(t)) {
+			return fmt.Sprintf("%d", int64(t))
+		}
+		return fmt.Sprint(t)
+	default:
+		return fmt.Sprint(t)
+	}
+}
+
+func wildcardMatch(pattern, s string) bool {
+	// iterative glob with backtracking to the last *
+	var p, i int
+	star, match := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			match++
+			i = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
@@ -0,0 +1,141 @@
+package detect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// presets are the built-in detections, in the same YAML form as rules files
+var presets = map[string]string{
+	"root-usage": `
+- id: builtin-root-usage
+  title: Root account used
+  description: An API call or console action was made with root credentials.
+  severity: high
+  tags: [attack.privilege_escalation, attack.t1078.004]
+  match:
+    userIdentity.type: Root
+    userIdentity.invokedBy: null
+  not:
+    eventType: AwsServiceEvent
+`,
+	"console-login": `
+- id: builtin-console-login-failure
+  title: Console login failed
+  severity: medium
+  tags: [attack.credential_access, attack.t1110]
+  match:
+    eventName: ConsoleLogin
+    responseElements.ConsoleLogin: Failure
+- id: builtin-console-login-no-mfa
+  title: Console login without MFA
+  description: An IAM user or root signed in to the console without MFA.
+  severity: medium
+  tags: [attack.initial_access, attack.t1078.004]
+  match:
+    eventName: ConsoleLogin
+    responseElements.ConsoleLogin: Success
+    additionalEventData.MFAUsed: "No"
+    userIdentity.type: [IAMUser, Root]
+`,
+	"cloudtrail-tampering": `
+- id: builtin-cloudtrail-tampering
+  title: CloudTrail logging stopped or trail deleted
+  severity: critical
+  tags: [attack.defense_evasion, attack.t1562.008]
+  match:
+    eventSource: cloudtrail.amazonaws.com
+    eventName: [StopLogging, DeleteTrail]
+`,
+	"iam-changes": `
+- id: builtin-iam-policy-change
+  title: IAM policy changed
+  severity: medium
+  tags: [attack.persistence, attack.privilege_escalation, attack.t1098]
+  match:
+    eventSource: iam.amazonaws.com
+    eventName:
+      - CreatePolicy
+      - CreatePolicyVersion
+      - DeletePolicy
+      - DeletePolicyVersion
+      - SetDefaultPolicyVersion
+      - AttachUserPolicy
+      - AttachGroupPolicy
+      - AttachRolePolicy
+      - DetachUserPolicy
+      - DetachGroupPolicy
+      - DetachRolePolicy
+      - PutUserPolicy
+      - PutGroupPolicy
+      - PutRolePolicy
+      - DeleteUserPolicy
+      - DeleteGroupPolicy
+      - DeleteRolePolicy
+      - UpdateAssumeRolePolicy
+  not:
+    errorCode: "*"
+- id: builtin-iam-access-key-change
+  title: IAM access key created, updated or deleted
+  severity: medium
+  tags: [attack.persistence, attack.t1098.001]
+  match:
+    eventSource: iam.amazonaws.com
+    eventName: [CreateAccessKey, UpdateAccessKey, DeleteAccessKey]
+  not:
+    errorCode: "*"
+`,
+	"kms-key-deletion": `
+- id: builtin-kms-key-deletion
+  title: KMS key scheduled for deletion
+  severity: high
+  tags: [attack.impact, attack.t1485]
+  match:
+    eventSource: kms.amazonaws.com
+    eventName: ScheduleKeyDeletion
+  not:
+    errorCode: "*"
+`,
+}
+
+// PresetNames lists the built-in detections
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PresetRules compiles the named built-in detections; "all" enables every one
+func PresetRules(names []string) ([]*Rule, error) {
+	selected := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "all" {
+			for preset := range presets {
+				selected[preset] = true
+			}
+			continue
+		}
+		if _, ok := presets[name]; !ok {
+			return nil, fmt.Errorf("unknown detection preset %q (available: all, %s)", name, strings.Join(PresetNames(), ", "))
+		}
+		selected[name] = true
+	}
+
+	var rules []*Rule
+	for _, name := range PresetNames() {
+		if !selected[name] {
+			continue
+		}
+		compiled, err := ParseRules([]byte(presets[name]))
+		if err != nil {
+			return nil, fmt.Errorf("preset %s: %w", name, err)
+		}
+		rules = append(rules, compiled...)
+	}
+	return rules, nil
+}
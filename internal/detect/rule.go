@@ -0,0 +1,230 @@
+package detect
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Rule is a compiled detection
+type Rule struct {
+	ID          string
+	Title       string
+	Description string
+	Severity    string
+	Tags        []string
+	// optional: only fire once Count matching events fall in one window
+	Threshold *Threshold
+
+	matcher Matcher
+}
+
+type Threshold struct {
+	Count   int
+	Window  time.Duration
+	GroupBy []string
+}
+
+// ruleSpec is the YAML form of a rule
+type ruleSpec struct {
+	ID          string         `yaml:"id"`
+	Title       string         `yaml:"title"`
+	Description string         `yaml:"description"`
+	Severity    string         `yaml:"severity"`
+	Tags        []string       `yaml:"tags"`
+	Match       map[string]any `yaml:"match"`
+	Not         map[string]any `yaml:"not"`
+	Threshold   *struct {
+		Count   int      `yaml:"count"`
+		Window  string   `yaml:"window"`
+		GroupBy []string `yaml:"group_by"`
+	} `yaml:"threshold"`
+}
+
+// LoadRules reads YAML rule files, or every .yml/.yaml file in a directory
+func LoadRules(paths []string) ([]*Rule, error) {
+	var rules []*Rule
+	seen := make(map[string]string)
+
+	for _, path := range paths {
+		files, err := ruleFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			loaded, err := loadRuleFile(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, rule := range loaded {
+				if other, dup := seen[rule.ID]; dup {
+					return nil, fmt.Errorf("%s: rule %q already defined in %s", file, rule.ID, other)
+				}
+				seen[rule.ID] = file
+				rules = append(rules, rule)
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+func ruleFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if !entry.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func loadRuleFile(path string) ([]*Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read rules: %w", err)
+	}
+	rules, err := ParseRules(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// ParseRules compiles YAML holding either a list of rules or a document
+// with a top-level rules: list
+func ParseRules(data []byte) ([]*Rule, error) {
+	var specs []ruleSpec
+	if err := yaml.Unmarshal(data, &specs); err != nil {
+		var doc struct {
+			Rules []ruleSpec `yaml:"rules"`
+		}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parse rules: %w", err)
+		}
+		specs = doc.Rules
+	}
+
+	rules := make([]*Rule, 0, len(specs))
+	for _, spec := range specs {
+		rule, err := spec.compile()
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s ruleSpec) compile() (*Rule, error) {
+	if s.ID == "" {
+		return nil, fmt.Errorf("rule %q: id is required", s.Title)
+	}
+	if len(s.Match) == 0 {
+		return nil, fmt.Errorf("rule %s: match needs at least one field", s.ID)
+	}
+
+	matcher, err := CompileMatch(s.Match, s.Not)
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: %w", s.ID, err)
+	}
+
+	rule := &Rule{
+		ID:          s.ID,
+		Title:       s.Title,
+		Description: s.Description,
+		Severity:    strings.ToLower(s.Severity),
+		Tags:        s.Tags,
+		matcher:     matcher,
+	}
+	if rule.Title == "" {
+		rule.Title = s.ID
+	}
+	if rule.Severity == "" {
+		rule.Severity = "medium"
+	}
+
+	if t := s.Threshold; t != nil {
+		window, err := time.ParseDuration(t.Window)
+		if err != nil || window <= 0 {
+			return nil, fmt.Errorf("rule %s: threshold window %q must be a positive duration like 10m", s.ID, t.Window)
+		}
+		if t.Count < 1 {
+			return nil, fmt.Errorf("rule %s: threshold count must be at least 1", s.ID)
+		}
+		rule.Threshold = &Threshold{Count: t.Count, Window: window, GroupBy: t.GroupBy}
+	}
+
+	return rule, nil
+}
+
+// CompileMatch compiles a rule's match and not fields into one matcher, for
+// selecting events outside of rules. An empty match takes every event.
+func CompileMatch(match, exclude map[string]any) (Matcher, error) {
+	matcher, err := compileFields(match, false)
+	if err != nil {
+		return nil, fmt.Errorf("match: %w", err)
+	}
+	if len(exclude) > 0 {
+		notMatcher, err := compileFields(exclude, false)
+		if err != nil {
+			return nil, fmt.Errorf("not: %w", err)
+		}
+		matcher = allOf{matcher, not{notMatcher}}
+	}
+	return matcher, nil
+}
+
+// compileFields ANDs one matcher per field. A scalar value must match (with
+// * and ? wildcards), a list matches any element, and null matches a missing
+// field.
+func compileFields(fields map[string]any, fold bool) (Matcher, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	matchers := make(allOf, 0, len(names))
+	for _, name := range names {
+		var values []any
+		if list, ok := fields[name].([]any); ok {
+			values = list
+		} else {
+			values = []any{fields[name]}
+		}
+
+		fm := fieldMatcher{path: strings.Split(name, ".")}
+		for _, v := range values {
+			switch v.(type) {
+			case nil:
+				fm.tests = append(fm.tests, absentTest)
+			case string, int, float64, bool:
+				fm.tests = append(fm.tests, patternTest(stringify(v), fold))
+			default:
+				return nil, fmt.Errorf("field %s: unsupported value %v", name, v)
+			}
+		}
+		matchers = append(matchers, fm)
+	}
+	return matchers, nil
+}
@@ -0,0 +1,171 @@
+package detect
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/securityhub"
+	"github.com/aws/aws-sdk-go-v2/service/securityhub/types"
+)
+
+// BatchImportFindings accepts at most 100 findings per call
+const securityHubBatchSize = 100
+
+// SecurityHub imports findings into Security Hub in ASFF. Findings are
+// owned by AccountID (the importing account, as Security Hub requires for
+// the default product) with the event's account attached as a resource.
+type SecurityHub struct {
+	Client    *securityhub.Client
+	AccountID string
+
+	mu    sync.Mutex
+	batch []types.AwsSecurityFinding
+}
+
+func (s *SecurityHub) Write(ctx context.Context, f Finding) error {
+	s.mu.Lock()
+	s.batch = append(s.batch, s.toASFF(f))
+	var batch []types.AwsSecurityFinding
+	if len(s.batch) >= securityHubBatchSize {
+		batch, s.batch = s.batch, nil
+	}
+	s.mu.Unlock()
+
+	if batch == nil {
+		return nil
+	}
+	return s.importFindings(ctx, batch)
+}
+
+func (s *SecurityHub) Close() error {
+	s.mu.Lock()
+	batch := s.batch
+	s.batch = nil
+	s.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return s.importFindings(ctx, batch)
+}
+
+func (s *SecurityHub) importFindings(ctx context.Context, batch []types.AwsSecurityFinding) error {
+	out, err := s.Client.BatchImportFindings(ctx, &securityhub.BatchImportFindingsInput{
+		Findings: batch,
+	})
+	if err != nil {
+		return fmt.Errorf("import findings to security hub: %w", err)
+	}
+
+	var errs []error
+	for _, failed := range out.FailedFindings {
+		errs = append(errs, fmt.Errorf("security hub rejected finding %s: %s: %s",
+			aws.ToString(failed.Id), aws.ToString(failed.ErrorCode), aws.ToString(failed.ErrorMessage)))
+	}
+	return errors.Join(errs...)
+}
+
+func (s *SecurityHub) toASFF(f Finding) types.AwsSecurityFinding {
+	region := s.Client.Options().Region
+	now := f.DetectedAt.Format(time.RFC3339)
+
+	observed := f.EventTime
+	if f.WindowStart != nil {
+		observed = f.WindowStart.Format(time.RFC3339)
+	}
+
+	description := f.Description
+	if description == "" {
+		description = f.Title
+	}
+
+	resourceRegion := f.Region
+	if resourceRegion == "" {
+		resourceRegion = region
+	}
+
+	fields := map[string]string{
+		"gocloudtrail/RuleId":      f.RuleID,
+		"gocloudtrail/EventId":     f.EventID,
+		"gocloudtrail/EventName":   f.EventName,
+		"gocloudtrail/EventSource": f.EventSource,
+	}
+	if f.Count > 0 {
+		fields["gocloudtrail/Count"] = fmt.Sprint(f.Count)
+	}
+	for k, v := range f.Group {
+		fields["gocloudtrail/Group/"+k] = v
+	}
+
+	return types.AwsSecurityFinding{
+		SchemaVersion:   aws.String("2018-10-08"),
+		Id:              aws.String(findingID(f)),
+		ProductArn:      aws.String(fmt.Sprintf("arn:aws:securityhub:%s:%s:product/%s/default", region, s.AccountID, s.AccountID)),
+		GeneratorId:     aws.String("gocloudtrail/" + f.RuleID),
+		AwsAccountId:    aws.String(s.AccountID),
+		Types:           []string{"TTPs"},
+		CreatedAt:       aws.String(now),
+		UpdatedAt:       aws.String(now),
+		FirstObservedAt: aws.String(observed),
+		LastObservedAt:  aws.String(f.EventTime),
+		Severity:        &types.Severity{Label: severityLabel(f.Severity), Original: aws.String(f.Severity)},
+		Title:           aws.String(truncate(f.Title, 256)),
+		Description:     aws.String(truncate(description, 1024)),
+		ProductFields:   fields,
+		Resources: []types.Resource{{
+			Type:   aws.String("AwsAccount"),
+			Id:     aws.String("AWS::::Account:" + f.AccountID),
+			Region: aws.String(resourceRegion),
+		}},
+		RecordState: types.RecordStateActive,
+	}
+}
+
+// findingID is stable across runs, so re-importing a finding after a
+// backfill is re-run updates it rather than creating a duplicate. Threshold
+// findings are keyed by their window and group, since which event crosses
+// the threshold can vary.
+func findingID(f Finding) string {
+	key := []string{f.RuleID, f.EventID}
+	if f.WindowStart != nil {
+		key = []string{f.RuleID, f.WindowStart.Format(time.RFC3339)}
+		groups := make([]string, 0, len(f.Group))
+		for k, v := range f.Group {
+			groups = append(groups, k+"="+v)
+		}
+		sort.Strings(groups)
+		key = append(key, groups...)
+	}
+	return fmt.Sprintf("gocloudtrail/%x", sha256.Sum256([]byte(strings.Join(key, "\x00"))))
+}
+
+func severityLabel(severity string) types.SeverityLabel {
+	switch strings.ToLower(severity) {
+	case "critical":
+		return types.SeverityLabelCritical
+	case "high":
+		return types.SeverityLabelHigh
+	case "low":
+		return types.SeverityLabelLow
+	case "informational", "info":
+		return types.SeverityLabelInformational
+	default:
+		return types.SeverityLabelMedium
+	}
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n]
+}
@@ -0,0 +1,406 @@
+package detect
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sigmaRule is the subset of the Sigma rule format that applies to
+// CloudTrail events
+type sigmaRule struct {
+	Title       string   `yaml:"title"`
+	ID          string   `yaml:"id"`
+	Description string   `yaml:"description"`
+	Level       string   `yaml:"level"`
+	Tags        []string `yaml:"tags"`
+	LogSource   struct {
+		Product string `yaml:"product"`
+		Service string `yaml:"service"`
+	} `yaml:"logsource"`
+	Detection map[string]any `yaml:"detection"`
+}
+
+// LoadSigmaRules reads Sigma rules from files or directory trees, keeping
+// those with an aws/cloudtrail logsource. Rules using unsupported features
+// are skipped with a warning so a whole public ruleset can be pointed at.
+func LoadSigmaRules(paths []string, logger *slog.Logger) ([]*Rule, error) {
+	var rules []*Rule
+	seen := make(map[string]bool)
+	var skipped int
+
+	for _, root := range paths {
+		var files []string
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			ext := filepath.Ext(path)
+			if !d.IsDir() && (ext == ".yml" || ext == ".yaml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("read sigma rules: %w", err)
+		}
+		sort.Strings(files)
+
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("read sigma rules: %w", err)
+			}
+
+			var sr sigmaRule
+			if err := yaml.Unmarshal(data, &sr); err != nil {
+				logger.Warn("skipping unparseable sigma rule",
+					slog.String("file", file),
+					slog.String("error", err.Error()))
+				skipped++
+				continue
+			}
+			if !strings.EqualFold(sr.LogSource.Product, "aws") || !strings.EqualFold(sr.LogSource.Service, "cloudtrail") {
+				continue
+			}
+
+			rule, err := sr.compile()
+			if err != nil {
+				logger.Warn("skipping unsupported sigma rule",
+					slog.String("file", file),
+					slog.String("error", err.Error()))
+				skipped++
+				continue
+			}
+			if seen[rule.ID] {
+				continue
+			}
+			seen[rule.ID] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	if skipped > 0 {
+		logger.Warn("some sigma rules were skipped", slog.Int("skipped", skipped))
+	}
+	return rules, nil
+}
+
+func (sr sigmaRule) compile() (*Rule, error) {
+	if sr.ID == "" {
+		return nil, fmt.Errorf("rule %q has no id", sr.Title)
+	}
+
+	condition, ok := sr.Detection["condition"].(string)
+	if !ok {
+		return nil, fmt.Errorf("rule %s: condition must be a single string", sr.ID)
+	}
+	if strings.Contains(condition, "|") {
+		return nil, fmt.Errorf("rule %s: aggregation conditions are not supported", sr.ID)
+	}
+
+	searches := make(map[string]Matcher)
+	for name, def := range sr.Detection {
+		if name == "condition" || name == "timeframe" {
+			continue
+		}
+		m, err := sigmaSearch(def)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s: %w", sr.ID, name, err)
+		}
+		searches[name] = m
+	}
+
+	p := &conditionParser{tokens: tokenize(condition), searches: searches}
+	matcher, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("rule %s: condition %q: %w", sr.ID, condition, err)
+	}
+
+	severity := strings.ToLower(sr.Level)
+	if severity == "" {
+		severity = "medium"
+	}
+	return &Rule{
+		ID:          sr.ID,
+		Title:       sr.Title,
+		Description: strings.TrimSpace(sr.Description),
+		Severity:    severity,
+		Tags:        sr.Tags,
+		matcher:     matcher,
+	}, nil
+}
+
+// sigmaSearch compiles a search identifier: a map ANDs its fields, a list of
+// maps ORs them
+func sigmaSearch(def any) (Matcher, error) {
+	switch t := def.(type) {
+	case map[string]any:
+		return sigmaFields(t)
+	case []any:
+		var alternatives anyOf
+		for _, item := range t {
+			fields, ok := item.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("keyword searches are not supported")
+			}
+			m, err := sigmaFields(fields)
+			if err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, m)
+		}
+		return alternatives, nil
+	default:
+		return nil, fmt.Errorf("unsupported search definition")
+	}
+}
+
+func sigmaFields(fields map[string]any) (Matcher, error) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var matchers allOf
+	for _, key := range names {
+		parts := strings.Split(key, "|")
+		field, modifiers := parts[0], parts[1:]
+
+		var values []any
+		if list, ok := fields[key].([]any); ok {
+			values = list
+		} else {
+			values = []any{fields[key]}
+		}
+
+		var all bool
+		var transform func(string) string
+		var kind string
+		for _, mod := range modifiers {
+			switch mod {
+			case "all":
+				all = true
+			case "contains":
+				transform = func(v string) string { return "*" + v + "*" }
+			case "startswith":
+				transform = func(v string) string { return v + "*" }
+			case "endswith":
+				transform = func(v string) string { return "*" + v }
+			case "re", "exists":
+				kind = mod
+			default:
+				return nil, fmt.Errorf("modifier %q is not supported", mod)
+			}
+		}
+
+		path := strings.Split(field, ".")
+		var tests []valueTest
+		for _, v := range values {
+			switch {
+			case kind == "exists":
+				want, _ := v.(bool)
+				tests = append(tests, func(_ any, present bool) bool { return present == want })
+			case v == nil:
+				tests = append(tests, absentTest)
+			case kind == "re":
+				re, err := regexp.Compile(stringify(v))
+				if err != nil {
+					return nil, fmt.Errorf("field %s: %w", field, err)
+				}
+				tests = append(tests, func(val any, present bool) bool {
+					return present && re.MatchString(stringify(val))
+				})
+			default:
+				s := stringify(v)
+				if transform != nil {
+					s = transform(s)
+				}
+				// Sigma string matching is case-insensitive
+				tests = append(tests, patternTest(s, true))
+			}
+		}
+
+		if all {
+			for _, test := range tests {
+				matchers = append(matchers, fieldMatcher{path: path, tests: []valueTest{test}})
+			}
+			continue
+		}
+		matchers = append(matchers, fieldMatcher{path: path, tests: tests})
+	}
+	return matchers, nil
+}
+
+// conditionParser parses Sigma conditions:
+//
+//	expr   = term { "or" term }
+//	term   = factor { "and" factor }
+//	factor = "not" factor | "(" expr ")" | ("1"|"all") "of" pattern | name
+type conditionParser struct {
+	tokens   []string
+	pos      int
+	searches map[string]Matcher
+}
+
+func tokenize(s string) []string {
+	var tokens []string
+	var cur strings.Builder
+	flush := func() {
+		if cur.Len() > 0 {
+			tokens = append(tokens, cur.String())
+			cur.Reset()
+		}
+	}
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			cur.WriteRune(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func (p *conditionParser) parse() (Matcher, error) {
+	m, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	return m, nil
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return strings.ToLower(p.tokens[p.pos])
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	tok := p.tokens[p.pos]
+	p.pos++
+	return tok
+}
+
+func (p *conditionParser) expr() (Matcher, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	alternatives := anyOf{left}
+	for p.peek() == "or" {
+		p.next()
+		right, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, right)
+	}
+	if len(alternatives) == 1 {
+		return left, nil
+	}
+	return alternatives, nil
+}
+
+func (p *conditionParser) term() (Matcher, error) {
+	left, err := p.factor()
+	if err != nil {
+		return nil, err
+	}
+	all := allOf{left}
+	for p.peek() == "and" {
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, right)
+	}
+	if len(all) == 1 {
+		return left, nil
+	}
+	return all, nil
+}
+
+func (p *conditionParser) factor() (Matcher, error) {
+	switch tok := p.peek(); tok {
+	case "":
+		return nil, fmt.Errorf("unexpected end of condition")
+	case "not":
+		p.next()
+		m, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return not{m}, nil
+	case "(":
+		p.next()
+		m, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.next()
+		return m, nil
+	case "1", "all":
+		p.next()
+		if p.peek() != "of" {
+			return nil, fmt.Errorf("expected 'of' after %q", tok)
+		}
+		p.next()
+		if p.peek() == "" {
+			return nil, fmt.Errorf("expected a pattern after 'of'")
+		}
+		return p.quantified(tok == "all", p.next())
+	default:
+		name := p.next()
+		m, ok := p.searches[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown search %q", name)
+		}
+		return m, nil
+	}
+}
+
+// quantified expands "1 of x*" / "all of them" over the matching searches
+func (p *conditionParser) quantified(all bool, pattern string) (Matcher, error) {
+	names := make([]string, 0, len(p.searches))
+	for name := range p.searches {
+		if pattern == "them" || wildcardMatch(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no searches match %q", pattern)
+	}
+	sort.Strings(names)
+
+	matchers := make([]Matcher, 0, len(names))
+	for _, name := range names {
+		matchers = append(matchers, p.searches[name])
+	}
+	if all {
+		return allOf(matchers), nil
+	}
+	return anyOf(matchers), nil
+}
@@ -0,0 +1,93 @@
+package detect
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Sink receives findings as they are produced
+type Sink interface {
+	Write(ctx context.Context, f Finding) error
+	Close() error
+}
+
+// FileSink appends findings as JSON lines to a file
+type FileSink struct {
+	path string
+
+	mu   sync.Mutex
+	file *os.File
+	buf  *bufio.Writer
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+func (s *FileSink) Write(_ context.Context, f Finding) error {
+	line, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("marshal finding: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if dir := filepath.Dir(s.path); dir != "." {
+			if err := os.MkdirAll(dir, 0o755); err != nil {
+				return fmt.Errorf("create findings directory: %w", err)
+			}
+		}
+		file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open findings file: %w", err)
+		}
+		s.file = file
+		s.buf = bufio.NewWriter(file)
+	}
+
+	if _, err := s.buf.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write finding: %w", err)
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := errors.Join(s.buf.Flush(), s.file.Close())
+	s.file, s.buf = nil, nil
+	return err
+}
+
+// MultiSink writes each finding to every sink
+type MultiSink []Sink
+
+func (m MultiSink) Write(ctx context.Context, f Finding) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(ctx, f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m MultiSink) Close() error {
+	var errs []error
+	for _, s := range m {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
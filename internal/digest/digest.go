@@ -0,0 +1,44 @@
+// Package digest reads CloudTrail digest files, the hourly inventories of
+// the log files CloudTrail delivered for an account and region
+package digest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// File is the part of a digest file needed to check delivery completeness
+type File struct {
+	AWSAccountID    string    `json:"awsAccountId"`
+	DigestStartTime time.Time `json:"digestStartTime"`
+	DigestEndTime   time.Time `json:"digestEndTime"`
+	DigestS3Bucket  string    `json:"digestS3Bucket"`
+	DigestS3Object  string    `json:"digestS3Object"`
+	LogFiles        []LogFile `json:"logFiles"`
+}
+
+// LogFile is a log file CloudTrail delivered during the digest's period
+type LogFile struct {
+	S3Bucket      string `json:"s3Bucket"`
+	S3Object      string `json:"s3Object"`
+	HashValue     string `json:"hashValue"`
+	HashAlgorithm string `json:"hashAlgorithm"`
+}
+
+// Decode decompresses and parses a digest file
+func Decode(data []byte) (*File, error) {
+	gr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	defer func() { _ = gr.Close() }()
+
+	var f File
+	if err := json.NewDecoder(gr).Decode(&f); err != nil {
+		return nil, fmt.Errorf("parse JSON: %w", err)
+	}
+	return &f, nil
+}
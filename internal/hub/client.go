@@ -0,0 +1,40 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"google.golang.org/grpc"
+
+	"github.com/deceptiq/gocloudtrail/internal/rpc"
+)
+
+// Subscribe streams the events req selects from the hub at conn to fn,
+// until ctx is done, the hub closes the stream or fn fails
+func Subscribe(ctx context.Context, conn *grpc.ClientConn, token string, req SubscribeRequest, fn func(json.RawMessage) error) error {
+	ctx, cancel := context.WithCancel(rpc.WithToken(ctx, token))
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, SubscribeMethod)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&req); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		var ev Event
+		if err := stream.RecvMsg(&ev); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := fn(ev.Event); err != nil {
+			return err
+		}
+	}
+}
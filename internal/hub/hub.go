@@ -0,0 +1,223 @@
+// Package hub streams the events a run writes to consumers subscribed over
+// gRPC, each with its own filter, so other tools can follow a collector
+// live instead of reading its output files
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/deceptiq/gocloudtrail/internal/detect"
+	"github.com/deceptiq/gocloudtrail/internal/rpc"
+)
+
+const serviceName = "gocloudtrail.hub.v1.Hub"
+
+// SubscribeMethod is the server-streaming method a subscription opens: one
+// SubscribeRequest in, Events out, with content subtype rpc.Codec
+const SubscribeMethod = "/" + serviceName + "/Subscribe"
+
+// events queued per subscriber; a consumer further behind loses events
+// rather than holding up the run
+const subscriberBuffer = 10000
+
+// SubscribeRequest opens a subscription. Match and Not select events like
+// a detection rule's fields; without either every event is sent.
+type SubscribeRequest struct {
+	// shown in the logs
+	Name  string         `json:"name,omitempty"`
+	Match map[string]any `json:"match,omitempty"`
+	Not   map[string]any `json:"not,omitempty"`
+}
+
+// Event is one streamed event, as written
+type Event struct {
+	Event json.RawMessage `json:"event"`
+}
+
+// Options configures the server
+type Options struct {
+	// address to serve on
+	Listen string
+	// bearer token subscribers must send, none when empty
+	Token string
+	// serve TLS with this certificate and key when set
+	TLSCert, TLSKey string
+}
+
+type subscriber struct {
+	name    string
+	match   detect.Matcher
+	events  chan json.RawMessage
+	dropped atomic.Int64
+}
+
+// Hub is a sink fanning the events it's sent out to its subscribers.
+// Sending never blocks on a consumer.
+type Hub struct {
+	token  string
+	server *grpc.Server
+	logger *slog.Logger
+
+	mu     sync.RWMutex
+	subs   map[*subscriber]struct{}
+	closed bool
+}
+
+// Listen starts serving subscriptions
+func Listen(opts Options, logger *slog.Logger) (*Hub, error) {
+	h := &Hub{
+		token:  opts.Token,
+		logger: logger,
+		subs:   make(map[*subscriber]struct{}),
+	}
+	var serverOpts []grpc.ServerOption
+	if opts.TLSCert != "" || opts.TLSKey != "" {
+		creds, err := credentials.NewServerTLSFromFile(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	h.server = grpc.NewServer(serverOpts...)
+	h.server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{
+			{StreamName: "Subscribe", Handler: h.subscribe, ServerStreams: true},
+		},
+	}, h)
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("hub listen: %w", err)
+	}
+	go func() {
+		if err := h.server.Serve(ln); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+			logger.Error("event hub failed", slog.String("error", err.Error()))
+		}
+	}()
+	logger.Info("event hub listening",
+		slog.String("addr", ln.Addr().String()),
+		slog.Bool("tls", opts.TLSCert != ""))
+	return h, nil
+}
+
+// Send hands each event to the subscribers whose filter it passes,
+// dropping it for any that are too far behind
+func (h *Hub) Send(ctx context.Context, events []json.RawMessage) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subs) == 0 || h.closed {
+		return nil
+	}
+	var filtered bool
+	for sub := range h.subs {
+		filtered = filtered || sub.match != nil
+	}
+
+	for _, event := range events {
+		// filters test the decoded event, decoded once for all of them
+		var ev map[string]any
+		if filtered && json.Unmarshal(event, &ev) != nil {
+			ev = nil
+		}
+		for sub := range h.subs {
+			if sub.match != nil && (ev == nil || !sub.match.Match(ev)) {
+				continue
+			}
+			select {
+			case sub.events <- event:
+			default:
+				if sub.dropped.Add(1) == 1 {
+					h.logger.Warn("event hub subscriber is falling behind, dropping events",
+						slog.String("subscriber", sub.name))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Close ends every subscription and stops serving
+func (h *Hub) Close() error {
+	h.mu.Lock()
+	h.closed = true
+	for sub := range h.subs {
+		close(sub.events)
+	}
+	h.mu.Unlock()
+	h.server.GracefulStop()
+	return nil
+}
+
+func (h *Hub) subscribe(_ any, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	if err := rpc.CheckToken(ctx, h.token); err != nil {
+		return err
+	}
+	var req SubscribeRequest
+	if err := stream.RecvMsg(&req); err != nil {
+		return err
+	}
+	sub := &subscriber{name: req.Name, events: make(chan json.RawMessage, subscriberBuffer)}
+	if sub.name == "" {
+		sub.name = "unnamed"
+	}
+	if len(req.Match) > 0 || len(req.Not) > 0 {
+		match, err := detect.CompileMatch(req.Match, req.Not)
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		sub.match = match
+	}
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return status.Error(codes.Unavailable, "event hub is shutting down")
+	}
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+	h.logger.Info("event hub subscriber joined",
+		slog.String("subscriber", sub.name),
+		slog.Bool("filtered", sub.match != nil))
+
+	err := h.stream(ctx, stream, sub)
+
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+	h.logger.Info("event hub subscriber left",
+		slog.String("subscriber", sub.name),
+		slog.Int64("dropped", sub.dropped.Load()))
+	return err
+}
+
+// stream sends the subscriber's events until it goes away or the hub closes
+func (h *Hub) stream(ctx context.Context, stream grpc.ServerStream, sub *subscriber) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-sub.events:
+			if !ok {
+				return nil
+			}
+			if err := stream.SendMsg(&Event{Event: event}); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,282 @@
+// Package lake reads CloudTrail events back out of Parquet exports: CloudTrail
+// Lake event data (CloudTrail field names, nested), Security Lake OCSF API
+// activity, and this tool's own Parquet output
+package lake
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/parquet-go/parquet-go/deprecated"
+	"github.com/parquet-go/parquet-go/format"
+)
+
+type Format string
+
+const (
+	FormatAuto       Format = "auto"
+	FormatCloudTrail Format = "cloudtrail"
+	FormatOCSF       Format = "ocsf"
+	// rows written by the parquet output format, which keep the raw event
+	FormatRaw Format = "raw"
+)
+
+// ParseFormat validates an input format name, defaulting to auto
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case "":
+		return FormatAuto, nil
+	case FormatAuto, FormatCloudTrail, FormatOCSF, FormatRaw:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown input format %q (want auto, cloudtrail, ocsf or raw)", s)
+}
+
+// Read converts every row of a Parquet file into a CloudTrail record, handing
+// them to fn batchSize at a time. Rows missing required fields are still
+// passed on, for the pipeline's own validation to reject.
+func Read(r io.ReaderAt, size int64, f Format, batchSize int, fn func([]json.RawMessage) error) error {
+	file, err := parquet.OpenFile(r, size)
+	if err != nil {
+		return fmt.Errorf("open parquet: %w", err)
+	}
+	schema := file.Schema()
+
+	if f == FormatAuto {
+		if f, err = Detect(schema); err != nil {
+			return err
+		}
+	}
+
+	reader := parquet.NewReader(file)
+	defer func() { _ = reader.Close() }()
+
+	batch := make([]json.RawMessage, 0, batchSize)
+	for {
+		var row any
+		if err := reader.Read(&row); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return fmt.Errorf("read row: %w", err)
+		}
+
+		record, err := convert(schema, row, f)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, record)
+
+		if len(batch) == batchSize {
+			if err := fn(batch); err != nil {
+				return err
+			}
+			batch = make([]json.RawMessage, 0, batchSize)
+		}
+	}
+
+	if len(batch) > 0 {
+		return fn(batch)
+	}
+	return nil
+}
+
+// Detect works out the layout of a file from its top-level columns
+func Detect(schema *parquet.Schema) (Format, error) {
+	columns := make(map[string]bool)
+	for _, field := range schema.Fields() {
+		columns[strings.ToLower(field.Name())] = true
+	}
+
+	switch {
+	case columns["raw"] && columns["event_id"]:
+		return FormatRaw, nil
+	case columns["class_uid"] || (columns["api"] && columns["metadata"]):
+		return FormatOCSF, nil
+	case columns["eventid"] || columns["eventname"]:
+		return FormatCloudTrail, nil
+	}
+	return "", fmt.Errorf("unrecognised parquet schema: not CloudTrail Lake, OCSF or gocloudtrail output")
+}
+
+func convert(schema *parquet.Schema, row any, f Format) (json.RawMessage, error) {
+	m, _ := normalize(schema, row).(map[string]any)
+
+	var event map[string]any
+	switch f {
+	case FormatRaw:
+		raw, _ := m["raw"].(string)
+		return json.RawMessage(raw), nil
+	case FormatOCSF:
+		event = fromOCSF(m)
+	default:
+		event = fromCloudTrail(m)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("encode event: %w", err)
+	}
+	return data, nil
+}
+
+// normalize turns the generic values parquet-go reconstructs into JSON
+// friendly ones: byte arrays to strings and timestamps to RFC3339
+func normalize(node parquet.Node, v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return string(v)
+	case int64, int32:
+		if unit, ok := timestampUnit(node); ok {
+			n := toInt64(v)
+			return time.Unix(0, n*int64(unit)).UTC().Format(time.RFC3339)
+		}
+		return v
+	case deprecated.Int96:
+		return int96Time(v).Format(time.RFC3339)
+	case []any:
+		elem := listElement(node)
+		for i, x := range v {
+			v[i] = normalize(elem, x)
+		}
+		return v
+	case map[string]any:
+		if isMap(node) {
+			value := mapValue(node)
+			for k, x := range v {
+				v[k] = normalize(value, x)
+			}
+			return v
+		}
+		for _, field := range node.Fields() {
+			if x, ok := v[field.Name()]; ok {
+				v[field.Name()] = normalize(field, x)
+			}
+		}
+		return v
+	}
+	return v
+}
+
+func toInt64(v any) int64 {
+	if n, ok := v.(int32); ok {
+		return int64(n)
+	}
+	return v.(int64)
+}
+
+func timestampUnit(node parquet.Node) (time.Duration, bool) {
+	if node == nil || !node.Leaf() {
+		return 0, false
+	}
+	if lt := node.Type().LogicalType(); lt != nil {
+		if ts, ok := lt.Value.(*format.TimestampType); ok && ts.Unit.Value != nil {
+			return ts.Unit.Value.Duration(), true
+		}
+	}
+	if ct := node.Type().ConvertedType(); ct != nil {
+		switch *ct {
+		case deprecated.TimestampMillis:
+			return time.Millisecond, true
+		case deprecated.TimestampMicros:
+			return time.Microsecond, true
+		}
+	}
+	return 0, false
+}
+
+// int96Time decodes the legacy INT96 timestamp: nanoseconds within the day
+// followed by the Julian day number
+func int96Time(v deprecated.Int96) time.Time {
+	var b [12]byte
+	binary.LittleEndian.PutUint32(b[0:], v[0])
+	binary.LittleEndian.PutUint32(b[4:], v[1])
+	binary.LittleEndian.PutUint32(b[8:], v[2])
+	nanos := int64(binary.LittleEndian.Uint64(b[0:8]))
+	days := int64(binary.LittleEndian.Uint32(b[8:12])) - 2440588 // Julian day of the Unix epoch
+	return time.Unix(days*86400, nanos).UTC()
+}
+
+func isMap(node parquet.Node) bool {
+	if node == nil || node.Leaf() {
+		return false
+	}
+	lt := node.Type().LogicalType()
+	if lt != nil {
+		if _, ok := lt.Value.(*format.MapType); ok {
+			return true
+		}
+	}
+	ct := node.Type().ConvertedType()
+	return ct != nil && (*ct == deprecated.Map || *ct == deprecated.MapKeyValue)
+}
+
+func mapValue(node parquet.Node) parquet.Node {
+	fields := node.Fields()
+	if len(fields) == 0 {
+		return nil
+	}
+	for _, f := range fields[0].Fields() {
+		if f.Name() == "value" {
+			return f
+		}
+	}
+	return nil
+}
+
+// listElement returns the node describing each element of a list, for both
+// the standard three-level layout and legacy repeated fields
+func listElement(node parquet.Node) parquet.Node {
+	if node == nil || node.Leaf() || len(node.Fields()) == 0 {
+		return node
+	}
+	inner := node.Fields()[0]
+	if !inner.Leaf() && len(inner.Fields()) == 1 && len(node.Fields()) == 1 {
+		return inner.Fields()[0]
+	}
+	if len(node.Fields()) == 1 {
+		return inner
+	}
+	return node
+}
+
+// eventTime rewrites the timestamp layouts Lake and Athena use, and OCSF
+// epoch milliseconds, as RFC3339
+func eventTime(v any) any {
+	if ms, ok := v.(int64); ok {
+		return time.UnixMilli(ms).UTC().Format(time.RFC3339)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.000", "2006-01-02 15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return s
+}
+
+// jsonValue embeds strings holding JSON objects or arrays as JSON, the way
+// they appear in the original log files
+func jsonValue(v any) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+	trimmed := strings.TrimSpace(s)
+	if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+		return v
+	}
+	if !json.Valid([]byte(trimmed)) {
+		return v
+	}
+	return json.RawMessage(trimmed)
+}
@@ -0,0 +1,260 @@
+package lake
+
+import (
+	"strings"
+)
+
+// CloudTrail record field names, so columns lowercased by Glue or Athena can
+// be restored
+var cloudTrailNames = names(
+	"eventVersion", "userIdentity", "eventTime", "eventSource", "eventName", "awsRegion",
+	"sourceIPAddress", "userAgent", "errorCode", "errorMessage", "requestParameters",
+	"responseElements", "additionalEventData", "requestID", "eventID", "readOnly", "resources",
+	"eventType", "apiVersion", "managementEvent", "recipientAccountId", "sharedEventID",
+	"serviceEventDetails", "vpcEndpointId", "vpcEndpointAccountId", "eventCategory", "addendum",
+	"sessionCredentialFromConsole", "edgeDeviceDetails", "tlsDetails", "insightDetails",
+	// userIdentity and its session context
+	"type", "principalId", "arn", "accountId", "accessKeyId", "userName", "sessionContext",
+	"invokedBy", "identityProvider", "credentialId", "onBehalfOf", "inScopeOf", "attributes",
+	"sessionIssuer", "webIdFederationData", "sourceIdentity", "ec2RoleDelivery", "assumedRoot",
+	"creationDate", "mfaAuthenticated",
+	// tlsDetails
+	"tlsVersion", "cipherSuite", "clientProvidedHostHeader",
+)
+
+// fields holding arbitrary service data; Lake and Athena keep them as JSON
+// strings, or maps of JSON strings
+var jsonFields = []string{"requestParameters", "responseElements", "additionalEventData", "serviceEventDetails", "addendum", "edgeDeviceDetails", "insightDetails"}
+
+// nested objects whose keys are CloudTrail names rather than service data
+var structFields = map[string]bool{
+	"userIdentity": true, "sessionContext": true, "attributes": true,
+	"sessionIssuer": true, "webIdFederationData": true, "tlsDetails": true,
+}
+
+func names(list ...string) map[string]string {
+	m := make(map[string]string, len(list))
+	for _, name := range list {
+		m[strings.ToLower(name)] = name
+	}
+	return m
+}
+
+// fromCloudTrail rebuilds a log file record from a CloudTrail Lake row
+func fromCloudTrail(row map[string]any) map[string]any {
+	event := restoreNames(row)
+
+	if v, ok := event["eventTime"]; ok {
+		event["eventTime"] = eventTime(v)
+	}
+	for _, field := range jsonFields {
+		switch v := event[field].(type) {
+		case string:
+			event[field] = jsonValue(v)
+		case map[string]any:
+			for k, x := range v {
+				v[k] = jsonValue(x)
+			}
+		}
+	}
+	if resources, ok := event["resources"].([]any); ok {
+		for _, r := range resources {
+			if m, ok := r.(map[string]any); ok {
+				if arn, ok := m["arn"]; ok {
+					delete(m, "arn")
+					m["ARN"] = arn
+				}
+				for k, v := range m {
+					if name, ok := cloudTrailNames[strings.ToLower(k)]; ok && name != k {
+						delete(m, k)
+						m[name] = v
+					}
+				}
+			}
+		}
+	}
+	return event
+}
+
+// restoreNames restores CloudTrail's field names and drops null columns
+func restoreNames(m map[string]any) map[string]any {
+	out := make(map[string]any, len(m))
+	for k, v := range m {
+		if v == nil {
+			continue
+		}
+		name, ok := cloudTrailNames[strings.ToLower(k)]
+		if !ok {
+			name = k
+		}
+		if nested, ok := v.(map[string]any); ok && structFields[name] {
+			v = restoreNames(nested)
+		}
+		out[name] = v
+	}
+	return out
+}
+
+// fromOCSF maps a Security Lake OCSF API activity row back to the
+// CloudTrail record it was built from. Security Lake keeps the CloudTrail
+// fields it has no OCSF attribute for in unmapped, and those are restored
+// as well.
+func fromOCSF(row map[string]any) map[string]any {
+	event := make(map[string]any)
+	set := func(name string, paths ...string) {
+		if v := firstOf(row, paths...); v != nil {
+			event[name] = v
+		}
+	}
+
+	set("eventVersion", "metadata.product.version")
+	set("eventTime", "time_dt", "time")
+	set("eventID", "metadata.uid")
+	set("eventType", "metadata.event_code")
+	set("eventName", "api.operation")
+	set("eventSource", "api.service.name")
+	set("requestID", "api.request.uid")
+	set("requestParameters", "api.request.data")
+	set("responseElements", "api.response.data")
+	set("errorCode", "api.response.error")
+	set("errorMessage", "api.response.message")
+	set("awsRegion", "cloud.region")
+	set("recipientAccountId", "cloud.account.uid", "cloud.account_uid")
+	set("sourceIPAddress", "src_endpoint.ip", "src_endpoint.domain")
+	set("userAgent", "http_request.user_agent")
+	set("vpcEndpointId", "src_endpoint.uid")
+
+	identity := make(map[string]any)
+	setIdentity := func(name string, paths ...string) {
+		if v := firstOf(row, paths...); v != nil {
+			identity[name] = v
+		}
+	}
+	setIdentity("type", "actor.user.type")
+	setIdentity("principalId", "actor.user.uid_alt")
+	setIdentity("arn", "actor.user.uid")
+	setIdentity("accountId", "actor.user.account.uid", "actor.user.account_uid")
+	setIdentity("accessKeyId", "actor.user.credential_uid")
+	setIdentity("userName", "actor.user.name")
+	setIdentity("invokedBy", "actor.invoked_by")
+	setIdentity("identityProvider", "actor.idp.name")
+
+	session := make(map[string]any)
+	if issuer := firstOf(row, "actor.session.issuer"); issuer != nil {
+		session["sessionIssuer"] = map[string]any{"arn": issuer}
+	}
+	attributes := make(map[string]any)
+	if v := firstOf(row, "actor.session.created_time_dt", "actor.session.created_time"); v != nil {
+		attributes["creationDate"] = eventTime(v)
+	}
+	if v, ok := lookup(row, "actor.session.is_mfa").(bool); ok {
+		attributes["mfaAuthenticated"] = boolString(v)
+	}
+	if len(attributes) > 0 {
+		session["attributes"] = attributes
+	}
+	if len(session) > 0 {
+		identity["sessionContext"] = session
+	}
+	if len(identity) > 0 {
+		event["userIdentity"] = identity
+	}
+
+	if resources, ok := lookup(row, "resources").([]any); ok {
+		var out []any
+		for _, r := range resources {
+			m, ok := r.(map[string]any)
+			if !ok {
+				continue
+			}
+			res := make(map[string]any)
+			if v := lookup(m, "uid"); v != nil {
+				res["ARN"] = v
+			}
+			if v := lookup(m, "type"); v != nil {
+				res["type"] = v
+			}
+			if v := firstOf(m, "owner.account.uid", "account_uid"); v != nil {
+				res["accountId"] = v
+			}
+			out = append(out, res)
+		}
+		if len(out) > 0 {
+			event["resources"] = out
+		}
+	}
+
+	if unmapped, ok := row["unmapped"].(map[string]any); ok {
+		for k, v := range unmapped {
+			if v != nil {
+				setUnmapped(event, strings.Split(k, "."), jsonValue(v))
+			}
+		}
+	}
+
+	if v, ok := event["eventTime"]; ok {
+		event["eventTime"] = eventTime(v)
+	}
+	for _, field := range jsonFields {
+		if v, ok := event[field]; ok {
+			event[field] = jsonValue(v)
+		}
+	}
+	return event
+}
+
+// setUnmapped sets a value from unmapped at its dotted path, without
+// replacing anything already mapped
+func setUnmapped(m map[string]any, path []string, v any) {
+	name, ok := cloudTrailNames[strings.ToLower(path[0])]
+	if !ok {
+		name = path[0]
+	}
+	if len(path) == 1 {
+		if _, exists := m[name]; !exists {
+			m[name] = v
+		}
+		return
+	}
+
+	child, ok := m[name].(map[string]any)
+	if !ok {
+		if _, exists := m[name]; exists {
+			return
+		}
+		child = make(map[string]any)
+		m[name] = child
+	}
+	setUnmapped(child, path[1:], v)
+}
+
+// lookup follows a dotted path through nested maps
+func lookup(m map[string]any, path string) any {
+	var v any = m
+	for _, part := range strings.Split(path, ".") {
+		cur, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		if v, ok = cur[part]; !ok {
+			return nil
+		}
+	}
+	return v
+}
+
+func firstOf(m map[string]any, paths ...string) any {
+	for _, p := range paths {
+		if v := lookup(m, p); v != nil && v != "" {
+			return v
+		}
+	}
+	return nil
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
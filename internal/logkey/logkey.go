@@ -0,0 +1,90 @@
+package logkey
+
+import (
+	"path"
+	"strings"
+	"time"
+)
+
+// CloudTrail log files are named
+// AccountID_CloudTrail_Region_YYYYMMDDTHHmmZ_UniqueString.json.gz
+const fileTimeLayout = "20060102T1504Z"
+
+// Time returns the delivery timestamp embedded in a CloudTrail log file key
+func Time(key string) (time.Time, bool) {
+	for _, part := range strings.Split(path.Base(key), "_") {
+		if len(part) != len(fileTimeLayout) {
+			continue
+		}
+		if t, err := time.Parse(fileTimeLayout, part); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// log file suffixes, covering re-delivered files that were left uncompressed
+// or recompressed; the content's actual encoding is detected when decoding
+var logFileSuffixes = []string{".json.gz", ".json", ".json.zst", ".json.zstd"}
+
+// IsLogFile reports whether a key names a log file rather than some other
+// object under the trail prefix
+func IsLogFile(key string) bool {
+	for _, suffix := range logFileSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// CloudTrail files each kind of log file under its own folder between the
+// account and the region, AWSLogs/<account>/<folder>/<region>/, and names
+// the files after that folder
+const (
+	// management, data and network activity events
+	DefaultFolder = "CloudTrail"
+	// CloudTrail Insights events
+	InsightFolder = "CloudTrail-Insight"
+	// digest files, which hold no events
+	DigestFolder = "CloudTrail-Digest"
+)
+
+// DefaultFolders are the folders listed for events unless configured
+// otherwise
+var DefaultFolders = []string{DefaultFolder, InsightFolder}
+
+// FolderCategory returns the eventCategory of events delivered under a
+// folder that don't carry one, empty to leave it to the event
+func FolderCategory(folder string) string {
+	if folder == InsightFolder {
+		return "Insight"
+	}
+	return ""
+}
+
+// Source returns the account and region a CloudTrail log file key was
+// delivered for, read from its file name
+func Source(key string) (accountID, region string, ok bool) {
+	parts := strings.Split(path.Base(key), "_")
+	if len(parts) < 4 || !isLogFolder(parts[1]) || parts[0] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[2], true
+}
+
+// Folder returns the folder a CloudTrail log file key was delivered under,
+// read from its file name
+func Folder(key string) (string, bool) {
+	parts := strings.Split(path.Base(key), "_")
+	if len(parts) < 4 || !isLogFolder(parts[1]) {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// isLogFolder reports whether files named after folder hold events: any
+// CloudTrail folder but the digests'
+func isLogFolder(folder string) bool {
+	return (folder == DefaultFolder || strings.HasPrefix(folder, DefaultFolder+"-")) && folder != DigestFolder
+}
@@ -0,0 +1,165 @@
+// Package logsample keeps identical warnings and errors from flooding the
+// log: each is logged a few times per interval, and the rest are counted and
+// summarised once the interval is up
+package logsample
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Handler passes records on to another handler, sampling warnings and errors
+// that repeat. Records repeat when they share a level, a message and, when
+// they have one, an error_class attribute, whatever their other attributes
+// (key, error text) say.
+type Handler struct {
+	next  slog.Handler
+	store *store
+}
+
+type store struct {
+	mu       sync.Mutex
+	first    int
+	interval time.Duration
+	windows  map[sampleKey]*window
+}
+
+type sampleKey struct {
+	level slog.Level
+	msg   string
+	class string
+}
+
+type window struct {
+	start      time.Time
+	seen       int
+	suppressed int
+	// the last record suppressed, and the handler it would have gone to
+	last     slog.Record
+	lastNext slog.Handler
+}
+
+// New logs the first records of each kind per interval through next, and
+// counts the rest
+func New(next slog.Handler, first int, interval time.Duration) *Handler {
+	return &Handler{next: next, store: &store{
+		first:    first,
+		interval: interval,
+		windows:  make(map[sampleKey]*window),
+	}}
+}
+
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelWarn {
+		return h.next.Handle(ctx, r)
+	}
+	key := sampleKey{level: r.Level, msg: r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "error_class" {
+			key.class = a.Value.String()
+			return false
+		}
+		return true
+	})
+
+	s := h.store
+	s.mu.Lock()
+	w := s.windows[key]
+	var expired *window
+	if w != nil && r.Time.Sub(w.start) >= s.interval {
+		expired, w = w, nil
+	}
+	if w == nil {
+		w = &window{start: r.Time}
+		s.windows[key] = w
+	}
+	w.seen++
+	pass := w.seen <= s.first
+	if !pass {
+		w.suppressed++
+		w.last, w.lastNext = r.Clone(), h.next
+	}
+	s.mu.Unlock()
+
+	if expired != nil {
+		expired.summarise(ctx, key, s.interval)
+	}
+	if !pass {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &Handler{next: h.next.WithAttrs(attrs), store: h.store}
+}
+
+func (h *Handler) WithGroup(name string) slog.Handler {
+	return &Handler{next: h.next.WithGroup(name), store: h.store}
+}
+
+// Run summarises the intervals that ended every interval until ctx is done
+func (h *Handler) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.store.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.flush(ctx, now)
+		}
+	}
+}
+
+// Flush summarises every interval with suppressed records, ended or not, as
+// at the end of a run
+func (h *Handler) Flush() {
+	h.flush(context.Background(), time.Time{})
+}
+
+// flush summarises the intervals that ended by now, or all of them when now
+// is zero
+func (h *Handler) flush(ctx context.Context, now time.Time) {
+	s := h.store
+	s.mu.Lock()
+	ended := make(map[sampleKey]*window)
+	for key, w := range s.windows {
+		if now.IsZero() || now.Sub(w.start) >= s.interval {
+			ended[key] = w
+			delete(s.windows, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for key, w := range ended {
+		w.summarise(ctx, key, s.interval)
+	}
+}
+
+// summarise logs how many records of the interval were left out, with the
+// attributes of the last one
+func (w *window) summarise(ctx context.Context, key sampleKey, interval time.Duration) {
+	if w.suppressed == 0 {
+		return
+	}
+	r := slog.NewRecord(time.Now(), key.level, "suppressed repeated log lines", 0)
+	last := make([]any, 0, w.last.NumAttrs())
+	w.last.Attrs(func(a slog.Attr) bool {
+		last = append(last, a)
+		return true
+	})
+	r.AddAttrs(
+		slog.String("message", key.msg),
+		slog.Int("suppressed", w.suppressed),
+		slog.Int("total", w.seen),
+		slog.Duration("interval", interval),
+		slog.Group("last", last...))
+	_ = w.lastNext.Handle(ctx, r)
+}
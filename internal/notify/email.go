@@ -0,0 +1,101 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+)
+
+// Attachment is a file sent along with an email message
+type Attachment struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// SES emails messages, with any attachments, through Amazon SES
+type SES struct {
+	Client *sesv2.Client
+	From   string
+	To     []string
+}
+
+func (s *SES) Send(ctx context.Context, m Message) error {
+	raw, err := buildEmail(s.From, s.To, m, time.Now())
+	if err != nil {
+		return err
+	}
+
+	_, err = s.Client.SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(s.From),
+		Destination:      &types.Destination{ToAddresses: s.To},
+		Content:          &types.EmailContent{Raw: &types.RawMessage{Data: raw}},
+	})
+	if err != nil {
+		return fmt.Errorf("send email: %w", err)
+	}
+	return nil
+}
+
+// buildEmail renders a message as a MIME multipart/mixed email
+func buildEmail(from string, to []string, m Message, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Title))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%q\r\n\r\n", mw.Boundary())
+
+	var body strings.Builder
+	body.WriteString(m.Text + "\n\n")
+	for _, f := range m.Facts {
+		fmt.Fprintf(&body, "%s: %s\n", f.Name, f.Value)
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("build email: %w", err)
+	}
+	if _, err := part.Write([]byte(body.String())); err != nil {
+		return nil, fmt.Errorf("build email: %w", err)
+	}
+
+	for _, a := range m.Attachments {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Name})},
+			"Content-Transfer-Encoding": {"base64"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("build email: %w", err)
+		}
+
+		// wrap base64 at 76 columns as RFC 2045 requires
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			fmt.Fprintf(part, "%s\r\n", encoded[:76])
+			encoded = encoded[76:]
+		}
+		fmt.Fprintf(part, "%s\r\n", encoded)
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, fmt.Errorf("build email: %w", err)
+	}
+	return buf.Bytes(), nil
+}
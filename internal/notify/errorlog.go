@@ -0,0 +1,103 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// ErrorLog is a slog handler that passes records on to another handler and
+// keeps the first error-level records of a run for the summary report
+type ErrorLog struct {
+	next  slog.Handler
+	attrs []slog.Attr
+	store *errorStore
+}
+
+type errorStore struct {
+	mu      sync.Mutex
+	limit   int
+	dropped int
+	entries []map[string]any
+}
+
+// NewErrorLog keeps up to limit error records logged through next
+func NewErrorLog(next slog.Handler, limit int) *ErrorLog {
+	return &ErrorLog{next: next, store: &errorStore{limit: limit}}
+}
+
+func (h *ErrorLog) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= slog.LevelError || h.next.Enabled(ctx, level)
+}
+
+func (h *ErrorLog) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError {
+		h.store.add(r, h.attrs)
+	}
+	if !h.next.Enabled(ctx, r.Level) {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *ErrorLog) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ErrorLog{
+		next:  h.next.WithAttrs(attrs),
+		attrs: append(append([]slog.Attr{}, h.attrs...), attrs...),
+		store: h.store,
+	}
+}
+
+// WithGroup is passed through; captured records keep their attributes flat
+func (h *ErrorLog) WithGroup(name string) slog.Handler {
+	return &ErrorLog{next: h.next.WithGroup(name), attrs: h.attrs, store: h.store}
+}
+
+// Len returns how many error records were logged, including any not kept
+func (h *ErrorLog) Len() int {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+	return len(h.store.entries) + h.store.dropped
+}
+
+// Report returns the kept error records as JSON lines
+func (h *ErrorLog) Report() []byte {
+	h.store.mu.Lock()
+	defer h.store.mu.Unlock()
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, e := range h.store.entries {
+		_ = enc.Encode(e)
+	}
+	if h.store.dropped > 0 {
+		_ = enc.Encode(map[string]any{"msg": "further errors not kept", "count": h.store.dropped})
+	}
+	return buf.Bytes()
+}
+
+func (s *errorStore) add(r slog.Record, attrs []slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.entries) >= s.limit {
+		s.dropped++
+		return
+	}
+
+	entry := map[string]any{
+		"time": r.Time.UTC().Format(time.RFC3339),
+		"msg":  r.Message,
+	}
+	for _, a := range attrs {
+		entry[a.Key] = a.Value.Resolve().Any()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		entry[a.Key] = a.Value.Resolve().Any()
+		return true
+	})
+	s.entries = append(s.entries, entry)
+}
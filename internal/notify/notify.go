@@ -0,0 +1,124 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Message is a run notification: a title, a short status line and ordered
+// key/value facts. Attachments are only sent by email.
+type Message struct {
+	Title       string
+	Text        string
+	Facts       []Fact
+	Failed      bool
+	Attachments []Attachment
+}
+
+type Fact struct {
+	Name  string
+	Value string
+}
+
+// Sender delivers messages to a chat channel or mailbox
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Slack posts to a Slack incoming webhook
+type Slack struct {
+	URL    string
+	Client *http.Client
+}
+
+func (s *Slack) Send(ctx context.Context, m Message) error {
+	var b strings.Builder
+	icon := ":white_check_mark:"
+	if m.Failed {
+		icon = ":x:"
+	}
+	fmt.Fprintf(&b, "%s *%s*\n%s", icon, m.Title, m.Text)
+	for _, f := range m.Facts {
+		fmt.Fprintf(&b, "\n• *%s:* %s", f.Name, f.Value)
+	}
+	return post(ctx, s.Client, s.URL, map[string]string{"text": b.String()})
+}
+
+// Teams posts a MessageCard to a Microsoft Teams incoming webhook
+type Teams struct {
+	URL    string
+	Client *http.Client
+}
+
+func (t *Teams) Send(ctx context.Context, m Message) error {
+	type fact struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	facts := make([]fact, 0, len(m.Facts))
+	for _, f := range m.Facts {
+		facts = append(facts, fact{Name: f.Name, Value: f.Value})
+	}
+
+	color := "2EB886"
+	if m.Failed {
+		color = "D00000"
+	}
+	card := map[string]any{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    m.Title,
+		"themeColor": color,
+		"title":      m.Title,
+		"text":       m.Text,
+		"sections":   []map[string]any{{"facts": facts}},
+	}
+	return post(ctx, t.Client, t.URL, card)
+}
+
+// Multi sends each message to every sender
+type Multi []Sender
+
+func (m Multi) Send(ctx context.Context, msg Message) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Send(ctx, msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func post(ctx context.Context, client *http.Client, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("marshal message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post message: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post message: unexpected status %s", resp.Status)
+	}
+	return nil
+}
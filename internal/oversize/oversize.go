@@ -0,0 +1,231 @@
+// Package oversize shrinks CloudTrail records bigger than a size limit.
+// A few calls return responseElements or requestParameters running to
+// megabytes, enough on their own to push a sink's batch past its payload
+// limit.
+package oversize
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What to do with a record over the limit
+const (
+	// only count it
+	ActionCount = "count"
+	// replace its largest fields with a marker until it fits
+	ActionTruncate = "truncate"
+	// move its largest fields to a side file, leaving a pointer to it
+	ActionExternalize = "externalize"
+)
+
+// DefaultFields are the fields shrunk when none are configured, the ones
+// CloudTrail fills with whatever the API took or returned
+var DefaultFields = []string{"responseElements", "requestParameters", "additionalEventData", "serviceEventDetails"}
+
+// Options configures a Limiter
+type Options struct {
+	// records bigger than this many bytes are oversized
+	MaxBytes int
+	Action   string
+	// top-level fields that may be shrunk, largest first
+	Fields []string
+	// side files go here, by event day
+	Dir string
+}
+
+// Limiter finds and shrinks oversized records. A nil Limiter leaves every
+// record alone.
+type Limiter struct {
+	maxBytes int
+	action   string
+	fields   []string
+	dir      string
+}
+
+// New returns a Limiter for opts, nil when MaxBytes is unset
+func New(opts Options) (*Limiter, error) {
+	if opts.MaxBytes <= 0 {
+		return nil, nil
+	}
+	l := &Limiter{maxBytes: opts.MaxBytes, action: opts.Action, fields: opts.Fields, dir: opts.Dir}
+	switch l.action {
+	case "":
+		l.action = ActionTruncate
+	case ActionCount, ActionTruncate, ActionExternalize:
+	default:
+		return nil, fmt.Errorf("unknown action %q (want count, truncate or externalize)", opts.Action)
+	}
+	if len(l.fields) == 0 {
+		l.fields = DefaultFields
+	}
+	if l.action == ActionExternalize && l.dir == "" {
+		return nil, fmt.Errorf("externalize needs a dir")
+	}
+	return l, nil
+}
+
+// Result is what Apply found and did
+type Result struct {
+	// the record was over the limit
+	Oversized bool
+	// its size as it came
+	Size int
+	// the fields truncated or externalized, largest first
+	Fields []string
+	// the side file they went to
+	Path string
+}
+
+// Apply returns raw unchanged when it's within the limit, and otherwise
+// with its largest shrinkable fields truncated or externalized until it
+// fits, or until there are none left. A truncated field becomes
+// {"truncated":true,"bytes":N}, an externalized one
+// {"externalized":"<side file>","bytes":N}; everything else keeps its
+// bytes and order. If the side file can't be written the fields are
+// truncated instead and the error returned.
+func (l *Limiter) Apply(raw json.RawMessage, eventID string, eventTime time.Time) (json.RawMessage, Result, error) {
+	if l == nil || len(raw) <= l.maxBytes {
+		return raw, Result{}, nil
+	}
+	res := Result{Oversized: true, Size: len(raw)}
+	if l.action == ActionCount {
+		return raw, res, nil
+	}
+	spans, err := topLevelFields(raw)
+	if err != nil {
+		// not an object; nothing to shrink
+		return raw, res, nil
+	}
+
+	var candidates []span
+	for _, s := range spans {
+		if slices.Contains(l.fields, s.name) {
+			candidates = append(candidates, s)
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b span) int { return (b.end - b.start) - (a.end - a.start) })
+	size := len(raw)
+	var chosen []span
+	for _, s := range candidates {
+		// sorted largest first, so the rest wouldn't shrink it either
+		if size <= l.maxBytes || s.end-s.start <= 100 {
+			break
+		}
+		chosen = append(chosen, s)
+		res.Fields = append(res.Fields, s.name)
+		// a marker is well under 100 bytes
+		size -= s.end - s.start - 100
+	}
+	if len(chosen) == 0 {
+		return raw, res, nil
+	}
+
+	var writeErr error
+	if l.action == ActionExternalize {
+		res.Path, writeErr = l.externalize(raw, chosen, eventID, eventTime)
+	}
+	slices.SortFunc(chosen, func(a, b span) int { return a.start - b.start })
+	out := make([]byte, 0, size+len(chosen)*100)
+	prev := 0
+	for _, s := range chosen {
+		out = append(out, raw[prev:s.start]...)
+		if res.Path != "" {
+			out = append(out, `{"externalized":`...)
+			out = strconv.AppendQuote(out, res.Path)
+		} else {
+			out = append(out, `{"truncated":true`...)
+		}
+		out = append(out, `,"bytes":`...)
+		out = strconv.AppendInt(out, int64(s.end-s.start), 10)
+		out = append(out, '}')
+		prev = s.end
+	}
+	out = append(out, raw[prev:]...)
+	return out, res, writeErr
+}
+
+// externalize writes the spans' fields to a side file as one JSON object,
+// returning its path
+func (l *Limiter) externalize(raw []byte, spans []span, eventID string, eventTime time.Time) (string, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, s := range spans {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Quote(s.name))
+		buf.WriteByte(':')
+		buf.Write(raw[s.start:s.end])
+	}
+	buf.WriteString("}\n")
+
+	dir := filepath.Join(l.dir, eventTime.UTC().Format("2006/01/02"))
+	path := filepath.Join(dir, fileName(eventID, raw)+".json")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("create oversized dir: %w", err)
+	}
+	// a rewrite of the same event replaces its side file whole
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("write oversized fields: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("write oversized fields: %w", err)
+	}
+	return path, nil
+}
+
+// fileName is the event ID made safe for a file name, or a hash of the
+// record when it has none
+func fileName(eventID string, raw []byte) string {
+	if eventID == "" {
+		sum := sha256.Sum256(raw)
+		return hex.EncodeToString(sum[:16])
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, eventID)
+}
+
+// span is where a top-level field's value sits in a record
+type span struct {
+	name       string
+	start, end int
+}
+
+// topLevelFields finds the value of each of an object's top-level fields
+func topLevelFields(raw []byte) ([]span, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+	var spans []span
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		end := int(dec.InputOffset())
+		spans = append(spans, span{name: name, start: end - len(value), end: end})
+	}
+	return spans, nil
+}
@@ -0,0 +1,123 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/alert"
+)
+
+// AlertRules are the conditions checked while a run is in progress; a zero
+// threshold disables that condition
+type AlertRules struct {
+	Interval time.Duration
+	// errors / (files downloaded + errors) over one interval
+	MaxErrorRate float64
+	// no files downloaded for this long
+	StallAfter time.Duration
+	// a queue full and no files processed for this long
+	WedgedAfter time.Duration
+	// late events appended to a closed partition
+	PartitionReopened bool
+}
+
+func (p *Processor) alertMonitor(ctx context.Context) {
+	rules := p.config.Alerts
+	if p.config.Notifier == nil || rules.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(rules.Interval)
+	defer ticker.Stop()
+
+	active := make(map[string]bool)
+	lastErrors := p.stats.Errors.Load()
+	lastDownloaded := p.stats.FilesDownloaded.Load()
+	lastProcessed := p.stats.FilesProcessed.Load()
+	lastDownloadAt, lastProcessAt := time.Now(), time.Now()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			errors := p.stats.Errors.Load()
+			downloaded := p.stats.FilesDownloaded.Load()
+			processed := p.stats.FilesProcessed.Load()
+			if downloaded != lastDownloaded {
+				lastDownloadAt = now
+			}
+			if processed != lastProcessed {
+				lastProcessAt = now
+			}
+
+			if rules.MaxErrorRate > 0 {
+				newErrors := errors - lastErrors
+				var rate float64
+				if attempts := newErrors + downloaded - lastDownloaded; attempts > 0 {
+					rate = float64(newErrors) / float64(attempts)
+				}
+				p.setAlert(ctx, active, "error_rate", rate > rules.MaxErrorRate,
+					fmt.Sprintf("error rate %.1f%% over the last %s", rate*100, rules.Interval),
+					map[string]any{"errors": newErrors, "rate": rate, "threshold": rules.MaxErrorRate})
+			}
+
+			if rules.StallAfter > 0 {
+				idle := now.Sub(lastDownloadAt)
+				p.setAlert(ctx, active, "stalled", idle >= rules.StallAfter,
+					fmt.Sprintf("no files downloaded for %s", idle.Round(time.Second)),
+					map[string]any{"files_downloaded": downloaded, "idle_seconds": int64(idle.Seconds())})
+			}
+
+			if rules.WedgedAfter > 0 {
+				full := len(p.downloadJobs) == cap(p.downloadJobs) || len(p.processJobs) == cap(p.processJobs)
+				idle := now.Sub(lastProcessAt)
+				p.setAlert(ctx, active, "queue_wedged", full && idle >= rules.WedgedAfter,
+					fmt.Sprintf("queues full and no files processed for %s", idle.Round(time.Second)),
+					map[string]any{
+						"download_queue": len(p.downloadJobs),
+						"process_queue":  len(p.processJobs),
+						"idle_seconds":   int64(idle.Seconds()),
+					})
+			}
+
+			if p.config.MinFreeDisk > 0 {
+				status := p.Control()
+				p.setAlert(ctx, active, "disk_low", status.DiskLow,
+					fmt.Sprintf("output volume has %d MB free, downloads paused", status.DiskFreeBytes>>20),
+					map[string]any{"free_bytes": status.DiskFreeBytes, "min_free_bytes": p.config.MinFreeDisk})
+			}
+
+			lastErrors, lastDownloaded, lastProcessed = errors, downloaded, processed
+		}
+	}
+}
+
+// setAlert notifies when a condition starts firing and again when it clears
+func (p *Processor) setAlert(ctx context.Context, active map[string]bool, condition string, firing bool, message string, details map[string]any) {
+	if firing == active[condition] {
+		return
+	}
+	active[condition] = firing
+
+	a := alert.Alert{
+		Condition: condition,
+		Message:   message,
+		Resolved:  !firing,
+		Time:      time.Now().UTC(),
+		Details:   details,
+	}
+	if firing {
+		p.logger.Warn("alert firing", slog.String("condition", condition), slog.String("message", message))
+	} else {
+		p.logger.Info("alert resolved", slog.String("condition", condition))
+	}
+
+	if err := p.config.Notifier.Notify(ctx, a); err != nil {
+		p.logger.Error("failed to send alert",
+			slog.String("condition", condition),
+			slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,235 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// distinct values tracked per counter; later values are counted as "(other)"
+// so high-cardinality fields like source IPs can't grow memory without bound
+const maxTrackedValues = 100_000
+
+// AnalyticsOptions enable the top-N summary of newly written events
+type AnalyticsOptions struct {
+	TopN int
+	// also emit the summary this often during the run; zero = only at the end
+	Interval time.Duration
+	// JSON summary written alongside the log lines; empty = log only
+	File string
+}
+
+// Summary is a triage report of the events written by a run
+type Summary struct {
+	GeneratedAt   time.Time        `json:"generated_at"`
+	Events        int64            `json:"events"`
+	TopEventNames []ValueCount     `json:"top_event_names"`
+	TopPrincipals []ValueCount     `json:"top_principals"`
+	TopSourceIPs  []ValueCount     `json:"top_source_ips"`
+	TopErrorCodes []ValueCount     `json:"top_error_codes"`
+	Accounts      []AccountSummary `json:"accounts"`
+}
+
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// AccountSummary is an account's event count with a per-day histogram
+type AccountSummary struct {
+	AccountID string           `json:"account_id"`
+	Events    int64            `json:"events"`
+	Days      map[string]int64 `json:"days"`
+}
+
+type analytics struct {
+	mu         sync.Mutex
+	events     int64
+	eventNames map[string]int64
+	principals map[string]int64
+	sourceIPs  map[string]int64
+	errorCodes map[string]int64
+	accounts   map[string]map[string]int64
+}
+
+func newAnalytics() *analytics {
+	return &analytics{
+		eventNames: make(map[string]int64),
+		principals: make(map[string]int64),
+		sourceIPs:  make(map[string]int64),
+		errorCodes: make(map[string]int64),
+		accounts:   make(map[string]map[string]int64),
+	}
+}
+
+func (a *analytics) add(ev *MinimalEvent, accountID string, eventTime time.Time) {
+	principal := ev.UserIdentity.ARN
+	if principal == "" {
+		principal = ev.UserIdentity.InvokedBy
+	}
+	if principal == "" {
+		principal = ev.UserIdentity.Type
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.events++
+	count(a.eventNames, ev.EventSource+":"+ev.EventName)
+	count(a.principals, principal)
+	count(a.sourceIPs, ev.SourceIPAddress)
+	if ev.ErrorCode != "" {
+		count(a.errorCodes, ev.ErrorCode)
+	}
+
+	days, ok := a.accounts[accountID]
+	if !ok {
+		days = make(map[string]int64)
+		a.accounts[accountID] = days
+	}
+	days[eventTime.UTC().Format("2006-01-02")]++
+}
+
+func count(counts map[string]int64, value string) {
+	if value == "" {
+		return
+	}
+	if _, ok := counts[value]; !ok && len(counts) >= maxTrackedValues {
+		value = "(other)"
+	}
+	counts[value]++
+}
+
+func (a *analytics) summary(n int) Summary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := Summary{
+		GeneratedAt:   time.Now().UTC(),
+		Events:        a.events,
+		TopEventNames: topN(a.eventNames, n),
+		TopPrincipals: topN(a.principals, n),
+		TopSourceIPs:  topN(a.sourceIPs, n),
+		TopErrorCodes: topN(a.errorCodes, n),
+	}
+	for account, days := range a.accounts {
+		as := AccountSummary{AccountID: account, Days: make(map[string]int64, len(days))}
+		for day, c := range days {
+			as.Days[day] = c
+			as.Events += c
+		}
+		s.Accounts = append(s.Accounts, as)
+	}
+	sort.Slice(s.Accounts, func(i, j int) bool {
+		if s.Accounts[i].Events != s.Accounts[j].Events {
+			return s.Accounts[i].Events > s.Accounts[j].Events
+		}
+		return s.Accounts[i].AccountID < s.Accounts[j].AccountID
+	})
+	return s
+}
+
+func topN(counts map[string]int64, n int) []ValueCount {
+	top := make([]ValueCount, 0, len(counts))
+	for value, c := range counts {
+		top = append(top, ValueCount{Value: value, Count: c})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top
+}
+
+// Summary returns the analytics summary so far, or nil when disabled
+func (p *Processor) Summary() *Summary {
+	if p.analytics == nil {
+		return nil
+	}
+	s := p.analytics.summary(p.config.Analytics.TopN)
+	return &s
+}
+
+// emitSummary logs the summary and writes it to the summary file
+func (p *Processor) emitSummary() {
+	s := p.Summary()
+	if s == nil {
+		return
+	}
+
+	accounts := make([]ValueCount, 0, len(s.Accounts))
+	for _, a := range s.Accounts {
+		accounts = append(accounts, ValueCount{Value: a.AccountID, Count: a.Events})
+	}
+	if len(accounts) > p.config.Analytics.TopN {
+		accounts = accounts[:p.config.Analytics.TopN]
+	}
+	p.logger.Info("analytics summary",
+		slog.Int64("events", s.Events),
+		slog.String("top_event_names", formatCounts(s.TopEventNames)),
+		slog.String("top_principals", formatCounts(s.TopPrincipals)),
+		slog.String("top_source_ips", formatCounts(s.TopSourceIPs)),
+		slog.String("top_error_codes", formatCounts(s.TopErrorCodes)),
+		slog.String("top_accounts", formatCounts(accounts)))
+
+	if path := p.config.Analytics.File; path != "" {
+		if err := writeSummary(path, s); err != nil {
+			p.logger.Error("failed to write analytics summary", slog.String("error", err.Error()))
+		}
+	}
+}
+
+func formatCounts(counts []ValueCount) string {
+	parts := make([]string, 0, len(counts))
+	for _, c := range counts {
+		parts = append(parts, fmt.Sprintf("%s=%d", c.Value, c.Count))
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeSummary replaces the file atomically so readers never see a partial one
+func writeSummary(path string, s *Summary) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal summary: %w", err)
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return fmt.Errorf("create summary directory: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("write summary: %w", err)
+	}
+	return nil
+}
+
+func (p *Processor) summaryReporter(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.emitSummary()
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package processor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// objectAudit gathers what the audit log records about one downloaded
+// object as it moves through the pipeline
+type objectAudit struct {
+	downloadedAt time.Time
+	size         int64
+	sha256       string
+	etag         string
+	versionID    string
+	records      int64
+	duplicate    int64
+	filtered     int64
+	// partition dirs events were written to
+	outputs map[string]bool
+}
+
+func newObjectAudit(data []byte, meta objectMeta) *objectAudit {
+	return &objectAudit{
+		downloadedAt: time.Now(),
+		size:         int64(len(data)),
+		sha256:       fmt.Sprintf("%x", sha256.Sum256(data)),
+		etag:         meta.etag,
+		versionID:    meta.versionID,
+		outputs:      make(map[string]bool),
+	}
+}
+
+// auditFile queues the audit log entry for a file leaving the pipeline
+func (p *Processor) auditFile(job DownloadJob, written int64, err error) {
+	rec := state.AuditRecord{
+		RunID:      p.runID,
+		Bucket:     job.Bucket,
+		Key:        job.Key,
+		Size:       job.Size,
+		FinishedAt: time.Now(),
+		Status:     state.ObjectDone,
+		Written:    written,
+		Outputs:    []string{},
+	}
+	if err != nil {
+		rec.Status, rec.Error = state.ObjectFailed, err.Error()
+	}
+	if a := job.audit; a != nil {
+		rec.VersionID, rec.ETag, rec.Size, rec.SHA256 = a.versionID, a.etag, a.size, a.sha256
+		rec.DownloadedAt = a.downloadedAt
+		rec.Records, rec.Duplicate, rec.Filtered = a.records, a.duplicate, a.filtered
+		rec.Invalid = max(a.records-written-a.duplicate-a.filtered, 0)
+		for dir := range a.outputs {
+			rec.Outputs = append(rec.Outputs, dir)
+		}
+		sort.Strings(rec.Outputs)
+	}
+
+	p.objects.mu.Lock()
+	p.objects.audit = append(p.objects.audit, rec)
+	p.objects.mu.Unlock()
+}
+
+// saveAudit appends the queued audit log entries to the state DB
+func (p *Processor) saveAudit(pending []state.AuditRecord) {
+	if len(pending) == 0 {
+		return
+	}
+	if err := p.stateDB.AppendAudit(pending); err != nil {
+		p.logger.Error("failed to save audit log entries",
+			slog.Int("count", len(pending)),
+			slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,312 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+type BackfillOptions struct {
+	Start time.Time
+	End   time.Time
+	// span of each unit in days; 7 day units start on Mondays
+	UnitDays int
+	// units listed and processed at once
+	Parallel int
+	// run only units that failed before, rather than every unfinished one
+	RetryFailed bool
+}
+
+// backfillUnit tracks the files of one unit through the pipeline
+type backfillUnit struct {
+	state.BackfillUnit
+	pending sync.WaitGroup
+	files   atomic.Int64
+	events  atomic.Int64
+	mu      sync.Mutex
+	err     error
+}
+
+// finish records that one of the unit's files has left the pipeline, keeping
+// the first error; it does nothing for jobs outside a backfill
+func (u *backfillUnit) finish(events int64, err error) {
+	if u == nil {
+		return
+	}
+	u.events.Add(events)
+	if err != nil {
+		u.mu.Lock()
+		if u.err == nil {
+			u.err = err
+		}
+		u.mu.Unlock()
+	}
+	u.pending.Done()
+}
+
+// Backfill splits [Start, End) into day or week units per trail
+// account/region, records them in the state database and processes the
+// unfinished ones. Checkpoints used by Run are left alone, and units already
+// done are skipped, so an interrupted backfill picks up where it stopped.
+func (p *Processor) Backfill(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration, opts BackfillOptions) error {
+	if opts.Start.IsZero() || opts.End.IsZero() || !opts.End.After(opts.Start) {
+		return fmt.Errorf("backfill needs a start time before its end time")
+	}
+	if opts.UnitDays <= 0 {
+		opts.UnitDays = 1
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+
+	return p.run(ctx, "backfill", progressInterval, flushInterval, bloomSaveInterval, p.resolveSettings,
+		func(ctx context.Context, settings []*trailSettings) error {
+			return p.backfill(ctx, settings, opts)
+		})
+}
+
+type backfillTarget struct {
+	ts     *trailSettings
+	prefix string
+}
+
+func (p *Processor) backfill(ctx context.Context, settings []*trailSettings, opts BackfillOptions) error {
+	starts := unitStarts(opts.Start, opts.End, opts.UnitDays)
+	span := time.Duration(opts.UnitDays) * 24 * time.Hour
+
+	targets := make(map[string]backfillTarget)
+	ends := make(map[string]time.Time)
+	var planned []state.BackfillUnit
+	for _, ts := range settings {
+		if ts.logGroup != nil {
+			p.logger.Info("backfill skips log group", slog.String("log_group", ts.logGroup.Name))
+			continue
+		}
+		// widen the range to whole units so a unit marked done covers all of
+		// its span, even if the backfill is later extended
+		ts.startTime = starts[0]
+		ts.endTime = starts[len(starts)-1].Add(span)
+
+		basePrefix, pairs := p.discoverTrailPairs(ctx, ts.trail)
+		for _, pair := range pairs {
+			key := targetKey(ts.trail.Bucket, pair.AccountID, pair.stateRegion())
+			if _, ok := targets[key]; ok {
+				continue
+			}
+			targets[key] = backfillTarget{ts: ts, prefix: pair.prefix(basePrefix)}
+			for _, start := range starts {
+				u := state.BackfillUnit{
+					Bucket:    ts.trail.Bucket,
+					AccountID: pair.AccountID,
+					Region:    pair.stateRegion(),
+					Start:     start,
+					End:       start.Add(span),
+					Trail:     ts.trail.Name,
+				}
+				planned = append(planned, u)
+				ends[unitKey(u)] = u.End
+			}
+		}
+	}
+
+	added, err := p.stateDB.PlanBackfillUnits(planned)
+	if err != nil {
+		return err
+	}
+	existing, err := p.stateDB.ListBackfillUnits()
+	if err != nil {
+		return err
+	}
+
+	var units []*backfillUnit
+	byStatus := make(map[string]int)
+	for _, u := range existing {
+		end, ok := ends[unitKey(u)]
+		if !ok {
+			continue
+		}
+		if !u.End.Equal(end) {
+			return fmt.Errorf("unit %s starting %s is already planned with a different span; run backfill reset to replan",
+				targetKey(u.Bucket, u.AccountID, u.Region), u.Start.Format(time.DateOnly))
+		}
+		byStatus[u.Status]++
+		if u.Status == state.UnitDone || (opts.RetryFailed && u.Status != state.UnitFailed) {
+			continue
+		}
+		units = append(units, &backfillUnit{BackfillUnit: u})
+	}
+
+	p.logger.Info("planned backfill",
+		slog.Int("units", len(planned)),
+		slog.Int("new", added),
+		slog.Int("done", byStatus[state.UnitDone]),
+		slog.Int("failed", byStatus[state.UnitFailed]),
+		slog.Int("to_run", len(units)))
+
+	sem := make(chan struct{}, opts.Parallel)
+	var wg sync.WaitGroup
+units:
+	for _, u := range units {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			break units
+		}
+		wg.Add(1)
+		go func(u *backfillUnit) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			target := targets[targetKey(u.Bucket, u.AccountID, u.Region)]
+			p.runUnit(ctx, target.ts, target.prefix, u)
+		}(u)
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// runUnit lists every day folder of the unit, waits for its files to be
+// processed and records the outcome
+func (p *Processor) runUnit(ctx context.Context, ts *trailSettings, prefix string, u *backfillUnit) {
+	u.Status, u.Error = state.UnitRunning, ""
+	p.saveUnit(u)
+
+	// held while listing so the unit can't complete early
+	u.pending.Add(1)
+	var err error
+	for day := u.Start; day.Before(u.End) && err == nil; day = day.AddDate(0, 0, 1) {
+		err = p.enqueueDay(ctx, ts, prefix+day.Format("2006/01/02/"), u)
+	}
+	u.pending.Done()
+	u.pending.Wait()
+
+	if err == nil {
+		u.mu.Lock()
+		err = u.err
+		u.mu.Unlock()
+	}
+	// a unit only counts as done once the sinks have its events
+	if err == nil && ctx.Err() == nil && p.acked() && !p.commitStream() {
+		err = errors.New("sink delivery failed")
+	}
+	u.Files, u.Events = u.files.Load(), u.events.Load()
+
+	switch {
+	case ctx.Err() != nil:
+		// interrupted, so it runs again next time
+		u.Status = state.UnitPending
+	case err != nil:
+		u.Status, u.Error = state.UnitFailed, err.Error()
+		p.logger.Warn("backfill unit failed",
+			slog.String("state_key", targetKey(u.Bucket, u.AccountID, u.Region)),
+			slog.String("start", u.Start.Format(time.DateOnly)),
+			slog.String("error", u.Error))
+	default:
+		u.Status = state.UnitDone
+		p.logger.Info("backfill unit done",
+			slog.String("state_key", targetKey(u.Bucket, u.AccountID, u.Region)),
+			slog.String("start", u.Start.Format(time.DateOnly)),
+			slog.Int64("files", u.Files),
+			slog.Int64("events", u.Events))
+	}
+	p.saveUnit(u)
+}
+
+// enqueueDay sends every log file under one day prefix to the download
+// workers as part of the unit
+func (p *Processor) enqueueDay(ctx context.Context, ts *trailSettings, prefix string, u *backfillUnit) error {
+	paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(ts.trail.Bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(ts.listBatchSize)),
+	})
+	for paginator.HasMorePages() {
+		if err := p.control.wait(ctx); err != nil {
+			return err
+		}
+		page, err := p.nextPage(ctx, paginator)
+		if err != nil {
+			p.countError(err)
+			return fmt.Errorf("list %s: %w", prefix, err)
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !logkey.IsLogFile(key) {
+				continue
+			}
+			if ts.skipKey(key) {
+				p.stats.FilesExcluded.Add(1)
+				continue
+			}
+
+			p.stats.FilesListed.Add(1)
+			ts.progress.listed.Add(1)
+			if keyTime, ok := logkey.Time(key); ok {
+				p.stats.observe(keyTime)
+			}
+
+			if err := p.control.waitQueue(ctx, ts.downloadJobs); err != nil {
+				return err
+			}
+			u.files.Add(1)
+			u.pending.Add(1)
+			job := DownloadJob{
+				Bucket:       ts.trail.Bucket,
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				ETag:         aws.ToString(obj.ETag),
+				AccountID:    u.AccountID,
+				Region:       u.Region,
+				trail:        ts,
+				unit:         u,
+			}
+			if err := p.enqueue(ctx, job); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Processor) saveUnit(u *backfillUnit) {
+	if err := p.stateDB.UpdateBackfillUnit(u.BackfillUnit); err != nil {
+		p.logger.Error("failed to save backfill unit",
+			slog.String("state_key", targetKey(u.Bucket, u.AccountID, u.Region)),
+			slog.String("error", err.Error()))
+	}
+}
+
+// unitStarts returns the start of every unit overlapping [start, end),
+// aligned to UTC midnight, or to Monday for whole week units
+func unitStarts(start, end time.Time, days int) []time.Time {
+	first := start.UTC().Truncate(24 * time.Hour)
+	if days%7 == 0 {
+		first = first.AddDate(0, 0, -((int(first.Weekday()) + 6) % 7))
+	}
+
+	var starts []time.Time
+	for t := first; t.Before(end); t = t.AddDate(0, 0, days) {
+		starts = append(starts, t)
+	}
+	return starts
+}
+
+func targetKey(bucket, accountID, region string) string {
+	return fmt.Sprintf("%s:%s:%s", bucket, accountID, region)
+}
+
+func unitKey(u state.BackfillUnit) string {
+	return fmt.Sprintf("%s:%d", targetKey(u.Bucket, u.AccountID, u.Region), u.Start.Unix())
+}
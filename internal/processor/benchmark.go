@@ -0,0 +1,379 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+)
+
+type BenchmarkOptions struct {
+	// trail to sample, the first enabled one when empty
+	Trail string
+	// log files downloaded at each concurrency level
+	Sample int
+	// download concurrency levels to try, in order
+	Concurrency []int
+}
+
+// BenchmarkLevel is what downloading the sample achieved at one concurrency
+type BenchmarkLevel struct {
+	Concurrency   int     `json:"concurrency"`
+	Objects       int     `json:"objects"`
+	Errors        int     `json:"errors"`
+	Bytes         int64   `json:"bytes"`
+	Seconds       float64 `json:"seconds"`
+	ObjectsPerSec float64 `json:"objects_per_sec"`
+	MBPerSec      float64 `json:"mb_per_sec"`
+	LatencyP50Ms  float64 `json:"latency_p50_ms"`
+	LatencyP95Ms  float64 `json:"latency_p95_ms"`
+	LatencyP99Ms  float64 `json:"latency_p99_ms"`
+	// first error seen, e.g. S3 throttling
+	FirstError string `json:"first_error,omitempty"`
+}
+
+// BenchmarkSettings are recommended values for the worker and queue settings
+type BenchmarkSettings struct {
+	DownloadWorkers   int `json:"download_workers"`
+	ProcessWorkers    int `json:"process_workers"`
+	DownloadQueueSize int `json:"download_queue_size"`
+	ProcessQueueSize  int `json:"process_queue_size"`
+}
+
+type BenchmarkReport struct {
+	Trail   string `json:"trail"`
+	Bucket  string `json:"bucket"`
+	Objects int    `json:"objects"`
+	CPUs    int    `json:"cpus"`
+	// mean compressed size of a sampled log file
+	AvgObjectBytes int64 `json:"avg_object_bytes"`
+	// time one core takes to decompress and parse one log file
+	DecodeMs        float64           `json:"decode_ms"`
+	EventsPerObject float64           `json:"events_per_object"`
+	Levels          []BenchmarkLevel  `json:"levels"`
+	Recommended     BenchmarkSettings `json:"recommended"`
+	// why the settings were picked
+	Notes []string `json:"notes,omitempty"`
+}
+
+const (
+	// error rate above which a concurrency level isn't recommended
+	benchmarkMaxErrorRate = 0.01
+	// the smallest level within this share of the best throughput is picked
+	benchmarkGoodEnough = 0.9
+	// decoding is only part of processing a file (dedup, filters, writing),
+	// so process workers are sized with this much headroom
+	benchmarkProcessHeadroom = 2
+	// seconds of downloads the download queue holds, to ride out slow
+	// listing pages
+	benchmarkDownloadQueueSeconds = 30
+	// bytes of downloaded files the process queue may hold
+	benchmarkProcessQueueBytes = 512 << 20
+)
+
+// Benchmark downloads a sample of recent log files from one trail at each
+// concurrency level, measures throughput, latency and errors, times decoding
+// them on one core, and recommends worker and queue settings for this host
+// and bucket. Nothing is written and no state changes.
+func (p *Processor) Benchmark(ctx context.Context, opts BenchmarkOptions) (*BenchmarkReport, error) {
+	if opts.Sample <= 0 {
+		opts.Sample = 200
+	}
+	if len(opts.Concurrency) == 0 {
+		opts.Concurrency = []int{4, 8, 16, 32, 64}
+	}
+
+	trail, err := p.benchmarkTrail(ctx, opts.Trail)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := p.benchmarkSample(ctx, trail, opts.Sample)
+	if err != nil {
+		return nil, err
+	}
+	p.logger.Info("sampled log files for benchmark",
+		slog.String("trail", trail.Name),
+		slog.Int("objects", len(keys)))
+
+	report := &BenchmarkReport{
+		Trail:   trail.Name,
+		Bucket:  trail.Bucket,
+		Objects: len(keys),
+		CPUs:    runtime.NumCPU(),
+	}
+	var sample [][]byte
+	for _, concurrency := range opts.Concurrency {
+		if concurrency <= 0 {
+			continue
+		}
+		level, data := p.benchmarkLevel(ctx, trail.Bucket, keys, concurrency)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		report.Levels = append(report.Levels, level)
+		if sample == nil {
+			sample = data
+		}
+		p.logger.Info("benchmark level done",
+			slog.Int("concurrency", concurrency),
+			slog.Float64("objects_per_sec", level.ObjectsPerSec),
+			slog.Float64("mb_per_sec", level.MBPerSec),
+			slog.Int("errors", level.Errors))
+	}
+	if len(report.Levels) == 0 {
+		return nil, fmt.Errorf("no concurrency level above zero to try")
+	}
+
+	report.measureDecode(sample)
+	report.recommend()
+	return report, nil
+}
+
+// benchmarkTrail finds the named trail, or the first enabled one
+func (p *Processor) benchmarkTrail(ctx context.Context, name string) (config.Trail, error) {
+	trails, err := p.resolveTrails(ctx)
+	if err != nil {
+		return config.Trail{}, err
+	}
+	for _, trail := range trails {
+		if name == "" && trail.IsEnabled() || name != "" && trail.Name == name {
+			return trail, nil
+		}
+	}
+	if name == "" {
+		return config.Trail{}, fmt.Errorf("no enabled trail to benchmark")
+	}
+	return config.Trail{}, fmt.Errorf("no trail named %q", name)
+}
+
+// benchmarkSample picks up to n log files spread across the trail's
+// account/regions, from yesterday's folders so their sizes are typical of a
+// full day, or from the start of a folder that has none
+func (p *Processor) benchmarkSample(ctx context.Context, trail config.Trail, n int) ([]string, error) {
+	basePrefix, pairs := p.discoverTrailPairs(ctx, trail)
+	if len(pairs) == 0 {
+		return nil, fmt.Errorf("trail %s: no account/regions found under %s", trail.Name, basePrefix)
+	}
+	perPair := max(1, (n+len(pairs)-1)/len(pairs))
+	day := time.Now().UTC().AddDate(0, 0, -1).Format("2006/01/02/")
+
+	var keys []string
+	for _, pair := range pairs {
+		if len(keys) >= n {
+			break
+		}
+		prefix := pair.prefix(basePrefix)
+		found, err := p.listSample(ctx, trail.Bucket, prefix+day, min(perPair, n-len(keys)))
+		if err == nil && len(found) == 0 {
+			found, err = p.listSample(ctx, trail.Bucket, prefix, min(perPair, n-len(keys)))
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			p.logger.Warn("failed to list objects for benchmark",
+				slog.String("prefix", prefix),
+				slog.String("error", err.Error()))
+			continue
+		}
+		keys = append(keys, found...)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("trail %s: no log files found to benchmark", trail.Name)
+	}
+	return keys, nil
+}
+
+func (p *Processor) listSample(ctx context.Context, bucket, prefix string, n int) ([]string, error) {
+	paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(int32(min(n*2, 1000))),
+	})
+	var keys []string
+	for paginator.HasMorePages() && len(keys) < n {
+		page, err := p.nextPage(ctx, paginator)
+		if err != nil {
+			return nil, fmt.Errorf("list %s: %w", prefix, err)
+		}
+		for _, obj := range page.Contents {
+			if key := aws.ToString(obj.Key); logkey.IsLogFile(key) && len(keys) < n {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// benchmarkLevel downloads every key with this many downloads at once,
+// returning the downloaded contents alongside the measurements
+func (p *Processor) benchmarkLevel(ctx context.Context, bucket string, keys []string, concurrency int) (BenchmarkLevel, [][]byte) {
+	type result struct {
+		data    []byte
+		latency time.Duration
+		err     error
+	}
+	results := make([]result, len(keys))
+	next := make(chan int)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for range min(concurrency, len(keys)) {
+		wg.Go(func() {
+			for i := range next {
+				began := time.Now()
+				data, err := p.downloadObject(ctx, bucket, keys[i])
+				results[i] = result{data: data, latency: time.Since(began), err: err}
+			}
+		})
+	}
+	for i := range keys {
+		select {
+		case next <- i:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(next)
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	level := BenchmarkLevel{Concurrency: concurrency, Seconds: elapsed.Seconds()}
+	var latencies []time.Duration
+	var data [][]byte
+	for _, r := range results {
+		if r.err != nil {
+			level.Errors++
+			if level.FirstError == "" {
+				level.FirstError = r.err.Error()
+			}
+			continue
+		}
+		if r.data == nil {
+			continue
+		}
+		level.Objects++
+		level.Bytes += int64(len(r.data))
+		latencies = append(latencies, r.latency)
+		data = append(data, r.data)
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		level.ObjectsPerSec = round2(float64(level.Objects) / secs)
+		level.MBPerSec = round2(float64(level.Bytes) / secs / (1 << 20))
+	}
+	slices.Sort(latencies)
+	level.LatencyP50Ms = percentileMs(latencies, 0.50)
+	level.LatencyP95Ms = percentileMs(latencies, 0.95)
+	level.LatencyP99Ms = percentileMs(latencies, 0.99)
+	return level, data
+}
+
+// measureDecode times decompressing and parsing the sample on this goroutine
+func (r *BenchmarkReport) measureDecode(sample [][]byte) {
+	if len(sample) == 0 {
+		return
+	}
+	var total, events int64
+	start := time.Now()
+	for _, data := range sample {
+		total += int64(len(data))
+		if records, err := decodeLogFile(data); err == nil {
+			events += int64(len(records))
+		}
+	}
+	elapsed := time.Since(start)
+	r.AvgObjectBytes = total / int64(len(sample))
+	r.DecodeMs = round2(float64(elapsed.Microseconds()) / 1000 / float64(len(sample)))
+	r.EventsPerObject = round2(float64(events) / float64(len(sample)))
+}
+
+// recommend picks the smallest concurrency within benchmarkGoodEnough of the
+// best throughput without too many errors, then sizes the process workers
+// to keep up with it and the queues to buffer it
+func (r *BenchmarkReport) recommend() {
+	var best *BenchmarkLevel
+	for i := range r.Levels {
+		l := &r.Levels[i]
+		if l.errorRate() > benchmarkMaxErrorRate {
+			r.Notes = append(r.Notes, fmt.Sprintf("concurrency %d: %.1f%% of downloads failed (%s)", l.Concurrency, l.errorRate()*100, l.FirstError))
+			continue
+		}
+		if best == nil || l.ObjectsPerSec > best.ObjectsPerSec {
+			best = l
+		}
+	}
+	if best == nil {
+		// every level failed too often, so go with the one that failed least
+		best = &r.Levels[0]
+		for i := range r.Levels {
+			if r.Levels[i].errorRate() < best.errorRate() {
+				best = &r.Levels[i]
+			}
+		}
+		r.Notes = append(r.Notes, "every level had errors; check access to the bucket before tuning")
+	}
+	chosen := best
+	for i := range r.Levels {
+		l := &r.Levels[i]
+		if l.errorRate() <= benchmarkMaxErrorRate && l.ObjectsPerSec >= best.ObjectsPerSec*benchmarkGoodEnough && l.Concurrency < chosen.Concurrency {
+			chosen = l
+		}
+	}
+	if chosen != best {
+		r.Notes = append(r.Notes, fmt.Sprintf("concurrency %d reached %.0f%% of the best throughput (%d downloads at once)",
+			chosen.Concurrency, chosen.ObjectsPerSec/best.ObjectsPerSec*100, best.Concurrency))
+	}
+	if best == &r.Levels[len(r.Levels)-1] && len(r.Levels) > 1 {
+		r.Notes = append(r.Notes, "throughput was still rising at the highest level; try higher --concurrency")
+	}
+
+	rate := chosen.ObjectsPerSec
+	processWorkers := int(math.Ceil(rate * r.DecodeMs / 1000 * benchmarkProcessHeadroom))
+	if processWorkers > r.CPUs*2 {
+		r.Notes = append(r.Notes, fmt.Sprintf("processing is CPU bound: %d cores decode about %.0f files/s against %.0f downloaded",
+			r.CPUs, float64(r.CPUs)*1000/max(r.DecodeMs, 0.001), rate))
+	}
+	processWorkers = min(max(processWorkers, 2), r.CPUs*2)
+
+	processQueue := int(math.Ceil(rate * 10))
+	if r.AvgObjectBytes > 0 {
+		processQueue = min(processQueue, int(benchmarkProcessQueueBytes/r.AvgObjectBytes))
+	}
+	r.Recommended = BenchmarkSettings{
+		DownloadWorkers:   chosen.Concurrency,
+		ProcessWorkers:    processWorkers,
+		DownloadQueueSize: max(int(math.Ceil(rate*benchmarkDownloadQueueSeconds)), chosen.Concurrency*2),
+		ProcessQueueSize:  max(processQueue, processWorkers*2),
+	}
+}
+
+func (l BenchmarkLevel) errorRate() float64 {
+	if total := l.Objects + l.Errors; total > 0 {
+		return float64(l.Errors) / float64(total)
+	}
+	return 0
+}
+
+func percentileMs(sorted []time.Duration, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(math.Ceil(q*float64(len(sorted))))-1, len(sorted)-1)
+	return round2(float64(sorted[max(i, 0)].Microseconds()) / 1000)
+}
+
+func round2(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -0,0 +1,177 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// checkpoints holds each listing's latest checkpoint until it's due, so the
+// state database is written every CheckpointEveryFiles files or
+// CheckpointInterval rather than once per file. A checkpoint only moves
+// past files that have finished, so stopping with files still queued
+// doesn't skip them next run. Stream-only and at-least-once runs commit
+// theirs with the sinks instead.
+type checkpoints struct {
+	// held while saving, so a due checkpoint and a timed save of the same
+	// key can't land out of order
+	mu      sync.Mutex
+	pending map[streamKey]*pendingCheckpoint
+}
+
+type pendingCheckpoint struct {
+	// keys listed that the checkpoint hasn't moved past yet, in order
+	queue []*listedCheckpoint
+	// the latest key whose file and every one listed before it have
+	// finished, empty once saved
+	value string
+	// files finished since the key's last save
+	files int
+}
+
+// listedCheckpoint is a listed key the checkpoint moves to once its file,
+// and every one listed before it, has finished
+type listedCheckpoint struct {
+	key   streamKey
+	value string
+	done  bool
+	// saved as soon as it's reached, as when its listing ended
+	final bool
+}
+
+// noteCheckpoint queues key as the account/region's checkpoint once the
+// file listed for it finishes, returning what the file finishes
+func (p *Processor) noteCheckpoint(bucket, accountID, region, key string) *listedCheckpoint {
+	c := &p.checkpoints
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lc := &listedCheckpoint{key: streamKey{bucket, accountID, region}, value: key}
+	pc := c.pendingLocked(lc.key)
+	pc.queue = append(pc.queue, lc)
+	return lc
+}
+
+// finishListed records that the file listed for lc has left the pipeline,
+// saving the checkpoint once CheckpointEveryFiles files have finished since
+// the last save. A file that failed because the run was stopping holds the
+// checkpoint before it for the rest of the run; other failures are recorded
+// with the file and passed.
+func (p *Processor) finishListed(lc *listedCheckpoint, err error) {
+	if lc == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	c := &p.checkpoints
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lc.done = true
+	p.advanceCheckpointLocked(lc.key)
+}
+
+// endCheckpoint saves the account/region's checkpoint as key once every
+// file listed before it has finished, as when its listing ends
+func (p *Processor) endCheckpoint(bucket, accountID, region, key string) {
+	c := &p.checkpoints
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	k := streamKey{bucket, accountID, region}
+	pc := c.pendingLocked(k)
+	pc.queue = append(pc.queue, &listedCheckpoint{key: k, value: key, done: true, final: true})
+	p.advanceCheckpointLocked(k)
+}
+
+// dropCheckpoint forgets the account/region's unsaved checkpoint, leaving
+// the saved one where it is
+func (p *Processor) dropCheckpoint(bucket, accountID, region string) {
+	c := &p.checkpoints
+	c.mu.Lock()
+	delete(c.pending, streamKey{bucket, accountID, region})
+	c.mu.Unlock()
+}
+
+// saveCheckpoints saves every pending checkpoint, on CheckpointInterval
+// and when the run stops
+func (p *Processor) saveCheckpoints() {
+	c := &p.checkpoints
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for k, pc := range c.pending {
+		if pc.value != "" {
+			p.writeCheckpoint(k, pc.value)
+			pc.value, pc.files = "", 0
+		}
+		if len(pc.queue) == 0 {
+			delete(c.pending, k)
+		}
+	}
+}
+
+func (c *checkpoints) pendingLocked(k streamKey) *pendingCheckpoint {
+	if c.pending == nil {
+		c.pending = make(map[streamKey]*pendingCheckpoint)
+	}
+	pc := c.pending[k]
+	if pc == nil {
+		pc = &pendingCheckpoint{}
+		c.pending[k] = pc
+	}
+	return pc
+}
+
+// advanceCheckpointLocked moves the key's checkpoint past its finished
+// files, saving it when due
+func (p *Processor) advanceCheckpointLocked(k streamKey) {
+	c := &p.checkpoints
+	pc := c.pending[k]
+	if pc == nil {
+		// dropped while its files were in the pipeline
+		return
+	}
+	n := 0
+	for ; n < len(pc.queue) && pc.queue[n].done; n++ {
+		lc := pc.queue[n]
+		pc.value = lc.value
+		if lc.final {
+			p.writeCheckpoint(k, pc.value)
+			pc.value, pc.files = "", 0
+			continue
+		}
+		pc.files++
+		if every := p.config.CheckpointEveryFiles; every > 0 && pc.files >= every {
+			p.writeCheckpoint(k, pc.value)
+			pc.value, pc.files = "", 0
+		}
+	}
+	pc.queue = pc.queue[n:]
+	if len(pc.queue) == 0 && pc.value == "" {
+		delete(c.pending, k)
+	}
+}
+
+func (p *Processor) writeCheckpoint(k streamKey, value string) {
+	if err := p.stateDB.UpdateLastProcessedKey(k.bucket, k.accountID, k.region, value); err != nil {
+		p.logger.Error("failed to update state",
+			slog.String("state_key", k.bucket+":"+k.accountID+":"+k.region),
+			slog.String("error", err.Error()))
+	}
+}
+
+// checkpointSaver saves pending checkpoints every interval
+func (p *Processor) checkpointSaver(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.saveCheckpoints()
+		}
+	}
+}
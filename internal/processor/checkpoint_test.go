@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"testing"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+func TestCheckpointsSkipUnfinishedFiles(t *testing.T) {
+	logger := slog.New(slog.DiscardHandler)
+	db, err := state.Open(filepath.Join(t.TempDir(), "state.db"), logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	p := New(nil, nil, nil, db, mapDedup{}, Config{CheckpointEveryFiles: 2}, logger)
+
+	const bucket, accountID, region = "bucket", "123456789012", "us-east-1"
+	prefix := "AWSLogs/123456789012/CloudTrail/us-east-1/2024/01/15/123456789012_CloudTrail_us-east-1_20240115T"
+	var jobs []DownloadJob
+	for i := range 6 {
+		key := fmt.Sprintf("%s%02d00Z_abc.json.gz", prefix, i)
+		jobs = append(jobs, DownloadJob{
+			Bucket: bucket, Key: key, AccountID: accountID, Region: region,
+			listed: p.noteCheckpoint(bucket, accountID, region, key),
+		})
+	}
+	saved := func() string {
+		t.Helper()
+		key, err := db.GetLastProcessedKey(bucket, accountID, region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+
+	// finished out of order, the checkpoint waits for the first
+	p.finishFile(jobs[1], 0, nil)
+	p.saveCheckpoints()
+	if got := saved(); got != "" {
+		t.Fatalf("saved %q before the first file finished", got)
+	}
+	// the first two make CheckpointEveryFiles
+	p.finishFile(jobs[0], 0, nil)
+	if got := saved(); got != jobs[1].Key {
+		t.Fatalf("saved %q, want %q", got, jobs[1].Key)
+	}
+	// a file that failed on its own is passed
+	p.finishFile(jobs[2], 0, fmt.Errorf("decode: %w", fmt.Errorf("unexpected EOF")))
+
+	// the run is cancelled: a queued file fails for it, the ones after it
+	// finish or are still queued, and the shutdown save runs
+	p.finishFile(jobs[3], 0, fmt.Errorf("download: %w", context.Canceled))
+	p.finishFile(jobs[4], 0, nil)
+	p.endCheckpoint(bucket, accountID, region, jobs[5].Key)
+	p.saveCheckpoints()
+	if got := saved(); got != jobs[2].Key {
+		t.Errorf("saved %q after cancelling, want %q, before the unprocessed %q", got, jobs[2].Key, jobs[3].Key)
+	}
+}
@@ -0,0 +1,240 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/digest"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+type CompletenessOptions struct {
+	// digests covering any part of [Start, End) are checked
+	Start time.Time
+	End   time.Time
+}
+
+// How a log file named in a digest was handled
+const (
+	// recorded as processed
+	FileProcessed = "processed"
+	// recorded as lost to a download, decode or write error
+	FileFailed = "failed"
+	// at or before the checkpoint but never recorded, so listing skipped it
+	FileMissed = "missed"
+	// at or before the checkpoint, but record_objects is off so it can't be
+	// confirmed
+	FileUnconfirmed = "unconfirmed"
+	// after the checkpoint, not reached yet
+	FilePending = "pending"
+)
+
+// CompletenessFile is a log file named in a digest and how it was handled
+type CompletenessFile struct {
+	Trail     string `json:"trail"`
+	Bucket    string `json:"bucket"`
+	Key       string `json:"key"`
+	AccountID string `json:"account_id"`
+	Region    string `json:"region"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+type CompletenessReport struct {
+	Digests      int            `json:"digests"`
+	DigestErrors int            `json:"digest_errors"`
+	LogFiles     int            `json:"log_files"`
+	ByStatus     map[string]int `json:"by_status"`
+	// failed and missed files, the ones worth fetching
+	Missing []CompletenessFile `json:"missing"`
+
+	mu sync.Mutex
+}
+
+func (r *CompletenessReport) add(f CompletenessFile) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.LogFiles++
+	r.ByStatus[f.Status]++
+	if f.Status == FileFailed || f.Status == FileMissed {
+		r.Missing = append(r.Missing, f)
+	}
+}
+
+// CheckCompleteness reads the digest files covering the time range and checks
+// each log file they name against the object records and checkpoints in the
+// state database. Digests are CloudTrail's own inventory of what it
+// delivered, so this catches files the S3 listing skipped as well as files
+// lost to errors.
+func (p *Processor) CheckCompleteness(ctx context.Context, opts CompletenessOptions) (*CompletenessReport, error) {
+	trails, err := p.resolveTrails(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &CompletenessReport{ByStatus: make(map[string]int)}
+	sem := make(chan struct{}, max(p.config.DownloadWorkers, 1))
+	var wg sync.WaitGroup
+	for _, trail := range trails {
+		basePrefix, pairs := p.discoverTrailPairs(ctx, trail)
+		for _, pair := range pairs {
+			// digests only cover the CloudTrail folder's log files
+			if pair.Folder != logkey.DefaultFolder {
+				continue
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				wg.Wait()
+				return report, ctx.Err()
+			}
+			wg.Add(1)
+			go func(pair AccountRegionPair) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				p.checkPairDigests(ctx, trail, digestPrefix(basePrefix, pair.OrgPath, pair.AccountID, pair.Region), pair, opts, report)
+			}(pair)
+		}
+	}
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+func (p *Processor) checkPairDigests(ctx context.Context, trail config.Trail, prefix string, pair AccountRegionPair, opts CompletenessOptions, report *CompletenessReport) {
+	logger := p.logger.With(
+		slog.String("trail", trail.Name),
+		slog.String("account", pair.AccountID),
+		slog.String("region", pair.Region))
+
+	// digests are filed under the day their period ends, so look one day past
+	// the range for the last hour's
+	for day := opts.Start.UTC().Truncate(24 * time.Hour); day.Before(opts.End.Add(24 * time.Hour)); day = day.AddDate(0, 0, 1) {
+		paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(trail.Bucket),
+			Prefix: aws.String(prefix + day.Format("2006/01/02/")),
+		})
+		for paginator.HasMorePages() {
+			page, err := p.nextPage(ctx, paginator)
+			if err != nil {
+				logger.Error("failed to list digests", slog.String("error", err.Error()))
+				report.mu.Lock()
+				report.DigestErrors++
+				report.mu.Unlock()
+				break
+			}
+
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				if !strings.HasSuffix(key, ".json.gz") {
+					continue
+				}
+				d, err := p.readDigest(ctx, trail.Bucket, key)
+				if err != nil {
+					logger.Error("failed to read digest", slog.String("key", key), slog.String("error", err.Error()))
+					report.mu.Lock()
+					report.DigestErrors++
+					report.mu.Unlock()
+					continue
+				}
+				if !d.DigestEndTime.After(opts.Start) || !d.DigestStartTime.Before(opts.End) {
+					continue
+				}
+
+				report.mu.Lock()
+				report.Digests++
+				report.mu.Unlock()
+				for _, lf := range d.LogFiles {
+					report.add(p.classifyLogFile(trail, pair, lf))
+				}
+			}
+		}
+	}
+}
+
+func (p *Processor) readDigest(ctx context.Context, bucket, key string) (*digest.File, error) {
+	data, err := p.downloadObject(ctx, bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return digest.Decode(data)
+}
+
+func (p *Processor) classifyLogFile(trail config.Trail, pair AccountRegionPair, lf digest.LogFile) CompletenessFile {
+	f := CompletenessFile{
+		Trail:     trail.Name,
+		Bucket:    lf.S3Bucket,
+		Key:       lf.S3Object,
+		AccountID: pair.AccountID,
+		Region:    pair.Region,
+	}
+
+	rec, err := p.stateDB.GetObject(lf.S3Bucket, lf.S3Object)
+	if err != nil {
+		p.logger.Error("failed to look up object", slog.String("key", lf.S3Object), slog.String("error", err.Error()))
+	}
+	if rec != nil {
+		f.Status = FileProcessed
+		if rec.Status == state.ObjectFailed {
+			f.Status, f.Error = FileFailed, rec.Error
+		}
+		return f
+	}
+
+	checkpoint, err := p.stateDB.GetLastProcessedKey(lf.S3Bucket, pair.AccountID, pair.Region)
+	if err != nil {
+		p.logger.Error("failed to get last processed key", slog.String("error", err.Error()))
+	}
+	switch {
+	case checkpoint == "" || lf.S3Object > checkpoint:
+		f.Status = FilePending
+	case p.config.RecordObjects:
+		f.Status = FileMissed
+	default:
+		f.Status = FileUnconfirmed
+	}
+	return f
+}
+
+// FetchObjects runs the given files through the pipeline, recording each
+// outcome so a later check sees them as processed
+func (p *Processor) FetchObjects(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration, files []CompletenessFile) error {
+	p.config.RecordObjects = true
+
+	return p.run(ctx, "check-completeness --fetch", progressInterval, flushInterval, bloomSaveInterval, p.resolveSettings,
+		func(ctx context.Context, settings []*trailSettings) error {
+			byTrail := make(map[string]*trailSettings, len(settings))
+			for _, ts := range settings {
+				if ts.logGroup == nil {
+					byTrail[ts.trail.Name] = ts
+				}
+			}
+
+			for _, f := range files {
+				ts, ok := byTrail[f.Trail]
+				if !ok {
+					continue
+				}
+				p.stats.FilesListed.Add(1)
+				ts.progress.listed.Add(1)
+				if err := p.enqueue(ctx, DownloadJob{
+					Bucket:    f.Bucket,
+					Key:       f.Key,
+					AccountID: f.AccountID,
+					Region:    f.Region,
+					trail:     ts,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
@@ -0,0 +1,315 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// control holds the knobs that can be turned while a run is going: pausing
+// listing and downloads, capping how many of the started workers are busy,
+// and spacing out download starts
+type control struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	paused bool
+	// set by the disk monitor while an output volume is low on space, apart
+	// from paused so Resume can't override it
+	diskLow  bool
+	diskFree uint64
+	// workers started and how many of them may be busy; a zero limit means
+	// all of them
+	downloadPool, downloadLimit, downloadActive int
+	processPool, processLimit, processActive    int
+	// downloads started per second, zero for no limit
+	rate float64
+	// earliest time the next download may start under rate
+	next time.Time
+
+	// set by the memory monitor: the fraction of workers and queue depth to
+	// use, and the compressed bytes that may be in the pipeline at once
+	// (zero for no limit)
+	memScale   float64
+	byteBudget int64
+	inflight   int64
+
+	// bytes of written files waiting to upload in spill mode, and how many
+	// may wait before downloads pause (zero for no limit)
+	spillPending, spillQuota int64
+}
+
+// ControlStatus is the current state of the runtime controls
+type ControlStatus struct {
+	Paused             bool    `json:"paused"`
+	DiskLow            bool    `json:"disk_low"`
+	DiskFreeBytes      uint64  `json:"disk_free_bytes,omitempty"`
+	DownloadWorkers    int     `json:"download_workers"`
+	MaxDownloadWorkers int     `json:"max_download_workers"`
+	ActiveDownloads    int     `json:"active_downloads"`
+	ProcessWorkers     int     `json:"process_workers"`
+	MaxProcessWorkers  int     `json:"max_process_workers"`
+	ActiveProcessing   int     `json:"active_processing"`
+	DownloadsPerSecond float64 `json:"downloads_per_second"`
+	MemoryScale        float64 `json:"memory_scale"`
+	InflightBytes      int64   `json:"inflight_bytes"`
+	InflightBudget     int64   `json:"inflight_budget,omitempty"`
+	SpillPendingBytes  int64   `json:"spill_pending_bytes,omitempty"`
+}
+
+func (c *control) init(rate float64, spillQuota int64) {
+	c.cond = sync.NewCond(&c.mu)
+	c.rate = rate
+	c.memScale = 1
+	c.spillQuota = spillQuota
+}
+
+// spillOver reports whether more bytes are waiting to upload than the quota
+// allows
+func (c *control) spillOver() bool {
+	return c.spillQuota > 0 && c.spillPending > c.spillQuota
+}
+
+// setPools records how many workers were started, which bounds the limits
+func (c *control) setPools(download, process int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.downloadPool, c.processPool = download, process
+}
+
+// wait blocks while paused, returning early when ctx is done
+func (c *control) wait(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.waitLocked(ctx, func() bool { return !c.paused })
+}
+
+// waitLocked waits on the condition until ready reports true or ctx is done
+func (c *control) waitLocked(ctx context.Context, ready func() bool) error {
+	if ready() {
+		return nil
+	}
+	stop := context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		c.cond.Broadcast()
+		c.mu.Unlock()
+	})
+	defer stop()
+	for !ready() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.cond.Wait()
+	}
+	return nil
+}
+
+// acquireDownload waits until downloads are running, a worker slot is free,
+// the object's size fits in the byte budget and the rate limit allows
+// another start. The size is held until releaseBytes.
+func (c *control) acquireDownload(ctx context.Context, size int64) error {
+	c.mu.Lock()
+	err := c.waitLocked(ctx, func() bool {
+		return !c.paused && !c.diskLow && c.downloadActive < c.downloadSlots() &&
+			(c.byteBudget == 0 || c.inflight == 0 || c.inflight+size <= c.byteBudget)
+	})
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	c.downloadActive++
+	c.inflight += size
+
+	var delay time.Duration
+	if c.rate > 0 {
+		now := time.Now()
+		if c.next.Before(now) {
+			c.next = now
+		}
+		delay = c.next.Sub(now)
+		c.next = c.next.Add(time.Duration(float64(time.Second) / c.rate))
+	}
+	c.mu.Unlock()
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			c.releaseDownload()
+			c.releaseBytes(size)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (c *control) releaseDownload() {
+	c.mu.Lock()
+	c.downloadActive--
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// releaseBytes returns a file's size to the byte budget once it has left
+// the pipeline
+func (c *control) releaseBytes(size int64) {
+	if size == 0 {
+		return
+	}
+	c.mu.Lock()
+	c.inflight -= size
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// downloadSlots is how many downloads may run at once: the admin limit, or
+// every worker, scaled down under memory pressure
+func (c *control) downloadSlots() int {
+	slots := c.downloadLimit
+	if slots == 0 {
+		slots = c.downloadPool
+	}
+	if c.memScale < 1 {
+		slots = max(1, min(slots, int(float64(c.downloadPool)*c.memScale)))
+	}
+	if slots == 0 {
+		// workers not started through run, e.g. an import's own pool
+		return int(^uint(0) >> 1)
+	}
+	return slots
+}
+
+// waitQueue blocks a lister while a download queue holds more jobs than
+// memory pressure allows
+func (c *control) waitQueue(ctx context.Context, queue chan DownloadJob) error {
+	for {
+		c.mu.Lock()
+		scale := c.memScale
+		c.mu.Unlock()
+		if scale >= 1 || len(queue) < max(1, int(float64(cap(queue))*scale)) {
+			return nil
+		}
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// setMemory applies the memory monitor's latest scale and byte budget
+func (c *control) setMemory(scale float64, budget int64) {
+	c.mu.Lock()
+	c.memScale, c.byteBudget = scale, budget
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// acquireProcess waits for a free process worker slot. Processing is never
+// paused, so the pipeline can always drain.
+func (c *control) acquireProcess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.processLimit > 0 && c.processActive >= c.processLimit {
+		c.cond.Wait()
+	}
+	c.processActive++
+}
+
+func (c *control) releaseProcess() {
+	c.mu.Lock()
+	c.processActive--
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// Pause stops listing and new downloads until Resume; files already
+// downloaded are still processed and written
+func (p *Processor) Pause() {
+	p.control.mu.Lock()
+	defer p.control.mu.Unlock()
+	p.control.paused = true
+}
+
+// Resume restarts listing and downloads after Pause
+func (p *Processor) Resume() {
+	p.control.mu.Lock()
+	defer p.control.mu.Unlock()
+	p.control.paused = false
+	p.control.cond.Broadcast()
+}
+
+// SetWorkers limits how many of the started download and process workers
+// may be busy at once. Zero leaves a limit unchanged; raising it past the
+// number of workers started is an error.
+func (p *Processor) SetWorkers(download, process int) error {
+	c := &p.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if download < 0 || process < 0 {
+		return fmt.Errorf("worker counts must not be negative")
+	}
+	if download > c.downloadPool {
+		return fmt.Errorf("download workers %d exceeds the %d started", download, c.downloadPool)
+	}
+	if process > c.processPool {
+		return fmt.Errorf("process workers %d exceeds the %d started", process, c.processPool)
+	}
+	if download > 0 {
+		c.downloadLimit = download
+	}
+	if process > 0 {
+		c.processLimit = process
+	}
+	c.cond.Broadcast()
+	return nil
+}
+
+// SetDownloadRate limits how many downloads start per second, zero for no
+// limit
+func (p *Processor) SetDownloadRate(perSecond float64) error {
+	if perSecond < 0 {
+		return fmt.Errorf("download rate must not be negative")
+	}
+	c := &p.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rate = perSecond
+	c.next = time.Time{}
+	return nil
+}
+
+// Control returns the current state of the runtime controls
+func (p *Processor) Control() ControlStatus {
+	c := &p.control
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := ControlStatus{
+		Paused:             c.paused,
+		DiskLow:            c.diskLow,
+		DiskFreeBytes:      c.diskFree,
+		DownloadWorkers:    c.downloadLimit,
+		MaxDownloadWorkers: c.downloadPool,
+		ActiveDownloads:    c.downloadActive,
+		ProcessWorkers:     c.processLimit,
+		MaxProcessWorkers:  c.processPool,
+		ActiveProcessing:   c.processActive,
+		DownloadsPerSecond: c.rate,
+		MemoryScale:        c.memScale,
+		InflightBytes:      c.inflight,
+		InflightBudget:     c.byteBudget,
+		SpillPendingBytes:  c.spillPending,
+	}
+	if status.DownloadWorkers == 0 {
+		status.DownloadWorkers = c.downloadPool
+	}
+	if status.ProcessWorkers == 0 {
+		status.ProcessWorkers = c.processPool
+	}
+	return status
+}
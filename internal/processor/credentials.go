@@ -0,0 +1,119 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// AWS error codes for requests signed with credentials that have expired
+var expiredCodes = map[string]bool{
+	"ExpiredToken": true, "ExpiredTokenException": true,
+}
+
+// a burst of requests failing on the same expired credentials refreshes
+// them once
+const credentialsRefreshGap = 30 * time.Second
+
+// credentials tracks refreshes of the AWS credentials the clients share
+type credentials struct {
+	mu        sync.Mutex
+	refreshed time.Time
+	expires   time.Time
+}
+
+func isExpired(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && expiredCodes[apiErr.ErrorCode()]
+}
+
+// credentialsCache returns the cache the clients get their credentials
+// from, nil when there is none to refresh
+func (p *Processor) credentialsCache() *aws.CredentialsCache {
+	cache, _ := p.s3Client.Options().Credentials.(*aws.CredentialsCache)
+	return cache
+}
+
+// refreshCredentials drops the cached credentials after err showed they
+// expired, so the next request fetches new ones. It reports whether
+// retrying the request is worthwhile.
+func (p *Processor) refreshCredentials(err error) bool {
+	cache := p.credentialsCache()
+	if cache == nil {
+		return false
+	}
+
+	c := &p.credentials
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if time.Since(c.refreshed) < credentialsRefreshGap {
+		return true
+	}
+	c.refreshed = time.Now()
+	cache.Invalidate()
+	p.logger.Warn("AWS credentials expired during the run, refreshing", slog.String("error", err.Error()))
+	return true
+}
+
+// nextPage fetches a listing page, retrying once with fresh credentials if
+// the current ones expired
+func (p *Processor) nextPage(ctx context.Context, paginator *s3.ListObjectsV2Paginator) (*s3.ListObjectsV2Output, error) {
+	page, err := paginator.NextPage(ctx, p.listOptions()...)
+	if err != nil && isExpired(err) && p.refreshCredentials(err) {
+		page, err = paginator.NextPage(ctx, p.listOptions()...)
+	}
+	return page, err
+}
+
+// credentialsRefresher fetches the credentials every interval, so the cache
+// renews them ahead of expiry even while the run is idle or paused, and
+// a refresh that fails (e.g. an SSO session that ended) is logged before
+// requests start failing
+func (p *Processor) credentialsRefresher(ctx context.Context, interval time.Duration) {
+	cache := p.credentialsCache()
+	if cache == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		creds, err := cache.Retrieve(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			class := p.countError(err)
+			p.logger.Error("failed to refresh AWS credentials",
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+			continue
+		}
+		if !creds.CanExpire {
+			continue
+		}
+
+		c := &p.credentials
+		c.mu.Lock()
+		renewed := !c.expires.IsZero() && !creds.Expires.Equal(c.expires)
+		c.expires = creds.Expires
+		c.mu.Unlock()
+		if renewed {
+			p.logger.Info("refreshed AWS credentials",
+				slog.String("source", creds.Source),
+				slog.Time("expires", creds.Expires))
+		}
+	}
+}
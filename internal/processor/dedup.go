@@ -0,0 +1,72 @@
+package processor
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"time"
+)
+
+// DedupStore remembers the event IDs written: the local bloom filter, or a
+// client of a dedup service shared with other collectors
+type DedupStore interface {
+	// Test reports whether the event ID may have been added
+	Test(id []byte, eventTime time.Time) bool
+	Add(id []byte, eventTime time.Time)
+	// Save persists what was added
+	Save() error
+}
+
+// dedupPrefetcher is a DedupStore that looks up many event IDs in one go,
+// so testing a file's events doesn't take a round trip each
+type dedupPrefetcher interface {
+	Prefetch(ids [][]byte, times []time.Time)
+}
+
+// prefetchDedup hands the event IDs of the file at fileKey to the store
+// ahead of testing them one by one, when the store benefits from that, with
+// their IDs under source when bySource is set
+func (p *Processor) prefetchDedup(fileKey string, records []json.RawMessage, source string, bySource bool) {
+	pf, ok := p.dedup.(dedupPrefetcher)
+	if !ok || len(records) == 0 {
+		return
+	}
+	ids := make([][]byte, 0, len(records))
+	times := make([]time.Time, 0, len(records))
+	for _, raw := range records {
+		var key struct {
+			EventID   string `json:"eventID"`
+			EventTime string `json:"eventTime"`
+		}
+		if json.Unmarshal(raw, &key) != nil || key.EventID == "" {
+			continue
+		}
+		t, _, ok := eventTimeOf(fileKey, key.EventTime)
+		if !ok {
+			continue
+		}
+		ids = append(ids, []byte(key.EventID))
+		times = append(times, t)
+		if bySource {
+			ids = append(ids, sourceDedupID(source, key.EventID))
+			times = append(times, t)
+		}
+	}
+	pf.Prefetch(ids, times)
+}
+
+// sourceDedupID is the ID an event is also added under when it's written to
+// a source-named file, so reprocessing the same object can tell its own
+// events from those another object added first
+func sourceDedupID(source, eventID string) []byte {
+	return []byte(source + "\x00" + eventID)
+}
+
+// closeDedup releases a store that holds a connection
+func (p *Processor) closeDedup() {
+	if c, ok := p.dedup.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			p.logger.Error("failed to close dedup store", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"errors"
+	"log/slog"
+	"sort"
+	"sync"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// deniedAfter is how many downloads in a row an account/region may fail
+// with AccessDenied before the rest of its objects are skipped for the run
+const deniedAfter = 5
+
+// errPrefixDenied fails the objects of a prefix skipped for AccessDenied
+var errPrefixDenied = classified(ErrorAccessDenied, errors.New("skipped: prefix keeps returning AccessDenied"))
+
+// deniedPairs tracks AccessDenied failures per account/region, so a prefix
+// the role can't read costs a handful of errors per run instead of one per
+// object
+type deniedPairs struct {
+	mu    sync.Mutex
+	pairs map[sourceKey]*pairDenial
+}
+
+type pairDenial struct {
+	// downloads denied since the last one that worked
+	streak int
+	// denied on the last run, so the first success clears the record
+	recorded bool
+	// set once the prefix is skipped for the rest of the run
+	skipped bool
+	err     string
+	files   int64
+}
+
+func (d *deniedPairs) get(key sourceKey) *pairDenial {
+	if d.pairs == nil {
+		d.pairs = make(map[sourceKey]*pairDenial)
+	}
+	pd, ok := d.pairs[key]
+	if !ok {
+		pd = &pairDenial{}
+		d.pairs[key] = pd
+	}
+	return pd
+}
+
+func jobSource(job DownloadJob) sourceKey {
+	return sourceKey{bucket: job.Bucket, accountID: job.AccountID, region: job.Region}
+}
+
+// retestDenied notes a prefix that was skipped on an earlier run; listing
+// it again is the re-test
+func (p *Processor) retestDenied(bucket, accountID, region string) {
+	rec, err := p.stateDB.GetDenied(bucket, accountID, region)
+	if err != nil {
+		p.logger.Error("failed to read denied prefix", slog.String("error", err.Error()))
+		return
+	}
+	if rec == nil {
+		return
+	}
+	p.logger.Info("re-testing prefix denied on earlier runs",
+		slog.String("state_key", bucket+":"+accountID+":"+region),
+		slog.Int64("runs", rec.Runs),
+		slog.String("error", rec.Error))
+
+	p.denied.mu.Lock()
+	p.denied.get(sourceKey{bucket, accountID, region}).recorded = true
+	p.denied.mu.Unlock()
+}
+
+// skipping reports whether the prefix is skipped for the rest of the run
+func (p *Processor) skipping(bucket, accountID, region string) bool {
+	p.denied.mu.Lock()
+	defer p.denied.mu.Unlock()
+	pd, ok := p.denied.pairs[sourceKey{bucket, accountID, region}]
+	return ok && pd.skipped
+}
+
+// jobDenied reports whether the job's prefix is skipped, counting the
+// object against it when it is
+func (p *Processor) jobDenied(job DownloadJob) bool {
+	if job.Bucket == "" {
+		return false
+	}
+	p.denied.mu.Lock()
+	defer p.denied.mu.Unlock()
+	pd, ok := p.denied.pairs[jobSource(job)]
+	if !ok || !pd.skipped {
+		return false
+	}
+	pd.files++
+	return true
+}
+
+// noteAccess records how reading one of the prefix's objects or listing
+// pages went, skipping the prefix after deniedAfter AccessDenied failures in
+// a row, or at once when listing itself is denied
+func (p *Processor) noteAccess(bucket, accountID, region string, err error, listing bool) {
+	if bucket == "" {
+		return
+	}
+	key := sourceKey{bucket, accountID, region}
+	// expired credentials say nothing about the prefix
+	denied := err != nil && Classify(err) == ErrorAccessDenied && !isExpired(err)
+	if err != nil && !denied {
+		return
+	}
+
+	p.denied.mu.Lock()
+	pd := p.denied.get(key)
+	if !denied {
+		pd.streak = 0
+		restored := pd.recorded
+		pd.recorded = false
+		p.denied.mu.Unlock()
+		if restored {
+			if err := p.stateDB.ClearDenied(bucket, accountID, region); err != nil {
+				p.logger.Error("failed to clear denied prefix", slog.String("error", err.Error()))
+			}
+			p.logger.Info("access restored to prefix denied on earlier runs",
+				slog.String("state_key", bucket+":"+accountID+":"+region))
+		}
+		return
+	}
+	pd.streak++
+	skip := !pd.skipped && (listing || pd.streak >= deniedAfter)
+	if skip {
+		pd.skipped, pd.err = true, err.Error()
+	}
+	p.denied.mu.Unlock()
+	if !skip {
+		return
+	}
+
+	p.logger.Warn("prefix keeps returning AccessDenied, skipping the rest of it this run",
+		slog.String("state_key", bucket+":"+accountID+":"+region),
+		slog.String("error", err.Error()))
+	if err := p.stateDB.RecordDenied(bucket, accountID, region, err.Error()); err != nil {
+		p.logger.Error("failed to record denied prefix", slog.String("error", err.Error()))
+	}
+}
+
+// DeniedPrefixes returns the prefixes this run skipped for AccessDenied
+func (p *Processor) DeniedPrefixes() []state.DeniedPrefix {
+	p.denied.mu.Lock()
+	defer p.denied.mu.Unlock()
+
+	var prefixes []state.DeniedPrefix
+	for key, pd := range p.denied.pairs {
+		if pd.skipped {
+			prefixes = append(prefixes, state.DeniedPrefix{
+				Bucket:    key.bucket,
+				AccountID: key.accountID,
+				Region:    key.region,
+				Error:     pd.err,
+			})
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		a, b := prefixes[i], prefixes[j]
+		if a.Bucket != b.Bucket {
+			return a.Bucket < b.Bucket
+		}
+		if a.AccountID != b.AccountID {
+			return a.AccountID < b.AccountID
+		}
+		return a.Region < b.Region
+	})
+	return prefixes
+}
+
+// reportDenied logs the prefixes skipped this run, with the objects each
+// skip covered
+func (p *Processor) reportDenied() {
+	p.denied.mu.Lock()
+	defer p.denied.mu.Unlock()
+	for key, pd := range p.denied.pairs {
+		if !pd.skipped {
+			continue
+		}
+		p.logger.Warn("prefix skipped for AccessDenied, it will be re-tested next run",
+			slog.String("state_key", key.bucket+":"+key.accountID+":"+key.region),
+			slog.Int64("objects_skipped", pd.files),
+			slog.String("error", pd.err))
+	}
+}
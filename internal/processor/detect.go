@@ -0,0 +1,21 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// detect runs the configured rules over a newly written event
+func (p *Processor) detect(ev map[string]any, rawEvent json.RawMessage, eventTime time.Time) {
+	for _, f := range p.config.Detector.Evaluate(ev, rawEvent, eventTime) {
+		p.stats.Findings.Add(1)
+		if err := p.config.Findings.Write(context.Background(), f); err != nil {
+			p.logger.Error("failed to write finding",
+				slog.String("rule", f.RuleID),
+				slog.String("event_id", f.EventID),
+				slog.String("error", err.Error()))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package processor
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"log/slog"
@@ -9,136 +10,263 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
 )
 
+// how many folders below the organization ID discoverAccounts descends
+// looking for account IDs unless the trail sets discovery_depth; Control
+// Tower nests accounts under OU paths
+const defaultOrgDepth = 6
+
+// accountDir is an account folder and the organization path above it
+type accountDir struct {
+	id      string
+	orgPath string
+}
+
 // find all AWS accounts in the S3 bucket structure (no need for organization discovery)
-func (p *Processor) discoverAccounts(ctx context.Context, bucket, basePrefix string) ([]string, string) {
+func (p *Processor) discoverAccounts(ctx context.Context, bucket, basePrefix string, maxDepth int) ([]accountDir, string) {
 	var orgID string
-	accountMap := make(map[string]bool)
-
-	input := &s3.ListObjectsV2Input{
-		Bucket:    aws.String(bucket),
-		Prefix:    aws.String(basePrefix),
-		Delimiter: aws.String("/"),
-		MaxKeys:   aws.Int32(100),
-	}
+	var accounts []accountDir
 
-	resp, err := p.s3Client.ListObjectsV2(ctx, input)
+	children, err := p.listFolders(ctx, bucket, basePrefix)
 	if err != nil {
+		p.discoveryErrors.Add(1)
 		p.logger.Error("failed to discover accounts", slog.String("error", err.Error()))
 		return nil, ""
 	}
 
-	for _, prefix := range resp.CommonPrefixes {
-		parts := strings.Split(aws.ToString(prefix.Prefix), "/")
-		if len(parts) >= 2 {
-			id := parts[len(parts)-2]
+	for _, id := range children {
+		switch {
+		// Check if this is an AWS Organization
+		case strings.HasPrefix(id, "o-"):
+			orgID = id
+			accounts = append(accounts, p.discoverOrgAccounts(ctx, bucket, basePrefix, id, 1, maxDepth)...)
+		case isAccountID(id):
+			accounts = append(accounts, accountDir{id: id})
+		}
+	}
 
-			// Check if this is an AWS Organization
-			if strings.HasPrefix(id, "o-") {
-				orgID = id
-				orgPrefix := basePrefix + id + "/"
-				orgInput := &s3.ListObjectsV2Input{
-					Bucket:    aws.String(bucket),
-					Prefix:    aws.String(orgPrefix),
-					Delimiter: aws.String("/"),
-					MaxKeys:   aws.Int32(1000),
-				}
+	// an account delivered under two layouts is processed once, as before
+	seen := make(map[string]bool, len(accounts))
+	unique := accounts[:0]
+	for _, acct := range accounts {
+		if !seen[acct.id] {
+			seen[acct.id] = true
+			unique = append(unique, acct)
+		}
+	}
+	return unique, orgID
+}
 
-				orgResp, err := p.s3Client.ListObjectsV2(ctx, orgInput)
-				if err != nil {
-					p.logger.Error("failed to list organization accounts",
-						slog.String("error", err.Error()))
-					continue
-				}
+// discoverOrgAccounts finds the account folders under an organization path,
+// descending through the OU folders of layouts like Control Tower's
+// (o-id/r-root/ou-a/ou-b/account)
+func (p *Processor) discoverOrgAccounts(ctx context.Context, bucket, basePrefix, orgPath string, depth, maxDepth int) []accountDir {
+	children, err := p.listFolders(ctx, bucket, basePrefix+orgPath+"/")
+	if err != nil {
+		p.discoveryErrors.Add(1)
+		p.logger.Error("failed to list organization accounts",
+			slog.String("org_path", orgPath),
+			slog.String("error", err.Error()))
+		return nil
+	}
 
-				for _, orgPfx := range orgResp.CommonPrefixes {
-					orgParts := strings.Split(aws.ToString(orgPfx.Prefix), "/")
-					if len(orgParts) >= 3 {
-						accountMap[orgParts[len(orgParts)-2]] = true
-					}
-				}
-			} else if len(id) == 12 && isNumeric(id) {
-				accountMap[id] = true
+	var accounts []accountDir
+	for _, id := range children {
+		if isAccountID(id) {
+			accounts = append(accounts, accountDir{id: id, orgPath: orgPath})
+		} else if depth < maxDepth {
+			accounts = append(accounts, p.discoverOrgAccounts(ctx, bucket, basePrefix, orgPath+"/"+id, depth+1, maxDepth)...)
+		}
+	}
+	return accounts
+}
+
+// configuredAccounts returns a trail's accounts list as account folders,
+// each an account ID optionally under its organization path
+func configuredAccounts(accounts []string) ([]accountDir, error) {
+	dirs := make([]accountDir, 0, len(accounts))
+	seen := make(map[string]bool, len(accounts))
+	for _, account := range accounts {
+		account = strings.Trim(account, "/")
+		orgPath, id := "", account
+		if i := strings.LastIndex(account, "/"); i >= 0 {
+			orgPath, id = account[:i], account[i+1:]
+		}
+		if !isAccountID(id) {
+			return nil, fmt.Errorf("accounts: %q doesn't end in a 12 digit account ID", account)
+		}
+		if !seen[id] {
+			seen[id] = true
+			dirs = append(dirs, accountDir{id: id, orgPath: orgPath})
+		}
+	}
+	return dirs, nil
+}
+
+// listFolders returns the names of the folders directly under prefix
+func (p *Processor) listFolders(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var names []string
+	paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+		MaxKeys:   aws.Int32(p.discoveryPageSize()),
+	})
+	for paginator.HasMorePages() {
+		page, err := p.nextPage(ctx, paginator)
+		if err != nil {
+			return nil, err
+		}
+		for _, cp := range page.CommonPrefixes {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(cp.Prefix), prefix), "/")
+			if name != "" {
+				names = append(names, name)
 			}
 		}
 	}
+	return names, nil
+}
 
-	accounts := make([]string, 0, len(accountMap))
-	for account := range accountMap {
-		accounts = append(accounts, account)
+// discoveryPageSize is the page size of the delimiter listings that find
+// account and region folders, every page of which is read; config.Load keeps
+// it within S3's limit of 1000 per page
+func (p *Processor) discoveryPageSize() int32 {
+	if n := p.config.DiscoveryPageSize; n > 0 {
+		return int32(n)
 	}
+	return 1000
+}
 
-	return accounts, orgID
+func isAccountID(s string) bool {
+	return len(s) == 12 && isNumeric(s)
 }
 
 // AccountRegionPair represents an account/region combination that has data
 type AccountRegionPair struct {
 	AccountID string
 	Region    string
+	// folders between AWSLogs/ and the account ID, e.g. o-abc123 for an
+	// organization trail, or a longer OU path for Control Tower
+	OrgPath string
+	// the folder between the account ID and the region, CloudTrail or
+	// e.g. CloudTrail-Insight
+	Folder string
+}
+
+// prefix returns the key prefix holding the pair's log files
+func (pair AccountRegionPair) prefix(basePrefix string) string {
+	return folderPrefix(basePrefix, pair.OrgPath, pair.AccountID, pair.Folder, pair.Region)
+}
+
+// stateRegion returns the region the pair's checkpoint is saved under
+func (pair AccountRegionPair) stateRegion() string {
+	return stateRegion(pair.Folder, pair.Region)
+}
+
+// stateRegion keeps the checkpoints of folders other than CloudTrail apart
+// from the region's own, under e.g. CloudTrail-Insight/us-east-1
+func stateRegion(folder, region string) string {
+	if folder == "" || folder == logkey.DefaultFolder {
+		return region
+	}
+	return folder + "/" + region
+}
+
+// logFolders returns the folders listed for log files under each account
+func (p *Processor) logFolders() []string {
+	if len(p.config.LogFolders) > 0 {
+		return p.config.LogFolders
+	}
+	return logkey.DefaultFolders
+}
+
+// configuredPairs pairs each account with each of a trail's configured
+// regions, in each of the log folders, without listing anything
+func (p *Processor) configuredPairs(accounts []accountDir, regions []string) []AccountRegionPair {
+	var pairs []AccountRegionPair
+	for _, acct := range accounts {
+		for _, folder := range p.logFolders() {
+			for _, region := range regions {
+				pairs = append(pairs, AccountRegionPair{
+					AccountID: acct.id,
+					Region:    region,
+					OrgPath:   acct.orgPath,
+					Folder:    folder,
+				})
+			}
+		}
+	}
+	return pairs
 }
 
-// discoverAccountRegions finds all account/region combinations that actually have CloudTrail logs
-func (p *Processor) discoverAccountRegions(ctx context.Context, bucket, basePrefix string, accounts []string, orgID string) []AccountRegionPair {
+// discoverAccountRegions finds all account/region combinations that actually
+// have CloudTrail logs, in each of the log folders
+func (p *Processor) discoverAccountRegions(ctx context.Context, bucket, basePrefix string, accounts []accountDir) []AccountRegionPair {
 	var pairs []AccountRegionPair
 	var mu sync.Mutex
 
 	var wg sync.WaitGroup
-	for _, accountID := range accounts {
-		wg.Add(1)
-		go func(acct string) {
-			defer wg.Done()
-
-			var prefix string
-			if orgID != "" {
-				prefix = fmt.Sprintf("%s%s/%s/CloudTrail/", basePrefix, orgID, acct)
-			} else {
-				prefix = fmt.Sprintf("%s%s/CloudTrail/", basePrefix, acct)
-			}
-
-			input := &s3.ListObjectsV2Input{
-				Bucket:    aws.String(bucket),
-				Prefix:    aws.String(prefix),
-				Delimiter: aws.String("/"),
-				MaxKeys:   aws.Int32(1000),
-			}
-
-			paginator := s3.NewListObjectsV2Paginator(p.s3Client, input)
-			for paginator.HasMorePages() {
-				page, err := paginator.NextPage(ctx)
-				if err != nil {
-					p.logger.Error("failed to discover regions",
-						slog.String("account", acct),
-						slog.String("error", err.Error()))
-					break
+	for _, account := range accounts {
+		for _, folder := range p.logFolders() {
+			wg.Add(1)
+			go func(acct accountDir) {
+				defer wg.Done()
+
+				prefix := accountPrefix(basePrefix, acct.orgPath, acct.id) + folder + "/"
+
+				input := &s3.ListObjectsV2Input{
+					Bucket:    aws.String(bucket),
+					Prefix:    aws.String(prefix),
+					Delimiter: aws.String("/"),
+					MaxKeys:   aws.Int32(p.discoveryPageSize()),
 				}
 
-				for _, commonPrefix := range page.CommonPrefixes {
-					parts := strings.Split(aws.ToString(commonPrefix.Prefix), "/")
-					for i, part := range parts {
-						if part == "CloudTrail" && i+1 < len(parts) {
-							region := parts[i+1]
-							if region != "" {
-								mu.Lock()
-								pairs = append(pairs, AccountRegionPair{
-									AccountID: acct,
-									Region:    region,
-								})
-								mu.Unlock()
+				paginator := s3.NewListObjectsV2Paginator(p.s3Client, input)
+				for paginator.HasMorePages() {
+					page, err := p.nextPage(ctx, paginator)
+					if err != nil {
+						p.discoveryErrors.Add(1)
+						p.logger.Error("failed to discover regions",
+							slog.String("account", acct.id),
+							slog.String("folder", folder),
+							slog.String("error", err.Error()))
+						break
+					}
+
+					for _, commonPrefix := range page.CommonPrefixes {
+						parts := strings.Split(aws.ToString(commonPrefix.Prefix), "/")
+						for i, part := range parts {
+							if part == folder && i+1 < len(parts) {
+								region := parts[i+1]
+								if region != "" {
+									mu.Lock()
+									pairs = append(pairs, AccountRegionPair{
+										AccountID: acct.id,
+										Region:    region,
+										OrgPath:   acct.orgPath,
+										Folder:    folder,
+									})
+									mu.Unlock()
+								}
+								break
 							}
-							break
 						}
 					}
 				}
-			}
-		}(accountID)
+			}(account)
+		}
 	}
 	wg.Wait()
 
 	return pairs
 }
 
-func (p *Processor) processAccountRegion(ctx context.Context, bucket, basePrefix, accountID, region, orgID string) {
+func (p *Processor) processAccountRegion(ctx context.Context, ts *trailSettings, basePrefix string, pair AccountRegionPair) {
+	bucket := ts.trail.Bucket
+	accountID, region := pair.AccountID, pair.stateRegion()
 	stateKey := fmt.Sprintf("%s:%s:%s", bucket, accountID, region)
 
 	// Check for resumption state
@@ -148,81 +276,242 @@ func (p *Processor) processAccountRegion(ctx context.Context, bucket, basePrefix
 			slog.String("state_key", stateKey),
 			slog.String("error", err.Error()))
 	}
-	if lastKey != "" {
+	watermark, err := p.stateDB.GetLastModified(bucket, accountID, region)
+	if err != nil {
+		p.logger.Error("failed to get last modified",
+			slog.String("state_key", stateKey),
+			slog.String("error", err.Error()))
+	}
+	p.retestDenied(bucket, accountID, region)
+	if lastKey != "" && !ts.tailing {
 		p.logger.Info("resuming from last checkpoint",
 			slog.String("state_key", stateKey),
 			slog.String("last_key", lastKey))
 	}
 
 	// Build S3 prefix
-	var searchPrefix string
-	if orgID != "" {
-		searchPrefix = fmt.Sprintf("%s%s/%s/CloudTrail/%s/", basePrefix, orgID, accountID, region)
-	} else {
-		searchPrefix = fmt.Sprintf("%s%s/CloudTrail/%s/", basePrefix, accountID, region)
-	}
+	searchPrefix := pair.prefix(basePrefix)
 
 	input := &s3.ListObjectsV2Input{
 		Bucket:  aws.String(bucket),
 		Prefix:  aws.String(searchPrefix),
-		MaxKeys: aws.Int32(int32(p.config.ListBatchSize)),
+		MaxKeys: aws.Int32(int32(ts.listBatchSize)),
 	}
 
 	if lastKey != "" {
 		input.StartAfter = aws.String(lastKey)
 	}
 
+	// jump straight to the first day folder of the time range
+	if !ts.startTime.IsZero() {
+		startKey := searchPrefix + ts.startTime.Format("2006/01/02/")
+		if startKey > lastKey {
+			input.StartAfter = aws.String(startKey)
+		}
+	}
+
+	listing := p.newListing(ts, pair, lastKey)
+	var lane *orderLane
+	if p.config.Ordered {
+		lane = newOrderLane()
+	}
+
+	// latest LastModified listed, which next run's late scan starts from
+	latest := watermark
+	if p.config.LateDelivery != LateIgnore && lastKey != "" && !watermark.IsZero() && !ts.tailing &&
+		!p.skipping(bucket, accountID, region) {
+		latest = p.scanLate(ctx, ts, searchPrefix, accountID, region, lastKey, watermark, listing, lane)
+	}
+
+	// a checkpoint past the range's last day folder has nothing left to
+	// list; only the late scan above may still find files
+	pastEnd := ts.pastEndDay(searchPrefix, aws.ToString(input.StartAfter))
+	if pastEnd {
+		p.logger.Debug("checkpoint past end of time range, not listing",
+			slog.String("state_key", stateKey),
+			slog.String("last_key", lastKey))
+	}
+
 	filesListed := 0
 	var lastSeenKey string
+	// set from the first key still inside the settle window, after which
+	// the checkpoint stays put
+	holding := false
+	resettled := false
+	pairKey := streamKey{bucket, accountID, region}
 	paginator := s3.NewListObjectsV2Paginator(p.s3Client, input)
-	for paginator.HasMorePages() {
-		page, err := paginator.NextPage(ctx)
+listing:
+	for !pastEnd && paginator.HasMorePages() {
+		if err := p.control.wait(ctx); err != nil {
+			return
+		}
+		page, err := p.nextPage(ctx, paginator)
 		if err != nil {
+			p.noteAccess(bucket, accountID, region, err, true)
+			class := p.countError(err)
 			p.logger.Error("failed to list objects",
 				slog.String("state_key", stateKey),
-				slog.String("error", err.Error()))
-			p.stats.Errors.Add(1)
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
 			return
 		}
 
 		for _, obj := range page.Contents {
 			key := aws.ToString(obj.Key)
 
-			if !strings.HasSuffix(key, ".json.gz") {
+			if !logkey.IsLogFile(key) {
+				continue
+			}
+
+			// keys are in delivery order, so everything after this is too new
+			if ts.pastEndTime(key) {
+				break listing
+			}
+			latest = maxTime(latest, aws.ToTime(obj.LastModified))
+			if !holding && !p.settled(key, aws.ToTime(obj.LastModified)) {
+				holding = true
+			}
+			if ts.skipKey(key) {
+				p.stats.FilesExcluded.Add(1)
+				// still moves the checkpoint past it
+				if !holding {
+					lastSeenKey = key
+				}
+				continue
+			}
+			// read by an earlier listing in this process while unsettled
+			if p.settle.listed(pairKey, key) {
+				if !holding {
+					lastSeenKey = key
+					resettled = true
+				}
 				continue
 			}
 
 			p.stats.FilesListed.Add(1)
+			ts.progress.listed.Add(1)
 			filesListed++
-			lastSeenKey = key
+			if !holding {
+				lastSeenKey = key
+			}
+			if keyTime, ok := logkey.Time(key); ok {
+				p.stats.observe(keyTime)
+			}
+			// the checkpoint stays put, so the next run re-tests from here
+			if p.skipping(bucket, accountID, region) {
+				break listing
+			}
 
-			p.downloadJobs <- DownloadJob{
+			if err := p.control.waitQueue(ctx, ts.downloadJobs); err != nil {
+				return
+			}
+			job := DownloadJob{
 				Bucket:       bucket,
 				Key:          key,
 				Size:         aws.ToInt64(obj.Size),
 				LastModified: aws.ToTime(obj.LastModified),
+				ETag:         aws.ToString(obj.ETag),
+				AccountID:    accountID,
+				Region:       region,
+				trail:        ts,
+				listing:      listing,
+				lane:         lane,
 			}
-
-			// Periodically save progress
-			if filesListed%100 == 0 {
-				if err := p.stateDB.UpdateLastProcessedKey(bucket, accountID, region, key); err != nil {
-					p.logger.Error("failed to update state",
-						slog.String("state_key", stateKey),
-						slog.String("error", err.Error()))
+			if p.acked() {
+				// an unsettled file commits the checkpoint held back before it
+				value := key
+				if holding {
+					value = cmp.Or(lastSeenKey, lastKey)
 				}
+				if job.checkpoint, err = p.trackCheckpoint(ctx, bucket, accountID, region, value); err != nil {
+					return
+				}
+			}
+			if !p.acked() && !holding {
+				job.listed = p.noteCheckpoint(bucket, accountID, region, key)
+			}
+			if holding {
+				p.settle.add(pairKey, key)
+			}
+			listing.add(key)
+			if lane != nil {
+				job.seq = lane.add()
+			}
+			if err := p.enqueue(ctx, job); err != nil {
+				return
 			}
 		}
 	}
 
-	// Save final state (critical for account/regions with < 100 files)
-	if filesListed > 0 {
-		if err := p.stateDB.UpdateLastProcessedKey(bucket, accountID, region, lastSeenKey); err != nil {
-			p.logger.Error("failed to save final state",
+	listing.end()
+
+	if latest.After(watermark) {
+		if err := p.stateDB.UpdateLastModified(bucket, accountID, region, latest); err != nil {
+			p.logger.Error("failed to save last modified",
 				slog.String("state_key", stateKey),
 				slog.String("error", err.Error()))
 		}
+	}
+
+	if holding {
+		p.logger.Debug("checkpoint held back by the settle window",
+			slog.String("state_key", stateKey),
+			slog.String("checkpoint", cmp.Or(lastSeenKey, lastKey)))
+	}
+	p.settle.prune(pairKey, lastSeenKey)
+
+	// save the final checkpoint, which the periodic ones fall short of, once
+	// the files before it have finished
+	if (filesListed > 0 || resettled) && !p.acked() {
+		switch {
+		case p.skipping(bucket, accountID, region):
+			p.dropCheckpoint(bucket, accountID, region)
+		case lastSeenKey != "":
+			p.endCheckpoint(bucket, accountID, region, lastSeenKey)
+		}
+	}
+	if filesListed > 0 {
 		p.logger.Info("enqueued files",
 			slog.String("state_key", stateKey),
 			slog.Int("count", filesListed))
 	}
 }
+
+// pastEndTime reports whether a key was delivered after the time range, with
+// deliveryDelaySlack for events delivered late
+func (ts *trailSettings) pastEndTime(key string) bool {
+	if ts.endTime.IsZero() {
+		return false
+	}
+	keyTime, ok := logkey.Time(key)
+	return ok && keyTime.After(ts.endTime.Add(deliveryDelaySlack))
+}
+
+// pastEndDay reports whether a listing starting after key, under prefix,
+// would only reach day folders past the end of the time range, comparing
+// the key's date folders with the day after the range's last one
+func (ts *trailSettings) pastEndDay(prefix, key string) bool {
+	if ts.endTime.IsZero() || !strings.HasPrefix(key, prefix) {
+		return false
+	}
+	last := ts.endTime.Add(deliveryDelaySlack).UTC()
+	return key >= prefix+last.AddDate(0, 0, 1).Format("2006/01/02/")
+}
+
+// folderPrefix returns the key prefix holding one folder's log files for one
+// account/region
+func folderPrefix(basePrefix, orgPath, accountID, folder, region string) string {
+	return accountPrefix(basePrefix, orgPath, accountID) + folder + "/" + region + "/"
+}
+
+// digestPrefix returns the key prefix holding digest files for one account/region
+func digestPrefix(basePrefix, orgPath, accountID, region string) string {
+	return accountPrefix(basePrefix, orgPath, accountID) + logkey.DigestFolder + "/" + region + "/"
+}
+
+func accountPrefix(basePrefix, orgPath, accountID string) string {
+	if orgPath != "" {
+		return basePrefix + orgPath + "/" + accountID + "/"
+	}
+	return basePrefix + accountID + "/"
+}
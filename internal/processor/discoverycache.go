@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// discoveryScope sums up the settings a trail's discovery depends on, so a
+// change to any of them discovers afresh rather than reusing the cache
+func (p *Processor) discoveryScope(trail config.Trail) string {
+	return fmt.Sprintf("folders=%s depth=%d accounts=%s",
+		strings.Join(p.logFolders(), ","), trail.DiscoveryDepth, strings.Join(trail.Accounts, ","))
+}
+
+// cachedPairs returns the trail's pairs from the discovery cache when they
+// were discovered within the TTL with the same settings
+func (p *Processor) cachedPairs(trail config.Trail, basePrefix, scope string) ([]AccountRegionPair, bool) {
+	disc, err := p.stateDB.GetDiscovery(trail.Bucket, basePrefix)
+	if err != nil {
+		p.logger.Error("failed to read discovery cache",
+			slog.String("trail", trail.Name),
+			slog.String("error", err.Error()))
+		return nil, false
+	}
+	if disc == nil || disc.Scope != scope {
+		return nil, false
+	}
+	age := time.Since(disc.DiscoveredAt)
+	if age > p.config.DiscoveryCacheTTL {
+		return nil, false
+	}
+
+	pairs := make([]AccountRegionPair, len(disc.Pairs))
+	for i, dp := range disc.Pairs {
+		pairs[i] = AccountRegionPair{AccountID: dp.AccountID, Region: dp.Region, OrgPath: dp.OrgPath, Folder: dp.Folder}
+	}
+	p.logger.Info("using cached account/region combinations",
+		slog.String("trail", trail.Name),
+		slog.Int("count", len(pairs)),
+		slog.Duration("age", age.Round(time.Second)))
+	return pairs, true
+}
+
+// cachePairs saves the trail's freshly discovered pairs for later runs
+func (p *Processor) cachePairs(trail config.Trail, basePrefix, scope string, pairs []AccountRegionPair) {
+	cached := make([]state.DiscoveredPair, len(pairs))
+	for i, pair := range pairs {
+		cached[i] = state.DiscoveredPair{AccountID: pair.AccountID, Region: pair.Region, OrgPath: pair.OrgPath, Folder: pair.Folder}
+	}
+	if err := p.stateDB.SaveDiscovery(trail.Bucket, basePrefix, scope, cached); err != nil {
+		p.logger.Error("failed to save discovery cache",
+			slog.String("trail", trail.Name),
+			slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how often free space on the output volumes is checked
+const diskCheckInterval = 10 * time.Second
+
+// diskMonitor pauses listing and downloads while any output directory's
+// volume has less than MinFreeDisk bytes free, and resumes once it's back
+// above, so a full disk stalls the run rather than failing every flush
+func (p *Processor) diskMonitor(ctx context.Context, settings []*trailSettings) {
+	dirs := p.outputDirs(settings)
+	ticker := time.NewTicker(diskCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		low, dir, free := false, "", uint64(0)
+		for _, d := range dirs {
+			f, ok := freeSpace(d)
+			if !ok {
+				continue
+			}
+			if free == 0 || f < free {
+				dir, free = d, f
+			}
+			if f < p.config.MinFreeDisk {
+				low = true
+			}
+		}
+		p.setDiskLow(low, dir, free)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Processor) setDiskLow(low bool, dir string, free uint64) {
+	c := &p.control
+	c.mu.Lock()
+	changed := c.diskLow != low
+	c.diskLow, c.diskFree = low, free
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if low {
+		p.stats.DiskPauses.Add(1)
+		p.logger.Warn("output volume low on space, pausing downloads",
+			slog.String("dir", dir),
+			slog.Uint64("free_bytes", free),
+			slog.Uint64("min_free_bytes", p.config.MinFreeDisk))
+	} else {
+		p.logger.Info("output volume space reclaimed, resuming downloads",
+			slog.String("dir", dir),
+			slog.Uint64("free_bytes", free))
+	}
+}
+
+// outputDirs returns every directory events are written to, creating them
+// so their volume can be checked before the first write
+func (p *Processor) outputDirs(settings []*trailSettings) []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		abs, err := filepath.Abs(dir)
+		if err != nil || seen[abs] {
+			return
+		}
+		seen[abs] = true
+		_ = os.MkdirAll(abs, 0o755)
+		dirs = append(dirs, abs)
+	}
+
+	add(p.config.EventsDir)
+	for _, ts := range settings {
+		add(ts.eventsDir)
+		for _, dir := range ts.categoryDirs {
+			add(dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package processor
+
+// freeSpace isn't implemented here, so the disk monitor never pauses
+func freeSpace(dir string) (uint64, bool) {
+	return 0, false
+}
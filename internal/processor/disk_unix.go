@@ -0,0 +1,14 @@
+//go:build unix
+
+package processor
+
+import "golang.org/x/sys/unix"
+
+// freeSpace returns the bytes available to this process on dir's volume
+func freeSpace(dir string) (uint64, bool) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, false
+	}
+	return st.Bavail * uint64(st.Bsize), true
+}
@@ -0,0 +1,135 @@
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+)
+
+// ErrorClass is the kind of failure an error is counted as
+type ErrorClass int
+
+const (
+	ErrorOther ErrorClass = iota
+	ErrorThrottling
+	ErrorAccessDenied
+	ErrorNotFound
+	ErrorDecompress
+	ErrorParse
+	ErrorSink
+	ErrorPanic
+	numErrorClasses
+)
+
+var errorClassNames = [numErrorClasses]string{
+	"other", "throttling", "access_denied", "not_found", "decompress", "parse", "sink", "panic",
+}
+
+func (c ErrorClass) String() string {
+	if c < 0 || c >= numErrorClasses {
+		return "other"
+	}
+	return errorClassNames[c]
+}
+
+// ClassifiedError marks an error as a class that can't be told from the
+// error itself, like a decode failure
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ClassifiedError) Error() string { return e.Err.Error() }
+func (e *ClassifiedError) Unwrap() error { return e.Err }
+
+func classified(class ErrorClass, err error) error {
+	return &ClassifiedError{Class: class, Err: err}
+}
+
+// AWS error codes by class, across the services we call
+var (
+	throttlingCodes = map[string]bool{
+		"Throttling": true, "ThrottlingException": true, "ThrottledException": true,
+		"RequestThrottled": true, "RequestThrottledException": true, "SlowDown": true,
+		"TooManyRequestsException": true, "RequestLimitExceeded": true,
+		"ProvisionedThroughputExceededException": true, "LimitExceededException": true,
+	}
+	accessDeniedCodes = map[string]bool{
+		"AccessDenied": true, "AccessDeniedException": true, "UnauthorizedOperation": true,
+		"AllAccessDisabled": true, "InvalidAccessKeyId": true, "InvalidClientTokenId": true,
+		"SignatureDoesNotMatch": true, "ExpiredToken": true, "ExpiredTokenException": true,
+		"UnrecognizedClientException": true, "KMS.AccessDeniedException": true,
+	}
+	notFoundCodes = map[string]bool{
+		"NoSuchKey": true, "NoSuchBucket": true, "NotFound": true,
+		"ResourceNotFoundException": true, "TrailNotFoundException": true,
+	}
+)
+
+// Classify returns the class of err: the one it was marked with, else one
+// read from the AWS error code or HTTP status, else ErrorOther
+func Classify(err error) ErrorClass {
+	var ce *ClassifiedError
+	if errors.As(err, &ce) {
+		return ce.Class
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		switch {
+		case throttlingCodes[code]:
+			return ErrorThrottling
+		case accessDeniedCodes[code] || strings.HasSuffix(code, "AccessDenied"):
+			return ErrorAccessDenied
+		case notFoundCodes[code]:
+			return ErrorNotFound
+		}
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.HTTPStatusCode() {
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			return ErrorThrottling
+		case http.StatusForbidden, http.StatusUnauthorized:
+			return ErrorAccessDenied
+		case http.StatusNotFound:
+			return ErrorNotFound
+		}
+	}
+	return ErrorOther
+}
+
+// countError counts err against the run's errors and its class, returning
+// the class for logging
+func (p *Processor) countError(err error) ErrorClass {
+	class := Classify(err)
+	p.stats.Errors.Add(1)
+	p.stats.ErrorClasses[class].Add(1)
+	return class
+}
+
+// ErrorBreakdown returns the count of each class with errors
+func (s *Stats) ErrorBreakdown() map[string]int64 {
+	counts := make(map[string]int64)
+	for c := range numErrorClasses {
+		if n := s.ErrorClasses[c].Load(); n > 0 {
+			counts[c.String()] = n
+		}
+	}
+	return counts
+}
+
+// ErrorSummary lists the classes with errors as class=count
+func (s *Stats) ErrorSummary() string {
+	var parts []string
+	for c := range numErrorClasses {
+		if n := s.ErrorClasses[c].Load(); n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", c, n))
+		}
+	}
+	return strings.Join(parts, " ")
+}
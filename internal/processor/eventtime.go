@@ -0,0 +1,39 @@
+package processor
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/event"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+)
+
+// eventTimeOf parses an event's eventTime leniently. When it can't be
+// parsed, the delivery time in the S3 log file's key stands in, reported
+// by fallback; ok is false when neither works.
+func eventTimeOf(key, value string) (t time.Time, fallback, ok bool) {
+	if t, err := event.ParseTime(value); err == nil {
+		return t, false, true
+	}
+	if t, ok := logkey.Time(key); ok {
+		return t, true, true
+	}
+	return time.Time{}, false, false
+}
+
+// countTimeFallback counts an event given its log file's time for want of
+// a usable eventTime, warning about the first
+func (p *Processor) countTimeFallback(job DownloadJob, ev *MinimalEvent, t time.Time) {
+	attrs := []any{
+		slog.String("key", job.Key),
+		slog.String("object_id", job.id),
+		slog.String("event_id", ev.EventID),
+		slog.String("event_time", ev.EventTime),
+		slog.Time("key_time", t),
+	}
+	if p.stats.EventsTimeFallback.Add(1) == 1 {
+		p.logger.Warn("unparseable eventTime, using the log file's time from its key; later ones are logged at debug level", attrs...)
+	} else {
+		p.logger.Debug("unparseable eventTime, using the log file's time", attrs...)
+	}
+}
@@ -0,0 +1,14 @@
+package processor
+
+import "time"
+
+// wanted reports whether an event passes the trail's time range and filters
+func (ts *trailSettings) wanted(ev *MinimalEvent, eventTime time.Time) bool {
+	if !ts.startTime.IsZero() && eventTime.Before(ts.startTime) {
+		return false
+	}
+	if !ts.endTime.IsZero() && !eventTime.Before(ts.endTime) {
+		return false
+	}
+	return ts.filters.Match(ev.EventSource, ev.EventName)
+}
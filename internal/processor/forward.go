@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/sink"
+)
+
+// forward queues a newly written event for every sink whose route takes it,
+// holding the checkpoint, when tracked, until each sink acknowledges it
+func (p *Processor) forward(rawEvent json.RawMessage, ev map[string]any, c *streamCheckpoint) {
+	for _, s := range p.config.Sinks {
+		if !s.Wants(ev) {
+			continue
+		}
+		if err := s.WriteAcked(context.Background(), rawEvent, p.ackFunc(c)); err != nil {
+			class := p.countError(classified(ErrorSink, err))
+			p.logger.Error("failed to forward events",
+				slog.String("sink", s.Name),
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+			if p.acked() {
+				p.breakStream(s.Name, err)
+			}
+			continue
+		}
+		p.stats.EventsForwarded.Add(1)
+	}
+}
+
+// sinkFlusher sends the sink's partial batch every interval, its own
+// flush_interval when set
+func (p *Processor) sinkFlusher(ctx context.Context, s *sink.Buffered, interval time.Duration) {
+	if s.FlushInterval > 0 {
+		interval = s.FlushInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.flushSink(s)
+		}
+	}
+}
+
+func (p *Processor) flushSink(s *sink.Buffered) {
+	if p.acked() {
+		// a checkpoint commit must not slip in while this batch is unacknowledged
+		p.stream.gate.RLock()
+		defer p.stream.gate.RUnlock()
+	}
+	if err := s.Flush(context.Background()); err != nil {
+		class := p.countError(classified(ErrorSink, err))
+		p.logger.Error("failed to flush sink",
+			slog.String("sink", s.Name),
+			slog.String("error", err.Error()),
+			slog.String("error_class", class.String()))
+		if p.acked() {
+			p.breakStream(s.Name, err)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
+
+	"github.com/deceptiq/gocloudtrail/internal/alert"
+)
+
+// TrailHealth is what GetTrailStatus reported for a trail at the start of
+// the run
+type TrailHealth struct {
+	Logging             bool      `json:"logging"`
+	LatestDeliveryError string    `json:"latest_delivery_error,omitempty"`
+	LatestDelivery      time.Time `json:"latest_delivery,omitzero"`
+	StoppedLogging      time.Time `json:"stopped_logging,omitzero"`
+}
+
+// Healthy reports whether the trail is logging and delivering without errors
+func (h *TrailHealth) Healthy() bool {
+	return h.Logging && h.LatestDeliveryError == ""
+}
+
+// checkTrailHealth asks CloudTrail whether the trail is still logging and
+// delivering, warning loudly when it isn't: a stopped trail looks just like
+// a quiet account from the bucket. Trails without an ARN (hand-written or
+// found by scanning buckets) aren't checked.
+func (p *Processor) checkTrailHealth(ctx context.Context, ts *trailSettings) {
+	trail := ts.trail
+	if trail.ARN == "" {
+		return
+	}
+	region := trail.HomeRegion
+	if region == "" {
+		// arn:aws:cloudtrail:<region>:<account>:trail/<name>
+		if parts := strings.Split(trail.ARN, ":"); len(parts) > 3 {
+			region = parts[3]
+		}
+	}
+
+	resp, err := p.ctClient.GetTrailStatus(ctx, &cloudtrail.GetTrailStatusInput{Name: aws.String(trail.ARN)},
+		func(o *cloudtrail.Options) {
+			if region != "" {
+				o.Region = region
+			}
+		})
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Warn("failed to check trail status",
+				slog.String("trail", trail.Name),
+				slog.String("error", err.Error()))
+		}
+		return
+	}
+
+	health := &TrailHealth{
+		Logging:             aws.ToBool(resp.IsLogging),
+		LatestDeliveryError: aws.ToString(resp.LatestDeliveryError),
+		LatestDelivery:      aws.ToTime(resp.LatestDeliveryTime),
+		StoppedLogging:      aws.ToTime(resp.StopLoggingTime),
+	}
+	ts.health.Store(health)
+	if health.Healthy() {
+		return
+	}
+	p.stats.TrailsUnhealthy.Add(1)
+
+	var message string
+	attrs := []any{slog.String("trail", trail.Name), slog.String("bucket", trail.Bucket)}
+	if !health.Logging {
+		message = "TRAIL LOGGING IS STOPPED: no new events are being delivered"
+		if !health.StoppedLogging.IsZero() {
+			attrs = append(attrs, slog.Time("stopped_logging", health.StoppedLogging))
+		}
+	} else {
+		message = "TRAIL DELIVERY IS FAILING: CloudTrail reports errors writing to the bucket"
+	}
+	if health.LatestDeliveryError != "" {
+		attrs = append(attrs, slog.String("latest_delivery_error", health.LatestDeliveryError))
+	}
+	if !health.LatestDelivery.IsZero() {
+		attrs = append(attrs, slog.Time("latest_delivery", health.LatestDelivery))
+	}
+	p.logger.Warn(message, attrs...)
+
+	if p.config.Notifier != nil {
+		details := map[string]any{"trail": trail.Name, "arn": trail.ARN, "logging": health.Logging}
+		if health.LatestDeliveryError != "" {
+			details["latest_delivery_error"] = health.LatestDeliveryError
+		}
+		if err := p.config.Notifier.Notify(ctx, alert.Alert{
+			Condition: "trail_unhealthy",
+			Message:   trail.Name + ": " + message,
+			Time:      time.Now().UTC(),
+			Details:   details,
+		}); err != nil {
+			p.logger.Error("failed to send alert",
+				slog.String("condition", "trail_unhealthy"),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// UnhealthyTrails returns the trails of the run that have stopped logging or
+// report delivery errors
+func (p *Processor) UnhealthyTrails() []string {
+	p.settingsMu.Lock()
+	settings := p.settings
+	p.settingsMu.Unlock()
+
+	var names []string
+	for _, ts := range settings {
+		if h := ts.health.Load(); h != nil && !h.Healthy() {
+			names = append(names, ts.trail.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
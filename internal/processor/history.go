@@ -0,0 +1,66 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// startRunRecord adds the run to the run history in the state DB
+func (p *Processor) startRunRecord(command string) {
+	host, _ := os.Hostname()
+	id, err := p.stateDB.StartRun(state.Run{
+		Command:    command,
+		ConfigHash: p.config.ConfigHash,
+		RangeStart: p.config.StartTime,
+		RangeEnd:   p.config.EndTime,
+		StartedAt:  p.stats.StartTime,
+		PID:        os.Getpid(),
+		Host:       host,
+	})
+	if err != nil {
+		p.logger.Error("failed to record run", slog.String("error", err.Error()))
+		return
+	}
+	p.runID = id
+}
+
+// finishRunRecord records how the run ended, the trails it processed and
+// its totals
+func (p *Processor) finishRunRecord(runErr error) {
+	if p.runID == 0 {
+		return
+	}
+
+	p.settingsMu.Lock()
+	settings := p.settings
+	p.settingsMu.Unlock()
+	trails := make([]string, 0, len(settings))
+	for _, ts := range settings {
+		trails = append(trails, ts.trail.Name)
+	}
+	sort.Strings(trails)
+
+	run := state.Run{
+		ID:      p.runID,
+		EndedAt: time.Now(),
+		Status:  state.RunCompleted,
+		Trails:  trails,
+		Totals:  p.stats.counters(),
+	}
+	switch {
+	case errors.Is(runErr, context.Canceled):
+		run.Status = state.RunInterrupted
+	case runErr != nil:
+		run.Status = state.RunFailed
+		run.Error = runErr.Error()
+	}
+	if err := p.stateDB.FinishRun(run); err != nil {
+		p.logger.Error("failed to record run end", slog.String("error", err.Error()))
+	}
+}
@@ -0,0 +1,171 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/lake"
+)
+
+// rows handed to the process workers at a time
+const importBatchSize = 1000
+
+type ImportOptions struct {
+	// Parquet files or directories, local or as s3://bucket/prefix
+	Inputs []string
+	Format lake.Format
+}
+
+// Import reads Parquet exports (CloudTrail Lake, Security Lake OCSF or this
+// tool's own parquet output) and runs their events through the pipeline like
+// downloaded log files, using the global output, filters and time range
+func (p *Processor) Import(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration, opts ImportOptions) error {
+	resolve := func(ctx context.Context) ([]*trailSettings, error) {
+		ts, err := p.newTrailSettings(config.Trail{Name: "import"})
+		if err != nil {
+			return nil, err
+		}
+		return []*trailSettings{ts}, nil
+	}
+
+	return p.run(ctx, "import", progressInterval, flushInterval, bloomSaveInterval, resolve,
+		func(ctx context.Context, settings []*trailSettings) error {
+			return p.importInputs(ctx, settings[0], opts)
+		})
+}
+
+func (p *Processor) importInputs(ctx context.Context, ts *trailSettings, opts ImportOptions) error {
+	var files []string
+	for _, input := range opts.Inputs {
+		found, err := p.importFiles(ctx, input)
+		if err != nil {
+			return err
+		}
+		files = append(files, found...)
+	}
+	p.stats.FilesListed.Add(int64(len(files)))
+	ts.progress.listed.Add(int64(len(files)))
+	p.logger.Info("importing parquet files", slog.Int("count", len(files)))
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range max(p.config.DownloadWorkers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				if err := p.importFile(ctx, ts, name, opts.Format); err != nil {
+					class := p.countError(err)
+					p.stats.FilesSkipped.Add(1)
+					p.logger.Error("failed to import file",
+						slog.String("file", name),
+						slog.String("error", err.Error()),
+						slog.String("error_class", class.String()))
+				}
+			}
+		}()
+	}
+
+	for _, name := range files {
+		select {
+		case jobs <- name:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// importFiles expands an input into the Parquet files under it
+func (p *Processor) importFiles(ctx context.Context, input string) ([]string, error) {
+	if bucket, prefix, ok := parseS3URI(input); ok {
+		var files []string
+		paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+			Bucket: aws.String(bucket),
+			Prefix: aws.String(prefix),
+		})
+		for paginator.HasMorePages() {
+			page, err := p.nextPage(ctx, paginator)
+			if err != nil {
+				return nil, fmt.Errorf("list %s: %w", input, err)
+			}
+			for _, obj := range page.Contents {
+				if key := aws.ToString(obj.Key); strings.HasSuffix(key, ".parquet") {
+					files = append(files, "s3://"+bucket+"/"+key)
+				}
+			}
+		}
+		return files, nil
+	}
+
+	var files []string
+	err := filepath.WalkDir(input, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && (path == input || strings.HasSuffix(path, ".parquet")) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan %s: %w", input, err)
+	}
+	return files, nil
+}
+
+func (p *Processor) importFile(ctx context.Context, ts *trailSettings, name string, format lake.Format) error {
+	if err := p.control.acquireDownload(ctx, 0); err != nil {
+		return err
+	}
+	var data []byte
+	var err error
+	if bucket, key, ok := parseS3URI(name); ok {
+		data, err = p.downloadObject(ctx, bucket, key)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	p.control.releaseDownload()
+	if err != nil {
+		return err
+	}
+	p.stats.FilesDownloaded.Add(1)
+	ts.progress.downloaded.Add(1)
+	p.stats.BytesDownloaded.Add(int64(len(data)))
+
+	// without a bucket the job is left out of object records and run metrics
+	job := DownloadJob{Key: name, trail: ts}
+	return lake.Read(bytes.NewReader(data), int64(len(data)), format, importBatchSize, func(records []json.RawMessage) error {
+		select {
+		case p.processJobs <- ProcessedFile{Job: job, Records: records}:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
+
+// parseS3URI splits s3://bucket/prefix
+func parseS3URI(s string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(s, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	return bucket, prefix, bucket != ""
+}
@@ -0,0 +1,178 @@
+package processor
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/quarantine"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// outputRepairs counts what checkOutput found
+type outputRepairs struct {
+	checked     int
+	truncated   int
+	quarantined int
+	removed     int
+}
+
+// checkOutput finds runs whose process died without recording their end,
+// checks the output files written since the first of them started, before
+// anything new is written, and marks them crashed. A plain JSONL file cut
+// short is truncated to its last complete line, any other damaged file is
+// moved to the quarantine dir, and leftover temporary files are removed, so
+// partial files never reach downstream readers.
+func (p *Processor) checkOutput(settings []*trailSettings) {
+	runs, err := p.stateDB.UnfinishedRuns()
+	if err != nil {
+		p.logger.Error("failed to look for crashed runs", slog.String("error", err.Error()))
+		return
+	}
+	host, _ := os.Hostname()
+	var crashed []state.Run
+	for _, r := range runs {
+		if r.ID == p.runID {
+			continue
+		}
+		// a run on another host, or still going here (a backfill alongside
+		// this run), isn't ours to judge; a container restarted with the
+		// same PID as the run it replaces is this process
+		if r.PID != 0 && (r.Host != host || r.PID != os.Getpid() && processAlive(r.PID)) {
+			continue
+		}
+		crashed = append(crashed, r)
+	}
+	if len(crashed) == 0 {
+		return
+	}
+
+	since := crashed[0].StartedAt
+	for _, r := range crashed[1:] {
+		since = minTime(since, r.StartedAt)
+	}
+	for _, r := range crashed {
+		p.logger.Warn("found a run that did not finish",
+			slog.Int64("run", r.ID),
+			slog.String("command", r.Command),
+			slog.Time("started_at", r.StartedAt))
+	}
+
+	if p.config.CheckOutput && !p.config.StreamOnly {
+		start := time.Now()
+		var repairs outputRepairs
+		q := p.quarantine
+		if q == nil {
+			q = quarantine.New(p.config.QuarantineDir)
+			defer func() { _ = q.Close() }()
+		}
+		// file times are compared with some slack for coarse timestamps
+		for _, dir := range p.outputDirs(settings) {
+			p.checkOutputDir(dir, dir, since.Add(-time.Minute), q, &repairs)
+		}
+		p.logger.Info("checked output left by crashed runs",
+			slog.Int("files_checked", repairs.checked),
+			slog.Int("files_truncated", repairs.truncated),
+			slog.Int("files_quarantined", repairs.quarantined),
+			slog.Int("temp_files_removed", repairs.removed),
+			slog.Duration("elapsed", time.Since(start).Round(time.Millisecond)))
+	}
+
+	now := time.Now()
+	for _, r := range crashed {
+		if err := p.stateDB.MarkRunCrashed(r.ID, now); err != nil {
+			p.logger.Error("failed to record crashed run", slog.String("error", err.Error()))
+		}
+	}
+}
+
+// checkOutputDir checks the files in dir and below modified since the
+// crashed runs started. Writers only ever add files, which touches their
+// dir, so files in dirs unchanged since then are skipped without a stat.
+func (p *Processor) checkOutputDir(eventsDir, dir string, since time.Time, q *quarantine.Writer, repairs *outputRepairs) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		p.logger.Warn("failed to read output dir", slog.String("dir", dir), slog.String("error", err.Error()))
+		return
+	}
+	info, err := os.Stat(dir)
+	fresh := err == nil && !info.ModTime().Before(since)
+
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			p.checkOutputDir(eventsDir, path, since, q, repairs)
+			continue
+		}
+		if !fresh || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil || info.ModTime().Before(since) {
+			continue
+		}
+
+		name := e.Name()
+		if strings.HasSuffix(name, ".tmp") && (writer.IsEventsFile(strings.TrimSuffix(name, ".tmp")) || name == writer.ManifestName+".tmp") {
+			// never renamed into place, so nothing refers to it
+			if err := os.Remove(path); err != nil {
+				p.logger.Error("failed to remove temporary file", slog.String("path", path), slog.String("error", err.Error()))
+				continue
+			}
+			repairs.removed++
+			p.logger.Warn("removed temporary file left by a crashed run", slog.String("path", path))
+			continue
+		}
+		if !writer.IsEventsFile(name) {
+			continue
+		}
+
+		repairs.checked++
+		damage, err := writer.CheckEventsFile(path, p.config.Cipher)
+		if err != nil {
+			p.logger.Error("failed to check output file", slog.String("path", path), slog.String("error", err.Error()))
+			continue
+		}
+		if damage == nil {
+			continue
+		}
+		p.repairOutputFile(eventsDir, path, info.Size(), damage, q, repairs)
+	}
+}
+
+func (p *Processor) repairOutputFile(eventsDir, path string, size int64, damage *writer.Damage, q *quarantine.Writer, repairs *outputRepairs) {
+	if damage.Keep > 0 {
+		if err := os.Truncate(path, damage.Keep); err != nil {
+			p.logger.Error("failed to truncate damaged output file", slog.String("path", path), slog.String("error", err.Error()))
+			return
+		}
+		repairs.truncated++
+		p.logger.Warn("truncated damaged output file to its last complete event",
+			slog.String("path", path),
+			slog.String("reason", damage.Reason),
+			slog.Int64("bytes_dropped", size-damage.Keep))
+	} else {
+		rel, err := filepath.Rel(eventsDir, path)
+		if err != nil || !filepath.IsLocal(rel) {
+			rel = filepath.Base(path)
+		}
+		// kept under the events dir's name, unless it has none, like /
+		if name := filepath.Base(filepath.Clean(eventsDir)); filepath.IsLocal(name) {
+			rel = filepath.Join(name, rel)
+		}
+		if err := q.Output(path, rel, damage.Reason); err != nil {
+			p.logger.Error("failed to quarantine damaged output file", slog.String("path", path), slog.String("error", err.Error()))
+			return
+		}
+		repairs.quarantined++
+		p.logger.Warn("quarantined damaged output file",
+			slog.String("path", path),
+			slog.String("reason", damage.Reason))
+	}
+	if err := writer.UpdateManifest(path); err != nil {
+		p.logger.Error("failed to update manifest", slog.String("path", path), slog.String("error", err.Error()))
+	}
+}
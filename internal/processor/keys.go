@@ -0,0 +1,102 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+)
+
+// LoadKeys reads a keys file, locally or from s3://bucket/key: one object
+// per line, as s3://bucket/key or as a key under a configured trail's
+// prefix. Blank lines and lines starting with # are skipped.
+func (p *Processor) LoadKeys(ctx context.Context, source string) ([]string, error) {
+	var data []byte
+	var err error
+	if bucket, key, ok := parseS3URI(source); ok {
+		data, err = p.downloadObject(ctx, bucket, key)
+	} else {
+		data, err = os.ReadFile(source)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read keys file %s: %w", source, err)
+	}
+
+	var keys []string
+	for line := range strings.Lines(string(data)) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		keys = append(keys, line)
+	}
+	return keys, nil
+}
+
+// ProcessKeys runs exactly the listed objects through the pipeline, without
+// listing or moving checkpoints, recording each outcome so a later check
+// sees them as processed. Keys no configured trail covers are skipped.
+func (p *Processor) ProcessKeys(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration, keys []string) error {
+	p.config.RecordObjects = true
+
+	return p.run(ctx, "run --keys-file", progressInterval, flushInterval, bloomSaveInterval, p.resolveSettings,
+		func(ctx context.Context, settings []*trailSettings) error {
+			var trails []*trailSettings
+			for _, ts := range settings {
+				if ts.logGroup == nil {
+					trails = append(trails, ts)
+				}
+			}
+
+			for _, entry := range keys {
+				bucket, key, ok := parseS3URI(entry)
+				if !ok {
+					key = entry
+				}
+				ts := keyTrail(trails, bucket, key)
+				if ts == nil {
+					p.stats.FilesSkipped.Add(1)
+					p.logger.Warn("no configured trail covers key, skipping", slog.String("key", entry))
+					continue
+				}
+				accountID, region, ok := logkey.Source(key)
+				folder, _ := logkey.Folder(key)
+				if !ok {
+					p.stats.FilesSkipped.Add(1)
+					p.logger.Warn("not a CloudTrail log file key, skipping", slog.String("key", entry))
+					continue
+				}
+
+				p.stats.FilesListed.Add(1)
+				ts.progress.listed.Add(1)
+				if err := p.enqueue(ctx, DownloadJob{
+					Bucket:    ts.trail.Bucket,
+					Key:       key,
+					AccountID: accountID,
+					Region:    stateRegion(folder, region),
+					trail:     ts,
+				}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+}
+
+// keyTrail returns the first trail whose bucket and log prefix hold the key;
+// a key without a bucket matches on the prefix alone
+func keyTrail(trails []*trailSettings, bucket, key string) *trailSettings {
+	for _, ts := range trails {
+		if bucket != "" && ts.trail.Bucket != bucket {
+			continue
+		}
+		if strings.HasPrefix(key, ts.trail.LogPrefix()) {
+			return ts
+		}
+	}
+	return nil
+}
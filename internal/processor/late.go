@@ -0,0 +1,152 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+)
+
+// LateDelivery is what a run does with log files delivered behind an
+// account/region's checkpoint after an earlier run listed past them, which
+// listing from the checkpoint would never see
+type LateDelivery int
+
+const (
+	// only track the LastModified watermark
+	LateIgnore LateDelivery = iota
+	// log and count them
+	LateFlag
+	// also process them like newly listed files
+	LateProcess
+)
+
+func ParseLateDelivery(s string) (LateDelivery, error) {
+	switch s {
+	case "", "off":
+		return LateIgnore, nil
+	case "flag":
+		return LateFlag, nil
+	case "process":
+		return LateProcess, nil
+	}
+	return 0, fmt.Errorf("unknown late_deliveries %q (want off, flag or process)", s)
+}
+
+// scanLate re-lists the lookback window before lastKey for log files
+// modified after watermark, the latest LastModified earlier runs listed, and
+// flags or enqueues them. It returns the latest LastModified it saw.
+func (p *Processor) scanLate(ctx context.Context, ts *trailSettings, searchPrefix, accountID, region, lastKey string,
+	watermark time.Time, listing *pairListing, lane *orderLane) time.Time {
+	latest := watermark
+	lastTime, ok := logkey.Time(lastKey)
+	if !ok {
+		return latest
+	}
+	bucket := ts.trail.Bucket
+
+	startAfter := searchPrefix + lastTime.Add(-p.config.LateLookback).Format("2006/01/02/")
+	if !ts.startTime.IsZero() {
+		if startKey := searchPrefix + ts.startTime.Format("2006/01/02/"); startKey > startAfter {
+			startAfter = startKey
+		}
+	}
+
+	if ts.pastEndDay(searchPrefix, startAfter) {
+		return latest
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+		Bucket:     aws.String(bucket),
+		Prefix:     aws.String(searchPrefix),
+		StartAfter: aws.String(startAfter),
+		MaxKeys:    aws.Int32(int32(ts.listBatchSize)),
+	})
+	for paginator.HasMorePages() {
+		page, err := p.nextPage(ctx, paginator)
+		if err != nil {
+			class := p.countError(err)
+			p.logger.Error("failed to list for late deliveries",
+				slog.String("state_key", fmt.Sprintf("%s:%s:%s", bucket, accountID, region)),
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+			return latest
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			// the rest is listed from the checkpoint as usual
+			if key > lastKey || ts.pastEndTime(key) {
+				return latest
+			}
+			modified := aws.ToTime(obj.LastModified)
+			if !logkey.IsLogFile(key) || !modified.After(watermark) {
+				continue
+			}
+			latest = maxTime(latest, modified)
+			if ts.skipKey(key) {
+				continue
+			}
+
+			p.stats.FilesLate.Add(1)
+			p.logger.Warn("log file delivered behind the checkpoint",
+				slog.String("bucket", bucket),
+				slog.String("key", key),
+				slog.Time("last_modified", modified),
+				slog.Time("watermark", watermark),
+				slog.Bool("processing", p.config.LateDelivery == LateProcess))
+			if p.config.LateDelivery != LateProcess {
+				continue
+			}
+
+			p.stats.FilesListed.Add(1)
+			ts.progress.listed.Add(1)
+			if keyTime, ok := logkey.Time(key); ok {
+				p.stats.observe(keyTime)
+			}
+			if err := p.control.waitQueue(ctx, ts.downloadJobs); err != nil {
+				return latest
+			}
+			// no checkpoint to hold back: it's already past the file
+			job := DownloadJob{
+				Bucket:       bucket,
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: modified,
+				ETag:         aws.ToString(obj.ETag),
+				AccountID:    accountID,
+				Region:       region,
+				trail:        ts,
+				listing:      listing,
+				lane:         lane,
+			}
+			listing.add(key)
+			if lane != nil {
+				job.seq = lane.add()
+			}
+			if err := p.enqueue(ctx, job); err != nil {
+				return latest
+			}
+		}
+	}
+	return latest
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func minTime(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
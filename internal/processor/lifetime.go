@@ -0,0 +1,96 @@
+package processor
+
+import (
+	"log/slog"
+	"maps"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// counters returns the Stats counters kept as lifetime totals in the state
+// DB, by name
+func (s *Stats) counters() map[string]int64 {
+	return map[string]int64{
+		"files_processed":         s.FilesProcessed.Load(),
+		"files_skipped":           s.FilesSkipped.Load(),
+		"files_late":              s.FilesLate.Load(),
+		"files_already_processed": s.FilesAlreadyProcessed.Load(),
+		"bytes_downloaded":        s.BytesDownloaded.Load(),
+		"events_processed":        s.EventsProcessed.Load(),
+		"events_written":          s.EventsWritten.Load(),
+		"events_duplicate":        s.EventsDuplicate.Load(),
+		"events_filtered":         s.EventsFiltered.Load(),
+		"events_invalid":          s.EventsInvalid.Load(),
+		"events_forwarded":        s.EventsForwarded.Load(),
+		"events_newer_version":    s.EventsNewerVersion.Load(),
+		"events_oversized":        s.EventsOversized.Load(),
+		"events_time_fallback":    s.EventsTimeFallback.Load(),
+		"findings":                s.Findings.Load(),
+		"errors":                  s.Errors.Load(),
+	}
+}
+
+// Lifetime returns the totals across every run, this one included, or nil
+// when they weren't loaded
+func (s *Stats) Lifetime() map[string]int64 {
+	s.lifetimeMu.Lock()
+	base := s.lifetimeBase
+	s.lifetimeMu.Unlock()
+	if base == nil {
+		return nil
+	}
+
+	totals := maps.Clone(base)
+	for name, n := range s.counters() {
+		totals[name] += n
+	}
+	return totals
+}
+
+// startLifetime counts the run in the state DB and loads the totals earlier
+// runs left, which progress then reports alongside this run's counts
+func (p *Processor) startLifetime() {
+	if err := p.stateDB.StartRunCounters(); err != nil {
+		p.logger.Error("failed to start run counters", slog.String("error", err.Error()))
+		return
+	}
+	counters, err := p.stateDB.ListCounters()
+	if err != nil {
+		p.logger.Error("failed to load lifetime counters", slog.String("error", err.Error()))
+		return
+	}
+
+	base := make(map[string]int64, len(counters))
+	for _, c := range counters {
+		base[c.Name] = c.Total
+	}
+	p.stats.lifetimeMu.Lock()
+	p.stats.lifetimeBase = base
+	p.stats.lifetimeMu.Unlock()
+	p.logger.Info("loaded lifetime counters",
+		slog.Int64("runs", base[state.RunsCounter]),
+		slog.Int64("events_written", base["events_written"]))
+}
+
+// saveCounters adds what the counters gained since the last save to the
+// lifetime totals
+func (p *Processor) saveCounters() {
+	p.countersMu.Lock()
+	defer p.countersMu.Unlock()
+
+	current := p.stats.counters()
+	deltas := make(map[string]int64)
+	for name, n := range current {
+		if d := n - p.savedCounters[name]; d != 0 {
+			deltas[name] = d
+		}
+	}
+	if len(deltas) == 0 {
+		return
+	}
+	if err := p.stateDB.AddCounters(deltas); err != nil {
+		p.logger.Error("failed to save lifetime counters", slog.String("error", err.Error()))
+		return
+	}
+	p.savedCounters = current
+}
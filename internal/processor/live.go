@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// LiveOptions tunes the live tail run alongside a backfill
+type LiveOptions struct {
+	// wait between passes over the newest files
+	Interval time.Duration
+}
+
+// BackfillLive runs Backfill and a live tail together on shared workers and
+// a shared bloom filter. The backfill processes [Start, End) in units as
+// Backfill does; the tail lists every account/region from its run
+// checkpoint, or from End when it has none, every Interval until ctx is
+// done, so new events show up while history fills in behind them.
+func (p *Processor) BackfillLive(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration,
+	opts BackfillOptions, live LiveOptions) error {
+	if opts.Start.IsZero() || opts.End.IsZero() || !opts.End.After(opts.Start) {
+		return fmt.Errorf("backfill needs a start time before its end time")
+	}
+	if opts.UnitDays <= 0 {
+		opts.UnitDays = 1
+	}
+	if opts.Parallel <= 0 {
+		opts.Parallel = 1
+	}
+	if live.Interval <= 0 {
+		live.Interval = time.Minute
+	}
+
+	return p.run(ctx, "backfill+live", progressInterval, flushInterval, bloomSaveInterval, p.resolveSettings,
+		func(ctx context.Context, settings []*trailSettings) error {
+			// the backfill widens its trails' time ranges to whole units, so
+			// it gets settings of its own, sharing the writers and queues
+			var backfillSettings []*trailSettings
+			for _, ts := range settings {
+				if ts.logGroup != nil {
+					continue
+				}
+				bts, err := p.newTrailSettings(ts.trail)
+				if err != nil {
+					return fmt.Errorf("trail %s: %w", ts.trail.Name, err)
+				}
+				bts.downloadJobs = ts.downloadJobs
+				backfillSettings = append(backfillSettings, bts)
+
+				// files delivered just after End can hold events from up to
+				// the delivery slack before it, which the backfill may
+				// have listed past already; the bloom filter drops the
+				// overlap
+				if from := opts.End.Add(-deliveryDelaySlack); ts.startTime.Before(from) {
+					ts.startTime = from
+				}
+			}
+
+			// a backfill that can't run stops the tail too
+			tailCtx, stopTail := context.WithCancel(ctx)
+			defer stopTail()
+			backfillDone := make(chan error, 1)
+			go func() {
+				err := p.backfill(ctx, backfillSettings, opts)
+				switch {
+				case err == nil:
+					p.logger.Info("backfill complete, live tail continues")
+				case ctx.Err() == nil:
+					stopTail()
+				}
+				backfillDone <- err
+			}()
+
+			err := p.tail(tailCtx, settings, live.Interval)
+			if backfillErr := <-backfillDone; backfillErr != nil && ctx.Err() == nil {
+				return fmt.Errorf("backfill: %w", backfillErr)
+			}
+			return err
+		})
+}
+
+// tail lists the trails and log groups from their checkpoints every
+// interval until ctx is done. Only the first pass checks trail health and
+// looks for late deliveries.
+func (p *Processor) tail(ctx context.Context, settings []*trailSettings, interval time.Duration) error {
+	for pass := 1; ; pass++ {
+		start := time.Now()
+		listed := p.stats.FilesListed.Load()
+		if err := p.discoverAndProcess(ctx, settings); err != nil {
+			return err
+		}
+		for _, ts := range settings {
+			ts.tailing = true
+		}
+		p.pruneListings()
+		p.logger.Debug("live pass done",
+			slog.Int("pass", pass),
+			slog.Int64("files_listed", p.stats.FilesListed.Load()-listed),
+			slog.Duration("elapsed", time.Since(start).Round(time.Millisecond)))
+
+		timer := time.NewTimer(max(interval-time.Since(start), 0))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// pruneListings drops the listings of an account/region that a later
+// listing of it has taken over from, once all their files are written, so
+// a long tail doesn't keep every pass's listings
+func (p *Processor) pruneListings() {
+	type pair struct {
+		ts                        *trailSettings
+		accountID, region, folder string
+	}
+	p.listingsMu.Lock()
+	defer p.listingsMu.Unlock()
+
+	latest := make(map[pair]*pairListing, len(p.listings))
+	for _, l := range p.listings {
+		latest[pair{l.ts, l.accountID, l.region, l.folder}] = l
+	}
+	kept := p.listings[:0]
+	for _, l := range p.listings {
+		if latest[pair{l.ts, l.accountID, l.region, l.folder}] != l {
+			if pending, failed := l.counts(); pending == 0 && failed == 0 {
+				continue
+			}
+		}
+		kept = append(kept, l)
+	}
+	clear(p.listings[len(kept):])
+	p.listings = kept
+}
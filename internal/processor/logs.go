@@ -0,0 +1,172 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+)
+
+// checkpoints for log groups share the state table with S3 listings, keyed
+// by this prefix plus the group name in the bucket column
+const logGroupStatePrefix = "logs:"
+
+// save the log group checkpoint every this many pages
+const logGroupSaveEvery = 100
+
+// CloudTrail delivers events within about 15 minutes of when they happen;
+// allow generous slack around the time range and checkpoint
+const deliveryDelaySlack = time.Hour
+
+// resolveLogGroups returns the enabled log groups from config
+func (p *Processor) resolveLogGroups() []config.LogGroup {
+	groups := make([]config.LogGroup, 0, len(p.config.LogGroups))
+	for _, group := range p.config.LogGroups {
+		if !group.IsEnabled() {
+			p.logger.Info("skipping disabled log group", slog.String("log_group", group.Name))
+			continue
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// newLogGroupSettings applies a log group's overrides the same way as a trail's
+func (p *Processor) newLogGroupSettings(group config.LogGroup) (*trailSettings, error) {
+	ts, err := p.newTrailSettings(config.Trail{
+		Name:         group.Name,
+		EventsDir:    group.EventsDir,
+		OutputFormat: group.OutputFormat,
+		Filters:      group.Filters,
+		StartTime:    group.StartTime,
+		EndTime:      group.EndTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ts.logGroup = &group
+	return ts, nil
+}
+
+// processLogGroup reads CloudTrail events from a log group with
+// FilterLogEvents and hands each page to the process workers
+func (p *Processor) processLogGroup(ctx context.Context, ts *trailSettings) {
+	group := ts.logGroup
+	stateKey := logGroupStatePrefix + group.Name
+
+	logger := p.logger.With(
+		slog.String("log_group", group.Name),
+		slog.String("region", group.Region))
+
+	lastKey, err := p.stateDB.GetLastProcessedKey(stateKey, "", group.Region)
+	if err != nil {
+		class := p.countError(err)
+		logger.Error("failed to get state", slog.String("error", err.Error()), slog.String("error_class", class.String()))
+		return
+	}
+
+	input := &cloudwatchlogs.FilterLogEventsInput{
+		LogGroupName: aws.String(group.Name),
+	}
+
+	// events can be ingested out of order, so resume a little before the
+	// checkpoint and let the bloom filter drop the overlap
+	var start time.Time
+	if lastMillis, err := strconv.ParseInt(lastKey, 10, 64); err == nil {
+		start = time.UnixMilli(lastMillis).Add(-deliveryDelaySlack)
+	}
+	if ts.startTime.After(start) {
+		start = ts.startTime
+	}
+	if !start.IsZero() {
+		input.StartTime = aws.Int64(start.UnixMilli())
+	}
+	if !ts.endTime.IsZero() {
+		input.EndTime = aws.Int64(ts.endTime.Add(deliveryDelaySlack).UnixMilli())
+	}
+
+	logger.Info("processing log group", slog.Time("start", start))
+
+	var opts []func(*cloudwatchlogs.Options)
+	if group.Region != "" {
+		opts = append(opts, func(o *cloudwatchlogs.Options) {
+			o.Region = group.Region
+		})
+	}
+
+	var pages, events int
+	var latest int64
+	var lane *orderLane
+	if p.config.Ordered {
+		lane = newOrderLane()
+	}
+	save := func() {
+		// acknowledged checkpoints are saved by commits
+		if latest == 0 || p.acked() {
+			return
+		}
+		if err := p.stateDB.UpdateLastProcessedKey(stateKey, "", group.Region, strconv.FormatInt(latest, 10)); err != nil {
+			logger.Error("failed to update state", slog.String("error", err.Error()))
+		}
+	}
+
+	paginator := cloudwatchlogs.NewFilterLogEventsPaginator(p.logsClient, input)
+	for paginator.HasMorePages() {
+		if err := p.control.wait(ctx); err != nil {
+			break
+		}
+		page, err := paginator.NextPage(ctx, opts...)
+		if err != nil {
+			class := p.countError(err)
+			logger.Error("failed to filter log events", slog.String("error", err.Error()), slog.String("error_class", class.String()))
+			break
+		}
+
+		records := make([]json.RawMessage, 0, len(page.Events))
+		for _, ev := range page.Events {
+			message := aws.ToString(ev.Message)
+			p.stats.BytesDownloaded.Add(int64(len(message)))
+			records = append(records, json.RawMessage(message))
+			latest = max(latest, aws.ToInt64(ev.Timestamp))
+			p.stats.observe(time.UnixMilli(aws.ToInt64(ev.Timestamp)).UTC())
+		}
+		pages++
+		events += len(records)
+
+		if len(records) > 0 {
+			p.stats.FilesDownloaded.Add(1)
+			ts.progress.downloaded.Add(1)
+			job := DownloadJob{Key: group.Name, Region: group.Region, trail: ts, lane: lane}
+			if p.acked() {
+				if job.checkpoint, err = p.trackCheckpoint(ctx, stateKey, "", group.Region, strconv.FormatInt(latest, 10)); err != nil {
+					return
+				}
+			}
+			if lane != nil {
+				job.seq = lane.add()
+			}
+			select {
+			case p.processJobs <- ProcessedFile{
+				Job:     job,
+				Records: records,
+			}:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if pages%logGroupSaveEvery == 0 {
+			save()
+		}
+	}
+
+	save()
+	ts.progress.done.Store(true)
+	logger.Info("read log group events", slog.Int("events", events))
+}
@@ -0,0 +1,43 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// signManifests writes a fresh signature next to each manifest that changed
+// since the last flush
+func (p *Processor) signManifests(manifests []string) {
+	if p.config.ManifestSigner == nil {
+		return
+	}
+	for _, manifest := range manifests {
+		if err := p.signManifest(manifest); err != nil {
+			class := p.countError(err)
+			p.logger.Error("failed to sign manifest",
+				slog.String("manifest", manifest),
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+		}
+	}
+}
+
+func (p *Processor) signManifest(manifest string) error {
+	digest, err := writer.ManifestDigest(manifest)
+	if err != nil {
+		return err
+	}
+	sig, err := p.config.ManifestSigner.Sign(context.Background(), digest)
+	if err != nil {
+		return err
+	}
+
+	path := manifest + writer.SignatureExtension
+	if err := os.WriteFile(path+".tmp", sig, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
@@ -0,0 +1,374 @@
+package processor
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// markerName is written into each partition once it's complete
+const markerName = "_SUCCESS"
+
+// pairListing follows one account/region listing through the run, so the
+// event time up to which its events have all been written is known, and its
+// partitions up to there can be marked complete
+type pairListing struct {
+	ts        *trailSettings
+	accountID string
+	region    string
+	// the log folder listed, whose checkpoint is kept apart from the others
+	folder string
+	// partitions closing at or before from were settled by earlier runs;
+	// zero to check them all
+	from time.Time
+	// no file listed after this run can hold events for partitions closing
+	// at or before watermark
+	watermark time.Time
+
+	mu sync.Mutex
+	// key time of the latest file listed, or of the checkpoint resumed from
+	through time.Time
+	// files listed and not yet written, by key; failed ones stay
+	outstanding map[string]listedFile
+	// the listing reached the end rather than stopping on an error
+	listed bool
+}
+
+type listedFile struct {
+	keyTime time.Time
+	failed  bool
+}
+
+// partitionMarker is the content of a marker file
+type partitionMarker struct {
+	Watermark time.Time    `json:"watermark"`
+	Files     []markerFile `json:"files"`
+}
+
+type markerFile struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// newListing starts following an account/region listing that resumes after
+// lastKey
+func (p *Processor) newListing(ts *trailSettings, pair AccountRegionPair, lastKey string) *pairListing {
+	l := &pairListing{
+		ts:        ts,
+		accountID: pair.AccountID,
+		region:    pair.Region,
+		folder:    pair.Folder,
+		// CloudTrail may still deliver files this long after their events
+		watermark:   time.Now().UTC().Add(-deliveryDelaySlack),
+		outstanding: make(map[string]listedFile),
+	}
+	if t, ok := logkey.Time(lastKey); ok {
+		// the last run's files could hold events up to a slack before
+		// their delivery, and its watermark trailed them by another
+		l.from = t.Add(-2 * deliveryDelaySlack)
+		l.through = t
+	}
+
+	p.listingsMu.Lock()
+	p.listings = append(p.listings, l)
+	p.listingsMu.Unlock()
+	return l
+}
+
+// add counts a file enqueued by the listing
+func (l *pairListing) add(key string) {
+	if l == nil {
+		return
+	}
+	// a key without a time holds the watermark at zero until it's written
+	keyTime, _ := logkey.Time(key)
+	l.mu.Lock()
+	l.outstanding[key] = listedFile{keyTime: keyTime}
+	if keyTime.After(l.through) {
+		l.through = keyTime
+	}
+	l.mu.Unlock()
+}
+
+// finish records that one of the listing's files has left the pipeline
+func (l *pairListing) finish(key string, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err != nil {
+		l.outstanding[key] = listedFile{keyTime: l.outstanding[key].keyTime, failed: true}
+		return
+	}
+	delete(l.outstanding, key)
+}
+
+// end records that the listing reached the end
+func (l *pairListing) end() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.listed = true
+	l.mu.Unlock()
+}
+
+// eventWatermark returns the event time up to which every event the
+// account/region's files hold has been written, zero when unknown. Files
+// are delivered within deliveryDelaySlack of their events, so it trails
+// the earliest file still in the pipeline or failed, else how far the
+// listing got, by that slack.
+func (l *pairListing) eventWatermark() time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	bound := l.through
+	if l.listed {
+		bound = l.watermark.Add(deliveryDelaySlack)
+	}
+	for _, f := range l.outstanding {
+		if f.keyTime.Before(bound) {
+			bound = f.keyTime
+		}
+	}
+	if bound.IsZero() {
+		return time.Time{}
+	}
+	w := minTime(bound.Add(-deliveryDelaySlack), l.watermark)
+	// nothing past the trail's range is read
+	if !l.ts.endTime.IsZero() {
+		w = minTime(w, l.ts.endTime)
+	}
+	return w
+}
+
+// counts returns how many of the listing's files are still in the pipeline
+// and how many failed
+func (l *pairListing) counts() (pending, failed int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, f := range l.outstanding {
+		if f.failed {
+			failed++
+		} else {
+			pending++
+		}
+	}
+	return pending, failed
+}
+
+func (p *Processor) pairListings() []*pairListing {
+	p.listingsMu.Lock()
+	defer p.listingsMu.Unlock()
+	return slices.Clone(p.listings)
+}
+
+// saveWatermarks advances each listed account/region's event watermark in
+// the state DB to the earliest of its listings'
+func (p *Processor) saveWatermarks() {
+	type pair struct{ bucket, accountID, region string }
+	watermarks := make(map[pair]time.Time)
+	for _, l := range p.pairListings() {
+		k := pair{l.ts.trail.Bucket, l.accountID, stateRegion(l.folder, l.region)}
+		w, ok := watermarks[k]
+		if !ok || l.eventWatermark().Before(w) {
+			watermarks[k] = l.eventWatermark()
+		}
+	}
+	for k, w := range watermarks {
+		if w.IsZero() {
+			continue
+		}
+		if err := p.stateDB.UpdateEventWatermark(k.bucket, k.accountID, k.region, w); err != nil {
+			p.logger.Error("failed to save event watermark",
+				slog.String("state_key", targetKey(k.bucket, k.accountID, k.region)),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// markerTarget is the output of one account/region in one events dir, which
+// several trails' listings may write to
+type markerTarget struct {
+	dir, accountID, region string
+}
+
+// writeMarkers writes or refreshes the marker in every partition of each
+// account/region that closed by the event watermark of all its listings,
+// once the writers are flushed
+func (p *Processor) writeMarkers() {
+	listings := p.pairListings()
+
+	groups := make(map[markerTarget][]*pairListing)
+	for _, l := range listings {
+		dirs := []string{l.ts.eventsDir}
+		for _, dir := range l.ts.categoryDirs {
+			dirs = append(dirs, dir)
+		}
+		for _, dir := range dirs {
+			t := markerTarget{dir, l.accountID, l.region}
+			groups[t] = append(groups[t], l)
+		}
+	}
+
+	var marked int
+	for t, group := range groups {
+		marked += p.markTarget(t, group)
+	}
+	if marked > 0 {
+		p.logger.Info("marked partitions complete", slog.Int("partitions", marked))
+	}
+}
+
+// markTarget marks the target's closed partitions, returning how many
+// markers were written
+func (p *Processor) markTarget(t markerTarget, group []*pairListing) int {
+	from, watermark := group[0].from, group[0].eventWatermark()
+	for _, l := range group[1:] {
+		if l.from.Before(from) {
+			from = l.from
+		}
+		watermark = minTime(watermark, l.eventWatermark())
+	}
+	if watermark.IsZero() {
+		return 0
+	}
+
+	var marked int
+	for _, base := range p.partitionRoots(filepath.Join(t.dir, t.accountID, t.region)) {
+		marked += p.markRoot(base, group, from, watermark)
+	}
+	return marked
+}
+
+// partitionRoots returns the dirs under an account/region dir that the date
+// folders sit in: the dir itself, or each event source folder in it
+func (p *Processor) partitionRoots(dir string) []string {
+	if p.config.Partitioning.Layout != writer.LayoutEventSource {
+		return []string{dir}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var roots []string
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), writer.EventSourcePrefix) {
+			roots = append(roots, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return roots
+}
+
+// markRoot marks the closed partitions under one partition root
+func (p *Processor) markRoot(base string, group []*pairListing, from, watermark time.Time) int {
+	var marked int
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() || path == base {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		end, ok := writer.PartitionEnd(rel)
+		if !ok {
+			return filepath.SkipDir
+		}
+		if !from.IsZero() && !end.After(from) {
+			return filepath.SkipDir
+		}
+		// only the finest date dirs hold files
+		if strings.Count(filepath.ToSlash(rel), "/") < p.config.Partitioning.Granularity.Depth()-1 || end.After(watermark) {
+			return nil
+		}
+		if !p.partitionInRange(group, end) {
+			return nil
+		}
+
+		written, err := writeMarker(path, watermark)
+		if err != nil {
+			return err
+		}
+		if written {
+			marked++
+		}
+		return filepath.SkipDir
+	})
+	if err != nil {
+		class := p.countError(err)
+		p.logger.Error("failed to write partition markers",
+			slog.String("dir", base),
+			slog.String("error", err.Error()),
+			slog.String("error_class", class.String()))
+	}
+	return marked
+}
+
+// partitionInRange reports whether the partition closing at end lies wholly
+// in every listing's time range, so filtering by time didn't leave it partial
+func (p *Processor) partitionInRange(group []*pairListing, end time.Time) bool {
+	start := p.config.Partitioning.Granularity.Start(end)
+	for _, l := range group {
+		if !l.ts.startTime.IsZero() && start.Before(l.ts.startTime) {
+			return false
+		}
+		if !l.ts.endTime.IsZero() && end.After(l.ts.endTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// writeMarker writes dir's marker listing its event files, unless one
+// listing the same files is already there
+func writeMarker(dir string, watermark time.Time) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	marker := partitionMarker{Watermark: watermark, Files: []markerFile{}}
+	for _, e := range entries {
+		if _, ok := writer.FormatFromPath(e.Name()); !ok || e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return false, err
+		}
+		marker.Files = append(marker.Files, markerFile{Name: e.Name(), Size: info.Size()})
+	}
+
+	path := filepath.Join(dir, markerName)
+	if data, err := os.ReadFile(path); err == nil {
+		var existing partitionMarker
+		if json.Unmarshal(data, &existing) == nil && slices.Equal(existing.Files, marker.Files) {
+			return false, nil
+		}
+	}
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(path+".tmp", append(data, '\n'), 0o644); err != nil {
+		return false, err
+	}
+	return true, os.Rename(path+".tmp", path)
+}
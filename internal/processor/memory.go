@@ -0,0 +1,116 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// heap use, as a fraction of the memory limit, where throttling starts
+	// and where it bottoms out at a single download
+	memoryThrottleStart = 0.70
+	memoryThrottleFull  = 0.95
+	// roughly how much larger a log file is once decompressed and parsed,
+	// used to turn the limit into a budget of compressed bytes
+	decodedExpansion = 20
+	// share of a cgroup limit given to GOMEMLIMIT when it isn't set, leaving
+	// room for memory the Go runtime doesn't manage (sqlite, buffers)
+	cgroupLimitShare = 0.9
+)
+
+// cgroup files holding the container memory limit, v2 then v1
+var cgroupLimitFiles = []string{
+	"/sys/fs/cgroup/memory.max",
+	"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+}
+
+// memoryLimit returns the soft memory limit the run should stay under:
+// GOMEMLIMIT when set, otherwise the cgroup limit, which then also becomes
+// the runtime's limit so the GC works to stay below it. Zero means no limit
+// is known.
+func (p *Processor) memoryLimit() int64 {
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return limit
+	}
+
+	cgroup := cgroupMemoryLimit()
+	if cgroup == 0 {
+		return 0
+	}
+	limit := int64(float64(cgroup) * cgroupLimitShare)
+	debug.SetMemoryLimit(limit)
+	p.logger.Info("set Go memory limit from cgroup",
+		slog.Int64("cgroup_bytes", cgroup),
+		slog.Int64("limit_bytes", limit))
+	return limit
+}
+
+// cgroupMemoryLimit reads the container's memory limit, zero when there is
+// none
+func cgroupMemoryLimit() int64 {
+	for _, path := range cgroupLimitFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		n, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// "max" in v2, and v1 reports a huge number when unlimited
+		if err != nil || n <= 0 || n >= math.MaxInt64/2 {
+			return 0
+		}
+		return n
+	}
+	return 0
+}
+
+// memoryMonitor scales worker concurrency, queue depth and the in-flight
+// byte budget down as the heap approaches limit, and back up as it recovers
+func (p *Processor) memoryMonitor(ctx context.Context, limit int64) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	throttled := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		pressure := float64(m.HeapInuse) / float64(limit)
+		scale := memoryScale(pressure)
+		p.control.setMemory(scale, max(1, int64(scale*float64(limit)/decodedExpansion)))
+
+		if scale < 1 && !throttled {
+			p.logger.Warn("memory pressure, throttling downloads",
+				slog.Uint64("heap_bytes", m.HeapInuse),
+				slog.Int64("limit_bytes", limit),
+				slog.Float64("scale", scale))
+		} else if scale == 1 && throttled {
+			p.logger.Info("memory pressure eased, throttling lifted",
+				slog.Uint64("heap_bytes", m.HeapInuse))
+		}
+		throttled = scale < 1
+	}
+}
+
+// memoryScale maps heap use as a fraction of the limit to the share of
+// workers and queue depth to keep using
+func memoryScale(pressure float64) float64 {
+	switch {
+	case pressure <= memoryThrottleStart:
+		return 1
+	case pressure >= memoryThrottleFull:
+		return 0
+	}
+	return 1 - (pressure-memoryThrottleStart)/(memoryThrottleFull-memoryThrottleStart)
+}
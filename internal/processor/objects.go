@@ -0,0 +1,140 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+)
+
+// objectLog buffers object outcomes until the next state save
+type objectLog struct {
+	mu      sync.Mutex
+	pending []state.ObjectRecord
+	// audit log entries, with Config.AuditLog
+	audit []state.AuditRecord
+	// with Config.SkipProcessed, the done objects not saved to the state DB
+	// yet, for alreadyProcessed
+	done map[objectKey]state.ObjectRecord
+}
+
+type objectKey struct {
+	bucket, key string
+}
+
+// finishFile is called once per downloaded file when it leaves the pipeline,
+// with the events written from it and the first error that lost any of them
+func (p *Processor) finishFile(job DownloadJob, events int64, err error) {
+	p.control.releaseBytes(job.inflight)
+	p.finishCheckpoint(job.checkpoint, err)
+	p.finishListed(job.listed, err)
+	job.listing.finish(job.Key, err)
+	if err != nil {
+		job.unit.finish(events, fmt.Errorf("%s: %w", job.Key, err))
+	} else {
+		job.unit.finish(events, nil)
+	}
+
+	// nothing happened to it this run worth recording
+	if job.alreadyProcessed {
+		return
+	}
+
+	if p.config.AuditLog && job.Bucket != "" {
+		p.auditFile(job, events, err)
+	}
+
+	// log group pages aren't S3 objects
+	if job.Bucket == "" || (err == nil && !p.config.RecordObjects) {
+		return
+	}
+	rec := state.ObjectRecord{
+		Bucket:       job.Bucket,
+		Key:          job.Key,
+		AccountID:    job.AccountID,
+		Region:       job.Region,
+		Status:       state.ObjectDone,
+		Events:       events,
+		ETag:         job.ETag,
+		Size:         job.Size,
+		LastModified: job.LastModified,
+	}
+	if err != nil {
+		rec.Status, rec.Error = state.ObjectFailed, err.Error()
+	}
+
+	p.objects.mu.Lock()
+	p.objects.pending = append(p.objects.pending, rec)
+	if p.config.SkipProcessed && err == nil {
+		if p.objects.done == nil {
+			p.objects.done = make(map[objectKey]state.ObjectRecord)
+		}
+		p.objects.done[objectKey{rec.Bucket, rec.Key}] = rec
+	}
+	p.objects.mu.Unlock()
+}
+
+// alreadyProcessed reports whether an earlier run, or this one, processed
+// the object without error in the version listed: the same ETag and size,
+// or without ETags the same size and LastModified
+func (p *Processor) alreadyProcessed(job DownloadJob) bool {
+	if !p.config.SkipProcessed || job.Bucket == "" || (job.ETag == "" && job.LastModified.IsZero()) {
+		return false
+	}
+
+	p.objects.mu.Lock()
+	rec, ok := p.objects.done[objectKey{job.Bucket, job.Key}]
+	p.objects.mu.Unlock()
+	if !ok {
+		stored, err := p.stateDB.GetObject(job.Bucket, job.Key)
+		if err != nil {
+			p.logger.Error("failed to look up processed object",
+				slog.String("key", job.Key),
+				slog.String("object_id", job.id),
+				slog.String("error", err.Error()))
+			return false
+		}
+		if stored == nil || stored.Status != state.ObjectDone {
+			return false
+		}
+		rec = *stored
+	}
+
+	if job.Size != rec.Size {
+		return false
+	}
+	if job.ETag != "" && rec.ETag != "" {
+		return job.ETag == rec.ETag
+	}
+	return !rec.LastModified.IsZero() && job.LastModified.Equal(rec.LastModified)
+}
+
+// saveObjects writes the queued object records and audit log entries
+func (p *Processor) saveObjects() {
+	p.objects.mu.Lock()
+	pending := p.objects.pending
+	p.objects.pending = nil
+	audit := p.objects.audit
+	p.objects.audit = nil
+	p.objects.mu.Unlock()
+
+	p.saveAudit(audit)
+	if len(pending) == 0 {
+		return
+	}
+	if err := p.stateDB.RecordObjects(pending); err != nil {
+		p.logger.Error("failed to save object records",
+			slog.Int("count", len(pending)),
+			slog.String("error", err.Error()))
+	}
+
+	p.objects.mu.Lock()
+	for _, rec := range pending {
+		k := objectKey{rec.Bucket, rec.Key}
+		if done, ok := p.objects.done[k]; ok && done == rec {
+			delete(p.objects.done, k)
+		}
+	}
+	p.objects.mu.Unlock()
+}
@@ -0,0 +1,83 @@
+package processor
+
+import "sync"
+
+// orderLane keeps one account/region's files in listing order through the
+// process workers when Config.Ordered is set. Files that arrive early are
+// held, and whichever worker brings the next one in line processes it and
+// then every held file that follows, so a lane is only ever processed by
+// one worker at a time while other lanes run in parallel.
+type orderLane struct {
+	mu sync.Mutex
+	// assigned to the next file listed
+	seq int64
+	// the file to process next
+	next int64
+	held map[int64]ProcessedFile
+	busy bool
+}
+
+func newOrderLane() *orderLane {
+	return &orderLane{held: make(map[int64]ProcessedFile)}
+}
+
+// add returns the position of the file being listed
+func (l *orderLane) add() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	seq := l.seq
+	l.seq++
+	return seq
+}
+
+// processInOrder processes the file once every file listed before it in its
+// lane has been
+func (p *Processor) processInOrder(file ProcessedFile) {
+	l := file.Job.lane
+	l.mu.Lock()
+	if file.Job.seq != l.next {
+		// a held file gives back its budget, or a lane could fill the budget
+		// with files waiting on the one that can't download for lack of it
+		p.control.releaseBytes(file.Job.inflight)
+		file.Job.inflight = 0
+	}
+	l.held[file.Job.seq] = file
+	if l.busy {
+		l.mu.Unlock()
+		return
+	}
+	l.busy = true
+	// a panic mustn't leave the lane busy, or its later files would be
+	// held forever
+	locked := true
+	defer func() {
+		if !locked {
+			l.mu.Lock()
+		}
+		l.busy = false
+		l.mu.Unlock()
+	}()
+	for {
+		next, ok := l.held[l.next]
+		if !ok {
+			return
+		}
+		delete(l.held, l.next)
+		l.next++
+		l.mu.Unlock()
+		locked = false
+		p.processFile(next)
+		l.mu.Lock()
+		locked = true
+	}
+}
+
+// skipFile finishes a file that won't be processed. In an ordered lane it
+// still goes through the process workers to give up its place in line.
+func (p *Processor) skipFile(job DownloadJob, err error) {
+	if job.lane != nil {
+		p.processJobs <- ProcessedFile{Job: job, Err: err}
+		return
+	}
+	p.finishFile(job, 0, err)
+}
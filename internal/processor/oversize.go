@@ -0,0 +1,44 @@
+package processor
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// limitSize returns the event shrunk by Config.Oversize when it's over the
+// limit, counting it when count is set. A duplicate rewritten into its
+// source-named file is shrunk the same way but was counted the first time.
+func (p *Processor) limitSize(job DownloadJob, ev *MinimalEvent, eventTime time.Time, raw json.RawMessage, count bool) json.RawMessage {
+	out, res, err := p.config.Oversize.Apply(raw, ev.EventID, eventTime)
+	if !res.Oversized {
+		return raw
+	}
+	if err != nil {
+		p.countError(err)
+		p.logger.Error("failed to externalize oversized event fields, truncating them instead",
+			slog.String("key", job.Key),
+			slog.String("object_id", job.id),
+			slog.String("event_id", ev.EventID),
+			slog.String("error", err.Error()))
+	}
+	if !count {
+		return out
+	}
+	attrs := []any{
+		slog.String("key", job.Key),
+		slog.String("object_id", job.id),
+		slog.String("event_id", ev.EventID),
+		slog.String("event_name", ev.EventName),
+		slog.Int("bytes", res.Size),
+		slog.Int("bytes_after", len(out)),
+		slog.String("fields", strings.Join(res.Fields, ",")),
+	}
+	if p.stats.EventsOversized.Add(1) == 1 {
+		p.logger.Warn("event over oversized_records.max_bytes, later ones are logged at debug level", attrs...)
+	} else {
+		p.logger.Debug("oversized event", attrs...)
+	}
+	return out
+}
@@ -0,0 +1,56 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+)
+
+// the most panicked keys kept for the run notification
+const maxPanickedFiles = 100
+
+// panicError is a panic recovered while handling a file
+type panicError struct {
+	value any
+}
+
+func (e *panicError) Error() string { return fmt.Sprintf("panic: %v", e.value) }
+
+// panickedFiles lists the keys of files whose handling panicked this run
+type panickedFiles struct {
+	mu    sync.Mutex
+	keys  []string
+	count int
+}
+
+// recovered logs a panic raised while handling the job's file, with its
+// stack, and records the key. The error it returns fails the file like any
+// other, so it's recorded as a failed object and the run goes on.
+func (p *Processor) recovered(job DownloadJob, r any) error {
+	key := job.Key
+	if job.Bucket != "" {
+		key = job.Bucket + "/" + job.Key
+	}
+	p.logger.Error("recovered from a panic handling a file, it's counted as failed",
+		slog.String("key", key),
+		slog.String("object_id", job.id),
+		slog.String("panic", fmt.Sprint(r)),
+		slog.String("stack", string(debug.Stack())))
+
+	p.panics.mu.Lock()
+	p.panics.count++
+	if len(p.panics.keys) < maxPanickedFiles {
+		p.panics.keys = append(p.panics.keys, key)
+	}
+	p.panics.mu.Unlock()
+	return classified(ErrorPanic, &panicError{value: r})
+}
+
+// PanickedFiles returns the keys of files whose handling panicked, up to
+// maxPanickedFiles, and how many there were
+func (p *Processor) PanickedFiles() ([]string, int) {
+	p.panics.mu.Lock()
+	defer p.panics.mu.Unlock()
+	return append([]string(nil), p.panics.keys...), p.panics.count
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package processor
+
+// processAlive can't look processes up here, so every run that didn't
+// record its end counts as dead
+func processAlive(pid int) bool {
+	return false
+}
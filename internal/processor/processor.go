@@ -5,15 +5,22 @@ import (
 	"fmt"
 	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
-	"github.com/aws/aws-sdk-go-v2/service/cloudtrail/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 
-	"github.com/deceptiq/gocloudtrail/internal/bloom"
+	"github.com/deceptiq/gocloudtrail/internal/alert"
 	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/derive"
+	"github.com/deceptiq/gocloudtrail/internal/detect"
+	"github.com/deceptiq/gocloudtrail/internal/oversize"
+	"github.com/deceptiq/gocloudtrail/internal/quarantine"
+	"github.com/deceptiq/gocloudtrail/internal/sink"
 	"github.com/deceptiq/gocloudtrail/internal/state"
 	"github.com/deceptiq/gocloudtrail/internal/writer"
 )
@@ -24,56 +31,309 @@ type Config struct {
 	DownloadQueueSize int
 	ProcessQueueSize  int
 	ListBatchSize     int
+	DiscoveryPageSize int
 	EventsPerFile     int
 	EventsDir         string
-	Trails            []config.Trail
+	// events dir per event category, overriding EventsDir for that category
+	CategoryDirs map[string]string
+	OutputFormat writer.Format
+	// fields csv output flattens into columns
+	CSVColumns []string
+	// encrypts output files and decrypts encrypted ones; nil writes
+	// plaintext
+	Cipher       crypt.Cipher
+	Partitioning writer.Partitioning
+	// SHA-256 of the effective config, recorded in the run history
+	ConfigHash string
+	// append every S3 object handled, its hash and what came of it to the
+	// audit log
+	AuditLog bool
+	// write each S3 log file's events to files named after it, replacing
+	// what an earlier attempt at the same file wrote
+	SourceFileNames bool
+	// events outside [StartTime, EndTime) are skipped; zero means unbounded
+	StartTime time.Time
+	EndTime   time.Time
+	Filters   config.Filters
+	// S3 key prefixes or globs listing passes over, plus each trail's own
+	SkipKeys  []string
+	Trails    []config.Trail
+	LogGroups []config.LogGroup
+	// add trails for every AWSLogs/ prefix found in the account's buckets
+	DiscoverBuckets bool
+	// check required CloudTrail fields and quarantine rejects to QuarantineDir
+	ValidateEvents bool
+	QuarantineDir  string
+	// alert conditions checked during the run, sent to Notifier when set
+	Alerts   AlertRules
+	Notifier alert.Notifier
+	// rules run over each newly written event, with matches sent to Findings
+	Detector *detect.Engine
+	Findings detect.Sink
+	// top-N summary of written events; disabled when TopN is zero
+	Analytics AnalyticsOptions
+	// every newly written event is also sent to these
+	Sinks []*sink.Buffered
+	// fields added to the events written to files, nil for none
+	Derive *derive.Deriver
+	// shrinks events over a size limit before they're written or sent,
+	// nil for no limit
+	Oversize *oversize.Limiter
+	// record every processed object in the state DB, not just failures
+	RecordObjects bool
+	// interleave the shared download workers' jobs across trails, by trail
+	// weight, and across each trail's accounts
+	FairScheduling bool
+	// folders under each account listed for log files, e.g. CloudTrail and
+	// CloudTrail-Insight; empty for logkey.DefaultFolders
+	LogFolders []string
+	// reuse each trail's discovered account/region pairs from the state DB
+	// for this long (0 = discover every run); RefreshDiscovery discovers
+	// them afresh anyway and caches the result
+	DiscoveryCacheTTL time.Duration
+	RefreshDiscovery  bool
+	// hold each checkpoint back before files delivered within this long,
+	// so files that show up out of key order near the head of a listing
+	// aren't passed by the next run (0 = off)
+	SettleWindow time.Duration
+	// readiness, status and watchdog keepalives for systemd
+	Service ServiceOptions
+	// skip downloading objects recorded as processed in the same version;
+	// needs RecordObjects
+	SkipProcessed bool
+	// what to do with log files delivered behind a checkpoint, and how far
+	// before it to look for them
+	LateDelivery LateDelivery
+	LateLookback time.Duration
+	// tries per S3 download and per listing page, overriding the client's
+	// retryer when non-zero
+	GetObjectMaxAttempts   int
+	ListObjectsMaxAttempts int
+	// downloads started per second, zero for no limit
+	DownloadRateLimit float64
+	// pause downloads while an output volume has fewer bytes free, zero
+	// to never check
+	MinFreeDisk uint64
+	// upload output files as they're written instead of keeping them;
+	// disabled when Spill.Bucket is empty
+	Spill SpillOptions
+	// send events only to Sinks, writing no files, and save checkpoints
+	// once the sinks have acknowledged everything before them
+	StreamOnly bool
+	// save checkpoints, with files written too, only once each file's
+	// events are on disk and acknowledged by every sink
+	AtLeastOnce bool
+	// listing waits while this many checkpoints are unacknowledged, zero
+	// for no limit
+	MaxUnacked int
+	// otherwise, save a listing's checkpoint every this many files finished
+	// (zero for only when the listing's files are) and every
+	// CheckpointInterval (zero for never)
+	CheckpointEveryFiles int
+	CheckpointInterval   time.Duration
+	// process each account/region's (and log group's) files one at a time
+	// in listing order, so sinks get their events in key order
+	Ordered bool
+	// keep a SHA256SUMS manifest in each partition dir, signed by
+	// ManifestSigner after each flush when set
+	Checksums      bool
+	ManifestSigner crypt.Signer
+	// check and repair the output files of runs that died before they
+	// finished, before writing
+	CheckOutput bool
+	// which account's partitions each event goes to
+	AccountRouting AccountRouting
+	// write a _SUCCESS marker into each hour partition once no more events
+	// can arrive for it
+	PartitionMarkers bool
 }
 
 type Processor struct {
 	s3Client     *s3.Client
 	ctClient     *cloudtrail.Client
+	logsClient   *cloudwatchlogs.Client
 	stateDB      *state.DB
-	bloomFilter  *bloom.Filter
-	jsonlWriter  *writer.JSONLWriter
+	dedup        DedupStore
+	writersMu    sync.Mutex
+	writers      map[string]*writer.JSONLWriter
+	quarantine   *quarantine.Writer
+	analytics    *analytics
+	objects      objectLog
+	control      control
+	stream       streamState
+	checkpoints  checkpoints
+	panics       panickedFiles
+	settle       settling
+	denied       deniedPairs
+	credentials  credentials
+	reopened     reopenedPartitions
 	stats        *Stats
 	config       Config
 	logger       *slog.Logger
 	downloadJobs chan DownloadJob
 	processJobs  chan ProcessedFile
+	// feeds downloadJobs when scheduling fairly, nil otherwise
+	fair *fairQueue
+	// output files waiting for the spill uploaders
+	spillFiles chan spillFile
+	spillWG    sync.WaitGroup
+	// held shared while sending to spillFiles and exclusively to close it
+	spillSend   sync.RWMutex
+	spillClosed bool
+	// files whose upload failed, queued again on the next flush
+	spillRetryMu sync.Mutex
+	spillRetry   []spillFile
+	// account/region listings followed for partition markers
+	listingsMu sync.Mutex
+	listings   []*pairListing
+	// the trails and log groups of the current run
+	settingsMu sync.Mutex
+	settings   []*trailSettings
+	// counter values last added to the lifetime totals
+	countersMu    sync.Mutex
+	savedCounters map[string]int64
+	// this run's row in the run history, 0 when it couldn't be recorded
+	runID int64
+	// listing errors during discovery, which keep its result out of the cache
+	discoveryErrors atomic.Int64
 }
 
 func New(
 	s3Client *s3.Client,
 	ctClient *cloudtrail.Client,
+	logsClient *cloudwatchlogs.Client,
 	stateDB *state.DB,
-	bloomFilter *bloom.Filter,
+	dedup DedupStore,
 	config Config,
 	logger *slog.Logger,
 ) *Processor {
-	return &Processor{
+	p := &Processor{
 		s3Client:     s3Client,
 		ctClient:     ctClient,
+		logsClient:   logsClient,
 		stateDB:      stateDB,
-		bloomFilter:  bloomFilter,
-		jsonlWriter:  writer.New(config.EventsDir, config.EventsPerFile, logger),
+		dedup:        dedup,
+		writers:      make(map[string]*writer.JSONLWriter),
 		stats:        &Stats{StartTime: time.Now()},
 		config:       config,
 		logger:       logger,
 		downloadJobs: make(chan DownloadJob, config.DownloadQueueSize),
 		processJobs:  make(chan ProcessedFile, config.ProcessQueueSize),
 	}
+	if config.FairScheduling {
+		// jobs wait in the fair queue, so the workers' channel only needs to
+		// keep them busy
+		p.downloadJobs = make(chan DownloadJob, max(config.DownloadWorkers, 1))
+		p.fair = newFairQueue(p.downloadJobs, config.DownloadQueueSize)
+	}
+	p.control.init(config.DownloadRateLimit, config.Spill.Quota)
+	if config.Spill.Bucket != "" {
+		p.spillFiles = make(chan spillFile, config.ProcessQueueSize)
+	}
+	if config.ValidateEvents {
+		p.quarantine = quarantine.New(config.QuarantineDir)
+	}
+	if config.Analytics.TopN > 0 {
+		p.analytics = newAnalytics()
+	}
+	return p
+}
+
+// getObjectOptions and listOptions apply Config's per-operation retry
+// attempts to an S3 call
+func (p *Processor) getObjectOptions() []func(*s3.Options) {
+	return retryAttempts(p.config.GetObjectMaxAttempts)
+}
+
+func (p *Processor) listOptions() []func(*s3.Options) {
+	return retryAttempts(p.config.ListObjectsMaxAttempts)
+}
+
+func retryAttempts(n int) []func(*s3.Options) {
+	if n <= 0 {
+		return nil
+	}
+	return []func(*s3.Options){func(o *s3.Options) { o.RetryMaxAttempts = n }}
 }
 
 // Run executes the processing pipeline
 func (p *Processor) Run(ctx context.Context, progressInterval, flushInterval, bloomSaveInterval time.Duration) error {
+	return p.run(ctx, "run", progressInterval, flushInterval, bloomSaveInterval, p.resolveSettings, p.discoverAndProcess)
+}
+
+// run starts the workers and background tasks, lets produce enqueue jobs
+// for the sources returned by resolve, then drains the pipeline and saves
+// state. command names the run in the run history.
+func (p *Processor) run(ctx context.Context, command string, progressInterval, flushInterval, bloomSaveInterval time.Duration,
+	resolve func(context.Context) ([]*trailSettings, error),
+	produce func(context.Context, []*trailSettings) error) (err error) {
+	if p.config.Service.Notifier != nil {
+		// closed last, once state is saved
+		serviceDone := make(chan struct{})
+		defer close(serviceDone)
+		go p.serviceMonitor(ctx, serviceDone)
+	}
+	p.startLifetime()
+	p.startRunRecord(command)
+	if p.spillFiles != nil {
+		p.startSpill(ctx)
+	}
 	defer func() {
 		p.logger.Info("flushing buffers and saving state")
-		if err := p.jsonlWriter.FlushAll(); err != nil {
-			p.logger.Error("failed to flush JSONL buffers", slog.String("error", err.Error()))
+		p.flushWriters()
+		p.stopSpill()
+		if p.config.Spill.Catalog != nil {
+			regCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			p.registerPartitions(regCtx)
+			cancel()
+		}
+		if p.config.PartitionMarkers {
+			p.writeMarkers()
+		}
+		if p.config.Notifier != nil {
+			notifyCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			p.notifyReopened(notifyCtx)
+			cancel()
+		}
+		if p.acked() {
+			p.commitStream()
+		}
+		for _, s := range p.config.Sinks {
+			if err := s.Close(); err != nil {
+				p.logger.Error("failed to close sink", slog.String("sink", s.Name), slog.String("error", err.Error()))
+			}
 		}
-		if err := p.bloomFilter.Save(); err != nil {
-			p.logger.Error("failed to save bloom filter", slog.String("error", err.Error()))
+		p.reportDenied()
+		p.reportSkips()
+		p.emitSummary()
+		if p.config.Findings != nil {
+			if err := p.config.Findings.Close(); err != nil {
+				p.logger.Error("failed to close findings", slog.String("error", err.Error()))
+			}
 		}
+		if p.quarantine != nil {
+			if err := p.quarantine.Close(); err != nil {
+				p.logger.Error("failed to close quarantine", slog.String("error", err.Error()))
+			}
+		}
+		// with acknowledged checkpoints the filter is saved by commits, so
+		// it never holds events the sinks didn't acknowledge
+		if !p.acked() {
+			if err := p.dedup.Save(); err != nil {
+				p.logger.Error("failed to save bloom filter", slog.String("error", err.Error()))
+			}
+		}
+		p.closeDedup()
+		if !p.acked() {
+			p.saveCheckpoints()
+		}
+		p.saveObjects()
+		p.saveWatermarks()
+		if err := p.stateDB.SaveRunMetrics(p.stats.RunMetrics()); err != nil {
+			p.logger.Error("failed to save run metrics", slog.String("error", err.Error()))
+		}
+		p.saveCounters()
+		p.finishRunRecord(err)
 		_ = p.stateDB.Close()
 		p.logger.Info("state saved successfully")
 	}()
@@ -86,16 +346,79 @@ func (p *Processor) Run(ctx context.Context, progressInterval, flushInterval, bl
 	flushCtx, flushCancel := context.WithCancel(ctx)
 	defer flushCancel()
 	go p.jsonlFlusher(flushCtx, flushInterval)
+	for _, s := range p.config.Sinks {
+		go p.sinkFlusher(flushCtx, s, flushInterval)
+	}
 
 	bloomCtx, bloomCancel := context.WithCancel(ctx)
 	defer bloomCancel()
-	go p.bloomSaver(bloomCtx, bloomSaveInterval)
+	go p.stateSaver(bloomCtx, bloomSaveInterval)
+	if p.config.CheckpointInterval > 0 && !p.acked() {
+		go p.checkpointSaver(bloomCtx, p.config.CheckpointInterval)
+	}
 
-	// start downloader workers
+	alertCtx, alertCancel := context.WithCancel(ctx)
+	defer alertCancel()
+	go p.alertMonitor(alertCtx)
+
+	credentialsCtx, credentialsCancel := context.WithCancel(ctx)
+	defer credentialsCancel()
+	go p.credentialsRefresher(credentialsCtx, time.Minute)
+
+	if limit := p.memoryLimit(); limit > 0 {
+		memoryCtx, memoryCancel := context.WithCancel(ctx)
+		defer memoryCancel()
+		go p.memoryMonitor(memoryCtx, limit)
+	}
+
+	if p.analytics != nil && p.config.Analytics.Interval > 0 {
+		summaryCtx, summaryCancel := context.WithCancel(ctx)
+		defer summaryCancel()
+		go p.summaryReporter(summaryCtx, p.config.Analytics.Interval)
+	}
+
+	settings, err := resolve(ctx)
+	if err != nil {
+		return err
+	}
+	p.settingsMu.Lock()
+	p.settings = settings
+	p.settingsMu.Unlock()
+	p.checkOutput(settings)
+	if p.spillFiles != nil {
+		p.respill(settings)
+	}
+
+	if p.config.MinFreeDisk > 0 {
+		diskCtx, diskCancel := context.WithCancel(ctx)
+		defer diskCancel()
+		go p.diskMonitor(diskCtx, settings)
+	}
+
+	// start downloader workers, shared plus any dedicated per-trail pools
 	var downloadWg sync.WaitGroup
+	downloadWorkers := p.config.DownloadWorkers
 	for range p.config.DownloadWorkers {
 		downloadWg.Add(1)
-		go p.downloadWorker(ctx, &downloadWg)
+		go p.downloadWorker(ctx, p.downloadJobs, &downloadWg)
+	}
+	for _, ts := range settings {
+		if ts.trail.DownloadWorkers == 0 {
+			continue
+		}
+		ts.downloadJobs = make(chan DownloadJob, p.config.DownloadQueueSize)
+		downloadWorkers += ts.trail.DownloadWorkers
+		for range ts.trail.DownloadWorkers {
+			downloadWg.Add(1)
+			go p.downloadWorker(ctx, ts.downloadJobs, &downloadWg)
+		}
+	}
+	p.control.setPools(downloadWorkers, p.config.ProcessWorkers)
+	if p.fair != nil {
+		go p.fair.dispatch()
+		// a run that's cancelled or fails never reaches the close below,
+		// and would leave the dispatcher waiting for jobs
+		defer p.fair.close()
 	}
 
 	// start processor workers
@@ -104,9 +427,10 @@ func (p *Processor) Run(ctx context.Context, progressInterval, flushInterval, bl
 		processWg.Add(1)
 		go p.processWorker(&processWg)
 	}
+	p.notifyService(p.config.Service.Notifier.Ready("processing"))
 
 	// discover and enqueue jobs
-	if err := p.discoverAndProcess(ctx); err != nil {
+	if err := produce(ctx, settings); err != nil {
 		if ctx.Err() == context.Canceled {
 			return context.Canceled
 		}
@@ -114,7 +438,16 @@ func (p *Processor) Run(ctx context.Context, progressInterval, flushInterval, bl
 	}
 
 	// wait for pipeline to drain
-	close(p.downloadJobs)
+	if p.fair != nil {
+		p.fair.close()
+	} else {
+		close(p.downloadJobs)
+	}
+	for _, ts := range settings {
+		if ts.downloadJobs != p.downloadJobs {
+			close(ts.downloadJobs)
+		}
+	}
 	downloadWg.Wait()
 
 	close(p.processJobs)
@@ -123,130 +456,205 @@ func (p *Processor) Run(ctx context.Context, progressInterval, flushInterval, bl
 	return nil
 }
 
+// resolveSettings returns the effective settings of every configured trail
+// and log group
+func (p *Processor) resolveSettings(ctx context.Context) ([]*trailSettings, error) {
+	trails, err := p.resolveTrails(ctx)
+	if err != nil {
+		return nil, err
+	}
+	settings := make([]*trailSettings, 0, len(trails))
+	for _, trail := range trails {
+		ts, err := p.newTrailSettings(trail)
+		if err != nil {
+			return nil, fmt.Errorf("trail %s: %w", trail.Name, err)
+		}
+		settings = append(settings, ts)
+	}
+	for _, group := range p.resolveLogGroups() {
+		ts, err := p.newLogGroupSettings(group)
+		if err != nil {
+			return nil, fmt.Errorf("log group %s: %w", group.Name, err)
+		}
+		settings = append(settings, ts)
+	}
+	return settings, nil
+}
+
 func (p *Processor) Stats() *Stats {
 	return p.stats
 }
 
-func (p *Processor) discoverAndProcess(ctx context.Context) error {
-	// If trails are provided in config, use those instead of API discovery
-	if len(p.config.Trails) > 0 {
-		p.logger.Info("processing trails from config", slog.Int("count", len(p.config.Trails)))
+func (p *Processor) discoverAndProcess(ctx context.Context, settings []*trailSettings) error {
+	var wg sync.WaitGroup
+	for _, ts := range settings {
+		wg.Add(1)
+		go func(ts *trailSettings) {
+			defer wg.Done()
+			if ts.logGroup != nil {
+				p.processLogGroup(ctx, ts)
+				return
+			}
+			p.processTrail(ctx, ts)
+		}(ts)
+	}
 
-		var wg sync.WaitGroup
-		for _, trail := range p.config.Trails {
-			wg.Add(1)
-			go func(t config.Trail) {
-				defer wg.Done()
-				p.processConfigTrail(ctx, t)
-			}(trail)
+	wg.Wait()
+	return nil
+}
+
+// resolveTrails returns the trails from config, falling back to API discovery
+// when neither trails nor log groups are configured
+func (p *Processor) resolveTrails(ctx context.Context) ([]config.Trail, error) {
+	trails, err := p.configuredTrails(ctx)
+	if err != nil || !p.config.DiscoverBuckets {
+		return trails, err
+	}
+
+	discovered, err := config.DiscoverBuckets(ctx, p.s3Client, p.logger)
+	if err != nil {
+		return nil, fmt.Errorf("discover buckets: %w", err)
+	}
+	// trails already listed keep their settings
+	seen := make(map[string]bool, len(trails))
+	for _, trail := range trails {
+		seen[trail.Bucket+"/"+trail.LogPrefix()] = true
+	}
+	for _, trail := range discovered {
+		if seen[trail.Bucket+"/"+trail.LogPrefix()] {
+			continue
 		}
+		trails = append(trails, trail)
+	}
+	p.logger.Info("discovered trail buckets",
+		slog.Int("found", len(discovered)),
+		slog.Int("trails", len(trails)))
+	return trails, nil
+}
 
-		wg.Wait()
-		return nil
+// configuredTrails returns the enabled trails from the config, or when none
+// are configured (and buckets aren't being discovered instead) the trails
+// DescribeTrails reports
+func (p *Processor) configuredTrails(ctx context.Context) ([]config.Trail, error) {
+	if len(p.config.Trails) > 0 || len(p.config.LogGroups) > 0 || p.config.DiscoverBuckets {
+		trails := make([]config.Trail, 0, len(p.config.Trails))
+		for _, trail := range p.config.Trails {
+			if !trail.IsEnabled() {
+				p.logger.Info("skipping disabled trail", slog.String("trail", trail.Name))
+				continue
+			}
+			trails = append(trails, trail)
+		}
+		p.logger.Info("processing trails from config", slog.Int("count", len(trails)))
+		return trails, nil
 	}
 
-	// Fall back to API discovery
 	p.logger.Info("discovering CloudTrail trails via API")
 
 	resp, err := p.ctClient.DescribeTrails(ctx, &cloudtrail.DescribeTrailsInput{})
 	if err != nil {
-		return fmt.Errorf("describe trails: %w", err)
+		return nil, fmt.Errorf("describe trails: %w", err)
 	}
 
 	p.logger.Info("discovered trails", slog.Int("count", len(resp.TrailList)))
 
-	var wg sync.WaitGroup
+	trails := make([]config.Trail, 0, len(resp.TrailList))
 	for _, trail := range resp.TrailList {
-		wg.Add(1)
-		go func(t types.Trail) {
-			defer wg.Done()
-			p.processTrail(ctx, t)
-		}(trail)
+		trails = append(trails, config.Trail{
+			Name:       aws.ToString(trail.Name),
+			Bucket:     aws.ToString(trail.S3BucketName),
+			Prefix:     aws.ToString(trail.S3KeyPrefix),
+			ARN:        aws.ToString(trail.TrailARN),
+			HomeRegion: aws.ToString(trail.HomeRegion),
+		})
 	}
-
-	wg.Wait()
-	return nil
+	return trails, nil
 }
 
-func (p *Processor) processConfigTrail(ctx context.Context, trail config.Trail) {
-	trailName := trail.Name
-	bucketName := trail.Bucket
-	prefix := trail.Prefix
-
-	p.logger.Info("processing trail",
-		slog.String("trail", trailName),
-		slog.String("bucket", bucketName),
-		slog.String("prefix", prefix))
-
-	basePrefix := ""
-	if prefix != "" {
-		basePrefix = prefix + "/"
+// discoverTrailPairs finds the account/region combinations with data for a
+// trail, or takes them from its accounts and regions lists where it has them
+func (p *Processor) discoverTrailPairs(ctx context.Context, trail config.Trail) (string, []AccountRegionPair) {
+	basePrefix := trail.LogPrefix()
+
+	// configured regions need no listing to cache
+	caching := p.config.DiscoveryCacheTTL > 0 && len(trail.Regions) == 0
+	scope := p.discoveryScope(trail)
+	if caching && !p.config.RefreshDiscovery {
+		if pairs, ok := p.cachedPairs(trail, basePrefix, scope); ok {
+			return basePrefix, pairs
+		}
+	}
+	listErrors := p.discoveryErrors.Load()
+
+	var accounts []accountDir
+	if len(trail.Accounts) > 0 {
+		var err error
+		if accounts, err = configuredAccounts(trail.Accounts); err != nil {
+			p.logger.Error("invalid trail accounts",
+				slog.String("trail", trail.Name),
+				slog.String("error", err.Error()))
+			return basePrefix, nil
+		}
+		p.logger.Info("using configured accounts",
+			slog.String("trail", trail.Name),
+			slog.Int("count", len(accounts)))
+	} else {
+		maxDepth := defaultOrgDepth
+		if trail.DiscoveryDepth > 0 {
+			maxDepth = trail.DiscoveryDepth
+		}
+		var orgID string
+		accounts, orgID = p.discoverAccounts(ctx, trail.Bucket, basePrefix, maxDepth)
+		if orgID != "" {
+			p.logger.Info("AWS Organization detected",
+				slog.String("trail", trail.Name),
+				slog.String("org_id", orgID))
+		}
+		p.logger.Info("discovered accounts",
+			slog.String("trail", trail.Name),
+			slog.Int("count", len(accounts)))
+		if len(accounts) == 0 {
+			p.logger.Warn("no account folders found under trail prefix",
+				slog.String("trail", trail.Name),
+				slog.String("bucket", trail.Bucket),
+				slog.String("prefix", basePrefix))
+		}
 	}
-	basePrefix += "AWSLogs/"
 
-	// discover accounts
-	accounts, orgID := p.discoverAccounts(ctx, bucketName, basePrefix)
-	if orgID != "" {
-		p.logger.Info("AWS Organization detected",
-			slog.String("trail", trailName),
-			slog.String("org_id", orgID))
+	// configured regions are read whether or not they have data yet
+	if len(trail.Regions) > 0 {
+		pairs := p.configuredPairs(accounts, trail.Regions)
+		p.logger.Info("using configured regions",
+			slog.String("trail", trail.Name),
+			slog.Int("count", len(pairs)))
+		return basePrefix, pairs
 	}
-	p.logger.Info("discovered accounts",
-		slog.String("trail", trailName),
-		slog.Int("count", len(accounts)))
 
 	// discover account/region pairs that actually have data
-	pairs := p.discoverAccountRegions(ctx, bucketName, basePrefix, accounts, orgID)
+	pairs := p.discoverAccountRegions(ctx, trail.Bucket, basePrefix, accounts)
 	p.logger.Info("discovered account/region combinations with data",
-		slog.String("trail", trailName),
+		slog.String("trail", trail.Name),
 		slog.Int("count", len(pairs)))
 
-	// process only the account/region pairs that have data
-	var wg sync.WaitGroup
-	for _, pair := range pairs {
-		wg.Add(1)
-		go func(pr AccountRegionPair) {
-			defer wg.Done()
-			p.processAccountRegion(ctx, bucketName, basePrefix, pr.AccountID, pr.Region, orgID)
-		}(pair)
+	// a failed listing or an empty prefix isn't worth keeping for the TTL
+	if caching && len(pairs) > 0 && p.discoveryErrors.Load() == listErrors {
+		p.cachePairs(trail, basePrefix, scope, pairs)
 	}
-	wg.Wait()
-
-	p.logger.Info("finished processing trail", slog.String("trail", trailName))
+	return basePrefix, pairs
 }
 
-func (p *Processor) processTrail(ctx context.Context, trail types.Trail) {
-	trailName := aws.ToString(trail.Name)
-	bucketName := aws.ToString(trail.S3BucketName)
-	prefix := aws.ToString(trail.S3KeyPrefix)
-
+func (p *Processor) processTrail(ctx context.Context, ts *trailSettings) {
+	trail := ts.trail
 	p.logger.Info("processing trail",
-		slog.String("trail", trailName),
-		slog.String("bucket", bucketName),
-		slog.String("prefix", prefix))
+		slog.String("trail", trail.Name),
+		slog.String("bucket", trail.Bucket),
+		slog.String("prefix", trail.Prefix))
 
-	basePrefix := ""
-	if prefix != "" {
-		basePrefix = prefix + "/"
+	if !ts.tailing {
+		p.checkTrailHealth(ctx, ts)
 	}
-	basePrefix += "AWSLogs/"
-
-	// discover accounts
-	accounts, orgID := p.discoverAccounts(ctx, bucketName, basePrefix)
-	if orgID != "" {
-		p.logger.Info("AWS Organization detected",
-			slog.String("trail", trailName),
-			slog.String("org_id", orgID))
-	}
-	p.logger.Info("discovered accounts",
-		slog.String("trail", trailName),
-		slog.Int("count", len(accounts)))
-
-	// discover account/region pairs that actually have data
-	pairs := p.discoverAccountRegions(ctx, bucketName, basePrefix, accounts, orgID)
-	p.logger.Info("discovered account/region combinations with data",
-		slog.String("trail", trailName),
-		slog.Int("count", len(pairs)))
+	basePrefix, pairs := p.discoverTrailPairs(ctx, trail)
+	ts.progress.pairs.Store(int64(len(pairs)))
 
 	// process only the account/region pairs that have data
 	var wg sync.WaitGroup
@@ -254,12 +662,14 @@ func (p *Processor) processTrail(ctx context.Context, trail types.Trail) {
 		wg.Add(1)
 		go func(pr AccountRegionPair) {
 			defer wg.Done()
-			p.processAccountRegion(ctx, bucketName, basePrefix, pr.AccountID, pr.Region, orgID)
+			p.processAccountRegion(ctx, ts, basePrefix, pr)
+			ts.progress.pairsDone.Add(1)
 		}(pair)
 	}
 	wg.Wait()
+	ts.progress.done.Store(true)
 
-	p.logger.Info("finished processing trail", slog.String("trail", trailName))
+	p.logger.Info("finished processing trail", slog.String("trail", trail.Name))
 }
 
 func isNumeric(s string) bool {
@@ -0,0 +1,98 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/alert"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// reopenedPartitions tracks the closed partitions late events were appended
+// to this run
+type reopenedPartitions struct {
+	mu   sync.Mutex
+	dirs map[string]bool
+	// reopened partitions waiting for a partition_reopened alert
+	pending []alert.Alert
+}
+
+// partitionAppended is called by the writers after a file lands in a
+// partition that already held files. The partition counts as reopened when
+// it was marked complete, or closed longer ago than CloudTrail's delivery
+// slack; its marker then gets the new file list and watermark.
+func (p *Processor) partitionAppended(eventsDir, dir string) {
+	_, markerErr := os.Stat(filepath.Join(dir, markerName))
+	marked := markerErr == nil
+	_, end, ok := writer.PartitionRange(dir)
+	if !marked && (!ok || !end.Add(deliveryDelaySlack).Before(p.stats.StartTime)) {
+		return
+	}
+
+	watermark := time.Now().UTC().Add(-deliveryDelaySlack)
+	if marked {
+		if _, err := writeMarker(dir, watermark); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			p.logger.Error("failed to update reopened partition marker",
+				slog.String("dir", dir),
+				slog.String("error", err.Error()))
+		}
+	}
+
+	r := &p.reopened
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.dirs == nil {
+		r.dirs = make(map[string]bool)
+	}
+	if r.dirs[dir] {
+		return
+	}
+	r.dirs[dir] = true
+	p.stats.PartitionsReopened.Add(1)
+
+	partition := dir
+	if rel, err := filepath.Rel(eventsDir, dir); err == nil {
+		partition = filepath.ToSlash(rel)
+	}
+	p.logger.Info("late events appended to a closed partition",
+		slog.String("events_dir", eventsDir),
+		slog.String("partition", partition),
+		slog.Bool("marked", marked))
+
+	if p.config.Notifier != nil && p.config.Alerts.PartitionReopened {
+		r.pending = append(r.pending, alert.Alert{
+			Condition: "partition_reopened",
+			Message:   "late events were appended to " + partition,
+			Time:      time.Now().UTC(),
+			Details: map[string]any{
+				"events_dir": eventsDir,
+				"partition":  partition,
+				"watermark":  watermark,
+			},
+		})
+	}
+}
+
+// notifyReopened sends the partition_reopened alerts queued since the last
+// call
+func (p *Processor) notifyReopened(ctx context.Context) {
+	r := &p.reopened
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = nil
+	r.mu.Unlock()
+
+	for _, a := range pending {
+		if err := p.config.Notifier.Notify(ctx, a); err != nil {
+			p.logger.Error("failed to send alert",
+				slog.String("condition", a.Condition),
+				slog.String("error", err.Error()))
+		}
+	}
+}
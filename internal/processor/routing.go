@@ -0,0 +1,75 @@
+package processor
+
+import "fmt"
+
+// AccountField is an event field naming the account whose partitions the
+// event is written to
+type AccountField int
+
+const (
+	// recipientAccountId, the account the event was delivered for
+	AccountRecipient AccountField = iota
+	// userIdentity.accountId, the caller's account
+	AccountPrincipal
+)
+
+// AccountRouting picks the accounts an event is partitioned under
+type AccountRouting struct {
+	// fields tried in order, the first one set owning the event; empty for
+	// recipient, then principal
+	Order []AccountField
+	// also write an event whose recipient and principal accounts differ
+	// under the account that doesn't own it
+	CrossAccountBoth bool
+}
+
+// ParseAccountRouting reads partition_account and partition_cross_account
+func ParseAccountRouting(order []string, both bool) (AccountRouting, error) {
+	r := AccountRouting{CrossAccountBoth: both}
+	for _, s := range order {
+		var f AccountField
+		switch s {
+		case "recipient":
+			f = AccountRecipient
+		case "principal":
+			f = AccountPrincipal
+		default:
+			return AccountRouting{}, fmt.Errorf("unknown partition_account %q (want recipient or principal)", s)
+		}
+		r.Order = append(r.Order, f)
+	}
+	return r, nil
+}
+
+// accounts returns the account that owns the event, then the other one when
+// it's written under both; none when no field in the order is set
+func (r AccountRouting) accounts(e *MinimalEvent) []string {
+	order := r.Order
+	if len(order) == 0 {
+		order = []AccountField{AccountRecipient, AccountPrincipal}
+	}
+	var owner string
+	for _, f := range order {
+		if owner = e.account(f); owner != "" {
+			break
+		}
+	}
+	if owner == "" {
+		return nil
+	}
+	if r.CrossAccountBoth {
+		for _, f := range []AccountField{AccountRecipient, AccountPrincipal} {
+			if other := e.account(f); other != "" && other != owner {
+				return []string{owner, other}
+			}
+		}
+	}
+	return []string{owner}
+}
+
+func (e *MinimalEvent) account(f AccountField) string {
+	if f == AccountPrincipal {
+		return e.UserIdentity.AccountID
+	}
+	return e.RecipientAccountID
+}
@@ -0,0 +1,212 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+)
+
+// fairQueue feeds the shared download workers by weighted fair queuing
+// across trails, and round robin across each trail's accounts, so a trail
+// listing hundreds of account/regions at once can't crowd out a small one.
+// Each trail queues up to limit jobs before its listers wait; a dispatcher
+// moves jobs to the workers' channel one at a time, always from the trail
+// that has had the smallest share for its weight.
+type fairQueue struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	flows map[*trailSettings]*trailFlow
+	// pass of the flow dispatched last; a flow that goes idle and comes
+	// back starts from here rather than with credit saved up
+	vtime  float64
+	limit  int
+	closed bool
+	out    chan DownloadJob
+}
+
+// trailFlow is one trail's queued jobs, by account
+type trailFlow struct {
+	// advances by 1/weight per job dispatched
+	pass   float64
+	weight float64
+	queued int
+	// accounts with jobs queued, served in turn
+	accounts []*accountFlow
+	byID     map[string]*accountFlow
+	next     int
+}
+
+type accountFlow struct {
+	id   string
+	jobs []DownloadJob
+}
+
+func newFairQueue(out chan DownloadJob, limit int) *fairQueue {
+	q := &fairQueue{
+		flows: make(map[*trailSettings]*trailFlow),
+		limit: max(limit, 1),
+		out:   out,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// errQueueClosed is returned by push once the run has stopped taking jobs
+var errQueueClosed = errors.New("download queue closed")
+
+// push queues a listed job, waiting while its trail has limit jobs queued
+func (q *fairQueue) push(ctx context.Context, job DownloadJob) error {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		q.cond.Broadcast()
+		q.mu.Unlock()
+	})
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	f := q.flows[job.trail]
+	if f == nil {
+		f = &trailFlow{weight: float64(max(job.trail.trail.Weight, 1)), byID: make(map[string]*accountFlow)}
+		q.flows[job.trail] = f
+	}
+	for f.queued >= q.limit && ctx.Err() == nil && !q.closed {
+		q.cond.Wait()
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if q.closed {
+		return errQueueClosed
+	}
+
+	if f.queued == 0 {
+		f.pass = max(f.pass, q.vtime)
+	}
+	a := f.byID[job.AccountID]
+	if a == nil {
+		a = &accountFlow{id: job.AccountID}
+		f.byID[job.AccountID] = a
+	}
+	if len(a.jobs) == 0 {
+		f.accounts = append(f.accounts, a)
+	}
+	a.jobs = append(a.jobs, job)
+	f.queued++
+	q.cond.Broadcast()
+	return nil
+}
+
+// close lets the dispatcher close the workers' channel once the queued jobs
+// are handed out, and wakes listers waiting to push. It's safe to call
+// more than once.
+func (q *fairQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+}
+
+// dispatch hands queued jobs to the workers until the queue is closed and
+// empty
+func (q *fairQueue) dispatch() {
+	for {
+		q.mu.Lock()
+		var f *trailFlow
+		for {
+			f = q.nextFlow()
+			if f != nil || q.closed {
+				break
+			}
+			q.cond.Wait()
+		}
+		if f == nil {
+			q.mu.Unlock()
+			close(q.out)
+			return
+		}
+		job := f.pop()
+		q.vtime = f.pass
+		f.pass += 1 / f.weight
+		q.cond.Broadcast()
+		q.mu.Unlock()
+
+		q.out <- job
+	}
+}
+
+// nextFlow returns the flow with jobs queued that is furthest behind its
+// share
+func (q *fairQueue) nextFlow() *trailFlow {
+	var next *trailFlow
+	for _, f := range q.flows {
+		if f.queued > 0 && (next == nil || f.pass < next.pass) {
+			next = f
+		}
+	}
+	return next
+}
+
+// pop takes the next job from the account whose turn it is
+func (f *trailFlow) pop() DownloadJob {
+	if f.next >= len(f.accounts) {
+		f.next = 0
+	}
+	a := f.accounts[f.next]
+	job := a.jobs[0]
+	a.jobs[0] = DownloadJob{}
+	a.jobs = a.jobs[1:]
+	f.queued--
+	if len(a.jobs) == 0 {
+		f.accounts = append(f.accounts[:f.next], f.accounts[f.next+1:]...)
+	} else {
+		f.next++
+	}
+	return job
+}
+
+// len returns how many jobs are waiting across all trails
+func (q *fairQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var n int
+	for _, f := range q.flows {
+		n += f.queued
+	}
+	return n
+}
+
+// queued returns how many of the trail's jobs are waiting for a worker
+func (q *fairQueue) queued(ts *trailSettings) int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if f := q.flows[ts]; f != nil {
+		return int64(f.queued)
+	}
+	return 0
+}
+
+// enqueue hands a listed job to its trail's download workers, through the
+// fair queue when the trail shares them
+func (p *Processor) enqueue(ctx context.Context, job DownloadJob) error {
+	if job.id == "" {
+		job.id = newObjectID()
+	}
+	if p.fair != nil && job.trail.downloadJobs == p.downloadJobs {
+		return p.fair.push(ctx, job)
+	}
+	select {
+	case job.trail.downloadJobs <- job:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// newObjectID returns a short random ID to correlate the log lines about one
+// object, from listing to writing
+func newObjectID() string {
+	return fmt.Sprintf("%016x", rand.Uint64())
+}
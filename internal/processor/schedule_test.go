@@ -0,0 +1,41 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFairQueueClose(t *testing.T) {
+	out := make(chan DownloadJob, 2)
+	q := newFairQueue(out, 1)
+	ts := &trailSettings{}
+	if err := q.push(context.Background(), DownloadJob{Key: "a", trail: ts}); err != nil {
+		t.Fatal(err)
+	}
+	dispatched := make(chan struct{})
+	go func() {
+		q.dispatch()
+		close(dispatched)
+	}()
+
+	// as when a run is cancelled, closed more than once
+	q.close()
+	q.close()
+	select {
+	case <-dispatched:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher still running after close")
+	}
+	var keys []string
+	for job := range out {
+		keys = append(keys, job.Key)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("dispatched %v, want [a]", keys)
+	}
+	if err := q.push(context.Background(), DownloadJob{Key: "b", trail: ts}); !errors.Is(err, errQueueClosed) {
+		t.Errorf("push after close = %v, want %v", err, errQueueClosed)
+	}
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/systemd"
+)
+
+// ServiceOptions set up reporting to systemd when the run is a Type=notify
+// service
+type ServiceOptions struct {
+	// nil when not running under a notify unit
+	Notifier *systemd.Notifier
+	// with work queued or in flight, no file listed, downloaded or finished
+	// for this long stops the watchdog keepalives, so systemd restarts the
+	// run
+	WedgedAfter time.Duration
+	// how long, at most, the stop timeout is extended while a stopping run
+	// flushes and saves state; zero leaves the unit's TimeoutStopSec alone
+	StopTimeout time.Duration
+}
+
+// how often the status line is refreshed without a watchdog
+const serviceStatusInterval = 10 * time.Second
+
+// serviceMonitor keeps systemd informed until done is closed: a status line
+// and watchdog keepalives while the pipeline moves and, once ctx is done,
+// STOPPING, with the stop timeout extended while state is saved
+func (p *Processor) serviceMonitor(ctx context.Context, done <-chan struct{}) {
+	opts := p.config.Service
+	n := opts.Notifier
+	tick := serviceStatusInterval
+	if n.WatchdogInterval > 0 {
+		tick = min(tick, n.WatchdogInterval/2)
+	}
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	stop := ctx.Done()
+	var stopDeadline time.Time
+	var wedged bool
+	lastProgress, lastProgressAt := p.progressCount(), time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-stop:
+			stop = nil
+			stopDeadline = time.Now().Add(opts.StopTimeout)
+			p.notifyService(n.Stopping("flushing buffers and saving state"))
+			continue
+		case now := <-ticker.C:
+			if stop == nil {
+				// a stopping run saves state in one go, so keep it alive
+				// rather than judge its progress
+				if now.Before(stopDeadline) {
+					p.notifyService(n.ExtendTimeout(2 * tick))
+				}
+				if n.WatchdogInterval > 0 {
+					p.notifyService(n.Watchdog("flushing buffers and saving state"))
+				}
+				continue
+			}
+
+			if progress := p.progressCount(); progress != lastProgress || !p.busy() {
+				lastProgress, lastProgressAt = progress, now
+			}
+			idle := now.Sub(lastProgressAt)
+			if opts.WedgedAfter > 0 && idle >= opts.WedgedAfter {
+				if !wedged {
+					wedged = true
+					p.logger.Error("pipeline wedged, stopping watchdog keepalives",
+						slog.Duration("idle", idle.Round(time.Second)))
+				}
+				p.notifyService(n.Status(fmt.Sprintf("wedged: nothing finished for %s", idle.Round(time.Second))))
+				continue
+			}
+			if wedged {
+				wedged = false
+				p.logger.Info("pipeline moving again, resuming watchdog keepalives")
+			}
+
+			status := p.serviceStatus()
+			if n.WatchdogInterval > 0 {
+				p.notifyService(n.Watchdog(status))
+			} else {
+				p.notifyService(n.Status(status))
+			}
+		}
+	}
+}
+
+// progressCount moves whenever a file is listed, downloaded, finished or
+// fails
+func (p *Processor) progressCount() int64 {
+	s := p.stats
+	return s.FilesListed.Load() + s.FilesDownloaded.Load() + s.FilesProcessed.Load() +
+		s.FilesSkipped.Load() + s.Errors.Load()
+}
+
+// busy reports whether files are queued or in flight and the run isn't held
+// on purpose, so a lack of progress means it's stuck
+func (p *Processor) busy() bool {
+	status := p.Control()
+	if status.Paused || status.DiskLow {
+		return false
+	}
+	queued := len(p.downloadJobs) + len(p.processJobs)
+	if p.fair != nil {
+		queued += p.fair.len()
+	}
+	return queued > 0 || status.ActiveDownloads > 0 || status.ActiveProcessing > 0
+}
+
+func (p *Processor) serviceStatus() string {
+	s := p.stats
+	return fmt.Sprintf("%d files listed, %d downloaded, %d processed, %d events written, %d errors",
+		s.FilesListed.Load(), s.FilesDownloaded.Load(), s.FilesProcessed.Load(), s.EventsWritten.Load(), s.Errors.Load())
+}
+
+// notifyService logs a failed notification; the run goes on regardless
+func (p *Processor) notifyService(err error) {
+	if err != nil {
+		p.logger.Warn("failed to notify systemd", slog.String("error", err.Error()))
+	}
+}
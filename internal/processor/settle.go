@@ -0,0 +1,68 @@
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+)
+
+// settling remembers the files listed past each account/region's held-back
+// checkpoint, so later listings in the same process, like a live tail's or
+// a schedule's next pass, pass over them instead of reading them again.
+// Only the next process re-reads them, with the bloom filter dropping their
+// events.
+type settling struct {
+	mu   sync.Mutex
+	keys map[streamKey]map[string]bool
+}
+
+// settled reports whether a listed file is older than the settle window,
+// by the delivery time in its name or else its LastModified; files newer
+// than that may still have others delivered before them in key order
+func (p *Processor) settled(key string, modified time.Time) bool {
+	if p.config.SettleWindow <= 0 {
+		return true
+	}
+	t, ok := logkey.Time(key)
+	if !ok {
+		t = modified
+	}
+	return time.Since(t) >= p.config.SettleWindow
+}
+
+// listed reports whether the file was read while unsettled
+func (s *settling) listed(k streamKey, key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.keys[k][key]
+}
+
+func (s *settling) add(k streamKey, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[streamKey]map[string]bool)
+	}
+	if s.keys[k] == nil {
+		s.keys[k] = make(map[string]bool)
+	}
+	s.keys[k][key] = true
+}
+
+// prune forgets the files the checkpoint has moved past
+func (s *settling) prune(k streamKey, checkpoint string) {
+	if checkpoint == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range s.keys[k] {
+		if key <= checkpoint {
+			delete(s.keys[k], key)
+		}
+	}
+	if len(s.keys[k]) == 0 {
+		delete(s.keys, k)
+	}
+}
@@ -0,0 +1,93 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+	"sync/atomic"
+)
+
+// skipPattern is one skip_keys entry: a key prefix, or a glob matched
+// against the key and each of its parent directories
+type skipPattern struct {
+	pattern string
+	glob    bool
+	skipped atomic.Int64
+}
+
+func newSkipPatterns(patterns []string) ([]*skipPattern, error) {
+	var list []*skipPattern
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		glob := strings.ContainsAny(pattern, "*?[")
+		if glob {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("skip_keys pattern %q: %w", pattern, err)
+			}
+		}
+		list = append(list, &skipPattern{pattern: pattern, glob: glob})
+	}
+	return list, nil
+}
+
+func (s *skipPattern) match(key string) bool {
+	if !s.glob {
+		return strings.HasPrefix(key, s.pattern)
+	}
+	if ok, _ := path.Match(s.pattern, key); ok {
+		return true
+	}
+	for i := range len(key) {
+		if key[i] != '/' {
+			continue
+		}
+		// the directory with and without its trailing slash
+		if ok, _ := path.Match(s.pattern, key[:i]); ok {
+			return true
+		}
+		if ok, _ := path.Match(s.pattern, key[:i+1]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// skipKey reports whether listing should pass over the key, counting it
+// against the first pattern that matches
+func (ts *trailSettings) skipKey(key string) bool {
+	for _, s := range ts.skipKeys {
+		if s.match(key) {
+			s.skipped.Add(1)
+			return true
+		}
+	}
+	return false
+}
+
+// reportSkips logs how many listed keys each skip_keys pattern skipped
+func (p *Processor) reportSkips() {
+	p.settingsMu.Lock()
+	settings := p.settings
+	p.settingsMu.Unlock()
+
+	counts := make(map[string]int64)
+	var order []string
+	for _, ts := range settings {
+		for _, s := range ts.skipKeys {
+			if _, ok := counts[s.pattern]; !ok {
+				order = append(order, s.pattern)
+			}
+			counts[s.pattern] += s.skipped.Load()
+		}
+	}
+	for _, pattern := range order {
+		if counts[pattern] > 0 {
+			p.logger.Info("skipped keys matching skip_keys",
+				slog.String("pattern", pattern),
+				slog.Int64("files", counts[pattern]))
+		}
+	}
+}
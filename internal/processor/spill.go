@@ -0,0 +1,250 @@
+package processor
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/catalog"
+	"github.com/deceptiq/gocloudtrail/internal/prune"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+const (
+	// concurrent uploads of finished output files
+	spillUploaders = 4
+	// attempts per file before it waits for the next flush to be retried
+	spillAttempts = 3
+)
+
+// SpillOptions uploads each output file to Bucket under Prefix as soon as
+// it's written and deletes the local copy
+type SpillOptions struct {
+	Bucket string
+	Prefix string
+	// bytes of written files waiting to upload before downloads pause, zero
+	// for no limit
+	Quota int64
+	// registers the partition of each uploaded file, when set
+	Catalog *catalog.Glue
+}
+
+type spillFile struct {
+	eventsDir string
+	path      string
+	size      int64
+}
+
+// startSpill starts the uploaders. They keep going after ctx is cancelled so
+// the files flushed on shutdown still leave the disk; stopSpill waits for
+// them.
+func (p *Processor) startSpill(ctx context.Context) {
+	ctx = context.WithoutCancel(ctx)
+	for range spillUploaders {
+		p.spillWG.Add(1)
+		go func() {
+			defer p.spillWG.Done()
+			for f := range p.spillFiles {
+				p.uploadSpill(ctx, f)
+			}
+		}()
+	}
+}
+
+// stopSpill waits for every queued file to upload, once the writers are
+// flushed. Files that still fail stay on local disk for the next run.
+func (p *Processor) stopSpill() {
+	if p.spillFiles == nil {
+		return
+	}
+	p.retrySpill()
+	p.spillSend.Lock()
+	p.spillClosed = true
+	close(p.spillFiles)
+	p.spillSend.Unlock()
+	p.spillWG.Wait()
+
+	p.spillRetryMu.Lock()
+	failed := len(p.spillRetry)
+	p.spillRetryMu.Unlock()
+	if failed > 0 {
+		p.logger.Warn("output files failed to upload, leaving them for the next run",
+			slog.Int("files", failed))
+	}
+	p.logger.Info("spilled output uploaded",
+		slog.Int64("files", p.stats.FilesUploaded.Load()),
+		slog.Int64("bytes", p.stats.BytesUploaded.Load()))
+}
+
+// enqueueSpill is the writers' OnFile hook: the file counts against the
+// quota until its upload is done
+func (p *Processor) enqueueSpill(eventsDir, path string, size int64) {
+	p.addSpillPending(size)
+	p.sendSpill(spillFile{eventsDir: eventsDir, path: path, size: size})
+}
+
+// sendSpill hands a file to the uploaders, unless they've been stopped
+func (p *Processor) sendSpill(f spillFile) {
+	p.spillSend.RLock()
+	defer p.spillSend.RUnlock()
+	if p.spillClosed {
+		p.spillRetryMu.Lock()
+		p.spillRetry = append(p.spillRetry, f)
+		p.spillRetryMu.Unlock()
+		return
+	}
+	p.spillFiles <- f
+}
+
+// retrySpill queues the files whose upload failed again. They stay counted
+// against the quota meanwhile, so downloads pause rather than fill the disk
+// while uploads fail.
+func (p *Processor) retrySpill() {
+	if p.spillFiles == nil {
+		return
+	}
+	p.spillRetryMu.Lock()
+	files := p.spillRetry
+	p.spillRetry = nil
+	p.spillRetryMu.Unlock()
+
+	if len(files) > 0 {
+		p.logger.Info("retrying output file uploads", slog.Int("files", len(files)))
+	}
+	for _, f := range files {
+		p.sendSpill(f)
+	}
+}
+
+// respill queues the output files an earlier run left on local disk, which
+// failed to upload before it stopped
+func (p *Processor) respill(settings []*trailSettings) {
+	var files int
+	for _, dir := range p.outputDirs(settings) {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() || !writer.IsEventsFile(d.Name()) {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			files++
+			p.enqueueSpill(dir, path, info.Size())
+			return nil
+		})
+		if err != nil {
+			p.logger.Error("failed to look for output files left to upload",
+				slog.String("dir", dir),
+				slog.String("error", err.Error()))
+		}
+	}
+	if files > 0 {
+		p.logger.Info("uploading output files left by an earlier run", slog.Int("files", files))
+	}
+}
+
+func (p *Processor) uploadSpill(ctx context.Context, f spillFile) {
+	var err error
+	for attempt := range spillAttempts {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+		}
+		err = prune.Upload(ctx, p.s3Client, p.config.Spill.Bucket, p.config.Spill.Prefix, f.eventsDir, f.path)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		class := p.countError(err)
+		p.logger.Error("failed to upload output file, retrying on the next flush",
+			slog.String("file", f.path),
+			slog.String("error", err.Error()),
+			slog.String("error_class", class.String()))
+		p.spillRetryMu.Lock()
+		p.spillRetry = append(p.spillRetry, f)
+		p.spillRetryMu.Unlock()
+		return
+	}
+	defer p.addSpillPending(-f.size)
+
+	p.stats.FilesUploaded.Add(1)
+	// once the file is gone from the partition, only the recorded counter
+	// keeps later writers from numbering a file the same and uploading it
+	// over this one
+	if err := p.raiseFileCounter(f.eventsDir, f.path); err != nil {
+		class := p.countError(err)
+		p.logger.Error("failed to record file counter, keeping uploaded file locally",
+			slog.String("file", f.path),
+			slog.String("error", err.Error()),
+			slog.String("error_class", class.String()))
+	} else if err := os.Remove(f.path); err != nil {
+		p.logger.Warn("failed to remove uploaded output file",
+			slog.String("file", f.path),
+			slog.String("error", err.Error()))
+	}
+	p.stats.BytesUploaded.Add(f.size)
+	if p.config.Spill.Catalog != nil {
+		if dir, err := filepath.Rel(f.eventsDir, filepath.Dir(f.path)); err == nil {
+			p.config.Spill.Catalog.Add(filepath.ToSlash(dir))
+		}
+	}
+}
+
+// raiseFileCounter records the number after an events_NNNNN file as its
+// partition's next file number
+func (p *Processor) raiseFileCounter(eventsDir, path string) error {
+	n, ok := writer.FileNumber(filepath.Base(path))
+	if !ok {
+		return nil
+	}
+	dir, err := filepath.Rel(eventsDir, filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	return p.stateDB.RaiseFileCounters(eventsDir, map[string]int{dir: n + 1})
+}
+
+// registerPartitions adds the partitions of files uploaded since the last
+// call to the Glue catalog
+func (p *Processor) registerPartitions(ctx context.Context) {
+	if p.config.Spill.Catalog == nil {
+		return
+	}
+	created, err := p.config.Spill.Catalog.Flush(ctx)
+	if created > 0 {
+		p.logger.Info("registered Glue partitions", slog.Int("partitions", created))
+	}
+	if err != nil {
+		class := p.countError(err)
+		p.logger.Error("failed to register Glue partitions, will retry",
+			slog.String("error", err.Error()),
+			slog.String("error_class", class.String()))
+	}
+}
+
+// addSpillPending adjusts the bytes waiting to upload, pausing downloads
+// while they're over the quota
+func (p *Processor) addSpillPending(delta int64) {
+	c := &p.control
+	c.mu.Lock()
+	wasOver := c.spillOver()
+	c.spillPending += delta
+	over := c.spillOver()
+	pending := c.spillPending
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	switch {
+	case over && !wasOver:
+		p.logger.Warn("local quota reached, pausing downloads until uploads catch up",
+			slog.Int64("pending_bytes", pending),
+			slog.Int64("quota_bytes", p.config.Spill.Quota))
+	case !over && wasOver:
+		p.logger.Info("uploads caught up, resuming downloads",
+			slog.Int64("pending_bytes", pending))
+	}
+}
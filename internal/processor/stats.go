@@ -1,8 +1,13 @@
 package processor
 
 import (
+	"errors"
+	"fmt"
 	"log/slog"
+	"sync/atomic"
 	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
 )
 
 // PrintProgress outputs current processing statistics
@@ -14,16 +19,33 @@ func (s *Stats) PrintProgress(logger *slog.Logger) {
 	events := s.EventsProcessed.Load()
 	written := s.EventsWritten.Load()
 	duplicate := s.EventsDuplicate.Load()
+	filtered := s.EventsFiltered.Load()
+	invalid := s.EventsInvalid.Load()
+	quarantined := s.EventsQuarantined.Load()
+	filesQuarantined := s.FilesQuarantined.Load()
+	skipped := s.FilesSkipped.Load()
+	excluded := s.FilesExcluded.Load()
+	late := s.FilesLate.Load()
+	alreadyProcessed := s.FilesAlreadyProcessed.Load()
+	findings := s.Findings.Load()
+	forwarded := s.EventsForwarded.Load()
 	bytes := s.BytesDownloaded.Load()
 	jsonlFiles := s.JSONLFilesWritten.Load()
 	errors := s.Errors.Load()
+	diskPauses := s.DiskPauses.Load()
+	uploaded := s.FilesUploaded.Load()
+	unhealthy := s.TrailsUnhealthy.Load()
+	reopened := s.PartitionsReopened.Load()
+	newerVersion := s.EventsNewerVersion.Load()
+	oversized := s.EventsOversized.Load()
+	timeFallback := s.EventsTimeFallback.Load()
 
 	if elapsed.Seconds() > 0 {
 		downloadRate := float64(downloaded) / elapsed.Seconds()
 		eventRate := float64(events) / elapsed.Seconds()
 		mbps := float64(bytes) / elapsed.Seconds() / 1024 / 1024
 
-		logger.Info("progress",
+		attrs := []any{
 			slog.Duration("elapsed", elapsed.Round(time.Second)),
 			slog.Int64("files_listed", listed),
 			slog.Int64("files_downloaded", downloaded),
@@ -35,6 +57,335 @@ func (s *Stats) PrintProgress(logger *slog.Logger) {
 			slog.Int64("events_written", written),
 			slog.Int64("jsonl_files", jsonlFiles),
 			slog.Int64("events_duplicate", duplicate),
-			slog.Int64("errors", errors))
+			slog.Int64("events_filtered", filtered),
+			slog.Int64("events_invalid", invalid),
+			slog.Int64("events_quarantined", quarantined),
+			slog.Int64("files_quarantined", filesQuarantined),
+			slog.Int64("files_skipped", skipped),
+			slog.Int64("files_excluded", excluded),
+			slog.Int64("files_late", late),
+			slog.Int64("files_already_processed", alreadyProcessed),
+			slog.Int64("findings", findings),
+			slog.Int64("events_forwarded", forwarded),
+			slog.Int64("errors", errors),
+			slog.String("error_classes", s.ErrorSummary()),
+			slog.Int64("disk_pauses", diskPauses),
+			slog.Int64("files_uploaded", uploaded),
+			slog.Int64("trails_unhealthy", unhealthy),
+			slog.Int64("partitions_reopened", reopened),
+			slog.Int64("events_newer_version", newerVersion),
+			slog.Int64("events_oversized", oversized),
+			slog.Int64("events_time_fallback", timeFallback),
+		}
+		if lifetime := s.Lifetime(); lifetime != nil {
+			attrs = append(attrs, slog.Any("lifetime", lifetime))
+		}
+		logger.Info("progress", attrs...)
+	}
+}
+
+// StatsSnapshot is a point in time copy of the run's counters
+type StatsSnapshot struct {
+	Elapsed         string `json:"elapsed"`
+	FilesListed     int64  `json:"files_listed"`
+	FilesDownloaded int64  `json:"files_downloaded"`
+	FilesProcessed  int64  `json:"files_processed"`
+	FilesSkipped    int64  `json:"files_skipped"`
+	FilesExcluded   int64  `json:"files_excluded"`
+	FilesLate       int64  `json:"files_late"`
+	// skipped for being processed before in the same version
+	FilesAlreadyProcessed int64 `json:"files_already_processed"`
+	BytesDownloaded       int64 `json:"bytes_downloaded"`
+	EventsProcessed       int64 `json:"events_processed"`
+	EventsWritten         int64 `json:"events_written"`
+	EventsDuplicate       int64 `json:"events_duplicate"`
+	EventsFiltered        int64 `json:"events_filtered"`
+	EventsInvalid         int64 `json:"events_invalid"`
+	EventsQuarantined     int64 `json:"events_quarantined"`
+	EventsForwarded       int64 `json:"events_forwarded"`
+	Findings              int64 `json:"findings"`
+	Errors                int64 `json:"errors"`
+	// errors by class, for classes with any
+	ErrorClasses  map[string]int64 `json:"error_classes,omitempty"`
+	DiskPauses    int64            `json:"disk_pauses"`
+	FilesUploaded int64            `json:"files_uploaded"`
+	BytesUploaded int64            `json:"bytes_uploaded"`
+	// trails stopped or failing delivery at the start of the run
+	TrailsUnhealthy int64 `json:"trails_unhealthy"`
+	// closed partitions late events were appended to
+	PartitionsReopened int64 `json:"partitions_reopened"`
+	// events with an eventVersion newer than the event model knows, and
+	// how many of each version
+	EventsNewerVersion int64            `json:"events_newer_version"`
+	NewerVersions      map[string]int64 `json:"newer_versions,omitempty"`
+	// events over the oversized record limit
+	EventsOversized int64 `json:"events_oversized"`
+	// events given their log file's time for want of a usable eventTime
+	EventsTimeFallback int64 `json:"events_time_fallback"`
+	// totals across every run, this one included
+	Lifetime map[string]int64 `json:"lifetime,omitempty"`
+}
+
+// Snapshot copies the current counters
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Elapsed:               time.Since(s.StartTime).Round(time.Second).String(),
+		FilesListed:           s.FilesListed.Load(),
+		FilesDownloaded:       s.FilesDownloaded.Load(),
+		FilesProcessed:        s.FilesProcessed.Load(),
+		FilesSkipped:          s.FilesSkipped.Load(),
+		FilesExcluded:         s.FilesExcluded.Load(),
+		FilesLate:             s.FilesLate.Load(),
+		FilesAlreadyProcessed: s.FilesAlreadyProcessed.Load(),
+		BytesDownloaded:       s.BytesDownloaded.Load(),
+		EventsProcessed:       s.EventsProcessed.Load(),
+		EventsWritten:         s.EventsWritten.Load(),
+		EventsDuplicate:       s.EventsDuplicate.Load(),
+		EventsFiltered:        s.EventsFiltered.Load(),
+		EventsInvalid:         s.EventsInvalid.Load(),
+		EventsQuarantined:     s.EventsQuarantined.Load(),
+		EventsForwarded:       s.EventsForwarded.Load(),
+		Findings:              s.Findings.Load(),
+		Errors:                s.Errors.Load(),
+		ErrorClasses:          s.ErrorBreakdown(),
+		DiskPauses:            s.DiskPauses.Load(),
+		FilesUploaded:         s.FilesUploaded.Load(),
+		BytesUploaded:         s.BytesUploaded.Load(),
+		TrailsUnhealthy:       s.TrailsUnhealthy.Load(),
+		PartitionsReopened:    s.PartitionsReopened.Load(),
+		EventsNewerVersion:    s.EventsNewerVersion.Load(),
+		NewerVersions:         s.NewerVersions(),
+		EventsOversized:       s.EventsOversized.Load(),
+		EventsTimeFallback:    s.EventsTimeFallback.Load(),
+		Lifetime:              s.Lifetime(),
+	}
+}
+
+// trailProgress counts one trail or log group's files through the pipeline
+type trailProgress struct {
+	pairs      atomic.Int64
+	pairsDone  atomic.Int64
+	listed     atomic.Int64
+	downloaded atomic.Int64
+	processed  atomic.Int64
+	written    atomic.Int64
+	done       atomic.Bool
+}
+
+// TrailStatus is the progress of one trail or log group in the current run
+type TrailStatus struct {
+	Name            string `json:"name"`
+	Bucket          string `json:"bucket,omitempty"`
+	Prefix          string `json:"prefix,omitempty"`
+	LogGroup        string `json:"log_group,omitempty"`
+	Done            bool   `json:"done"`
+	Pairs           int64  `json:"account_regions"`
+	PairsDone       int64  `json:"account_regions_done"`
+	FilesListed     int64  `json:"files_listed"`
+	FilesDownloaded int64  `json:"files_downloaded"`
+	FilesProcessed  int64  `json:"files_processed"`
+	EventsWritten   int64  `json:"events_written"`
+	// listed and waiting for a shared download worker
+	FilesQueued int64 `json:"files_queued,omitempty"`
+	// the earliest of its account/regions' event watermarks
+	EventWatermark time.Time `json:"event_watermark,omitzero"`
+	// set once GetTrailStatus has been checked
+	Health *TrailHealth `json:"health,omitempty"`
+}
+
+// Trails returns the progress of every trail and log group in the run
+func (p *Processor) Trails() []TrailStatus {
+	p.settingsMu.Lock()
+	settings := p.settings
+	p.settingsMu.Unlock()
+
+	watermarks := make(map[*trailSettings]time.Time)
+	unknown := make(map[*trailSettings]bool)
+	for _, l := range p.pairListings() {
+		w := l.eventWatermark()
+		if w.IsZero() {
+			unknown[l.ts] = true
+		} else if prev, ok := watermarks[l.ts]; !ok || w.Before(prev) {
+			watermarks[l.ts] = w
+		}
+	}
+
+	statuses := make([]TrailStatus, 0, len(settings))
+	for _, ts := range settings {
+		status := TrailStatus{
+			Name:            ts.trail.Name,
+			Bucket:          ts.trail.Bucket,
+			Prefix:          ts.trail.Prefix,
+			Done:            ts.progress.done.Load(),
+			Pairs:           ts.progress.pairs.Load(),
+			PairsDone:       ts.progress.pairsDone.Load(),
+			FilesListed:     ts.progress.listed.Load(),
+			FilesDownloaded: ts.progress.downloaded.Load(),
+			FilesProcessed:  ts.progress.processed.Load(),
+			EventsWritten:   ts.progress.written.Load(),
+			Health:          ts.health.Load(),
+		}
+		if p.fair != nil {
+			status.FilesQueued = p.fair.queued(ts)
+		}
+		if !unknown[ts] {
+			status.EventWatermark = watermarks[ts]
+		}
+		if ts.logGroup != nil {
+			status.LogGroup = ts.logGroup.Name
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// PairWatermark is how complete one account/region listing's output is
+type PairWatermark struct {
+	Trail     string `json:"trail"`
+	Bucket    string `json:"bucket"`
+	AccountID string `json:"account_id"`
+	Region    string `json:"region"`
+	// every event up to this time has been written; zero until known
+	EventWatermark time.Time `json:"event_watermark,omitzero"`
+	LagSeconds     int64     `json:"lag_seconds,omitempty"`
+	Listed         bool      `json:"listed"`
+	FilesPending   int       `json:"files_pending"`
+	FilesFailed    int       `json:"files_failed"`
+}
+
+// Watermarks returns the event watermark of every account/region listed in
+// the run so far
+func (p *Processor) Watermarks() []PairWatermark {
+	now := time.Now()
+	listings := p.pairListings()
+	watermarks := make([]PairWatermark, 0, len(listings))
+	for _, l := range listings {
+		w := PairWatermark{
+			Trail:          l.ts.trail.Name,
+			Bucket:         l.ts.trail.Bucket,
+			AccountID:      l.accountID,
+			Region:         stateRegion(l.folder, l.region),
+			EventWatermark: l.eventWatermark(),
+		}
+		if !w.EventWatermark.IsZero() {
+			w.LagSeconds = int64(now.Sub(w.EventWatermark).Seconds())
+		}
+		w.FilesPending, w.FilesFailed = l.counts()
+		l.mu.Lock()
+		w.Listed = l.listed
+		l.mu.Unlock()
+		watermarks = append(watermarks, w)
+	}
+	return watermarks
+}
+
+// Thresholds are the failure limits a strict run must stay within
+type Thresholds struct {
+	MaxErrors           int64
+	MaxSkippedObjects   int64
+	MaxParseFailureRate float64
+}
+
+// CheckThresholds returns an error describing every threshold the run exceeded
+func (s *Stats) CheckThresholds(t Thresholds) error {
+	var errs []error
+
+	if n := s.Errors.Load(); n > t.MaxErrors {
+		errs = append(errs, fmt.Errorf("%d errors exceeds max_errors %d", n, t.MaxErrors))
+	}
+	if skipped := s.FilesSkipped.Load(); skipped > t.MaxSkippedObjects {
+		errs = append(errs, fmt.Errorf("%d skipped objects exceeds max_skipped_objects %d", skipped, t.MaxSkippedObjects))
+	}
+	if events := s.EventsProcessed.Load(); events > 0 {
+		rate := float64(s.EventsInvalid.Load()) / float64(events)
+		if rate > t.MaxParseFailureRate {
+			errs = append(errs, fmt.Errorf("parse failure rate %.4f exceeds max_parse_failure_rate %.4f", rate, t.MaxParseFailureRate))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// sourceKey identifies the checkpoint a source object was read under
+type sourceKey struct {
+	bucket    string
+	accountID string
+	region    string
+}
+
+// PairStats are event counts for one bucket/account/region
+type PairStats struct {
+	Events    atomic.Int64
+	Written   atomic.Int64
+	Duplicate atomic.Int64
+	Invalid   atomic.Int64
+	Filtered  atomic.Int64
+}
+
+// pair returns the counters for the checkpoint a job was listed under
+func (s *Stats) pair(job DownloadJob) *PairStats {
+	key := sourceKey{bucket: job.Bucket, accountID: job.AccountID, region: job.Region}
+	if job.Bucket == "" {
+		if job.trail != nil && job.trail.logGroup == nil {
+			// imported files have no checkpoint to report against
+			return &PairStats{}
+		}
+		// log group events are checkpointed under the group name
+		key.bucket = logGroupStatePrefix + job.Key
+	}
+
+	s.pairsMu.Lock()
+	defer s.pairsMu.Unlock()
+
+	if s.pairs == nil {
+		s.pairs = make(map[sourceKey]*PairStats)
+	}
+	ps, ok := s.pairs[key]
+	if !ok {
+		ps = &PairStats{}
+		s.pairs[key] = ps
 	}
+	return ps
+}
+
+// RunMetrics returns the per bucket/account/region counts gathered so far
+func (s *Stats) RunMetrics() []state.RunMetrics {
+	s.pairsMu.Lock()
+	defer s.pairsMu.Unlock()
+
+	metrics := make([]state.RunMetrics, 0, len(s.pairs))
+	for key, ps := range s.pairs {
+		metrics = append(metrics, state.RunMetrics{
+			Bucket:    key.bucket,
+			AccountID: key.accountID,
+			Region:    key.region,
+			Events:    ps.Events.Load(),
+			Written:   ps.Written.Load(),
+			Duplicate: ps.Duplicate.Load(),
+			Invalid:   ps.Invalid.Load(),
+			Filtered:  ps.Filtered.Load(),
+		})
+	}
+	return metrics
+}
+
+// observe widens the run's source time range to include t
+func (s *Stats) observe(t time.Time) {
+	s.rangeMu.Lock()
+	defer s.rangeMu.Unlock()
+
+	if s.rangeStart.IsZero() || t.Before(s.rangeStart) {
+		s.rangeStart = t
+	}
+	if t.After(s.rangeEnd) {
+		s.rangeEnd = t
+	}
+}
+
+// SourceRange returns the delivery times of the earliest and latest source
+// objects enqueued this run, zero when nothing was enqueued
+func (s *Stats) SourceRange() (time.Time, time.Time) {
+	s.rangeMu.Lock()
+	defer s.rangeMu.Unlock()
+	return s.rangeStart, s.rangeEnd
 }
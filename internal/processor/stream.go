@@ -0,0 +1,226 @@
+package processor
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// how long a commit may spend flushing the sinks
+const streamFlushTimeout = 2 * time.Minute
+
+// streamState ties checkpoints to sink acknowledgments in stream-only and
+// at-least-once modes: listing queues each checkpoint instead of saving it,
+// and a commit saves the ones whose files, and every file listed before
+// them, have been finished and had all their events acknowledged
+type streamState struct {
+	// held shared by process workers for each file and exclusively by
+	// commits, so a commit never sees half a file in the sink batches
+	gate sync.RWMutex
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[streamKey][]*streamCheckpoint
+	// keys whose queue stopped at a failed file for the rest of the run
+	frozen map[streamKey]bool
+	// tracked checkpoints not yet done, bounded by Config.MaxUnacked
+	unacked int
+	// set once a send fails; the bloom filter isn't saved again this run,
+	// so a restart resends whatever wasn't acknowledged
+	broken bool
+}
+
+// streamKey identifies a checkpoint row in the state database
+type streamKey struct {
+	bucket, accountID, region string
+}
+
+// streamCheckpoint is a checkpoint value waiting for its file to finish
+// and its events to be acknowledged
+type streamCheckpoint struct {
+	key   streamKey
+	value string
+	// one for the file itself plus one per event and sink still unacknowledged
+	pending atomic.Int64
+	done    bool
+	failed  bool
+}
+
+// acked reports whether checkpoints wait for sink acknowledgments
+func (p *Processor) acked() bool {
+	return p.config.StreamOnly || p.config.AtLeastOnce
+}
+
+// trackCheckpoint queues value as the checkpoint to save for the state key
+// once the file it was listed for finishes and its events are acknowledged.
+// It waits while MaxUnacked checkpoints are outstanding, and returns nil
+// once the key's queue is stuck behind a failed file.
+func (p *Processor) trackCheckpoint(ctx context.Context, bucket, accountID, region, value string) (*streamCheckpoint, error) {
+	s := &p.stream
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.queues == nil {
+		s.queues = make(map[streamKey][]*streamCheckpoint)
+		s.frozen = make(map[streamKey]bool)
+		s.cond = sync.NewCond(&s.mu)
+	}
+	if p.config.MaxUnacked > 0 && s.unacked >= p.config.MaxUnacked && !s.broken {
+		stop := context.AfterFunc(ctx, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		for s.unacked >= p.config.MaxUnacked && !s.broken && ctx.Err() == nil {
+			s.cond.Wait()
+		}
+		stop()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	k := streamKey{bucket, accountID, region}
+	if s.frozen[k] {
+		return nil, nil
+	}
+	c := &streamCheckpoint{key: k, value: value}
+	c.pending.Store(1)
+	s.queues[k] = append(s.queues[k], c)
+	s.unacked++
+	return c, nil
+}
+
+// ackFunc returns the ack for one of the checkpoint's events sent to one
+// sink, or nil when the file isn't tracked
+func (p *Processor) ackFunc(c *streamCheckpoint) func() {
+	if c == nil {
+		return nil
+	}
+	c.pending.Add(1)
+	return func() { p.releaseCheckpoint(c) }
+}
+
+// finishCheckpoint releases the file's own hold on its checkpoint. A failed
+// file's events didn't all get sent, so its key stops advancing for the
+// rest of the run.
+func (p *Processor) finishCheckpoint(c *streamCheckpoint, err error) {
+	if c == nil {
+		return
+	}
+	if err != nil && p.acked() {
+		s := &p.stream
+		s.mu.Lock()
+		c.failed = true
+		already := s.frozen[c.key]
+		s.frozen[c.key] = true
+		s.mu.Unlock()
+		if !already {
+			p.logger.Warn("file failed, its checkpoint won't advance for the rest of the run",
+				slog.String("state_key", c.key.bucket+":"+c.key.accountID+":"+c.key.region),
+				slog.String("key", c.value),
+				slog.String("error", err.Error()))
+		}
+	}
+	p.releaseCheckpoint(c)
+}
+
+func (p *Processor) releaseCheckpoint(c *streamCheckpoint) {
+	if c.pending.Add(-1) != 0 {
+		return
+	}
+	s := &p.stream
+	s.mu.Lock()
+	c.done = true
+	s.unacked--
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// breakStream stops bloom filter saves after a failed send
+func (p *Processor) breakStream(sinkName string, err error) {
+	s := &p.stream
+	s.mu.Lock()
+	already := s.broken
+	s.broken = true
+	if s.cond != nil {
+		// listing waiting on unacknowledged checkpoints may never be woken otherwise
+		s.cond.Broadcast()
+	}
+	s.mu.Unlock()
+
+	if !already {
+		p.logger.Error("sink delivery failed, its events' checkpoints won't advance for the rest of the run",
+			slog.String("sink", sinkName),
+			slog.String("error", err.Error()))
+	}
+}
+
+// commitStream flushes every sink and the output files and, once all have
+// acknowledged, saves the bloom filter, then commits checkpoints.
+// Processing is held for the duration. It reports whether the events of
+// every file finished so far have been acknowledged.
+func (p *Processor) commitStream() bool {
+	s := &p.stream
+	s.gate.Lock()
+	defer s.gate.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), streamFlushTimeout)
+	defer cancel()
+	for _, sk := range p.config.Sinks {
+		if err := sk.Flush(ctx); err != nil {
+			p.countError(classified(ErrorSink, err))
+			p.breakStream(sk.Name, err)
+		}
+	}
+	p.commitCheckpoints()
+
+	s.mu.Lock()
+	broken := s.broken
+	s.mu.Unlock()
+	if broken {
+		return false
+	}
+
+	// events in the filter are all acknowledged now, so a restart can
+	// safely skip them
+	if err := p.dedup.Save(); err != nil {
+		p.logger.Error("failed to save bloom filter", slog.String("error", err.Error()))
+		return false
+	}
+	return true
+}
+
+// commitCheckpoints saves, for each state key, the last checkpoint with
+// every one before it done. The output files are flushed between picking
+// them and saving, so the events of those files are on disk first.
+func (p *Processor) commitCheckpoints() {
+	s := &p.stream
+	s.mu.Lock()
+	commits := make(map[streamKey]string)
+	for k, queue := range s.queues {
+		n := 0
+		for n < len(queue) && queue[n].done && !queue[n].failed {
+			n++
+		}
+		// empty when a settle window held back a first checkpoint
+		if n > 0 && queue[n-1].value != "" {
+			commits[k] = queue[n-1].value
+		}
+		s.queues[k] = queue[n:]
+	}
+	s.mu.Unlock()
+
+	if !p.config.StreamOnly {
+		p.flushWriters()
+	}
+	for k, value := range commits {
+		if err := p.stateDB.UpdateLastProcessedKey(k.bucket, k.accountID, k.region, value); err != nil {
+			p.logger.Error("failed to update state",
+				slog.String("state_key", k.bucket+":"+k.accountID+":"+k.region),
+				slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// trailSettings are the effective settings for one trail once its overrides
+// have been applied over the global config
+type trailSettings struct {
+	trail         config.Trail
+	listBatchSize int
+	filters       config.Filters
+	eventsDir     string
+	// events outside [startTime, endTime) are skipped; zero means unbounded
+	startTime time.Time
+	endTime   time.Time
+	writer    *writer.JSONLWriter
+	// per event category outputs, keyed by lowercased category
+	categoryDirs    map[string]string
+	categoryWriters map[string]*writer.JSONLWriter
+	// the shared queue, or a dedicated one when the trail sets its own workers
+	downloadJobs chan DownloadJob
+	// listed keys matching these are passed over
+	skipKeys []*skipPattern
+	// set when events come from CloudWatch Logs rather than the S3 bucket
+	logGroup *config.LogGroup
+	progress trailProgress
+	// what GetTrailStatus reported, nil until checked
+	health atomic.Pointer[TrailHealth]
+	// set after a live tail's first pass, which alone checks health and
+	// scans for late deliveries
+	tailing bool
+}
+
+func (p *Processor) newTrailSettings(trail config.Trail) (*trailSettings, error) {
+	ts := &trailSettings{
+		trail:         trail,
+		listBatchSize: p.config.ListBatchSize,
+		filters:       p.config.Filters,
+		eventsDir:     p.config.EventsDir,
+		startTime:     p.config.StartTime,
+		endTime:       p.config.EndTime,
+		downloadJobs:  p.downloadJobs,
+	}
+
+	start, end, err := trail.TimeRange()
+	if err != nil {
+		return nil, err
+	}
+	if !start.IsZero() {
+		ts.startTime = start
+	}
+	if !end.IsZero() {
+		ts.endTime = end
+	}
+	if !ts.startTime.IsZero() && !ts.endTime.IsZero() && !ts.endTime.After(ts.startTime) {
+		return nil, fmt.Errorf("end_time must be after start_time")
+	}
+
+	if trail.ListBatchSize > 0 {
+		ts.listBatchSize = trail.ListBatchSize
+	}
+	if _, err := configuredAccounts(trail.Accounts); err != nil {
+		return nil, err
+	}
+	if trail.DiscoveryDepth < 0 {
+		return nil, fmt.Errorf("discovery_depth must not be negative")
+	}
+	// a trail's own patterns apply on top of the global ones
+	ts.skipKeys, err = newSkipPatterns(append(slices.Clone(p.config.SkipKeys), trail.SkipKeys...))
+	if err != nil {
+		return nil, err
+	}
+	if trail.Filters != nil {
+		ts.filters = *trail.Filters
+	}
+	if trail.EventsDir != "" {
+		ts.eventsDir = trail.EventsDir
+	}
+
+	format := p.config.OutputFormat
+	if trail.OutputFormat != "" {
+		f, err := writer.ParseFormat(trail.OutputFormat)
+		if err != nil {
+			return nil, err
+		}
+		format = f
+	}
+	ts.writer = p.writerFor(ts.eventsDir, format)
+
+	if len(p.config.CategoryDirs) > 0 {
+		ts.categoryDirs = make(map[string]string, len(p.config.CategoryDirs))
+		ts.categoryWriters = make(map[string]*writer.JSONLWriter, len(p.config.CategoryDirs))
+		for category, dir := range p.config.CategoryDirs {
+			category = strings.ToLower(category)
+			ts.categoryDirs[category] = dir
+			ts.categoryWriters[category] = p.writerFor(dir, format)
+		}
+	}
+
+	return ts, nil
+}
+
+// outputDir returns the events dir that events of a category are written to
+func (ts *trailSettings) outputDir(category string) string {
+	if dir, ok := ts.categoryDirs[strings.ToLower(category)]; ok {
+		return dir
+	}
+	return ts.eventsDir
+}
+
+// outputWriter returns the writer for events of a category
+func (ts *trailSettings) outputWriter(category string) *writer.JSONLWriter {
+	if w, ok := ts.categoryWriters[strings.ToLower(category)]; ok {
+		return w
+	}
+	return ts.writer
+}
+
+// writerFor returns the writer for an events dir and format, sharing one
+// writer between trails with the same output
+func (p *Processor) writerFor(eventsDir string, format writer.Format) *writer.JSONLWriter {
+	if format == "" {
+		format = writer.FormatJSONL
+	}
+	key := eventsDir + "\x00" + string(format)
+
+	p.writersMu.Lock()
+	defer p.writersMu.Unlock()
+
+	if w, ok := p.writers[key]; ok {
+		return w
+	}
+	w := writer.New(eventsDir, p.config.EventsPerFile, writer.Encoding{Format: format, CSVColumns: p.config.CSVColumns, Cipher: p.config.Cipher}, p.config.Partitioning, p.logger)
+	if p.spillFiles != nil {
+		w.OnFile(p.enqueueSpill)
+	}
+	w.OnAppend(p.partitionAppended)
+	if p.config.Checksums {
+		w.EnableChecksums()
+	}
+	if floors, err := p.stateDB.FileCounters(eventsDir); err != nil {
+		p.logger.Warn("failed to read file counters", slog.String("events_dir", eventsDir), slog.String("error", err.Error()))
+	} else if len(floors) > 0 {
+		w.FloorFileNumbers(floors)
+	}
+	p.writers[key] = w
+	return w
+}
+
+// allWriters returns every writer created so far
+func (p *Processor) allWriters() []*writer.JSONLWriter {
+	p.writersMu.Lock()
+	defer p.writersMu.Unlock()
+
+	writers := make([]*writer.JSONLWriter, 0, len(p.writers))
+	for _, w := range p.writers {
+		writers = append(writers, w)
+	}
+	return writers
+}
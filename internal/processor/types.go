@@ -2,6 +2,7 @@ package processor
 
 import (
 	"encoding/json"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -12,6 +13,34 @@ type DownloadJob struct {
 	Key          string
 	Size         int64
 	LastModified time.Time
+	// as listed or downloaded, empty when unknown
+	ETag string
+	// the account/region checkpoint the object was listed under
+	AccountID string
+	Region    string
+
+	trail *trailSettings
+	// the backfill unit the object belongs to, when listed by Backfill
+	unit *backfillUnit
+	// bytes held against the in-flight budget until the file is finished
+	inflight int64
+	// with acknowledged checkpoints, the one saved once this file is committed
+	checkpoint *streamCheckpoint
+	// otherwise, the checkpoint this file holds back until it finishes
+	listed *listedCheckpoint
+	// the listing followed for partition markers
+	listing *pairListing
+	// with Config.Ordered, the account/region lane the file keeps its
+	// place in, and that place
+	lane *orderLane
+	seq  int64
+	// with Config.AuditLog, what the audit log records about the download
+	audit *objectAudit
+	// skipped without downloading, with Config.SkipProcessed
+	alreadyProcessed bool
+	// correlation ID in every log line about the object, set when it's
+	// queued
+	id string
 }
 
 // parsed records from a CloudTrail log file
@@ -21,15 +50,32 @@ type ProcessedFile struct {
 	Err     error
 }
 
-// only the fields needed for deduplication and routing
+// only the fields needed for deduplication, routing and analytics
 type MinimalEvent struct {
-	EventTime    string `json:"eventTime"`
-	EventID      string `json:"eventID"`
-	AWSRegion    string `json:"awsRegion"`
-	UserIdentity struct {
+	EventVersion  string `json:"eventVersion"`
+	EventTime     string `json:"eventTime"`
+	EventID       string `json:"eventID"`
+	EventName     string `json:"eventName"`
+	EventSource   string `json:"eventSource"`
+	EventCategory string `json:"eventCategory,omitempty"` // absent on older management events
+	AWSRegion     string `json:"awsRegion"`
+	UserIdentity  struct {
+		Type      string `json:"type"`
+		ARN       string `json:"arn"`
 		AccountID string `json:"accountId"`
+		InvokedBy string `json:"invokedBy"`
 	} `json:"userIdentity"`
 	RecipientAccountID string `json:"recipientAccountId,omitempty"`
+	SourceIPAddress    string `json:"sourceIPAddress"`
+	ErrorCode          string `json:"errorCode"`
+}
+
+// Category returns the event category, defaulting to Management
+func (e *MinimalEvent) Category() string {
+	if e.EventCategory != "" {
+		return e.EventCategory
+	}
+	return "Management"
 }
 
 // the structure of a CloudTrail log file
@@ -45,8 +91,54 @@ type Stats struct {
 	EventsProcessed   atomic.Int64
 	EventsWritten     atomic.Int64
 	EventsDuplicate   atomic.Int64
-	BytesDownloaded   atomic.Int64
-	JSONLFilesWritten atomic.Int64
-	Errors            atomic.Int64
-	StartTime         time.Time
+	EventsFiltered    atomic.Int64
+	EventsInvalid     atomic.Int64
+	EventsQuarantined atomic.Int64
+	FilesQuarantined  atomic.Int64
+	FilesSkipped      atomic.Int64
+	// listed keys passed over for matching skip_keys
+	FilesExcluded atomic.Int64
+	// log files found delivered behind their checkpoint
+	FilesLate atomic.Int64
+	// objects skipped for being processed before in the same version
+	FilesAlreadyProcessed atomic.Int64
+	Findings              atomic.Int64
+	EventsForwarded       atomic.Int64
+	BytesDownloaded       atomic.Int64
+	JSONLFilesWritten     atomic.Int64
+	Errors                atomic.Int64
+	// Errors split by class
+	ErrorClasses [numErrorClasses]atomic.Int64
+	// times downloads were paused for low disk space
+	DiskPauses atomic.Int64
+	// output files uploaded and removed in spill mode
+	FilesUploaded atomic.Int64
+	BytesUploaded atomic.Int64
+	// trails GetTrailStatus reports stopped or failing delivery
+	TrailsUnhealthy atomic.Int64
+	// closed partitions late events were appended to
+	PartitionsReopened atomic.Int64
+	// events with an eventVersion newer than the event model knows
+	EventsNewerVersion atomic.Int64
+	// events over the oversized record limit
+	EventsOversized atomic.Int64
+	// events given their log file's time for want of a usable eventTime
+	EventsTimeFallback atomic.Int64
+	StartTime          time.Time
+
+	pairsMu sync.Mutex
+	pairs   map[sourceKey]*PairStats
+
+	// delivery times of the earliest and latest source objects enqueued
+	rangeMu    sync.Mutex
+	rangeStart time.Time
+	rangeEnd   time.Time
+
+	// EventsNewerVersion by version
+	versionsMu    sync.Mutex
+	newerVersions map[string]int64
+
+	// lifetime totals before this run, nil until loaded
+	lifetimeMu   sync.Mutex
+	lifetimeBase map[string]int64
 }
@@ -0,0 +1,67 @@
+package processor
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// validateEvent returns why an event is missing required CloudTrail fields,
+// or "" when it is complete
+func validateEvent(ev *MinimalEvent) string {
+	required := []struct {
+		name  string
+		value string
+	}{
+		{"eventVersion", ev.EventVersion},
+		{"eventID", ev.EventID},
+		{"eventTime", ev.EventTime},
+		{"eventName", ev.EventName},
+		{"eventSource", ev.EventSource},
+		{"awsRegion", ev.AWSRegion},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			return "missing " + field.name
+		}
+	}
+	return ""
+}
+
+// rejectRecord counts an event that can't be written and quarantines it when
+// validation is enabled
+func (p *Processor) rejectRecord(job DownloadJob, pair *PairStats, record []byte, reason string) {
+	p.stats.EventsInvalid.Add(1)
+	pair.Invalid.Add(1)
+	if p.quarantine == nil {
+		return
+	}
+
+	source := job.Key
+	if job.Bucket != "" {
+		source = fmt.Sprintf("s3://%s/%s", job.Bucket, job.Key)
+	}
+	if err := p.quarantine.Record(source, reason, record); err != nil {
+		p.logger.Error("failed to quarantine record",
+			slog.String("source", source),
+			slog.String("object_id", job.id),
+			slog.String("error", err.Error()))
+		return
+	}
+	p.stats.EventsQuarantined.Add(1)
+}
+
+// rejectFile quarantines a source object that couldn't be decoded
+func (p *Processor) rejectFile(job DownloadJob, data []byte, reason string) {
+	if p.quarantine == nil {
+		return
+	}
+	if err := p.quarantine.File(job.Bucket, job.Key, reason, data); err != nil {
+		p.logger.Error("failed to quarantine file",
+			slog.String("bucket", job.Bucket),
+			slog.String("key", job.Key),
+			slog.String("object_id", job.id),
+			slog.String("error", err.Error()))
+		return
+	}
+	p.stats.FilesQuarantined.Add(1)
+}
@@ -0,0 +1,341 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type VerifyOptions struct {
+	// fraction of checkpointed objects to re-check, 1 verifies everything
+	SampleRate float64
+	// stop after this many objects have been sampled (0 = no limit)
+	MaxObjects int
+	// partitions that ended by this time may have been pruned, so their
+	// events aren't checked (zero checks everything)
+	PrunedBefore time.Time
+}
+
+// counters gathered by Verify
+type VerifyReport struct {
+	ObjectsChecked  atomic.Int64
+	ObjectsFailed   atomic.Int64
+	EventsChecked   atomic.Int64
+	EventsSkipped   atomic.Int64
+	EventsMissing   atomic.Int64
+	MissingInBloom  atomic.Int64
+	MissingNotSeen  atomic.Int64
+	ObjectsWithLoss atomic.Int64
+}
+
+// Verify re-downloads already checkpointed source objects and confirms that
+// every routable record is present in the local output. Missing events the
+// bloom filter claims to have seen were lost to a false positive or to a
+// crash between dedup and flush; the rest were never processed.
+func (p *Processor) Verify(ctx context.Context, opts VerifyOptions) (*VerifyReport, error) {
+	trails, err := p.resolveTrails(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+
+	report := &VerifyReport{}
+
+	// trails and event categories may write to their own events dirs, so
+	// index each one
+	settings := make([]*trailSettings, 0, len(trails))
+	indexes := make(map[string]*partitionIndex)
+	for _, trail := range trails {
+		ts, err := p.newTrailSettings(trail)
+		if err != nil {
+			return nil, fmt.Errorf("trail %s: %w", trail.Name, err)
+		}
+		settings = append(settings, ts)
+		for _, dir := range append([]string{ts.eventsDir}, slices.Collect(maps.Values(ts.categoryDirs))...) {
+			if indexes[dir] == nil {
+				indexes[dir] = newPartitionIndex(dir, p.config.Cipher)
+			}
+		}
+	}
+
+	var sampled atomic.Int64
+	var wg sync.WaitGroup
+	for range p.config.DownloadWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range p.downloadJobs {
+				p.verifyObject(ctx, job, opts, indexes, report)
+			}
+		}()
+	}
+
+	for _, ts := range settings {
+		trail := ts.trail
+		basePrefix, pairs := p.discoverTrailPairs(ctx, trail)
+		for _, pair := range pairs {
+			if err := p.enqueueVerifyObjects(ctx, ts, basePrefix, pair, opts, &sampled); err != nil {
+				p.logger.Error("failed to list objects for verification",
+					slog.String("bucket", trail.Bucket),
+					slog.String("account", pair.AccountID),
+					slog.String("region", pair.Region),
+					slog.String("error", err.Error()))
+			}
+			if ctx.Err() != nil || (opts.MaxObjects > 0 && sampled.Load() >= int64(opts.MaxObjects)) {
+				break
+			}
+		}
+	}
+
+	close(p.downloadJobs)
+	wg.Wait()
+
+	return report, ctx.Err()
+}
+
+// enqueue a sample of the objects at or before the pair's checkpoint
+func (p *Processor) enqueueVerifyObjects(ctx context.Context, ts *trailSettings, basePrefix string, pair AccountRegionPair, opts VerifyOptions, sampled *atomic.Int64) error {
+	bucket := ts.trail.Bucket
+	lastKey, err := p.stateDB.GetLastProcessedKey(bucket, pair.AccountID, pair.stateRegion())
+	if err != nil {
+		return err
+	}
+	if lastKey == "" {
+		// nothing has been processed for this pair yet
+		return nil
+	}
+
+	paginator := s3.NewListObjectsV2Paginator(p.s3Client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(pair.prefix(basePrefix)),
+		MaxKeys: aws.Int32(int32(ts.listBatchSize)),
+	})
+	for paginator.HasMorePages() {
+		page, err := p.nextPage(ctx, paginator)
+		if err != nil {
+			return err
+		}
+
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if key > lastKey {
+				return nil
+			}
+			if !logkey.IsLogFile(key) {
+				continue
+			}
+			if opts.SampleRate < 1 && rand.Float64() >= opts.SampleRate {
+				continue
+			}
+			if opts.MaxObjects > 0 && sampled.Load() >= int64(opts.MaxObjects) {
+				return nil
+			}
+			sampled.Add(1)
+
+			select {
+			case p.downloadJobs <- DownloadJob{
+				Bucket:       bucket,
+				Key:          key,
+				Size:         aws.ToInt64(obj.Size),
+				LastModified: aws.ToTime(obj.LastModified),
+				trail:        ts,
+				id:           newObjectID(),
+			}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *Processor) verifyObject(ctx context.Context, job DownloadJob, opts VerifyOptions, indexes map[string]*partitionIndex, report *VerifyReport) {
+	data, err := p.downloadObject(ctx, job.Bucket, job.Key)
+	if err == nil {
+		var records []json.RawMessage
+		records, err = decodeLogFile(data)
+		if err == nil {
+			p.verifyRecords(job, records, opts, indexes, report)
+			return
+		}
+	}
+
+	report.ObjectsFailed.Add(1)
+	p.logger.Error("failed to fetch object for verification",
+		slog.String("bucket", job.Bucket),
+		slog.String("key", job.Key),
+		slog.String("error", err.Error()))
+}
+
+func (p *Processor) verifyRecords(job DownloadJob, records []json.RawMessage, opts VerifyOptions, indexes map[string]*partitionIndex, report *VerifyReport) {
+	report.ObjectsChecked.Add(1)
+
+	var missing, inBloom int
+	for _, rawEvent := range records {
+		// only records the processor would have routed are expected in output
+		var minimal MinimalEvent
+		if err := json.Unmarshal(rawEvent, &minimal); err != nil {
+			report.EventsSkipped.Add(1)
+			continue
+		}
+		eventTime, _, ok := eventTimeOf(job.Key, minimal.EventTime)
+		accounts := p.config.AccountRouting.accounts(&minimal)
+		if !ok || len(accounts) == 0 || !job.trail.wanted(&minimal, eventTime) {
+			report.EventsSkipped.Add(1)
+			continue
+		}
+
+		partition := p.config.Partitioning.PartitionKey(accounts[0], minimal.AWSRegion, minimal.EventSource, eventTime)
+		if end, ok := writer.PartitionEnd(partition); ok && !opts.PrunedBefore.IsZero() && !end.After(opts.PrunedBefore) {
+			report.EventsSkipped.Add(1)
+			continue
+		}
+		index := indexes[job.trail.outputDir(minimal.Category())]
+		present, first, err := index.contains(partition, minimal.EventID)
+		if err != nil {
+			// the failure is kept, so it's only logged for the first event
+			if first {
+				p.logger.Error("failed to read output partition",
+					slog.String("partition", partition),
+					slog.String("error", err.Error()))
+			}
+			report.EventsSkipped.Add(1)
+			continue
+		}
+		report.EventsChecked.Add(1)
+		if present {
+			continue
+		}
+
+		missing++
+		if p.dedup.Test([]byte(minimal.EventID), eventTime) {
+			inBloom++
+		}
+	}
+
+	if missing == 0 {
+		return
+	}
+
+	report.EventsMissing.Add(int64(missing))
+	report.MissingInBloom.Add(int64(inBloom))
+	report.MissingNotSeen.Add(int64(missing - inBloom))
+	report.ObjectsWithLoss.Add(1)
+
+	p.logger.Warn("source object has events missing from output",
+		slog.String("bucket", job.Bucket),
+		slog.String("key", job.Key),
+		slog.Int("records", len(records)),
+		slog.Int("missing", missing),
+		slog.Int("missing_in_bloom", inBloom))
+}
+
+// maximum number of partitions whose event IDs are held in memory at once
+const partitionIndexSize = 256
+
+// partitionIndex lazily loads and caches the event IDs present in output
+// partitions
+type partitionIndex struct {
+	mu        sync.Mutex
+	eventsDir string
+	cipher    crypt.Cipher
+	loaded    map[string]partitionIDs
+}
+
+// partitionIDs are the event IDs of one partition, or why they couldn't be
+// read
+type partitionIDs struct {
+	ids map[string]struct{}
+	err error
+}
+
+func newPartitionIndex(eventsDir string, c crypt.Cipher) *partitionIndex {
+	return &partitionIndex{
+		eventsDir: eventsDir,
+		cipher:    c,
+		loaded:    make(map[string]partitionIDs),
+	}
+}
+
+// contains reports whether the partition holds the event. A partition that
+// can't be read fails every lookup with the same error; first is set for
+// the lookup that read it.
+func (idx *partitionIndex) contains(partition, eventID string) (found, first bool, err error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	entry, ok := idx.loaded[partition]
+	if !ok {
+		ids, err := loadPartitionIDs(filepath.Join(idx.eventsDir, partition), idx.cipher)
+		entry = partitionIDs{ids: ids, err: err}
+		if len(idx.loaded) >= partitionIndexSize {
+			for k := range idx.loaded {
+				delete(idx.loaded, k)
+				break
+			}
+		}
+		idx.loaded[partition] = entry
+	}
+	if entry.err != nil {
+		return false, !ok, entry.err
+	}
+
+	_, found = entry.ids[eventID]
+	return found, !ok, nil
+}
+
+func loadPartitionIDs(dir string, c crypt.Cipher) (map[string]struct{}, error) {
+	ids := make(map[string]struct{})
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return ids, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read partition: %w", err)
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		format, ok := writer.FormatFromPath(path)
+		if !ok || entry.IsDir() || format == writer.FormatCSV {
+			// csv files keep only their columns, so hold no event IDs
+			continue
+		}
+
+		events, err := writer.ReadEventsFile(path, c)
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range events {
+			var ev struct {
+				EventID string `json:"eventID"`
+			}
+			if json.Unmarshal(event, &ev) == nil {
+				ids[ev.EventID] = struct{}{}
+			}
+		}
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,48 @@
+package processor
+
+import (
+	"log/slog"
+	"maps"
+
+	"github.com/deceptiq/gocloudtrail/event"
+)
+
+// checkVersion counts an event whose eventVersion is newer than the event
+// model knows, warning the first time each such version turns up. The event
+// is still written as it came, unknown fields included.
+func (p *Processor) checkVersion(job DownloadJob, ev *MinimalEvent) {
+	if !event.NewerThanKnown(ev.EventVersion) {
+		return
+	}
+	if p.stats.countNewerVersion(ev.EventVersion) {
+		p.logger.Warn("event version newer than known, passing its fields through unchanged",
+			slog.String("event_version", ev.EventVersion),
+			slog.String("latest_known", event.LatestVersion),
+			slog.String("key", job.Key),
+			slog.String("object_id", job.id))
+	}
+}
+
+// countNewerVersion counts an event of a newer version, reporting whether
+// it's the first of that version
+func (s *Stats) countNewerVersion(version string) bool {
+	s.EventsNewerVersion.Add(1)
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+	if s.newerVersions == nil {
+		s.newerVersions = make(map[string]int64)
+	}
+	s.newerVersions[version]++
+	return s.newerVersions[version] == 1
+}
+
+// NewerVersions returns the events of each version newer than the event
+// model knows, nil when there were none
+func (s *Stats) NewerVersions() map[string]int64 {
+	s.versionsMu.Lock()
+	defer s.versionsMu.Unlock()
+	if len(s.newerVersions) == 0 {
+		return nil
+	}
+	return maps.Clone(s.newerVersions)
+}
@@ -2,9 +2,12 @@ package processor
 
 import (
 	"bytes"
+	"cmp"
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log/slog"
 	"sync"
@@ -12,122 +15,468 @@ import (
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/sink"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
 )
 
-func (p *Processor) downloadWorker(ctx context.Context, wg *sync.WaitGroup) {
+func (p *Processor) downloadWorker(ctx context.Context, jobs <-chan DownloadJob, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for job := range p.downloadJobs {
-		resp, err := p.s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: aws.String(job.Bucket),
-			Key:    aws.String(job.Key),
-		})
-		if err != nil {
-			p.stats.Errors.Add(1)
-			p.logger.Error("failed to download object",
+	for job := range jobs {
+		if p.jobDenied(job) {
+			p.stats.FilesSkipped.Add(1)
+			p.skipFile(job, errPrefixDenied)
+			continue
+		}
+		if p.alreadyProcessed(job) {
+			p.stats.FilesAlreadyProcessed.Add(1)
+			p.logger.Debug("skipped (already processed)",
 				slog.String("bucket", job.Bucket),
 				slog.String("key", job.Key),
-				slog.String("error", err.Error()))
+				slog.String("object_id", job.id))
+			job.alreadyProcessed = true
+			p.skipFile(job, nil)
 			continue
 		}
-
-		data, err := io.ReadAll(resp.Body)
-		_ = resp.Body.Close()
-
+		if err := p.control.acquireDownload(ctx, job.Size); err != nil {
+			p.skipFile(job, fmt.Errorf("download: %w", err))
+			continue
+		}
+		job.inflight = job.Size
+		data, meta, err := p.getObject(ctx, job.Bucket, job.Key)
+		p.control.releaseDownload()
+		p.noteAccess(job.Bucket, job.AccountID, job.Region, err, false)
 		if err != nil {
-			p.stats.Errors.Add(1)
-			p.logger.Error("failed to read object",
+			class := p.countError(err)
+			p.stats.FilesSkipped.Add(1)
+			p.logger.Error("failed to download object",
 				slog.String("bucket", job.Bucket),
 				slog.String("key", job.Key),
-				slog.String("error", err.Error()))
+				slog.String("object_id", job.id),
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+			p.skipFile(job, fmt.Errorf("download: %w", err))
 			continue
 		}
 
+		// the version downloaded, which listing may not have said
+		job.ETag = cmp.Or(meta.etag, job.ETag)
+		if !meta.lastModified.IsZero() {
+			job.LastModified = meta.lastModified
+		}
+		if job.Size == 0 {
+			job.Size = int64(len(data))
+		}
 		p.stats.FilesDownloaded.Add(1)
 		p.stats.BytesDownloaded.Add(int64(len(data)))
-
-		gr, err := gzip.NewReader(bytes.NewReader(data))
-		if err != nil {
-			p.stats.Errors.Add(1)
-			p.logger.Error("failed to decompress object",
-				slog.String("bucket", job.Bucket),
-				slog.String("key", job.Key),
-				slog.String("error", err.Error()))
-			continue
+		job.trail.progress.downloaded.Add(1)
+		if p.config.AuditLog {
+			job.audit = newObjectAudit(data, meta)
 		}
 
-		var logFile CloudTrailLogFile
-		if err := json.NewDecoder(gr).Decode(&logFile); err != nil {
-			_ = gr.Close()
-			p.stats.Errors.Add(1)
-			p.logger.Error("failed to parse JSON",
+		records, err := p.decode(job, data)
+		if err != nil {
+			class := p.countError(err)
+			p.stats.FilesSkipped.Add(1)
+			p.logger.Error("failed to decode log file",
 				slog.String("bucket", job.Bucket),
 				slog.String("key", job.Key),
-				slog.String("error", err.Error()))
+				slog.String("object_id", job.id),
+				slog.String("error", err.Error()),
+				slog.String("error_class", class.String()))
+			p.rejectFile(job, data, err.Error())
+			p.skipFile(job, fmt.Errorf("decode: %w", err))
 			continue
 		}
-		_ = gr.Close()
+		p.logger.Debug("downloaded object",
+			slog.String("bucket", job.Bucket),
+			slog.String("key", job.Key),
+			slog.String("object_id", job.id),
+			slog.Int("bytes", len(data)),
+			slog.Int("records", len(records)))
 
 		p.processJobs <- ProcessedFile{
 			Job:     job,
-			Records: logFile.Records,
+			Records: records,
+		}
+	}
+}
+
+// decode decodes a log file, failing it rather than the run if the
+// decoder panics
+func (p *Processor) decode(job DownloadJob, data []byte) (records []json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			records, err = nil, p.recovered(job, r)
+		}
+	}()
+	return decodeLogFile(data)
+}
+
+// fetch the raw (still compressed) contents of an S3 object
+func (p *Processor) downloadObject(ctx context.Context, bucket, key string) ([]byte, error) {
+	data, _, err := p.getObject(ctx, bucket, key)
+	return data, err
+}
+
+// objectMeta is what S3 reported about a downloaded object
+type objectMeta struct {
+	etag         string
+	versionID    string
+	lastModified time.Time
+}
+
+func (p *Processor) getObject(ctx context.Context, bucket, key string) ([]byte, objectMeta, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	}
+	resp, err := p.s3Client.GetObject(ctx, input, p.getObjectOptions()...)
+	// once more with fresh credentials if they expired
+	if err != nil && isExpired(err) && p.refreshCredentials(err) {
+		resp, err = p.s3Client.GetObject(ctx, input, p.getObjectOptions()...)
+	}
+	if err != nil {
+		return nil, objectMeta{}, fmt.Errorf("get object: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, objectMeta{}, fmt.Errorf("read object: %w", err)
+	}
+	return data, objectMeta{
+		etag:         aws.ToString(resp.ETag),
+		versionID:    aws.ToString(resp.VersionId),
+		lastModified: aws.ToTime(resp.LastModified),
+	}, nil
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// decompressReader keeps the decompressor's own read error, which would
+// otherwise come out of the JSON decoder looking like a parse error
+type decompressReader struct {
+	r   io.Reader
+	err error
+}
+
+func (d *decompressReader) Read(b []byte) (int, error) {
+	n, err := d.r.Read(b)
+	if err != nil && err != io.EOF {
+		d.err = err
+	}
+	return n, err
+}
+
+// decompress and parse a CloudTrail log file into its records. The encoding
+// is taken from the content, so gzip, zstd and plain JSON files all work
+// whatever their key says.
+func decodeLogFile(data []byte) ([]json.RawMessage, error) {
+	var r io.Reader
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, classified(ErrorDecompress, fmt.Errorf("decompress: %w", err))
+		}
+		defer func() { _ = gr.Close() }()
+		r = gr
+	case bytes.HasPrefix(data, zstdMagic):
+		zr, err := zstd.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, classified(ErrorDecompress, fmt.Errorf("decompress: %w", err))
+		}
+		defer zr.Close()
+		r = zr
+	default:
+		r = bytes.NewReader(data)
+	}
+
+	dr := &decompressReader{r: r}
+	var logFile CloudTrailLogFile
+	if err := json.NewDecoder(dr).Decode(&logFile); err != nil {
+		if dr.err != nil {
+			return nil, classified(ErrorDecompress, fmt.Errorf("decompress: %w", dr.err))
 		}
+		return nil, classified(ErrorParse, fmt.Errorf("parse JSON: %w", err))
 	}
+	if logFile.Records == nil {
+		return nil, classified(ErrorParse, fmt.Errorf("unexpected structure: no Records array"))
+	}
+	return logFile.Records, nil
 }
 
 // process CloudTrail log files into JSONL files
 func (p *Processor) processWorker(wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	for !p.processFiles() {
+		p.logger.Warn("restarting process worker after a panic")
+	}
+}
+
+// processFiles processes files until the queue closes, returning false if
+// it panics outside a file, which processFile already recovers
+func (p *Processor) processFiles() (done bool) {
+	var current DownloadJob
+	defer func() {
+		if r := recover(); r != nil {
+			p.countError(p.recovered(current, r))
+		}
+	}()
+
 	for file := range p.processJobs {
-		if file.Err != nil {
+		current = file.Job
+		if file.Job.lane != nil {
+			p.processInOrder(file)
 			continue
 		}
+		p.processFile(file)
+	}
+	return true
+}
+
+// processFile writes and forwards the file's new events. A panic outside
+// its events still finishes the file, as failed, so its account/region's
+// checkpoint and the run's file counts aren't left waiting on it.
+func (p *Processor) processFile(file ProcessedFile) {
+	finished := false
+	defer func() {
+		if r := recover(); r != nil {
+			err := p.recovered(file.Job, r)
+			p.countError(err)
+			if !finished {
+				finished = true
+				p.finishFile(file.Job, 0, err)
+			}
+		}
+	}()
+	if file.Err != nil || file.Job.alreadyProcessed {
+		finished = true
+		p.finishFile(file.Job, 0, file.Err)
+		return
+	}
+	if p.acked() {
+		p.stream.gate.RLock()
+		defer p.stream.gate.RUnlock()
+	}
+	p.control.acquireProcess()
+	written, writeErr := p.writeEvents(file)
+
+	p.control.releaseProcess()
+	ts := file.Job.trail
+	p.stats.FilesProcessed.Add(1)
+	ts.progress.processed.Add(1)
+	ts.progress.written.Add(written)
+	var recovered *panicError
+	if errors.As(writeErr, &recovered) {
+		p.countError(writeErr)
+	} else if writeErr != nil {
+		writeErr = fmt.Errorf("write: %w", writeErr)
+	}
+	p.logger.Debug("processed object",
+		slog.String("key", file.Job.Key),
+		slog.String("object_id", file.Job.id),
+		slog.Int("records", len(file.Records)),
+		slog.Int64("written", written))
+	finished = true
+	p.finishFile(file.Job, written, writeErr)
+}
+
+// writeEvents writes and forwards the file's events, returning how many it
+// wrote and the last write error. A panic on one of its records fails the
+// file rather than the run.
+func (p *Processor) writeEvents(file ProcessedFile) (written int64, writeErr error) {
+	defer func() {
+		if r := recover(); r != nil {
+			writeErr = p.recovered(file.Job, r)
+		}
+	}()
+	ts := file.Job.trail
+	pair := p.stats.pair(file.Job)
+	// sink routes and detections test the whole event, decoded once
+	decode := p.config.Detector != nil || sink.Routed(p.config.Sinks)
+	// with source file names an S3 log file's events are gathered and written
+	// together once the file is done, into files named after it
+	var bySource map[*writer.JSONLWriter][]writer.SourceEvent
+	// the event IDs already gathered from the object
+	var inSource map[string]bool
+	source := file.Job.Bucket + "/" + file.Job.Key
+	if p.config.SourceFileNames && file.Job.Bucket != "" && !p.config.StreamOnly {
+		bySource = make(map[*writer.JSONLWriter][]writer.SourceEvent)
+		inSource = make(map[string]bool)
+	}
 
-		for _, rawEvent := range file.Records {
-			p.stats.EventsProcessed.Add(1)
+	audit := file.Job.audit
+	if audit != nil {
+		audit.records = int64(len(file.Records))
+	}
+	// events that don't name their category take their folder's
+	folder, _ := logkey.Folder(file.Job.Key)
+	category := logkey.FolderCategory(folder)
+	p.prefetchDedup(file.Job.Key, file.Records, source, bySource != nil)
+
+	for _, rawEvent := range file.Records {
+		p.stats.EventsProcessed.Add(1)
+		pair.Events.Add(1)
 
-			// parse minimal fields for deduplication
-			var minimal MinimalEvent
-			if err := json.Unmarshal(rawEvent, &minimal); err != nil {
+		// parse minimal fields for deduplication
+		var minimal MinimalEvent
+		if err := json.Unmarshal(rawEvent, &minimal); err != nil {
+			p.rejectRecord(file.Job, pair, rawEvent, "invalid JSON: "+err.Error())
+			continue
+		}
+		if minimal.EventCategory == "" {
+			minimal.EventCategory = category
+		}
+		p.checkVersion(file.Job, &minimal)
+
+		if p.config.ValidateEvents {
+			if reason := validateEvent(&minimal); reason != "" {
+				p.rejectRecord(file.Job, pair, rawEvent, reason)
 				continue
 			}
+		}
 
-			// check bloom filter for duplicates
-			if p.bloomFilter.Test([]byte(minimal.EventID)) {
-				p.stats.EventsDuplicate.Add(1)
-				continue
+		// parse event time, or take the log file's
+		eventTime, fallback, ok := eventTimeOf(file.Job.Key, minimal.EventTime)
+		if !ok {
+			p.rejectRecord(file.Job, pair, rawEvent, "invalid eventTime")
+			continue
+		}
+		if fallback {
+			p.countTimeFallback(file.Job, &minimal, eventTime)
+		}
+
+		// apply time range and filters before dedup so a later run with
+		// wider settings still picks these events up
+		if !ts.wanted(&minimal, eventTime) {
+			p.stats.EventsFiltered.Add(1)
+			pair.Filtered.Add(1)
+			if audit != nil {
+				audit.filtered++
 			}
+			continue
+		}
 
-			// parse event time
-			eventTime, err := time.Parse(time.RFC3339, minimal.EventTime)
-			if err != nil {
+		// check bloom filter for duplicates. A source-named file keeps the ones
+		// this same object added before, so rewriting it after a crash or on a
+		// rerun doesn't lose them; duplicates from other objects are dropped.
+		rewrite := false
+		if p.dedup.Test([]byte(minimal.EventID), eventTime) {
+			rewrite = bySource != nil && !inSource[minimal.EventID] &&
+				p.dedup.Test(sourceDedupID(source, minimal.EventID), eventTime)
+			if !rewrite {
+				p.stats.EventsDuplicate.Add(1)
+				pair.Duplicate.Add(1)
+				if audit != nil {
+					audit.duplicate++
+				}
 				continue
 			}
+		}
+
+		// the copy written and sent, so no sink batch gets a giant record;
+		// detections still see the event as delivered. A rewritten event was
+		// counted the first time.
+		event := p.limitSize(file.Job, &minimal, eventTime, rawEvent, !rewrite)
 
-			// determine account ID
-			accountID := minimal.RecipientAccountID
-			if accountID == "" {
-				accountID = minimal.UserIdentity.AccountID
+		// determine the accounts it's partitioned under, the owner first
+		accounts := p.config.AccountRouting.accounts(&minimal)
+		if len(accounts) == 0 {
+			p.rejectRecord(file.Job, pair, rawEvent, "no account ID")
+			continue
+		}
+		accountID := accounts[0]
+
+		// write to JSONL, unless events only go to the sinks
+		if bySource != nil {
+			w := ts.outputWriter(minimal.Category())
+			out := p.config.Derive.Apply(event)
+			for _, account := range accounts {
+				bySource[w] = append(bySource[w], sourceEvent(&minimal, account, eventTime, out))
 			}
-			if accountID == "" {
-				continue
+		} else if !p.config.StreamOnly {
+			w := ts.outputWriter(minimal.Category())
+			out := p.config.Derive.Apply(event)
+			var err error
+			for _, account := range accounts {
+				if err = w.Write(account, minimal.AWSRegion, minimal.EventSource, eventTime, out); err != nil {
+					break
+				}
 			}
-
-			// write to JSONL
-			if err := p.jsonlWriter.Write(accountID, minimal.AWSRegion, eventTime, rawEvent); err != nil {
+			if err != nil {
 				p.logger.Error("failed to write event to JSONL",
+					slog.String("key", file.Job.Key),
+					slog.String("object_id", file.Job.id),
 					slog.String("error", err.Error()))
+				writeErr = err
 				continue
 			}
+		}
 
-			// add to bloom filter
-			p.bloomFilter.Add([]byte(minimal.EventID))
+		// add to bloom filter, and for a source-named file under its object
+		p.dedup.Add([]byte(minimal.EventID), eventTime)
+		if bySource != nil {
+			p.dedup.Add(sourceDedupID(source, minimal.EventID), eventTime)
+			inSource[minimal.EventID] = true
+		}
+
+		p.stats.EventsWritten.Add(1)
+		pair.Written.Add(1)
+		written++
+		if audit != nil && !p.config.StreamOnly {
+			for _, account := range accounts {
+				audit.outputs[ts.outputWriter(minimal.Category()).PartitionDir(account, minimal.AWSRegion, minimal.EventSource, eventTime)] = true
+			}
+		}
+
+		// a rewritten event was already analysed, forwarded and checked
+		if rewrite {
+			continue
+		}
+		if p.analytics != nil {
+			p.analytics.add(&minimal, accountID, eventTime)
+		}
+		var ev map[string]any
+		if decode && json.Unmarshal(rawEvent, &ev) != nil {
+			ev = nil
+		}
+		if len(p.config.Sinks) > 0 {
+			p.forward(event, ev, file.Job.checkpoint)
+		}
+		if p.config.Detector != nil && ev != nil {
+			p.detect(ev, rawEvent, eventTime)
+		}
+	}
 
-			p.stats.EventsWritten.Add(1)
+	for w, events := range bySource {
+		if err := w.WriteSource(source, events); err != nil {
+			p.logger.Error("failed to write source file",
+				slog.String("key", file.Job.Key),
+				slog.String("object_id", file.Job.id),
+				slog.String("error", err.Error()))
+			writeErr = err
 		}
+	}
+
+	return written, writeErr
+}
 
-		p.stats.FilesProcessed.Add(1)
+func sourceEvent(minimal *MinimalEvent, accountID string, eventTime time.Time, rawEvent json.RawMessage) writer.SourceEvent {
+	return writer.SourceEvent{
+		AccountID:   accountID,
+		Region:      minimal.AWSRegion,
+		EventSource: minimal.EventSource,
+		Time:        eventTime,
+		Raw:         rawEvent,
 	}
 }
 
@@ -154,16 +503,37 @@ func (p *Processor) jsonlFlusher(ctx context.Context, interval time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := p.jsonlWriter.FlushAll(); err != nil {
-				p.logger.Error("failed to flush JSONL buffers",
-					slog.String("error", err.Error()))
+			if p.acked() {
+				p.commitCheckpoints()
+			} else {
+				p.flushWriters()
+			}
+			p.retrySpill()
+			p.registerPartitions(ctx)
+			if p.config.Notifier != nil {
+				p.notifyReopened(ctx)
 			}
-			p.stats.JSONLFilesWritten.Store(int64(p.jsonlWriter.BufferCount()))
 		}
 	}
 }
 
-func (p *Processor) bloomSaver(ctx context.Context, interval time.Duration) {
+// flush every writer and refresh the buffer count stat
+func (p *Processor) flushWriters() {
+	var buffers int
+	for _, w := range p.allWriters() {
+		if err := w.FlushAll(); err != nil {
+			p.logger.Error("failed to flush JSONL buffers",
+				slog.String("error", err.Error()))
+		}
+		buffers += w.BufferCount()
+		p.signManifests(w.ChangedManifests())
+	}
+	p.stats.JSONLFilesWritten.Store(int64(buffers))
+}
+
+// stateSaver periodically saves the bloom filter, recorded objects and
+// lifetime counters
+func (p *Processor) stateSaver(ctx context.Context, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
@@ -172,10 +542,14 @@ func (p *Processor) bloomSaver(ctx context.Context, interval time.Duration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			if err := p.bloomFilter.Save(); err != nil {
+			if p.acked() {
+				p.commitStream()
+			} else if err := p.dedup.Save(); err != nil {
 				p.logger.Error("failed to save bloom filter",
 					slog.String("error", err.Error()))
 			}
+			p.saveObjects()
+			p.saveCounters()
 		}
 	}
 }
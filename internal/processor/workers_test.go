@@ -0,0 +1,69 @@
+package processor
+
+import (
+	"encoding/json"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// mapDedup is a DedupStore without false positives
+type mapDedup map[string]bool
+
+func (m mapDedup) Test(id []byte, _ time.Time) bool { return m[string(id)] }
+func (m mapDedup) Add(id []byte, _ time.Time)       { m[string(id)] = true }
+func (m mapDedup) Save() error                      { return nil }
+
+func TestSourceFileNamesDedup(t *testing.T) {
+	dir := t.TempDir()
+	logger := slog.New(slog.DiscardHandler)
+	p := New(nil, nil, nil, nil, mapDedup{}, Config{SourceFileNames: true, EventsDir: dir}, logger)
+	ts := &trailSettings{writer: writer.New(dir, 1000, writer.Encoding{Format: writer.FormatJSONL}, writer.Partitioning{}, logger)}
+
+	event := json.RawMessage(`{"eventVersion":"1.11","eventID":"11111111-2222-3333-4444-555555555555","eventTime":"2024-01-15T07:10:00Z","eventSource":"s3.amazonaws.com","eventName":"GetObject","awsRegion":"us-east-1","recipientAccountId":"123456789012"}`)
+	process := func(key string) {
+		t.Helper()
+		job := DownloadJob{Bucket: "bucket", Key: key, AccountID: "123456789012", Region: "us-east-1", trail: ts}
+		if _, err := p.writeEvents(ProcessedFile{Job: job, Records: []json.RawMessage{event}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an organization trail and an account trail deliver the same event
+	orgKey := "AWSLogs/o-abc123/123456789012/CloudTrail/us-east-1/2024/01/15/123456789012_CloudTrail_us-east-1_20240115T0715Z_org.json.gz"
+	acctKey := "AWSLogs/123456789012/CloudTrail/us-east-1/2024/01/15/123456789012_CloudTrail_us-east-1_20240115T0715Z_acct.json.gz"
+	process(orgKey)
+	process(acctKey)
+	// reprocessing the first object rewrites its file with the event
+	process(orgKey)
+
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), "11111111-2222-3333-4444-555555555555") {
+			files = append(files, filepath.Base(path))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := writer.SourceFileName("bucket/"+orgKey, writer.Encoding{Format: writer.FormatJSONL})
+	if len(files) != 1 || files[0] != want {
+		t.Errorf("event written to %v, want only [%s]", files, want)
+	}
+	if got := p.stats.EventsDuplicate.Load(); got != 1 {
+		t.Errorf("EventsDuplicate = %d, want 1", got)
+	}
+}
@@ -0,0 +1,176 @@
+package prune
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type Options struct {
+	EventsDir string
+	// partitions whose period ended before this are pruned
+	Cutoff time.Time
+	// when set, files are uploaded here before being deleted locally
+	S3Client      *s3.Client
+	ArchiveBucket string
+	ArchivePrefix string
+	// where archived partitions' next file numbers are recorded, so files
+	// written to them later aren't uploaded over the archived ones; needed
+	// when archiving
+	StateDB *state.DB
+	DryRun  bool
+}
+
+type Result struct {
+	Partitions    int
+	FilesRemoved  int
+	FilesArchived int
+	BytesRemoved  int64
+}
+
+// Run deletes (or archives then deletes) every partition that closed before
+// the cutoff, based on the date components in its directory path
+func Run(ctx context.Context, opts Options, logger *slog.Logger) (Result, error) {
+	var res Result
+
+	partitions := make(map[string][]string)
+	err := filepath.WalkDir(opts.EventsDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		dir := filepath.Dir(p)
+		rel, err := filepath.Rel(opts.EventsDir, dir)
+		if err != nil {
+			return err
+		}
+		if end, ok := writer.PartitionEnd(rel); ok && !end.After(opts.Cutoff) {
+			partitions[dir] = append(partitions[dir], p)
+		}
+		return nil
+	})
+	if err != nil {
+		return res, fmt.Errorf("scan events dir: %w", err)
+	}
+
+	dirs := make([]string, 0, len(partitions))
+	for dir := range partitions {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if opts.ArchiveBucket != "" && !opts.DryRun {
+			if err := raiseFileCounter(opts, dir, partitions[dir]); err != nil {
+				return res, err
+			}
+		}
+
+		for _, file := range partitions[dir] {
+			info, err := os.Stat(file)
+			if err != nil {
+				return res, fmt.Errorf("stat %s: %w", file, err)
+			}
+
+			if opts.ArchiveBucket != "" && !opts.DryRun {
+				if err := Upload(ctx, opts.S3Client, opts.ArchiveBucket, opts.ArchivePrefix, opts.EventsDir, file); err != nil {
+					return res, fmt.Errorf("archive %s: %w", file, err)
+				}
+				res.FilesArchived++
+			}
+
+			if !opts.DryRun {
+				if err := os.Remove(file); err != nil {
+					return res, fmt.Errorf("remove %s: %w", file, err)
+				}
+			}
+			res.FilesRemoved++
+			res.BytesRemoved += info.Size()
+		}
+
+		res.Partitions++
+		if !opts.DryRun {
+			removeEmptyParents(dir, opts.EventsDir)
+		}
+
+		logger.Info("pruned partition",
+			slog.String("partition", dir),
+			slog.Int("files", len(partitions[dir])),
+			slog.Bool("archived", opts.ArchiveBucket != ""),
+			slog.Bool("dry_run", opts.DryRun))
+	}
+
+	return res, nil
+}
+
+// raiseFileCounter records the number after a partition's highest
+// events_NNNNN file before its files are removed
+func raiseFileCounter(opts Options, dir string, files []string) error {
+	next := 0
+	for _, file := range files {
+		if n, ok := writer.FileNumber(filepath.Base(file)); ok && n >= next {
+			next = n + 1
+		}
+	}
+	if next == 0 {
+		return nil
+	}
+	rel, err := filepath.Rel(opts.EventsDir, dir)
+	if err != nil {
+		return err
+	}
+	if err := opts.StateDB.RaiseFileCounters(opts.EventsDir, map[string]int{rel: next}); err != nil {
+		return fmt.Errorf("record file counter for %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Upload copies an output file to bucket, keyed by prefix and its path
+// relative to eventsDir
+func Upload(ctx context.Context, client *s3.Client, bucket, prefix, eventsDir, file string) error {
+	rel, err := filepath.Rel(eventsDir, file)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	key := path.Join(prefix, filepath.ToSlash(rel))
+	_, err = client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	return err
+}
+
+// remove dir and any parents left empty, stopping at root
+func removeEmptyParents(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && dir != "."; dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package quarantine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Writer sets aside records and files that failed validation, each with the
+// reason, so they can be inspected and replayed by hand
+type Writer struct {
+	dir string
+
+	mu      sync.Mutex
+	records *os.File
+	files   *os.File
+}
+
+// entry is one line of records.jsonl or files.jsonl
+type entry struct {
+	Time   time.Time       `json:"time"`
+	Source string          `json:"source"`
+	Reason string          `json:"reason"`
+	Record json.RawMessage `json:"record,omitempty"`
+	Raw    string          `json:"raw,omitempty"`
+	Path   string          `json:"path,omitempty"`
+}
+
+func New(dir string) *Writer {
+	return &Writer{dir: dir}
+}
+
+// Record quarantines a single event. Records that aren't valid JSON are kept
+// as a string.
+func (w *Writer) Record(source, reason string, record []byte) error {
+	e := entry{Time: time.Now().UTC(), Source: source, Reason: reason}
+	if json.Valid(record) {
+		e.Record = record
+	} else {
+		e.Raw = string(record)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.append(&w.records, "records.jsonl", e)
+}
+
+// File quarantines a whole source object, saving its raw bytes under
+// files/<bucket>/<key>
+func (w *Writer) File(bucket, key, reason string, data []byte) error {
+	// a key may start with a slash, which still puts it under its bucket
+	path, err := w.path("files", bucket, filepath.FromSlash(strings.TrimLeft(key, "/")))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("create quarantine directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("write quarantined file: %w", err)
+	}
+
+	e := entry{
+		Time:   time.Now().UTC(),
+		Source: fmt.Sprintf("s3://%s/%s", bucket, key),
+		Reason: reason,
+		Path:   path,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.append(&w.files, "files.jsonl", e)
+}
+
+// Output moves a damaged output file out of the events dir to
+// output/<rel>, where rel is its path under the events dir
+func (w *Writer) Output(path, rel, reason string) error {
+	dest, err := w.path("output", rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return fmt.Errorf("create quarantine directory: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		// the quarantine dir may be on another volume
+		data, readErr := os.ReadFile(path)
+		if readErr != nil {
+			return fmt.Errorf("read damaged file: %w", readErr)
+		}
+		if err := os.WriteFile(dest, data, 0o644); err != nil {
+			return fmt.Errorf("write quarantined file: %w", err)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("remove damaged file: %w", err)
+		}
+	}
+
+	e := entry{
+		Time:   time.Now().UTC(),
+		Source: path,
+		Reason: reason,
+		Path:   dest,
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.append(&w.files, "files.jsonl", e)
+}
+
+// path places parts under the quarantine dir's sub dir, refusing any part
+// that would climb out of the one before it, such as an object key with ..
+// segments or an absolute path
+func (w *Writer) path(sub string, parts ...string) (string, error) {
+	for _, part := range parts {
+		if !filepath.IsLocal(part) {
+			return "", fmt.Errorf("quarantine path %q escapes %s", part, filepath.Join(w.dir, sub))
+		}
+	}
+	return filepath.Join(append([]string{w.dir, sub}, parts...)...), nil
+}
+
+func (w *Writer) append(f **os.File, name string, e entry) error {
+	if *f == nil {
+		if err := os.MkdirAll(w.dir, 0o755); err != nil {
+			return fmt.Errorf("create quarantine directory: %w", err)
+		}
+		file, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open quarantine log: %w", err)
+		}
+		*f = file
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal quarantine entry: %w", err)
+	}
+	if _, err := (*f).Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write quarantine entry: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var firstErr error
+	for _, f := range []*os.File{w.records, w.files} {
+		if f == nil {
+			continue
+		}
+		if err := f.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	w.records, w.files = nil, nil
+	return firstErr
+}
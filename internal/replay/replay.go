@@ -0,0 +1,241 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/sink"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+type Options struct {
+	EventsDirs []string
+	// decrypts encrypted event files
+	Cipher crypt.Cipher
+	// events outside [Start, End) are skipped; zero means unbounded
+	Start   time.Time
+	End     time.Time
+	Filters config.Filters
+	// sleep between events for their original gap in event time, divided
+	// by Speed; gaps are capped at MaxGap when it is set
+	Pace   bool
+	Speed  float64
+	MaxGap time.Duration
+}
+
+type Result struct {
+	Files  int
+	Events int64
+	Sent   int64
+}
+
+type event struct {
+	time time.Time
+	raw  json.RawMessage
+}
+
+// Run sends the selected events to every sink in event-time order. Files are
+// read one time partition (an hour, unless partition_granularity is coarser)
+// at a time across all accounts and regions, so memory stays bounded by the
+// busiest partition.
+func Run(ctx context.Context, opts Options, sinks []*sink.Buffered, logger *slog.Logger) (Result, error) {
+	var res Result
+
+	hours, err := hourPartitions(opts)
+	if err != nil {
+		return res, err
+	}
+
+	routed := sink.Routed(sinks)
+	var last time.Time
+	for _, hour := range hours {
+		events, err := readHour(hour.files, opts, &res, logger)
+		if err != nil {
+			return res, err
+		}
+
+		for _, ev := range events {
+			if err := ctx.Err(); err != nil {
+				return res, err
+			}
+
+			if opts.Pace && !last.IsZero() {
+				if err := pause(ctx, ev.time.Sub(last), opts, sinks); err != nil {
+					return res, err
+				}
+			}
+			last = ev.time
+
+			var decoded map[string]any
+			if routed && json.Unmarshal(ev.raw, &decoded) != nil {
+				decoded = nil
+			}
+			for _, s := range sinks {
+				if !s.Wants(decoded) {
+					continue
+				}
+				if err := s.Write(ctx, ev.raw); err != nil {
+					return res, err
+				}
+			}
+			res.Sent++
+		}
+	}
+
+	for _, s := range sinks {
+		if err := s.Flush(ctx); err != nil {
+			return res, err
+		}
+	}
+	return res, nil
+}
+
+type hourPartition struct {
+	end   time.Time
+	files []string
+}
+
+// hourPartitions groups event files by the hour partition they belong to,
+// skipping hours outside the time range
+func hourPartitions(opts Options) ([]*hourPartition, error) {
+	byHour := make(map[time.Time]*hourPartition)
+	// category dirs often sit inside events_dir, so read each file once
+	seen := make(map[string]bool)
+
+	for _, root := range opts.EventsDirs {
+		if _, err := os.Stat(root); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if _, ok := writer.FormatFromPath(path); !ok {
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			if seen[abs] {
+				return nil
+			}
+			seen[abs] = true
+
+			start, end, ok := writer.PartitionRange(filepath.Dir(path))
+			if !ok {
+				return nil
+			}
+			if !opts.Start.IsZero() && !end.After(opts.Start) {
+				return nil
+			}
+			if !opts.End.IsZero() && !start.Before(opts.End) {
+				return nil
+			}
+
+			h, ok := byHour[end]
+			if !ok {
+				h = &hourPartition{end: end}
+				byHour[end] = h
+			}
+			h.files = append(h.files, path)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan events dir: %w", err)
+		}
+	}
+
+	hours := make([]*hourPartition, 0, len(byHour))
+	for _, h := range byHour {
+		hours = append(hours, h)
+	}
+	sort.Slice(hours, func(i, j int) bool { return hours[i].end.Before(hours[j].end) })
+	return hours, nil
+}
+
+func readHour(files []string, opts Options, res *Result, logger *slog.Logger) ([]event, error) {
+	var events []event
+	for _, path := range files {
+		records, err := writer.ReadEventsFile(path, opts.Cipher)
+		if err != nil {
+			logger.Warn("skipping unreadable events file",
+				slog.String("path", path),
+				slog.String("error", err.Error()))
+			continue
+		}
+		res.Files++
+
+		for _, raw := range records {
+			res.Events++
+
+			var ev struct {
+				EventTime   string `json:"eventTime"`
+				EventSource string `json:"eventSource"`
+				EventName   string `json:"eventName"`
+			}
+			if err := json.Unmarshal(raw, &ev); err != nil {
+				continue
+			}
+			t, err := time.Parse(time.RFC3339, ev.EventTime)
+			if err != nil {
+				continue
+			}
+			if !opts.Start.IsZero() && t.Before(opts.Start) {
+				continue
+			}
+			if !opts.End.IsZero() && !t.Before(opts.End) {
+				continue
+			}
+			if !opts.Filters.Match(ev.EventSource, ev.EventName) {
+				continue
+			}
+			events = append(events, event{time: t, raw: raw})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+	return events, nil
+}
+
+// pause waits out the gap before the next event, first flushing the sinks
+// so what has been replayed so far is delivered on time
+func pause(ctx context.Context, gap time.Duration, opts Options, sinks []*sink.Buffered) error {
+	if gap <= 0 {
+		return nil
+	}
+	if opts.MaxGap > 0 && gap > opts.MaxGap {
+		gap = opts.MaxGap
+	}
+	if opts.Speed > 0 {
+		gap = time.Duration(float64(gap) / opts.Speed)
+	}
+
+	for _, s := range sinks {
+		if err := s.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	timer := time.NewTimer(gap)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
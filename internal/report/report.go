@@ -0,0 +1,369 @@
+package report
+
+import (
+	"bufio"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/event"
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+	"github.com/deceptiq/gocloudtrail/internal/detect"
+	"github.com/deceptiq/gocloudtrail/internal/logkey"
+	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+//go:embed report.html.tmpl
+var reportTemplate string
+
+type Options struct {
+	// every directory events are written to (events_dir, category and
+	// per-trail dirs); missing ones are skipped
+	EventsDirs []string
+	// decrypts encrypted event files
+	Cipher      crypt.Cipher
+	Checkpoints []state.Checkpoint
+	// prefixes runs skip for AccessDenied
+	Denied       []state.DeniedPrefix
+	FindingsFile string
+	// most recent findings listed individually
+	MaxFindings int
+	Now         time.Time
+}
+
+type Report struct {
+	GeneratedAt time.Time
+	Events      int64
+	Errors      int64
+	Files       int
+	Days        []string
+	Accounts    []*Account
+	TopErrors   []ErrorCount
+	Findings    Findings
+	Health      []Health
+	Denied      []state.DeniedPrefix
+}
+
+// Account is one account's activity per day, with bars for the timeline
+type Account struct {
+	ID     string
+	Events int64
+	Errors int64
+	days   map[string]*dayCount
+	Bars   []Bar
+}
+
+type dayCount struct {
+	events, errors int64
+}
+
+// Bar is one day in an account's timeline, in the SVG's 100x20 viewBox
+type Bar struct {
+	X, Y, Width, Height float64
+	Label               string
+}
+
+type ErrorCount struct {
+	Code      string
+	EventName string
+	Count     int64
+}
+
+type Findings struct {
+	Total      int
+	BySeverity []Count
+	ByRule     []Count
+	Recent     []detect.Finding
+}
+
+type Count struct {
+	Name  string
+	Count int64
+}
+
+// Health is a checkpoint with its lag and last run's rejection rates
+type Health struct {
+	state.Checkpoint
+	CheckpointTime *time.Time
+	Lag            string
+	Stale          bool
+}
+
+// checkpoints further behind than this are highlighted
+const staleAfter = 24 * time.Hour
+
+// Build scans the output and state for the report
+func Build(opts Options, logger *slog.Logger) (*Report, error) {
+	r := &Report{GeneratedAt: opts.Now.UTC()}
+
+	accounts := make(map[string]*Account)
+	errorCounts := make(map[[2]string]int64)
+	// category dirs often sit inside events_dir, so read each file once
+	scanned := make(map[string]bool)
+	for _, dir := range opts.EventsDirs {
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err := r.scanEvents(dir, opts.Cipher, scanned, accounts, errorCounts, logger); err != nil {
+			return nil, err
+		}
+	}
+	r.buildTimeline(accounts)
+
+	for key, n := range errorCounts {
+		r.TopErrors = append(r.TopErrors, ErrorCount{Code: key[0], EventName: key[1], Count: n})
+	}
+	sort.Slice(r.TopErrors, func(i, j int) bool {
+		if r.TopErrors[i].Count != r.TopErrors[j].Count {
+			return r.TopErrors[i].Count > r.TopErrors[j].Count
+		}
+		return r.TopErrors[i].Code+r.TopErrors[i].EventName < r.TopErrors[j].Code+r.TopErrors[j].EventName
+	})
+	if len(r.TopErrors) > 25 {
+		r.TopErrors = r.TopErrors[:25]
+	}
+
+	if opts.FindingsFile != "" {
+		findings, err := readFindings(opts.FindingsFile, opts.MaxFindings)
+		if err != nil {
+			return nil, err
+		}
+		r.Findings = findings
+	}
+
+	for _, cp := range opts.Checkpoints {
+		h := Health{Checkpoint: cp, Lag: "-"}
+		if t, ok := logkey.Time(cp.LastProcessedKey); ok {
+			lag := opts.Now.Sub(t)
+			h.CheckpointTime = &t
+			h.Lag = lag.Round(time.Minute).String()
+			h.Stale = lag > staleAfter
+		}
+		r.Health = append(r.Health, h)
+	}
+	r.Denied = opts.Denied
+
+	return r, nil
+}
+
+// scanEvents counts events and errors per account and day. Partitions are
+// account/region/YYYY/MM/DD/HH, but each event is decoded anyway for its
+// error code.
+func (r *Report) scanEvents(root string, c crypt.Cipher, scanned map[string]bool, accounts map[string]*Account, errorCounts map[[2]string]int64, logger *slog.Logger) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if _, ok := writer.FormatFromPath(path); !ok {
+			return nil
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if scanned[abs] {
+			return nil
+		}
+		scanned[abs] = true
+
+		events, err := writer.ReadEventsFile(path, c)
+		if err != nil {
+			logger.Warn("skipping unreadable events file",
+				slog.String("path", path),
+				slog.String("error", err.Error()))
+			return nil
+		}
+		r.Files++
+
+		for _, raw := range events {
+			var ev struct {
+				EventTime    string `json:"eventTime"`
+				EventName    string `json:"eventName"`
+				ErrorCode    string `json:"errorCode"`
+				UserIdentity struct {
+					AccountID string `json:"accountId"`
+				} `json:"userIdentity"`
+				RecipientAccountID string `json:"recipientAccountId"`
+			}
+			if err := json.Unmarshal(raw, &ev); err != nil {
+				continue
+			}
+			eventTime, err := event.ParseTime(ev.EventTime)
+			if err != nil {
+				continue
+			}
+			accountID := ev.RecipientAccountID
+			if accountID == "" {
+				accountID = ev.UserIdentity.AccountID
+			}
+
+			a, ok := accounts[accountID]
+			if !ok {
+				a = &Account{ID: accountID, days: make(map[string]*dayCount)}
+				accounts[accountID] = a
+			}
+			day := eventTime.UTC().Format("2006-01-02")
+			dc, ok := a.days[day]
+			if !ok {
+				dc = &dayCount{}
+				a.days[day] = dc
+			}
+
+			r.Events++
+			a.Events++
+			dc.events++
+			if ev.ErrorCode != "" {
+				r.Errors++
+				a.Errors++
+				dc.errors++
+				errorCounts[[2]string{ev.ErrorCode, ev.EventName}]++
+			}
+		}
+		return nil
+	})
+}
+
+// buildTimeline lays out each account's days on the report's full day range
+func (r *Report) buildTimeline(accounts map[string]*Account) {
+	var first, last time.Time
+	for _, a := range accounts {
+		for day := range a.days {
+			t, _ := time.Parse("2006-01-02", day)
+			if first.IsZero() || t.Before(first) {
+				first = t
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		r.Accounts = append(r.Accounts, a)
+	}
+	sort.Slice(r.Accounts, func(i, j int) bool {
+		if r.Accounts[i].Events != r.Accounts[j].Events {
+			return r.Accounts[i].Events > r.Accounts[j].Events
+		}
+		return r.Accounts[i].ID < r.Accounts[j].ID
+	})
+	if first.IsZero() {
+		return
+	}
+
+	for t := first; !t.After(last); t = t.AddDate(0, 0, 1) {
+		r.Days = append(r.Days, t.Format("2006-01-02"))
+	}
+
+	width := 100 / float64(len(r.Days))
+	for _, a := range r.Accounts {
+		var peak int64
+		for _, dc := range a.days {
+			peak = max(peak, dc.events)
+		}
+		for i, day := range r.Days {
+			dc, ok := a.days[day]
+			if !ok {
+				continue
+			}
+			height := float64(dc.events) * 20 / float64(peak)
+			a.Bars = append(a.Bars, Bar{
+				X:      float64(i) * width,
+				Y:      20 - height,
+				Width:  width,
+				Height: height,
+				Label:  fmt.Sprintf("%s: %d events, %d errors", day, dc.events, dc.errors),
+			})
+		}
+	}
+}
+
+func readFindings(path string, limit int) (Findings, error) {
+	var out Findings
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return out, fmt.Errorf("open findings: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	severities := make(map[string]int64)
+	rules := make(map[string]int64)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var finding detect.Finding
+		if err := json.Unmarshal(scanner.Bytes(), &finding); err != nil {
+			continue
+		}
+		out.Total++
+		severities[finding.Severity]++
+		rules[finding.RuleID+" "+finding.Title]++
+
+		// the full event isn't shown, so don't hold on to it
+		finding.Event = nil
+		out.Recent = append(out.Recent, finding)
+	}
+	if err := scanner.Err(); err != nil {
+		return out, fmt.Errorf("read findings: %w", err)
+	}
+
+	sort.SliceStable(out.Recent, func(i, j int) bool {
+		return out.Recent[i].EventTime > out.Recent[j].EventTime
+	})
+	if limit > 0 && len(out.Recent) > limit {
+		out.Recent = out.Recent[:limit]
+	}
+	out.BySeverity = sortedCounts(severities)
+	out.ByRule = sortedCounts(rules)
+	return out, nil
+}
+
+func sortedCounts(m map[string]int64) []Count {
+	counts := make([]Count, 0, len(m))
+	for name, n := range m {
+		counts = append(counts, Count{Name: name, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Name < counts[j].Name
+	})
+	return counts
+}
+
+// Render writes the report as a single self-contained HTML page
+func Render(w io.Writer, r *Report) error {
+	tmpl, err := template.New("report").Funcs(template.FuncMap{
+		"percent": func(n, total int64) string {
+			if total == 0 {
+				return "-"
+			}
+			return fmt.Sprintf("%.1f%%", float64(n)*100/float64(total))
+		},
+		"lower": strings.ToLower,
+		"last":  func(days []string) string { return days[len(days)-1] },
+	}).Parse(reportTemplate)
+	if err != nil {
+		return fmt.Errorf("parse report template: %w", err)
+	}
+	if err := tmpl.Execute(w, r); err != nil {
+		return fmt.Errorf("render report: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+
+	"github.com/deceptiq/gocloudtrail/event"
+)
+
+// eventColumns are the string columns of the table sinks, mirroring the
+// parquet output columns. Tables also get event_time and raw, the full
+// event.
+var eventColumns = []string{
+	"event_id", "event_name", "event_source", "event_type", "event_category",
+	"aws_region", "source_ip_address", "user_agent", "error_code", "error_message",
+	"recipient_account_id", "user_identity_type", "user_identity_arn", "user_identity_account_id",
+}
+
+// columnEvent picks the columns out of a raw event
+type columnEvent struct {
+	EventTime          string `json:"eventTime"`
+	EventID            string `json:"eventID"`
+	EventName          string `json:"eventName"`
+	EventSource        string `json:"eventSource"`
+	EventType          string `json:"eventType"`
+	EventCategory      string `json:"eventCategory"`
+	AWSRegion          string `json:"awsRegion"`
+	SourceIPAddress    string `json:"sourceIPAddress"`
+	UserAgent          string `json:"userAgent"`
+	ErrorCode          string `json:"errorCode"`
+	ErrorMessage       string `json:"errorMessage"`
+	RecipientAccountID string `json:"recipientAccountId"`
+	UserIdentity       struct {
+		Type      string `json:"type"`
+		ARN       string `json:"arn"`
+		AccountID string `json:"accountId"`
+	} `json:"userIdentity"`
+}
+
+// column returns the named string column, empty when the event lacks it
+func (e *columnEvent) column(name string) string {
+	switch name {
+	case "event_date":
+		if t, err := event.ParseTime(e.EventTime); err == nil {
+			return t.UTC().Format(time.DateOnly)
+		}
+	case "event_id":
+		return e.EventID
+	case "event_name":
+		return e.EventName
+	case "event_source":
+		return e.EventSource
+	case "event_type":
+		return e.EventType
+	case "event_category":
+		return e.EventCategory
+	case "aws_region":
+		return e.AWSRegion
+	case "source_ip_address":
+		return e.SourceIPAddress
+	case "user_agent":
+		return e.UserAgent
+	case "error_code":
+		return e.ErrorCode
+	case "error_message":
+		return e.ErrorMessage
+	case "recipient_account_id":
+		return e.RecipientAccountID
+	case "user_identity_type":
+		return e.UserIdentity.Type
+	case "user_identity_arn":
+		return e.UserIdentity.ARN
+	case "user_identity_account_id":
+		return e.UserIdentity.AccountID
+	}
+	return ""
+}
+
+// buildRecord fills a record with schema's columns: timestamps take the
+// event time, raw the whole event and other strings the named column
+func buildRecord(schema *arrow.Schema, events []json.RawMessage) arrow.Record {
+	b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer b.Release()
+
+	for _, raw := range events {
+		var ev columnEvent
+		_ = json.Unmarshal(raw, &ev)
+		for f, field := range schema.Fields() {
+			switch col := b.Field(f).(type) {
+			case *array.TimestampBuilder:
+				t, err := event.ParseTime(ev.EventTime)
+				if err != nil {
+					col.AppendNull()
+					continue
+				}
+				col.Append(arrow.Timestamp(t.UnixMicro()))
+			case *array.StringBuilder:
+				switch v := ev.column(field.Name); {
+				case field.Name == "raw":
+					col.Append(string(raw))
+				case v == "":
+					col.AppendNull()
+				default:
+					col.Append(v)
+				}
+			default:
+				b.Field(f).AppendNull()
+			}
+		}
+	}
+	return b.NewRecord()
+}
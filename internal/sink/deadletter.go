@@ -0,0 +1,73 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/writer"
+)
+
+// deadLetterSeq keeps spool file names unique within a nanosecond
+var deadLetterSeq atomic.Int64
+
+// spool saves a batch that couldn't be delivered to the dead-letter dir,
+// returning the file written
+func (b *Buffered) spool(batch []json.RawMessage) (string, error) {
+	enc := writer.Encoding{Format: writer.FormatJSONLGzip, Cipher: b.DeadLetterCipher}
+	name := fmt.Sprintf("%019d-%d%s", time.Now().UnixNano(), deadLetterSeq.Add(1), enc.FileExtension())
+	path := filepath.Join(b.DeadLetterDir, name)
+	if err := writer.WriteEventsFile(path, enc, batch); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// DeadLetters lists the spooled batches, oldest first
+func (b *Buffered) DeadLetters() ([]string, error) {
+	entries, err := os.ReadDir(b.DeadLetterDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, e := range entries {
+		if _, ok := writer.FormatFromPath(e.Name()); ok && !e.IsDir() {
+			files = append(files, filepath.Join(b.DeadLetterDir, e.Name()))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Redrive resends the spooled batches oldest first, with the usual retries,
+// deleting each once it's delivered. It stops at the first batch that
+// still fails, which stays spooled.
+func (b *Buffered) Redrive(ctx context.Context) (batches, events int, err error) {
+	files, err := b.DeadLetters()
+	if err != nil {
+		return 0, 0, fmt.Errorf("sink %s: list dead letters: %w", b.Name, err)
+	}
+	for _, file := range files {
+		batch, err := writer.ReadEventsFile(file, b.DeadLetterCipher)
+		if err != nil {
+			return batches, events, fmt.Errorf("sink %s: read %s: %w", b.Name, file, err)
+		}
+		if err := b.deliver(ctx, batch); err != nil {
+			return batches, events, fmt.Errorf("sink %s: redrive %s: %w", b.Name, file, err)
+		}
+		if err := os.Remove(file); err != nil {
+			return batches, events, fmt.Errorf("sink %s: %w", b.Name, err)
+		}
+		batches++
+		events += len(batch)
+	}
+	return batches, events, nil
+}
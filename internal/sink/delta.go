@@ -0,0 +1,508 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/compress"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+)
+
+// DefaultDeltaPartitions partitions a Delta table by account, region and day
+var DefaultDeltaPartitions = []string{"recipient_account_id", "aws_region", "event_date"}
+
+// DeltaOptions selects where a Delta sink writes its table
+type DeltaOptions struct {
+	// s3://bucket/prefix or a local directory
+	Location string
+	// partition columns, any of eventColumns and event_date
+	PartitionBy []string
+	// parquet codec for data files: none, gzip, snappy (the default) or zstd
+	Compression string
+	// required for s3:// locations
+	S3 *s3.Client
+	// failed checkpoints are logged here, slog.Default() when nil
+	Logger *slog.Logger
+}
+
+// deltaCodecs are the parquet codecs a Delta sink writes, with the file
+// name suffix Spark gives each
+var deltaCodecs = map[string]struct {
+	codec  compress.Compression
+	suffix string
+}{
+	"none":   {compress.Codecs.Uncompressed, ".parquet"},
+	"gzip":   {compress.Codecs.Gzip, ".gz.parquet"},
+	"snappy": {compress.Codecs.Snappy, ".snappy.parquet"},
+	"zstd":   {compress.Codecs.Zstd, ".zstd.parquet"},
+}
+
+// Delta appends events to a Delta Lake table: parquet files under the
+// table location plus one _delta_log commit per batch. Only blind appends
+// are written, so concurrent writers just retry the next version. Every
+// checkpointInterval versions the table state is checkpointed, so readers
+// and later runs don't replay the whole log.
+type Delta struct {
+	store       deltaStore
+	partitionBy []string
+	// data file schema, without the partition columns
+	schema      *arrow.Schema
+	compression string
+	logger      *slog.Logger
+
+	mu      sync.Mutex
+	version int64
+	// version of the newest checkpoint, 0 for none, so checkpoints fall on
+	// multiples of checkpointInterval like other writers'
+	checkpointed       int64
+	checkpointInterval int64
+}
+
+const (
+	deltaReaderVersion = 1
+	deltaWriterVersion = 2
+	deltaCommitRetries = 10
+	deltaNullPartition = "__HIVE_DEFAULT_PARTITION__"
+)
+
+func NewDelta(ctx context.Context, opts DeltaOptions) (*Delta, error) {
+	partitionBy := opts.PartitionBy
+	if partitionBy == nil {
+		partitionBy = DefaultDeltaPartitions
+	}
+	for _, col := range partitionBy {
+		if col != "event_date" && !slices.Contains(eventColumns, col) {
+			return nil, fmt.Errorf("unknown partition column %q", col)
+		}
+	}
+
+	var store deltaStore
+	if loc, ok := strings.CutPrefix(opts.Location, "s3://"); ok {
+		if opts.S3 == nil {
+			return nil, fmt.Errorf("location %s needs an S3 client", opts.Location)
+		}
+		bucket, prefix, _ := strings.Cut(loc, "/")
+		store = &s3DeltaStore{client: opts.S3, bucket: bucket, prefix: strings.Trim(prefix, "/")}
+	} else {
+		store = localDeltaStore(opts.Location)
+	}
+
+	var fields []arrow.Field
+	for _, col := range deltaColumns() {
+		if !slices.Contains(partitionBy, col.Name) {
+			fields = append(fields, col)
+		}
+	}
+	compression := opts.Compression
+	if compression == "" {
+		compression = "snappy"
+	}
+	if _, ok := deltaCodecs[compression]; !ok {
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	d := &Delta{store: store, partitionBy: partitionBy, schema: arrow.NewSchema(fields, nil), compression: compression, logger: logger}
+	if err := d.open(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// deltaColumns is the table schema: the parquet output columns, the event
+// day for partitioning, and the raw event
+func deltaColumns() []arrow.Field {
+	fields := []arrow.Field{{Name: "event_time", Type: arrow.FixedWidthTypes.Timestamp_us, Nullable: true}}
+	for _, name := range eventColumns {
+		fields = append(fields, arrow.Field{Name: name, Type: arrow.BinaryTypes.String, Nullable: true})
+	}
+	return append(fields,
+		arrow.Field{Name: "event_date", Type: arrow.BinaryTypes.String, Nullable: true},
+		arrow.Field{Name: "raw", Type: arrow.BinaryTypes.String})
+}
+
+// deltaSchemaString is the table schema in the Delta log's JSON form
+func deltaSchemaString() string {
+	type field struct {
+		Name     string         `json:"name"`
+		Type     string         `json:"type"`
+		Nullable bool           `json:"nullable"`
+		Metadata map[string]any `json:"metadata"`
+	}
+	var fields []field
+	for _, col := range deltaColumns() {
+		typ := "string"
+		if col.Type.ID() == arrow.TIMESTAMP {
+			typ = "timestamp"
+		}
+		fields = append(fields, field{Name: col.Name, Type: typ, Nullable: col.Nullable, Metadata: map[string]any{}})
+	}
+	b, _ := json.Marshal(map[string]any{"type": "struct", "fields": fields})
+	return string(b)
+}
+
+type deltaProtocol struct {
+	MinReaderVersion int `json:"minReaderVersion"`
+	MinWriterVersion int `json:"minWriterVersion"`
+}
+
+type deltaMetadata struct {
+	ID               string            `json:"id"`
+	Name             string            `json:"name,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	Format           deltaFormat       `json:"format"`
+	SchemaString     string            `json:"schemaString"`
+	PartitionColumns []string          `json:"partitionColumns"`
+	Configuration    map[string]string `json:"configuration"`
+	CreatedTime      int64             `json:"createdTime"`
+}
+
+type deltaFormat struct {
+	Provider string            `json:"provider"`
+	Options  map[string]string `json:"options"`
+}
+
+type deltaAdd struct {
+	Path             string             `json:"path"`
+	PartitionValues  map[string]*string `json:"partitionValues"`
+	Size             int64              `json:"size"`
+	ModificationTime int64              `json:"modificationTime"`
+	DataChange       bool               `json:"dataChange"`
+	Stats            string             `json:"stats,omitempty"`
+}
+
+// deltaRemove and deltaTxn are only written by other writers, but are kept
+// in checkpoints
+type deltaRemove struct {
+	Path                 string             `json:"path"`
+	DeletionTimestamp    int64              `json:"deletionTimestamp,omitempty"`
+	DataChange           bool               `json:"dataChange"`
+	ExtendedFileMetadata bool               `json:"extendedFileMetadata,omitempty"`
+	PartitionValues      map[string]*string `json:"partitionValues,omitempty"`
+	Size                 int64              `json:"size,omitempty"`
+}
+
+type deltaTxn struct {
+	AppID       string `json:"appId"`
+	Version     int64  `json:"version"`
+	LastUpdated int64  `json:"lastUpdated,omitempty"`
+}
+
+type deltaCommitInfo struct {
+	Timestamp           int64             `json:"timestamp"`
+	Operation           string            `json:"operation"`
+	OperationParameters map[string]string `json:"operationParameters"`
+	EngineInfo          string            `json:"engineInfo"`
+	IsBlindAppend       bool              `json:"isBlindAppend"`
+}
+
+// deltaAction is one line of a commit file
+type deltaAction struct {
+	Protocol   *deltaProtocol   `json:"protocol,omitempty"`
+	MetaData   *deltaMetadata   `json:"metaData,omitempty"`
+	Add        *deltaAdd        `json:"add,omitempty"`
+	Remove     *deltaRemove     `json:"remove,omitempty"`
+	Txn        *deltaTxn        `json:"txn,omitempty"`
+	CommitInfo *deltaCommitInfo `json:"commitInfo,omitempty"`
+}
+
+// open finds the latest version, creating the table when there's none and
+// otherwise checking it can be appended to with this partitioning
+func (d *Delta) open(ctx context.Context) error {
+	latest, err := d.latestVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if latest < 0 {
+		partitionBy, _ := json.Marshal(d.partitionBy)
+		err := d.commit(ctx, 0, []deltaAction{
+			{CommitInfo: &deltaCommitInfo{
+				Timestamp:           time.Now().UnixMilli(),
+				Operation:           "CREATE TABLE",
+				OperationParameters: map[string]string{"partitionBy": string(partitionBy)},
+				EngineInfo:          "gocloudtrail",
+				IsBlindAppend:       true,
+			}},
+			{Protocol: &deltaProtocol{MinReaderVersion: deltaReaderVersion, MinWriterVersion: deltaWriterVersion}},
+			{MetaData: &deltaMetadata{
+				ID:               uuid.NewString(),
+				Format:           deltaFormat{Provider: "parquet", Options: map[string]string{}},
+				SchemaString:     deltaSchemaString(),
+				PartitionColumns: d.partitionBy,
+				Configuration:    map[string]string{},
+				CreatedTime:      time.Now().UnixMilli(),
+			}},
+		})
+		if err == nil {
+			d.version = 0
+			d.checkpointed = 0
+			d.checkpointInterval = deltaCheckpointInterval
+			return nil
+		}
+		if !errors.Is(err, errVersionExists) {
+			return err
+		}
+		// another writer created it first
+		if latest, err = d.latestVersion(ctx); err != nil {
+			return err
+		}
+	}
+	d.version = latest
+
+	// the newest protocol and metadata are the ones in force, from the
+	// commits after the last checkpoint or else the checkpoint itself
+	cp, err := d.lastCheckpoint(ctx)
+	if err != nil {
+		return err
+	}
+	oldest := int64(0)
+	d.checkpointed = 0
+	if cp != nil {
+		oldest = cp.Version + 1
+		d.checkpointed = cp.Version
+	}
+	var protocol *deltaProtocol
+	var meta *deltaMetadata
+	for v := latest; v >= oldest && (protocol == nil || meta == nil); v-- {
+		data, err := d.store.get(ctx, deltaCommitName(v))
+		if errors.Is(err, errNotFound) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read commit %d: %w", v, err)
+		}
+		for line := range bytes.Lines(data) {
+			var a deltaAction
+			if err := json.Unmarshal(line, &a); err != nil {
+				return fmt.Errorf("parse commit %d: %w", v, err)
+			}
+			if protocol == nil && a.Protocol != nil {
+				protocol = a.Protocol
+			}
+			if meta == nil && a.MetaData != nil {
+				meta = a.MetaData
+			}
+		}
+	}
+	if (protocol == nil || meta == nil) && cp != nil {
+		actions, err := d.readCheckpoint(ctx, *cp)
+		if err != nil {
+			return err
+		}
+		for _, a := range actions {
+			if a.Protocol != nil && protocol == nil {
+				protocol = a.Protocol
+			}
+			if a.MetaData != nil && meta == nil {
+				meta = a.MetaData
+			}
+		}
+	}
+	d.checkpointInterval = checkpointInterval(meta)
+	if protocol != nil && protocol.MinWriterVersion > deltaWriterVersion {
+		return fmt.Errorf("table needs writer version %d, only %d is supported", protocol.MinWriterVersion, deltaWriterVersion)
+	}
+	if meta != nil && !slices.Equal(meta.PartitionColumns, d.partitionBy) {
+		return fmt.Errorf("table is partitioned by %v, not %v", meta.PartitionColumns, d.partitionBy)
+	}
+	return nil
+}
+
+func deltaCommitName(version int64) string {
+	return fmt.Sprintf("_delta_log/%020d.json", version)
+}
+
+// latestVersion returns the newest commit in the log, -1 for no table,
+// listing only the commits after the last checkpoint
+func (d *Delta) latestVersion(ctx context.Context) (int64, error) {
+	cp, err := d.lastCheckpoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+	latest := int64(-1)
+	startAfter := ""
+	if cp != nil {
+		latest = cp.Version
+		startAfter = deltaCommitName(cp.Version)
+	}
+	names, err := d.store.list(ctx, "_delta_log/", startAfter)
+	if err != nil {
+		return 0, fmt.Errorf("list delta log: %w", err)
+	}
+	for _, name := range names {
+		base, ok := strings.CutSuffix(path.Base(name), ".json")
+		if !ok || len(base) != 20 {
+			continue
+		}
+		if v, err := strconv.ParseInt(base, 10, 64); err == nil && v > latest {
+			latest = v
+		}
+	}
+	return latest, nil
+}
+
+func (d *Delta) commit(ctx context.Context, version int64, actions []deltaAction) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, a := range actions {
+		if err := enc.Encode(a); err != nil {
+			return fmt.Errorf("encode commit: %w", err)
+		}
+	}
+	return d.store.create(ctx, deltaCommitName(version), buf.Bytes())
+}
+
+// Send writes a parquet file per partition in the batch and commits them
+// all as one version
+func (d *Delta) Send(ctx context.Context, events []json.RawMessage) error {
+	type partition struct {
+		values map[string]*string
+		dir    string
+		events []json.RawMessage
+	}
+	partitions := make(map[string]*partition)
+	var order []string
+	for _, raw := range events {
+		var ev columnEvent
+		_ = json.Unmarshal(raw, &ev)
+		values := make(map[string]*string, len(d.partitionBy))
+		dirs := make([]string, len(d.partitionBy))
+		for i, col := range d.partitionBy {
+			dir := deltaNullPartition
+			if v := ev.column(col); v != "" {
+				values[col] = &v
+				dir = escapePartitionValue(v)
+			} else {
+				values[col] = nil
+			}
+			dirs[i] = col + "=" + dir
+		}
+		key := strings.Join(dirs, "/")
+		p, ok := partitions[key]
+		if !ok {
+			p = &partition{values: values, dir: key}
+			partitions[key] = p
+			order = append(order, key)
+		}
+		p.events = append(p.events, raw)
+	}
+
+	actions := []deltaAction{{CommitInfo: &deltaCommitInfo{
+		Timestamp:           time.Now().UnixMilli(),
+		Operation:           "WRITE",
+		OperationParameters: map[string]string{"mode": "Append"},
+		EngineInfo:          "gocloudtrail",
+		IsBlindAppend:       true,
+	}}}
+	for _, key := range order {
+		p := partitions[key]
+		data, err := d.parquetFile(p.events)
+		if err != nil {
+			return err
+		}
+		name := path.Join(p.dir, fmt.Sprintf("part-00000-%s.c000%s", uuid.NewString(), deltaCodecs[d.compression].suffix))
+		if err := d.store.put(ctx, name, data); err != nil {
+			return fmt.Errorf("write data file: %w", err)
+		}
+		actions = append(actions, deltaAction{Add: &deltaAdd{
+			Path:             (&url.URL{Path: name}).EscapedPath(),
+			PartitionValues:  p.values,
+			Size:             int64(len(data)),
+			ModificationTime: time.Now().UnixMilli(),
+			DataChange:       true,
+			Stats:            fmt.Sprintf(`{"numRecords":%d}`, len(p.events)),
+		}})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for range deltaCommitRetries {
+		err := d.commit(ctx, d.version+1, actions)
+		if err == nil {
+			d.version++
+			d.maybeCheckpoint(ctx)
+			return nil
+		}
+		if !errors.Is(err, errVersionExists) {
+			return fmt.Errorf("commit version %d: %w", d.version+1, err)
+		}
+		// lost the race to another writer; appends never conflict, so
+		// just take the next free version
+		latest, err := d.latestVersion(ctx)
+		if err != nil {
+			return err
+		}
+		d.version = max(latest, d.version+1)
+	}
+	return fmt.Errorf("commit: gave up after %d conflicting versions", deltaCommitRetries)
+}
+
+// maybeCheckpoint checkpoints the version just committed once
+// checkpointInterval versions have passed since the last checkpoint. The
+// commit stands either way, so a failure is only logged and the next
+// commit tries again.
+func (d *Delta) maybeCheckpoint(ctx context.Context) {
+	if d.version-d.checkpointed < d.checkpointInterval {
+		return
+	}
+	if err := d.checkpoint(ctx, d.version); err != nil {
+		d.logger.Warn("delta checkpoint failed",
+			slog.Int64("version", d.version),
+			slog.String("error", err.Error()))
+		return
+	}
+	d.checkpointed = d.version
+}
+
+func (d *Delta) parquetFile(events []json.RawMessage) ([]byte, error) {
+	rec := buildRecord(d.schema, events)
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	w, err := pqarrow.NewFileWriter(d.schema, &buf,
+		parquet.NewWriterProperties(parquet.WithCompression(deltaCodecs[d.compression].codec)),
+		pqarrow.DefaultWriterProps())
+	if err != nil {
+		return nil, fmt.Errorf("create parquet writer: %w", err)
+	}
+	if err := w.Write(rec); err != nil {
+		return nil, fmt.Errorf("write parquet: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("write parquet: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// escapePartitionValue escapes a partition value for its directory name
+// the way Hive and Spark do
+func escapePartitionValue(v string) string {
+	var b strings.Builder
+	for _, c := range []byte(v) {
+		if c < 0x20 || c == 0x7f || strings.IndexByte("\"#%'*/:=?\\{[]^", c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func (d *Delta) Close() error { return nil }
@@ -0,0 +1,432 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+	"github.com/apache/arrow-go/v18/parquet"
+	"github.com/apache/arrow-go/v18/parquet/pqarrow"
+)
+
+const (
+	// versions between checkpoints, unless the table's
+	// delta.checkpointInterval says otherwise
+	deltaCheckpointInterval = 10
+	// how long removed files stay in checkpoints as tombstones, Delta's
+	// default delta.deletedFileRetentionDuration
+	deltaTombstoneRetention = 7 * 24 * time.Hour
+	deltaLastCheckpointName = "_delta_log/_last_checkpoint"
+)
+
+// deltaLastCheckpoint is the _last_checkpoint file, pointing readers and
+// writers at the newest checkpoint so they needn't list the whole log
+type deltaLastCheckpoint struct {
+	Version int64 `json:"version"`
+	// actions in the checkpoint
+	Size int64 `json:"size"`
+	// set when the checkpoint is split into several files
+	Parts int `json:"parts,omitempty"`
+}
+
+// lastCheckpoint reads _last_checkpoint, nil when there isn't a usable one
+func (d *Delta) lastCheckpoint(ctx context.Context) (*deltaLastCheckpoint, error) {
+	data, err := d.store.get(ctx, deltaLastCheckpointName)
+	if errors.Is(err, errNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read last checkpoint: %w", err)
+	}
+	var cp deltaLastCheckpoint
+	if json.Unmarshal(data, &cp) != nil || cp.Version < 0 {
+		// readers fall back to listing the log, and so do we
+		return nil, nil
+	}
+	return &cp, nil
+}
+
+func deltaCheckpointNames(cp deltaLastCheckpoint) []string {
+	if cp.Parts <= 1 {
+		return []string{fmt.Sprintf("_delta_log/%020d.checkpoint.parquet", cp.Version)}
+	}
+	names := make([]string, cp.Parts)
+	for i := range names {
+		names[i] = fmt.Sprintf("_delta_log/%020d.checkpoint.%010d.%010d.parquet", cp.Version, i+1, cp.Parts)
+	}
+	return names
+}
+
+// deltaSnapshot is the table state at a version: the actions in force once
+// the log up to it is replayed
+type deltaSnapshot struct {
+	protocol *deltaProtocol
+	meta     *deltaMetadata
+	txns     map[string]*deltaTxn
+	adds     map[string]*deltaAdd
+	removes  map[string]*deltaRemove
+}
+
+func (s *deltaSnapshot) apply(a deltaAction) {
+	switch {
+	case a.Protocol != nil:
+		s.protocol = a.Protocol
+	case a.MetaData != nil:
+		s.meta = a.MetaData
+	case a.Txn != nil:
+		s.txns[a.Txn.AppID] = a.Txn
+	case a.Add != nil:
+		s.adds[a.Add.Path] = a.Add
+		delete(s.removes, a.Add.Path)
+	case a.Remove != nil:
+		delete(s.adds, a.Remove.Path)
+		s.removes[a.Remove.Path] = a.Remove
+	}
+}
+
+// snapshot replays the log up to version, starting from the last checkpoint
+// before it
+func (d *Delta) snapshot(ctx context.Context, version int64) (*deltaSnapshot, error) {
+	s := &deltaSnapshot{
+		txns:    make(map[string]*deltaTxn),
+		adds:    make(map[string]*deltaAdd),
+		removes: make(map[string]*deltaRemove),
+	}
+	from := int64(0)
+	cp, err := d.lastCheckpoint(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if cp != nil && cp.Version <= version {
+		actions, err := d.readCheckpoint(ctx, *cp)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range actions {
+			s.apply(a)
+		}
+		from = cp.Version + 1
+	}
+	for v := from; v <= version; v++ {
+		data, err := d.store.get(ctx, deltaCommitName(v))
+		if err != nil {
+			return nil, fmt.Errorf("read commit %d: %w", v, err)
+		}
+		for line := range bytes.Lines(data) {
+			var a deltaAction
+			if err := json.Unmarshal(line, &a); err != nil {
+				return nil, fmt.Errorf("parse commit %d: %w", v, err)
+			}
+			s.apply(a)
+		}
+	}
+	if s.protocol == nil || s.meta == nil {
+		return nil, fmt.Errorf("no protocol or metadata at version %d", version)
+	}
+	return s, nil
+}
+
+// checkpoint writes the table state at version as a parquet checkpoint and
+// points _last_checkpoint at it
+func (d *Delta) checkpoint(ctx context.Context, version int64) error {
+	s, err := d.snapshot(ctx, version)
+	if err != nil {
+		return err
+	}
+
+	rows := []deltaCheckpointAction{{Protocol: s.protocol}, {MetaData: newDeltaCheckpointMeta(s.meta)}}
+	for _, id := range slices.Sorted(maps.Keys(s.txns)) {
+		rows = append(rows, deltaCheckpointAction{Txn: s.txns[id]})
+	}
+	for _, p := range slices.Sorted(maps.Keys(s.adds)) {
+		a := s.adds[p]
+		rows = append(rows, deltaCheckpointAction{Add: &deltaCheckpointAdd{
+			Path:             a.Path,
+			PartitionValues:  newDeltaMapEntries(a.PartitionValues),
+			Size:             a.Size,
+			ModificationTime: a.ModificationTime,
+			DataChange:       false,
+			Stats:            a.Stats,
+		}})
+	}
+	expired := time.Now().Add(-deltaTombstoneRetention).UnixMilli()
+	for _, p := range slices.Sorted(maps.Keys(s.removes)) {
+		r := s.removes[p]
+		if r.DeletionTimestamp < expired {
+			continue
+		}
+		rows = append(rows, deltaCheckpointAction{Remove: &deltaCheckpointRemove{
+			Path:                 r.Path,
+			DeletionTimestamp:    r.DeletionTimestamp,
+			DataChange:           false,
+			ExtendedFileMetadata: r.ExtendedFileMetadata,
+			PartitionValues:      newDeltaMapEntries(r.PartitionValues),
+			Size:                 r.Size,
+		}})
+	}
+
+	var rowsJSON bytes.Buffer
+	enc := json.NewEncoder(&rowsJSON)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return fmt.Errorf("encode checkpoint: %w", err)
+		}
+	}
+	schema := deltaCheckpointSchema()
+	rec, _, err := array.RecordFromJSON(memory.DefaultAllocator, schema, &rowsJSON, array.WithMultipleDocs())
+	if err != nil {
+		return fmt.Errorf("build checkpoint: %w", err)
+	}
+	defer rec.Release()
+
+	var buf bytes.Buffer
+	w, err := pqarrow.NewFileWriter(schema, &buf,
+		parquet.NewWriterProperties(parquet.WithCompression(deltaCodecs["snappy"].codec)),
+		pqarrow.DefaultWriterProps())
+	if err != nil {
+		return fmt.Errorf("create checkpoint writer: %w", err)
+	}
+	if err := w.Write(rec); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+
+	last := deltaLastCheckpoint{Version: version, Size: int64(len(rows))}
+	if err := d.store.put(ctx, deltaCheckpointNames(last)[0], buf.Bytes()); err != nil {
+		return fmt.Errorf("write checkpoint: %w", err)
+	}
+	// another writer may have checkpointed further on meanwhile
+	if cp, err := d.lastCheckpoint(ctx); err != nil || (cp != nil && cp.Version >= version) {
+		return err
+	}
+	data, _ := json.Marshal(last)
+	if err := d.store.put(ctx, deltaLastCheckpointName, data); err != nil {
+		return fmt.Errorf("write last checkpoint: %w", err)
+	}
+	return nil
+}
+
+// readCheckpoint reads a checkpoint's actions, whichever writer made it
+func (d *Delta) readCheckpoint(ctx context.Context, cp deltaLastCheckpoint) ([]deltaAction, error) {
+	var actions []deltaAction
+	for _, name := range deltaCheckpointNames(cp) {
+		data, err := d.store.get(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("read checkpoint %d: %w", cp.Version, err)
+		}
+		tbl, err := pqarrow.ReadTable(ctx, bytes.NewReader(data), nil, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+		if err != nil {
+			return nil, fmt.Errorf("read checkpoint %d: %w", cp.Version, err)
+		}
+		var rowsJSON bytes.Buffer
+		tr := array.NewTableReader(tbl, -1)
+		for tr.Next() {
+			if err = array.RecordToJSON(tr.Record(), &rowsJSON); err != nil {
+				break
+			}
+		}
+		tr.Release()
+		tbl.Release()
+		if err != nil {
+			return nil, fmt.Errorf("read checkpoint %d: %w", cp.Version, err)
+		}
+		for line := range bytes.Lines(rowsJSON.Bytes()) {
+			var row deltaCheckpointAction
+			if err := json.Unmarshal(line, &row); err != nil {
+				return nil, fmt.Errorf("parse checkpoint %d: %w", cp.Version, err)
+			}
+			actions = append(actions, row.action())
+		}
+	}
+	return actions, nil
+}
+
+// deltaCheckpointSchema holds the actions a checkpoint carries, one per row
+func deltaCheckpointSchema() *arrow.Schema {
+	str := arrow.BinaryTypes.String
+	i64 := arrow.PrimitiveTypes.Int64
+	i32 := arrow.PrimitiveTypes.Int32
+	boolean := arrow.FixedWidthTypes.Boolean
+	strMap := arrow.MapOf(str, str)
+	field := func(name string, typ arrow.DataType) arrow.Field {
+		return arrow.Field{Name: name, Type: typ, Nullable: true}
+	}
+	return arrow.NewSchema([]arrow.Field{
+		field("txn", arrow.StructOf(
+			field("appId", str), field("version", i64), field("lastUpdated", i64))),
+		field("add", arrow.StructOf(
+			field("path", str), field("partitionValues", strMap), field("size", i64),
+			field("modificationTime", i64), field("dataChange", boolean), field("stats", str))),
+		field("remove", arrow.StructOf(
+			field("path", str), field("deletionTimestamp", i64), field("dataChange", boolean),
+			field("extendedFileMetadata", boolean), field("partitionValues", strMap), field("size", i64))),
+		field("metaData", arrow.StructOf(
+			field("id", str), field("name", str), field("description", str),
+			field("format", arrow.StructOf(field("provider", str), field("options", strMap))),
+			field("schemaString", str), field("partitionColumns", arrow.ListOf(str)),
+			field("configuration", strMap), field("createdTime", i64))),
+		field("protocol", arrow.StructOf(
+			field("minReaderVersion", i32), field("minWriterVersion", i32))),
+	}, nil)
+}
+
+// deltaCheckpointAction is one checkpoint row as arrow reads and writes it
+// in JSON, where maps are lists of key/value entries
+type deltaCheckpointAction struct {
+	Txn      *deltaTxn              `json:"txn,omitempty"`
+	Add      *deltaCheckpointAdd    `json:"add,omitempty"`
+	Remove   *deltaCheckpointRemove `json:"remove,omitempty"`
+	MetaData *deltaCheckpointMeta   `json:"metaData,omitempty"`
+	Protocol *deltaProtocol         `json:"protocol,omitempty"`
+}
+
+type deltaCheckpointAdd struct {
+	Path             string          `json:"path"`
+	PartitionValues  []deltaMapEntry `json:"partitionValues"`
+	Size             int64           `json:"size"`
+	ModificationTime int64           `json:"modificationTime"`
+	DataChange       bool            `json:"dataChange"`
+	Stats            string          `json:"stats,omitempty"`
+}
+
+type deltaCheckpointRemove struct {
+	Path                 string          `json:"path"`
+	DeletionTimestamp    int64           `json:"deletionTimestamp"`
+	DataChange           bool            `json:"dataChange"`
+	ExtendedFileMetadata bool            `json:"extendedFileMetadata"`
+	PartitionValues      []deltaMapEntry `json:"partitionValues"`
+	Size                 int64           `json:"size"`
+}
+
+type deltaCheckpointMeta struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+	Format      struct {
+		Provider string          `json:"provider"`
+		Options  []deltaMapEntry `json:"options"`
+	} `json:"format"`
+	SchemaString     string          `json:"schemaString"`
+	PartitionColumns []string        `json:"partitionColumns"`
+	Configuration    []deltaMapEntry `json:"configuration"`
+	CreatedTime      int64           `json:"createdTime,omitempty"`
+}
+
+type deltaMapEntry struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value"`
+}
+
+func newDeltaMapEntries(m map[string]*string) []deltaMapEntry {
+	entries := make([]deltaMapEntry, 0, len(m))
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		entries = append(entries, deltaMapEntry{Key: k, Value: m[k]})
+	}
+	return entries
+}
+
+func deltaEntriesMap(entries []deltaMapEntry) map[string]*string {
+	m := make(map[string]*string, len(entries))
+	for _, e := range entries {
+		m[e.Key] = e.Value
+	}
+	return m
+}
+
+// stringPointers and derefStrings convert between the metadata's plain
+// string maps and map entries, whose values may be null
+func stringPointers(m map[string]string) map[string]*string {
+	out := make(map[string]*string, len(m))
+	for k, v := range m {
+		out[k] = &v
+	}
+	return out
+}
+
+func derefStrings(m map[string]*string) map[string]string {
+	out := make(map[string]string, len(m))
+	for k, v := range m {
+		if v != nil {
+			out[k] = *v
+		}
+	}
+	return out
+}
+
+func newDeltaCheckpointMeta(m *deltaMetadata) *deltaCheckpointMeta {
+	c := &deltaCheckpointMeta{
+		ID:               m.ID,
+		Name:             m.Name,
+		Description:      m.Description,
+		SchemaString:     m.SchemaString,
+		PartitionColumns: m.PartitionColumns,
+		Configuration:    newDeltaMapEntries(stringPointers(m.Configuration)),
+		CreatedTime:      m.CreatedTime,
+	}
+	c.Format.Provider = m.Format.Provider
+	c.Format.Options = newDeltaMapEntries(stringPointers(m.Format.Options))
+	return c
+}
+
+func (r deltaCheckpointAction) action() deltaAction {
+	var a deltaAction
+	switch {
+	case r.Protocol != nil:
+		a.Protocol = r.Protocol
+	case r.MetaData != nil:
+		m := r.MetaData
+		a.MetaData = &deltaMetadata{
+			ID:               m.ID,
+			Name:             m.Name,
+			Description:      m.Description,
+			Format:           deltaFormat{Provider: m.Format.Provider, Options: derefStrings(deltaEntriesMap(m.Format.Options))},
+			SchemaString:     m.SchemaString,
+			PartitionColumns: m.PartitionColumns,
+			Configuration:    derefStrings(deltaEntriesMap(m.Configuration)),
+			CreatedTime:      m.CreatedTime,
+		}
+	case r.Txn != nil:
+		a.Txn = r.Txn
+	case r.Add != nil:
+		a.Add = &deltaAdd{
+			Path:             r.Add.Path,
+			PartitionValues:  deltaEntriesMap(r.Add.PartitionValues),
+			Size:             r.Add.Size,
+			ModificationTime: r.Add.ModificationTime,
+			DataChange:       r.Add.DataChange,
+			Stats:            r.Add.Stats,
+		}
+	case r.Remove != nil:
+		a.Remove = &deltaRemove{
+			Path:                 r.Remove.Path,
+			DeletionTimestamp:    r.Remove.DeletionTimestamp,
+			DataChange:           r.Remove.DataChange,
+			ExtendedFileMetadata: r.Remove.ExtendedFileMetadata,
+			PartitionValues:      deltaEntriesMap(r.Remove.PartitionValues),
+			Size:                 r.Remove.Size,
+		}
+	}
+	return a
+}
+
+// checkpointInterval reads delta.checkpointInterval from the table
+// configuration
+func checkpointInterval(meta *deltaMetadata) int64 {
+	if meta != nil {
+		if n, err := strconv.ParseInt(meta.Configuration["delta.checkpointInterval"], 10, 64); err == nil && n > 0 {
+			return n
+		}
+	}
+	return deltaCheckpointInterval
+}
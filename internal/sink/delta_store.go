@@ -0,0 +1,188 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+var (
+	errVersionExists = errors.New("version already exists")
+	errNotFound      = errors.New("not found")
+)
+
+// deltaStore holds a Delta table's files, by slash-separated name relative
+// to the table location
+type deltaStore interface {
+	put(ctx context.Context, name string, data []byte) error
+	// create writes name only if it doesn't exist, else errVersionExists
+	create(ctx context.Context, name string, data []byte) error
+	get(ctx context.Context, name string) ([]byte, error)
+	// list returns the names in dir that sort after startAfter
+	list(ctx context.Context, dir, startAfter string) ([]string, error)
+}
+
+// localDeltaStore keeps the table in a local directory
+type localDeltaStore string
+
+func (l localDeltaStore) path(name string) string {
+	return filepath.Join(string(l), filepath.FromSlash(name))
+}
+
+// writeTemp writes data next to name, for renaming or linking into place
+func (l localDeltaStore) writeTemp(name string, data []byte) (string, error) {
+	p := l.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return "", err
+	}
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+func (l localDeltaStore) put(_ context.Context, name string, data []byte) error {
+	tmp, err := l.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path(name))
+}
+
+func (l localDeltaStore) create(_ context.Context, name string, data []byte) error {
+	tmp, err := l.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	// unlike rename, link fails when the target exists
+	if err := os.Link(tmp, l.path(name)); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return errVersionExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (l localDeltaStore) get(_ context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(l.path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotFound
+	}
+	return data, err
+}
+
+func (l localDeltaStore) list(_ context.Context, dir, startAfter string) ([]string, error) {
+	entries, err := os.ReadDir(l.path(dir))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if name := path.Join(dir, e.Name()); name > startAfter {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// s3DeltaStore keeps the table under an S3 prefix, using conditional
+// writes so concurrent commits can't overwrite each other
+type s3DeltaStore struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3DeltaStore) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3DeltaStore) put(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+		Body:   bytes.NewReader(data),
+	})
+	return err
+}
+
+func (s *s3DeltaStore) create(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.key(name)),
+		Body:        bytes.NewReader(data),
+		IfNoneMatch: aws.String("*"),
+	})
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return errVersionExists
+		}
+	}
+	return err
+}
+
+func (s *s3DeltaStore) get(ctx context.Context, name string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		var noKey *types.NoSuchKey
+		if errors.As(err, &noKey) {
+			return nil, errNotFound
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("read s3://%s/%s: %w", s.bucket, s.key(name), err)
+	}
+	return data, nil
+}
+
+func (s *s3DeltaStore) list(ctx context.Context, dir, startAfter string) ([]string, error) {
+	prefix := s.key(dir)
+	var names []string
+	input := &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}
+	if startAfter != "" {
+		input.StartAfter = aws.String(s.key(startAfter))
+	}
+	paginator := s3.NewListObjectsV2Paginator(s.client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			names = append(names, dir+strings.TrimPrefix(aws.ToString(obj.Key), prefix))
+		}
+	}
+	return names, nil
+}
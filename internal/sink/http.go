@@ -0,0 +1,116 @@
+package sink
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Webhook posts each batch as newline-delimited JSON
+type Webhook struct {
+	URL     string
+	Headers map[string]string
+	// gzip, or empty for none
+	Compression string
+	Client      *http.Client
+}
+
+func (w *Webhook) Send(ctx context.Context, events []json.RawMessage) error {
+	var body bytes.Buffer
+	for _, event := range events {
+		body.Write(event)
+		body.WriteByte('\n')
+	}
+	return postBatch(ctx, w.Client, w.URL, "application/x-ndjson", w.Headers, w.Compression, body.Bytes())
+}
+
+func (w *Webhook) Close() error { return nil }
+
+// Splunk sends events to a Splunk HTTP Event Collector, using the event time
+// as the Splunk timestamp
+type Splunk struct {
+	// the HEC event endpoint, e.g. https://splunk:8088/services/collector/event
+	URL        string
+	Token      string
+	Index      string
+	SourceType string
+	// gzip, or empty for none
+	Compression string
+	Client      *http.Client
+}
+
+type hecEvent struct {
+	Time       float64         `json:"time,omitempty"`
+	Index      string          `json:"index,omitempty"`
+	SourceType string          `json:"sourcetype,omitempty"`
+	Source     string          `json:"source"`
+	Event      json.RawMessage `json:"event"`
+}
+
+func (s *Splunk) Send(ctx context.Context, events []json.RawMessage) error {
+	sourceType := s.SourceType
+	if sourceType == "" {
+		sourceType = "aws:cloudtrail"
+	}
+
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, event := range events {
+		ev := hecEvent{Index: s.Index, SourceType: sourceType, Source: "gocloudtrail", Event: event}
+		if t, err := time.Parse(time.RFC3339, metaOf(event).EventTime); err == nil {
+			ev.Time = float64(t.UnixMilli()) / 1000
+		}
+		if err := enc.Encode(ev); err != nil {
+			return fmt.Errorf("encode event: %w", err)
+		}
+	}
+
+	headers := map[string]string{"Authorization": "Splunk " + s.Token}
+	return postBatch(ctx, s.Client, s.URL, "application/json", headers, s.Compression, body.Bytes())
+}
+
+func (s *Splunk) Close() error { return nil }
+
+func postBatch(ctx context.Context, client *http.Client, url, contentType string, headers map[string]string, compression string, body []byte) error {
+	if compression == "gzip" {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, _ = gw.Write(body)
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("compress events: %w", err)
+		}
+		body = buf.Bytes()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if compression == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post events: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("post events: %w", &StatusError{Code: resp.StatusCode, Status: resp.Status})
+	}
+	return nil
+}
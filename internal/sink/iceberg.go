@@ -0,0 +1,170 @@
+//go:build iceberg
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/iceberg-go"
+	"github.com/apache/iceberg-go/catalog"
+	"github.com/apache/iceberg-go/catalog/glue"
+	"github.com/apache/iceberg-go/catalog/rest"
+	"github.com/apache/iceberg-go/table"
+	"github.com/apache/iceberg-go/utils"
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// Iceberg appends events to an Iceberg table, committing one snapshot per
+// batch. The table is partitioned by account, region and event day.
+type Iceberg struct {
+	aws    aws.Config
+	schema *arrow.Schema
+
+	mu  sync.Mutex
+	tbl *table.Table
+}
+
+func icebergSchema() *iceberg.Schema {
+	fields := []iceberg.NestedField{
+		{ID: 1, Name: "event_time", Type: iceberg.PrimitiveTypes.TimestampTz},
+	}
+	for _, name := range eventColumns {
+		fields = append(fields, iceberg.NestedField{ID: len(fields) + 1, Name: name, Type: iceberg.PrimitiveTypes.String})
+	}
+	fields = append(fields, iceberg.NestedField{ID: len(fields) + 1, Name: "raw", Type: iceberg.PrimitiveTypes.String, Required: true})
+	return iceberg.NewSchema(0, fields...)
+}
+
+func NewIceberg(ctx context.Context, opts IcebergOptions) (*Iceberg, error) {
+	ident := catalog.ToIdentifier(opts.Table)
+	if len(ident) < 2 {
+		return nil, fmt.Errorf("table %q: want namespace.table", opts.Table)
+	}
+	ctx = utils.WithAwsConfig(ctx, &opts.AWS)
+
+	var cat catalog.Catalog
+	switch opts.Catalog {
+	case "glue":
+		cat = glue.NewCatalog(glue.WithAwsConfig(opts.AWS))
+	case "rest":
+		restOpts := []rest.Option{rest.WithAwsConfig(opts.AWS)}
+		if opts.Token != "" {
+			restOpts = append(restOpts, rest.WithOAuthToken(opts.Token))
+		}
+		if opts.Warehouse != "" {
+			restOpts = append(restOpts, rest.WithWarehouseLocation(opts.Warehouse))
+		}
+		rc, err := rest.NewCatalog(ctx, "rest", opts.URI, restOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("connect to REST catalog: %w", err)
+		}
+		cat = rc
+	default:
+		return nil, fmt.Errorf("unknown catalog %q (want glue or rest)", opts.Catalog)
+	}
+
+	tbl, err := openIcebergTable(ctx, cat, ident, opts.Warehouse)
+	if err != nil {
+		return nil, err
+	}
+	if codec := icebergCodec(opts.Compression); codec != "" && tbl.Properties()[table.ParquetCompressionKey] != codec {
+		txn := tbl.NewTransaction()
+		if err := txn.SetProperties(iceberg.Properties{table.ParquetCompressionKey: codec}); err != nil {
+			return nil, fmt.Errorf("set compression: %w", err)
+		}
+		if tbl, err = txn.Commit(ctx); err != nil {
+			return nil, fmt.Errorf("set compression: %w", err)
+		}
+	}
+	schema, err := table.SchemaToArrowSchema(tbl.Schema(), nil, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("table schema: %w", err)
+	}
+	return &Iceberg{aws: opts.AWS, schema: schema, tbl: tbl}, nil
+}
+
+// openIcebergTable loads the table, creating it and its namespace when
+// they don't exist yet
+func openIcebergTable(ctx context.Context, cat catalog.Catalog, ident table.Identifier, warehouse string) (*table.Table, error) {
+	exists, err := cat.CheckTableExists(ctx, ident)
+	if err != nil {
+		return nil, fmt.Errorf("check table %s: %w", strings.Join(ident, "."), err)
+	}
+	if exists {
+		tbl, err := cat.LoadTable(ctx, ident)
+		if err != nil {
+			return nil, fmt.Errorf("load table %s: %w", strings.Join(ident, "."), err)
+		}
+		return tbl, nil
+	}
+
+	ns := catalog.NamespaceFromIdent(ident)
+	nsExists, err := cat.CheckNamespaceExists(ctx, ns)
+	if err != nil {
+		return nil, fmt.Errorf("check namespace %s: %w", strings.Join(ns, "."), err)
+	}
+	if !nsExists {
+		if err := cat.CreateNamespace(ctx, ns, nil); err != nil {
+			return nil, fmt.Errorf("create namespace %s: %w", strings.Join(ns, "."), err)
+		}
+	}
+
+	schema := icebergSchema()
+	spec := iceberg.NewPartitionSpec(
+		iceberg.PartitionField{SourceID: fieldID(schema, "recipient_account_id"), FieldID: 1000, Name: "account_id", Transform: iceberg.IdentityTransform{}},
+		iceberg.PartitionField{SourceID: fieldID(schema, "aws_region"), FieldID: 1001, Name: "region", Transform: iceberg.IdentityTransform{}},
+		iceberg.PartitionField{SourceID: fieldID(schema, "event_time"), FieldID: 1002, Name: "day", Transform: iceberg.DayTransform{}},
+	)
+	createOpts := []catalog.CreateTableOpt{catalog.WithPartitionSpec(&spec)}
+	if warehouse != "" {
+		createOpts = append(createOpts, catalog.WithLocation(strings.TrimSuffix(warehouse, "/")+"/"+strings.Join(ident, "/")))
+	}
+	tbl, err := cat.CreateTable(ctx, ident, schema, createOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("create table %s: %w", strings.Join(ident, "."), err)
+	}
+	return tbl, nil
+}
+
+// icebergCodec maps a compression setting to the table property value
+func icebergCodec(compression string) string {
+	if compression == "none" {
+		return "uncompressed"
+	}
+	return compression
+}
+
+func fieldID(schema *iceberg.Schema, name string) int {
+	field, _ := schema.FindFieldByName(name)
+	return field.ID
+}
+
+// Send appends the batch to the table as a single snapshot commit. Commits
+// are serialized, since concurrent ones would conflict on the table
+// metadata anyway.
+func (i *Iceberg) Send(ctx context.Context, events []json.RawMessage) error {
+	rec := buildRecord(i.schema, events)
+	defer rec.Release()
+	rdr, err := array.NewRecordReader(i.schema, []arrow.Record{rec})
+	if err != nil {
+		return fmt.Errorf("build records: %w", err)
+	}
+	defer rdr.Release()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	tbl, err := i.tbl.Append(utils.WithAwsConfig(ctx, &i.aws), rdr, nil)
+	if err != nil {
+		return fmt.Errorf("append to table: %w", err)
+	}
+	i.tbl = tbl
+	return nil
+}
+
+func (i *Iceberg) Close() error { return nil }
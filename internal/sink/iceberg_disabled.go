@@ -0,0 +1,23 @@
+//go:build !iceberg
+
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// Iceberg is only built with the iceberg build tag, which links iceberg-go
+// and its catalog clients
+type Iceberg struct{}
+
+func NewIceberg(ctx context.Context, opts IcebergOptions) (*Iceberg, error) {
+	return nil, errors.New("iceberg sinks need a build with -tags iceberg")
+}
+
+func (i *Iceberg) Send(ctx context.Context, events []json.RawMessage) error {
+	return errors.New("iceberg sinks need a build with -tags iceberg")
+}
+
+func (i *Iceberg) Close() error { return nil }
@@ -0,0 +1,21 @@
+package sink
+
+import "github.com/aws/aws-sdk-go-v2/aws"
+
+// IcebergOptions selects the catalog and table an Iceberg sink writes to
+type IcebergOptions struct {
+	// glue or rest
+	Catalog string
+	// REST catalog endpoint
+	URI string
+	// REST catalog bearer token, optional
+	Token string
+	// s3:// location for new tables, e.g. s3://bucket/warehouse
+	Warehouse string
+	// namespace.table, created if missing
+	Table string
+	// parquet codec for data files (none, gzip, snappy, zstd), empty to
+	// leave the table's setting
+	Compression string
+	AWS         aws.Config
+}
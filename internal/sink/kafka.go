@@ -0,0 +1,52 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Kafka produces each event as a message keyed by its eventID
+type Kafka struct {
+	writer *kafka.Writer
+}
+
+// kafkaCodecs are the compression codecs a Kafka sink supports
+var kafkaCodecs = map[string]kafka.Compression{
+	"gzip":   kafka.Gzip,
+	"snappy": kafka.Snappy,
+	"lz4":    kafka.Lz4,
+	"zstd":   kafka.Zstd,
+}
+
+// NewKafka produces to topic, compressing message batches with
+// compression (gzip, snappy, lz4, zstd, or empty for none)
+func NewKafka(brokers []string, topic, compression string) *Kafka {
+	return &Kafka{writer: &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireAll,
+		Compression:  kafkaCodecs[compression],
+		// Send already hands over whole batches, so don't wait for more
+		BatchTimeout: 10 * time.Millisecond,
+	}}
+}
+
+func (k *Kafka) Send(ctx context.Context, events []json.RawMessage) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		msgs[i] = kafka.Message{Key: []byte(metaOf(event).EventID), Value: event}
+	}
+	if err := k.writer.WriteMessages(ctx, msgs...); err != nil {
+		return fmt.Errorf("produce events: %w", err)
+	}
+	return nil
+}
+
+func (k *Kafka) Close() error {
+	return k.writer.Close()
+}
@@ -0,0 +1,75 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+// RetryPolicy is how a failed batch is retried before it's given up on
+type RetryPolicy struct {
+	// total tries, at least one
+	Attempts int
+	// wait before the first retry, doubling for each one after
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// StatusError is an unsuccessful HTTP response from a sink
+type StatusError struct {
+	Code   int
+	Status string
+}
+
+func (e *StatusError) Error() string {
+	return "unexpected status " + e.Status
+}
+
+// Retryable reports whether a failed send may succeed if tried again.
+// Throttling, server errors and network errors are retried; other HTTP
+// client errors, cancellation and errors that say they aren't temporary
+// are not. Anything else is assumed transient.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		return status.Code >= 500 || status.Code == 408 || status.Code == 429
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	var temp interface{ Temporary() bool }
+	if errors.As(err, &temp) {
+		return temp.Temporary()
+	}
+	return true
+}
+
+// deliver sends the batch, retrying under the policy while the error is
+// retryable
+func (b *Buffered) deliver(ctx context.Context, batch []json.RawMessage) error {
+	backoff := b.Retry.Backoff
+	var err error
+	for attempt := range max(b.Retry.Attempts, 1) {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return err
+			}
+			backoff *= 2
+			if b.Retry.MaxBackoff > 0 {
+				backoff = min(backoff, b.Retry.MaxBackoff)
+			}
+		}
+		if err = b.Sink.Send(ctx, batch); err == nil || !Retryable(err) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,100 @@
+package sink
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/interpreter"
+
+	"github.com/deceptiq/gocloudtrail/event"
+)
+
+// routeEventVar names the whole decoded event in a route, for fields the
+// event model doesn't know
+const routeEventVar = "event"
+
+// routeFields are the top-level CloudTrail fields a route can name directly
+var routeFields = recordFields()
+
+func recordFields() []string {
+	var names []string
+	t := reflect.TypeFor[event.Record]()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// celRoute is a Route from a CEL expression
+type celRoute struct {
+	program cel.Program
+}
+
+// CompileRoute compiles a CEL predicate over an event into a Route. The
+// expression names top-level CloudTrail fields directly, each null when the
+// event lacks it, and the whole event as event, e.g.
+// errorCode != null && eventName == "ConsoleLogin". It must be boolean, or
+// of a field's dynamic type, which only matches when it's true.
+func CompileRoute(expr string) (Route, error) {
+	opts := []cel.EnvOption{
+		cel.Variable(routeEventVar, cel.MapType(cel.StringType, cel.DynType)),
+		// JSON numbers decode as doubles, compared with int literals as written
+		cel.CrossTypeNumericComparisons(true),
+		cel.OptionalTypes(),
+	}
+	for _, name := range routeFields {
+		opts = append(opts, cel.Variable(name, cel.DynType))
+	}
+	env, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create CEL environment: %w", err)
+	}
+
+	ast, issues := env.Compile(expr)
+	if issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	if !ast.OutputType().IsExactType(cel.BoolType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("expression is %s, not bool", ast.OutputType())
+	}
+	program, err := env.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &celRoute{program: program}, nil
+}
+
+// Match reports whether the expression holds for ev. An event that couldn't
+// be decoded, or on which the expression fails, e.g. indexing a missing
+// nested field, isn't matched.
+func (r *celRoute) Match(ev map[string]any) bool {
+	if ev == nil {
+		return false
+	}
+	out, _, err := r.program.Eval(routeActivation(ev))
+	if err != nil {
+		return false
+	}
+	matched, ok := out.Value().(bool)
+	return ok && matched
+}
+
+// routeActivation resolves a route's variables from the decoded event
+// without copying it
+type routeActivation map[string]any
+
+func (a routeActivation) ResolveName(name string) (any, bool) {
+	if name == routeEventVar {
+		return map[string]any(a), true
+	}
+	return a[name], true
+}
+
+func (a routeActivation) Parent() interpreter.Activation {
+	return nil
+}
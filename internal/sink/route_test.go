@@ -0,0 +1,55 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCompileRoute(t *testing.T) {
+	decode := func(s string) map[string]any {
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(s), &ev); err != nil {
+			t.Fatal(err)
+		}
+		return ev
+	}
+	failedLogin := decode(`{"eventName":"ConsoleLogin","errorCode":"Failed authentication","responseElements":{"ConsoleLogin":"Failure"},"userIdentity":{"type":"IAMUser"}}`)
+	login := decode(`{"eventName":"ConsoleLogin","responseElements":{"ConsoleLogin":"Success"},"userIdentity":{"type":"IAMUser"}}`)
+	listing := decode(`{"eventName":"ListBuckets","eventSource":"s3.amazonaws.com","readOnly":true,"requestParameters":{"maxResults":500},"newField":"x"}`)
+
+	tests := []struct {
+		expr string
+		ev   map[string]any
+		want bool
+	}{
+		{`errorCode != null && eventName == "ConsoleLogin"`, failedLogin, true},
+		{`errorCode != null && eventName == "ConsoleLogin"`, login, false},
+		{`errorCode != null && eventName == "ConsoleLogin"`, listing, false},
+		{`responseElements.?ConsoleLogin.orValue('') == 'Failure'`, failedLogin, true},
+		{`responseElements.?ConsoleLogin.orValue('') == 'Failure'`, login, false},
+		{`eventSource in ['iam.amazonaws.com', 's3.amazonaws.com']`, listing, true},
+		{`eventSource in ['iam.amazonaws.com', 's3.amazonaws.com']`, login, false},
+		{`readOnly && requestParameters.maxResults > 100`, listing, true},
+		{`requestParameters.maxResults == 500 && !(requestParameters.maxResults < 500.5)`, listing, false},
+		{`event['newField'] == 'x'`, listing, true},
+		{`userIdentity.type.startsWith('IAM')`, login, true},
+		// a missing nested field fails the expression, which doesn't match
+		{`requestParameters.maxResults > 100`, login, false},
+		{`eventName == 'ConsoleLogin'`, nil, false},
+	}
+	for _, tt := range tests {
+		route, err := CompileRoute(tt.expr)
+		if err != nil {
+			t.Fatalf("CompileRoute(%q): %v", tt.expr, err)
+		}
+		if got := route.Match(tt.ev); got != tt.want {
+			t.Errorf("%q on %v = %v, want %v", tt.expr, tt.ev, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{`eventName ==`, `'ConsoleLogin'`, `unknownField == 1`} {
+		if _, err := CompileRoute(expr); err == nil {
+			t.Errorf("CompileRoute(%q) succeeded, want an error", expr)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+)
+
+// Sink delivers batches of raw CloudTrail events downstream
+type Sink interface {
+	Send(ctx context.Context, events []json.RawMessage) error
+	Close() error
+}
+
+// Options tunes how a Buffered sink batches and sends
+type Options struct {
+	// events per batch
+	BatchSize int
+	// send a batch before its events add up to more than this many bytes,
+	// zero for no limit
+	MaxBatchBytes int
+	// how often partial batches are sent, zero for the caller's default
+	FlushInterval time.Duration
+	// batches being sent at once, zero for no limit
+	Concurrency int
+	Retry       RetryPolicy
+	// where batches that still fail after retrying are saved for Redrive,
+	// empty to drop them
+	DeadLetterDir string
+	// encrypts spooled batches; nil spools them in plaintext
+	DeadLetterCipher crypt.Cipher
+	// send batches one at a time in the order they fill, so the sink gets
+	// events in the order they were written
+	Ordered bool
+	// only events the route matches are sent, nil sends every event
+	Route Route
+	// rewrites each event as it's queued, nil sends events as written
+	Transform Transform
+}
+
+// Route picks the events a sink receives, tested against the decoded event
+type Route interface {
+	Match(ev map[string]any) bool
+}
+
+// Transform rewrites the events a sink receives, e.g. adding derived fields
+type Transform interface {
+	Apply(event json.RawMessage) json.RawMessage
+}
+
+// Buffered batches events for a sink, sending once BatchSize events or
+// MaxBatchBytes are queued and on Flush
+type Buffered struct {
+	Name string
+	Sink Sink
+	Options
+
+	mu         sync.Mutex
+	batch      []json.RawMessage
+	acks       []func()
+	batchBytes int
+	slots      chan struct{}
+}
+
+func NewBuffered(name string, s Sink, opts Options) *Buffered {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 1
+	}
+	b := &Buffered{Name: name, Sink: s, Options: opts}
+	if opts.Concurrency > 0 {
+		b.slots = make(chan struct{}, opts.Concurrency)
+	}
+	return b
+}
+
+// Wants reports whether an event passes the sink's route; ev is the decoded
+// event, nil when it couldn't be decoded
+func (b *Buffered) Wants(ev map[string]any) bool {
+	return b.Route == nil || b.Route.Match(ev)
+}
+
+// Routed reports whether any of the sinks has a route, so events need
+// decoding before they're written
+func Routed(sinks []*Buffered) bool {
+	for _, b := range sinks {
+		if b.Route != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Buffered) Write(ctx context.Context, event json.RawMessage) error {
+	return b.WriteAcked(ctx, event, nil)
+}
+
+// WriteAcked queues an event like Write, calling ack, when set, once the
+// batch holding it has been delivered. A batch that fails never acks.
+func (b *Buffered) WriteAcked(ctx context.Context, event json.RawMessage, ack func()) error {
+	if b.Transform != nil {
+		event = b.Transform.Apply(event)
+	}
+	b.mu.Lock()
+	var full []pendingBatch
+	if b.MaxBatchBytes > 0 && len(b.batch) > 0 && b.batchBytes+len(event) > b.MaxBatchBytes {
+		full = append(full, b.take())
+	}
+	b.batch = append(b.batch, event)
+	b.acks = append(b.acks, ack)
+	b.batchBytes += len(event)
+	if len(b.batch) >= b.BatchSize {
+		full = append(full, b.take())
+	}
+	defer b.release()()
+
+	for _, batch := range full {
+		if err := b.send(ctx, batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pendingBatch is a batch taken for sending, with each event's ack
+type pendingBatch struct {
+	events []json.RawMessage
+	acks   []func()
+}
+
+// take empties the batch, returning what was queued; b.mu must be held
+func (b *Buffered) take() pendingBatch {
+	batch := pendingBatch{events: b.batch, acks: b.acks}
+	b.batch, b.acks, b.batchBytes = nil, nil, 0
+	return batch
+}
+
+// release unlocks b.mu once batches taken under it are sent when Ordered,
+// so they can't overtake each other, and otherwise right away
+func (b *Buffered) release() func() {
+	if b.Ordered {
+		return b.mu.Unlock
+	}
+	b.mu.Unlock()
+	return func() {}
+}
+
+func (b *Buffered) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	batch := b.take()
+	defer b.release()()
+
+	if len(batch.events) == 0 {
+		return nil
+	}
+	return b.send(ctx, batch)
+}
+
+func (b *Buffered) send(ctx context.Context, pending pendingBatch) error {
+	if b.slots != nil {
+		select {
+		case b.slots <- struct{}{}:
+			defer func() { <-b.slots }()
+		case <-ctx.Done():
+			return fmt.Errorf("sink %s: %w", b.Name, ctx.Err())
+		}
+	}
+	batch := pending.events
+	err := b.deliver(ctx, batch)
+	if err == nil {
+		for _, ack := range pending.acks {
+			if ack != nil {
+				ack()
+			}
+		}
+		return nil
+	}
+	if b.DeadLetterDir == "" {
+		return fmt.Errorf("sink %s: %w", b.Name, err)
+	}
+	path, spoolErr := b.spool(batch)
+	if spoolErr != nil {
+		return fmt.Errorf("sink %s: %w (and saving the batch failed: %v)", b.Name, err, spoolErr)
+	}
+	return fmt.Errorf("sink %s: %w (%d events saved to %s)", b.Name, err, len(batch), path)
+}
+
+// Close flushes what's left and closes the sink
+func (b *Buffered) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return errors.Join(b.Flush(ctx), b.Sink.Close())
+}
+
+// eventMeta holds the fields sinks use for keys and timestamps
+type eventMeta struct {
+	EventID   string `json:"eventID"`
+	EventTime string `json:"eventTime"`
+}
+
+func metaOf(event json.RawMessage) eventMeta {
+	var m eventMeta
+	_ = json.Unmarshal(event, &m)
+	return m
+}
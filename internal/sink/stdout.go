@@ -0,0 +1,39 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// Stdout writes each batch as newline-delimited JSON to standard output, so
+// a run can feed jq and the like
+type Stdout struct {
+	// os.Stdout when nil
+	W io.Writer
+
+	mu sync.Mutex
+}
+
+func (s *Stdout) Send(ctx context.Context, events []json.RawMessage) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		buf.Write(event)
+		buf.WriteByte('\n')
+	}
+
+	// batches sent at once don't interleave their lines
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w := s.W
+	if w == nil {
+		w = os.Stdout
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (s *Stdout) Close() error { return nil }
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// AuditRecord is one audit log entry: an S3 object a run handled and what
+// came of it
+type AuditRecord struct {
+	ID     int64  `json:"id"`
+	RunID  int64  `json:"run_id"`
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	// as S3 reported them when the object was downloaded
+	VersionID string `json:"version_id,omitempty"`
+	ETag      string `json:"etag,omitempty"`
+	Size      int64  `json:"size"`
+	// SHA-256 of the object as downloaded, before decompression
+	SHA256       string    `json:"sha256,omitempty"`
+	DownloadedAt time.Time `json:"downloaded_at,omitzero"`
+	FinishedAt   time.Time `json:"finished_at"`
+	Status       string    `json:"status"`
+	Error        string    `json:"error,omitempty"`
+	Records      int64     `json:"records"`
+	Written      int64     `json:"written"`
+	Duplicate    int64     `json:"duplicate"`
+	Filtered     int64     `json:"filtered"`
+	// rejected as invalid or lost to a write error
+	Invalid int64 `json:"invalid"`
+	// partition dirs its events were written to
+	Outputs []string `json:"outputs"`
+}
+
+// AppendAudit adds entries to the audit log, which never changes a row once
+// written
+func (d *DB) AppendAudit(records []AuditRecord) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, r := range records {
+		outputs, err := json.Marshal(r.Outputs)
+		if err != nil {
+			return fmt.Errorf("encode audit outputs: %w", err)
+		}
+		_, err = tx.Exec(`
+			INSERT INTO audit_log (run_id, bucket, key, version_id, etag, size, sha256, downloaded_at, finished_at,
+				status, error, records, written, duplicate, filtered, invalid, outputs)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, r.RunID, r.Bucket, r.Key, r.VersionID, r.ETag, r.Size, r.SHA256, nullTime(r.DownloadedAt), r.FinishedAt.UTC(),
+			r.Status, r.Error, r.Records, r.Written, r.Duplicate, r.Filtered, r.Invalid, string(outputs))
+		if err != nil {
+			return fmt.Errorf("append audit record: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit audit records: %w", err)
+	}
+	return nil
+}
+
+// AuditFilter narrows ListAudit; zero fields match everything
+type AuditFilter struct {
+	RunID  int64
+	Bucket string
+	// key prefix
+	Prefix string
+	Since  time.Time
+	Until  time.Time
+}
+
+// ListAudit returns the matching audit log entries in the order they were
+// written
+func (d *DB) ListAudit(f AuditFilter) ([]AuditRecord, error) {
+	query := `
+		SELECT id, run_id, bucket, key, version_id, etag, size, sha256, downloaded_at, finished_at,
+			status, error, records, written, duplicate, filtered, invalid, outputs
+		FROM audit_log WHERE 1 = 1`
+	var args []any
+	if f.RunID != 0 {
+		query += " AND run_id = ?"
+		args = append(args, f.RunID)
+	}
+	if f.Bucket != "" {
+		query += " AND bucket = ?"
+		args = append(args, f.Bucket)
+	}
+	if f.Prefix != "" {
+		query += " AND substr(key, 1, ?) = ?"
+		args = append(args, len(f.Prefix), f.Prefix)
+	}
+	if !f.Since.IsZero() {
+		query += " AND finished_at >= ?"
+		args = append(args, f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		query += " AND finished_at < ?"
+		args = append(args, f.Until.UTC())
+	}
+	query += " ORDER BY id"
+
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var records []AuditRecord
+	for rows.Next() {
+		var r AuditRecord
+		var downloadedAt sql.NullTime
+		var outputs string
+		if err := rows.Scan(&r.ID, &r.RunID, &r.Bucket, &r.Key, &r.VersionID, &r.ETag, &r.Size, &r.SHA256,
+			&downloadedAt, &r.FinishedAt, &r.Status, &r.Error, &r.Records, &r.Written, &r.Duplicate,
+			&r.Filtered, &r.Invalid, &outputs); err != nil {
+			return nil, fmt.Errorf("scan audit record: %w", err)
+		}
+		r.DownloadedAt = downloadedAt.Time
+		if err := json.Unmarshal([]byte(outputs), &r.Outputs); err != nil {
+			return nil, fmt.Errorf("decode audit record %d outputs: %w", r.ID, err)
+		}
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate audit log: %w", err)
+	}
+	return records, nil
+}
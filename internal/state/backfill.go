@@ -0,0 +1,111 @@
+package state
+
+import (
+	"fmt"
+	"time"
+)
+
+// Backfill unit statuses
+const (
+	UnitPending = "pending"
+	UnitRunning = "running"
+	UnitDone    = "done"
+	UnitFailed  = "failed"
+)
+
+// BackfillUnit is a planned slice of a backfill and how it went
+type BackfillUnit struct {
+	Bucket    string    `json:"bucket"`
+	AccountID string    `json:"account_id"`
+	Region    string    `json:"region"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Trail     string    `json:"trail"`
+	Status    string    `json:"status"`
+	Files     int64     `json:"files"`
+	Events    int64     `json:"events"`
+	Attempts  int64     `json:"attempts"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// PlanBackfillUnits records units as pending, leaving any that are already
+// planned untouched, and returns how many were new
+func (d *DB) PlanBackfillUnits(units []BackfillUnit) (int, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var added int
+	for _, u := range units {
+		res, err := tx.Exec(`
+			INSERT OR IGNORE INTO backfill_units (bucket, account_id, region, unit_start, unit_end, trail)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, u.Bucket, u.AccountID, u.Region, u.Start.UTC(), u.End.UTC(), u.Trail)
+		if err != nil {
+			return 0, fmt.Errorf("plan backfill unit: %w", err)
+		}
+		n, _ := res.RowsAffected()
+		added += int(n)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit backfill plan: %w", err)
+	}
+	return added, nil
+}
+
+// UpdateBackfillUnit saves a unit's status and counts. Moving to running
+// counts an attempt.
+func (d *DB) UpdateBackfillUnit(u BackfillUnit) error {
+	attempt := 0
+	if u.Status == UnitRunning {
+		attempt = 1
+	}
+	_, err := d.db.Exec(`
+		UPDATE backfill_units SET
+			status = ?, files = ?, events = ?, error = ?,
+			attempts = attempts + ?, updated_at = CURRENT_TIMESTAMP
+		WHERE bucket = ? AND account_id = ? AND region = ? AND unit_start = ?
+	`, u.Status, u.Files, u.Events, u.Error, attempt, u.Bucket, u.AccountID, u.Region, u.Start.UTC())
+	if err != nil {
+		return fmt.Errorf("update backfill unit: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) ListBackfillUnits() ([]BackfillUnit, error) {
+	rows, err := d.db.Query(`
+		SELECT bucket, account_id, region, unit_start, unit_end, trail, status, files, events, attempts, error, updated_at
+		FROM backfill_units
+		ORDER BY unit_start, bucket, account_id, region
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query backfill units: %w", err)
+	}
+	defer rows.Close()
+
+	var units []BackfillUnit
+	for rows.Next() {
+		var u BackfillUnit
+		if err := rows.Scan(&u.Bucket, &u.AccountID, &u.Region, &u.Start, &u.End, &u.Trail,
+			&u.Status, &u.Files, &u.Events, &u.Attempts, &u.Error, &u.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan backfill unit: %w", err)
+		}
+		units = append(units, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate backfill units: %w", err)
+	}
+	return units, nil
+}
+
+// ResetBackfill deletes every planned unit
+func (d *DB) ResetBackfill() error {
+	if _, err := d.db.Exec("DELETE FROM backfill_units"); err != nil {
+		return fmt.Errorf("reset backfill: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package state
+
+import (
+	"fmt"
+	"os"
+)
+
+// LatestSchemaVersion returns the schema version this build migrates
+// databases to
+func LatestSchemaVersion() (int, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return 0, err
+	}
+	return migrations[len(migrations)-1].version, nil
+}
+
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist, while it stays in use
+func (d *DB) Snapshot(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %s: file exists", path)
+	}
+	if _, err := d.db.Exec(`VACUUM INTO ?`, path); err != nil {
+		return fmt.Errorf("snapshot state database: %w", err)
+	}
+	return nil
+}
+
+// FileCounters returns the next file number recorded for each partition of
+// an events dir, keyed by the partition's path under it
+func (d *DB) FileCounters(eventsDir string) (map[string]int, error) {
+	rows, err := d.db.Query(`SELECT partition, next_file FROM file_counters WHERE events_dir = ?`, eventsDir)
+	if err != nil {
+		return nil, fmt.Errorf("query file counters: %w", err)
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int)
+	for rows.Next() {
+		var partition string
+		var next int
+		if err := rows.Scan(&partition, &next); err != nil {
+			return nil, fmt.Errorf("scan file counter: %w", err)
+		}
+		counters[partition] = next
+	}
+	return counters, rows.Err()
+}
+
+// RaiseFileCounters records next file numbers for partitions of an events
+// dir, keeping any higher one already recorded
+func (d *DB) RaiseFileCounters(eventsDir string, counters map[string]int) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for partition, next := range counters {
+		_, err := tx.Exec(`
+			INSERT INTO file_counters (events_dir, partition, next_file) VALUES (?, ?, ?)
+			ON CONFLICT(events_dir, partition) DO UPDATE SET
+				next_file = MAX(file_counters.next_file, excluded.next_file)
+		`, eventsDir, partition, next)
+		if err != nil {
+			return fmt.Errorf("save file counter: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit file counters: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// RunsCounter counts the runs that have added to the totals
+const RunsCounter = "runs"
+
+// Counter is a total kept across every run, with the part the most recent
+// run added
+type Counter struct {
+	Name      string    `json:"name"`
+	Total     int64     `json:"total"`
+	LastRun   int64     `json:"last_run"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// StartRunCounters begins a run: every counter's last run part goes back to
+// zero and the run is counted
+func (d *DB) StartRunCounters() error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(`UPDATE counters SET last_run = 0`); err != nil {
+		return fmt.Errorf("reset run counters: %w", err)
+	}
+	if err := addCounter(tx, RunsCounter, 1); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit run counters: %w", err)
+	}
+	return nil
+}
+
+// AddCounters adds to the lifetime totals and the current run's part
+func (d *DB) AddCounters(deltas map[string]int64) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for name, delta := range deltas {
+		if err := addCounter(tx, name, delta); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit counters: %w", err)
+	}
+	return nil
+}
+
+func addCounter(tx *sql.Tx, name string, delta int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO counters (name, total, last_run, last_updated)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(name) DO UPDATE SET
+			total = total + excluded.total,
+			last_run = last_run + excluded.last_run,
+			last_updated = CURRENT_TIMESTAMP
+	`, name, delta, delta)
+	if err != nil {
+		return fmt.Errorf("add counter %s: %w", name, err)
+	}
+	return nil
+}
+
+// ListCounters returns every counter by name
+func (d *DB) ListCounters() ([]Counter, error) {
+	rows, err := d.db.Query(`SELECT name, total, last_run, last_updated FROM counters ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("query counters: %w", err)
+	}
+	defer rows.Close()
+
+	var counters []Counter
+	for rows.Next() {
+		var c Counter
+		if err := rows.Scan(&c.Name, &c.Total, &c.LastRun, &c.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("scan counter: %w", err)
+		}
+		counters = append(counters, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate counters: %w", err)
+	}
+	return counters, nil
+}
@@ -0,0 +1,86 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DeniedPrefix is a bucket/account/region that kept returning AccessDenied
+type DeniedPrefix struct {
+	Bucket    string `json:"bucket"`
+	AccountID string `json:"account_id"`
+	Region    string `json:"region"`
+	Error     string `json:"error"`
+	// runs in a row that skipped it
+	Runs        int64     `json:"runs"`
+	FirstDenied time.Time `json:"first_denied"`
+	LastDenied  time.Time `json:"last_denied"`
+}
+
+// RecordDenied notes that this run skipped the prefix for AccessDenied
+func (d *DB) RecordDenied(bucket, accountID, region, errMsg string) error {
+	_, err := d.db.Exec(`
+		INSERT INTO denied_prefixes (bucket, account_id, region, error, runs, first_denied, last_denied)
+		VALUES (?, ?, ?, ?, 1, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)
+		ON CONFLICT(bucket, account_id, region) DO UPDATE SET
+			error = excluded.error,
+			runs = denied_prefixes.runs + 1,
+			last_denied = CURRENT_TIMESTAMP
+	`, bucket, accountID, region, errMsg)
+	if err != nil {
+		return fmt.Errorf("record denied prefix: %w", err)
+	}
+	return nil
+}
+
+// ClearDenied forgets a prefix once it can be read again
+func (d *DB) ClearDenied(bucket, accountID, region string) error {
+	_, err := d.db.Exec(`DELETE FROM denied_prefixes WHERE bucket = ? AND account_id = ? AND region = ?`,
+		bucket, accountID, region)
+	if err != nil {
+		return fmt.Errorf("clear denied prefix: %w", err)
+	}
+	return nil
+}
+
+// GetDenied returns the prefix's denial record, or nil if it has none
+func (d *DB) GetDenied(bucket, accountID, region string) (*DeniedPrefix, error) {
+	p := &DeniedPrefix{Bucket: bucket, AccountID: accountID, Region: region}
+	err := d.db.QueryRow(`
+		SELECT error, runs, first_denied, last_denied
+		FROM denied_prefixes WHERE bucket = ? AND account_id = ? AND region = ?
+	`, bucket, accountID, region).Scan(&p.Error, &p.Runs, &p.FirstDenied, &p.LastDenied)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query denied prefix: %w", err)
+	}
+	return p, nil
+}
+
+func (d *DB) ListDenied() ([]DeniedPrefix, error) {
+	rows, err := d.db.Query(`
+		SELECT bucket, account_id, region, error, runs, first_denied, last_denied
+		FROM denied_prefixes
+		ORDER BY bucket, account_id, region
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query denied prefixes: %w", err)
+	}
+	defer rows.Close()
+
+	var prefixes []DeniedPrefix
+	for rows.Next() {
+		var p DeniedPrefix
+		if err := rows.Scan(&p.Bucket, &p.AccountID, &p.Region, &p.Error, &p.Runs, &p.FirstDenied, &p.LastDenied); err != nil {
+			return nil, fmt.Errorf("scan denied prefix: %w", err)
+		}
+		prefixes = append(prefixes, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate denied prefixes: %w", err)
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DiscoveredPair is an account/region with log files under a trail's prefix
+type DiscoveredPair struct {
+	AccountID string `json:"account_id"`
+	Region    string `json:"region"`
+	OrgPath   string `json:"org_path,omitempty"`
+	Folder    string `json:"folder,omitempty"`
+}
+
+// Discovery is the cached result of discovering a trail's pairs
+type Discovery struct {
+	Scope        string
+	Pairs        []DiscoveredPair
+	DiscoveredAt time.Time
+}
+
+// GetDiscovery returns the pairs last discovered under a bucket and prefix,
+// or nil if there are none
+func (d *DB) GetDiscovery(bucket, prefix string) (*Discovery, error) {
+	var disc Discovery
+	var pairs string
+	err := d.db.QueryRow(`
+		SELECT scope, pairs, discovered_at FROM discovery_cache WHERE bucket = ? AND prefix = ?
+	`, bucket, prefix).Scan(&disc.Scope, &pairs, &disc.DiscoveredAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query discovery cache: %w", err)
+	}
+	if err := json.Unmarshal([]byte(pairs), &disc.Pairs); err != nil {
+		return nil, fmt.Errorf("decode discovery cache: %w", err)
+	}
+	return &disc, nil
+}
+
+// SaveDiscovery replaces the pairs cached for a bucket and prefix
+func (d *DB) SaveDiscovery(bucket, prefix, scope string, pairs []DiscoveredPair) error {
+	data, err := json.Marshal(pairs)
+	if err != nil {
+		return fmt.Errorf("encode discovery cache: %w", err)
+	}
+	_, err = d.db.Exec(`
+		INSERT INTO discovery_cache (bucket, prefix, scope, pairs, discovered_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT(bucket, prefix) DO UPDATE SET
+			scope = excluded.scope,
+			pairs = excluded.pairs,
+			discovered_at = excluded.discovered_at
+	`, bucket, prefix, scope, string(data))
+	if err != nil {
+		return fmt.Errorf("save discovery cache: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package state
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// migrations/NNNN_description.sql, applied in order, each once. Add a new
+// file for every schema change; never edit one that has shipped.
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// the versions applied to a database, one row each
+const createSchemaVersionSQL = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	description TEXT NOT NULL DEFAULT '',
+	applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+)`
+
+type migration struct {
+	version     int
+	description string
+	sql         string
+}
+
+// loadMigrations reads the embedded migrations sorted by version
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("read migrations: %w", err)
+	}
+
+	var migrations []migration
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".sql")
+		number, description, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must start with a positive version number", e.Name())
+		}
+		data, err := migrationFiles.ReadFile("migrations/" + e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %w", e.Name(), err)
+		}
+		migrations = append(migrations, migration{
+			version:     version,
+			description: strings.ReplaceAll(description, "_", " "),
+			sql:         string(data),
+		})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].version < migrations[j].version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("two migrations with version %d", migrations[i].version)
+		}
+	}
+	return migrations, nil
+}
+
+// migrate brings the schema up to the latest version, applying each missing
+// migration in its own transaction. A database from a newer build is
+// refused rather than risk writing rows it doesn't understand.
+func migrate(db *sql.DB, logger *slog.Logger) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+	if _, err := db.Exec(createSchemaVersionSQL); err != nil {
+		return fmt.Errorf("create schema_version: %w", err)
+	}
+
+	current, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+	latest := migrations[len(migrations)-1].version
+	if current > latest {
+		return fmt.Errorf("state database schema version %d is newer than this build supports (%d); upgrade gocloudtrail", current, latest)
+	}
+
+	for _, m := range migrations {
+		if m.version <= current {
+			continue
+		}
+		if err := apply(db, m); err != nil {
+			return err
+		}
+		logger.Info("migrated state database",
+			slog.Int("version", m.version),
+			slog.String("migration", m.description))
+	}
+	return nil
+}
+
+func apply(db *sql.DB, m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin migration %d: %w", m.version, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return fmt.Errorf("apply migration %d (%s): %w", m.version, m.description, err)
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_version (version, description) VALUES (?, ?)`, m.version, m.description); err != nil {
+		return fmt.Errorf("record migration %d: %w", m.version, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d: %w", m.version, err)
+	}
+	return nil
+}
+
+// schemaVersion returns the highest migration applied, 0 for a new database
+// or one from before versioning
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	if err := db.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, fmt.Errorf("read schema version: %w", err)
+	}
+	return int(version.Int64), nil
+}
+
+// SchemaVersion returns the database's schema version
+func (d *DB) SchemaVersion() (int, error) {
+	return schemaVersion(d.db)
+}
@@ -0,0 +1,94 @@
+-- the tables as they were before schema versioning; every statement is
+-- IF NOT EXISTS so databases created by earlier builds pass through
+
+CREATE TABLE IF NOT EXISTS state (
+	bucket TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	last_processed_key TEXT,
+	processed_count INTEGER DEFAULT 0,
+	last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bucket, account_id, region)
+);
+
+-- event counts from the most recent run, per bucket/account/region
+CREATE TABLE IF NOT EXISTS run_metrics (
+	bucket TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	events INTEGER NOT NULL DEFAULT 0,
+	written INTEGER NOT NULL DEFAULT 0,
+	duplicate INTEGER NOT NULL DEFAULT 0,
+	invalid INTEGER NOT NULL DEFAULT 0,
+	filtered INTEGER NOT NULL DEFAULT 0,
+	last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bucket, account_id, region)
+);
+
+-- one row per planned backfill unit: a day or week of one
+-- bucket/account/region
+CREATE TABLE IF NOT EXISTS backfill_units (
+	bucket TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	unit_start TIMESTAMP NOT NULL,
+	unit_end TIMESTAMP NOT NULL,
+	trail TEXT NOT NULL DEFAULT '',
+	status TEXT NOT NULL DEFAULT 'pending',
+	files INTEGER NOT NULL DEFAULT 0,
+	events INTEGER NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bucket, account_id, region, unit_start)
+);
+
+-- outcome per source object: failures always, successes when the run
+-- records objects
+CREATE TABLE IF NOT EXISTS objects (
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	status TEXT NOT NULL,
+	events INTEGER NOT NULL DEFAULT 0,
+	error TEXT NOT NULL DEFAULT '',
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bucket, key)
+);
+
+-- prefixes a run skipped for returning AccessDenied; each run re-tests them
+-- and clears the row once access works again
+CREATE TABLE IF NOT EXISTS denied_prefixes (
+	bucket TEXT NOT NULL,
+	account_id TEXT NOT NULL,
+	region TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	runs INTEGER NOT NULL DEFAULT 0,
+	first_denied TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	last_denied TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (bucket, account_id, region)
+);
+
+-- lifetime totals of the run counters, so restarts don't reset them
+CREATE TABLE IF NOT EXISTS counters (
+	name TEXT PRIMARY KEY,
+	total INTEGER NOT NULL DEFAULT 0,
+	last_run INTEGER NOT NULL DEFAULT 0,
+	last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- one row per run, for auditing what was ingested when and how
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	command TEXT NOT NULL,
+	config_hash TEXT NOT NULL DEFAULT '',
+	range_start TIMESTAMP,
+	range_end TIMESTAMP,
+	started_at TIMESTAMP NOT NULL,
+	ended_at TIMESTAMP,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	trails TEXT NOT NULL DEFAULT '[]',
+	totals TEXT NOT NULL DEFAULT '{}'
+);
@@ -0,0 +1,35 @@
+-- append-only record of every S3 object a run handled, for chain of
+-- custody: what was downloaded, when, by which run, and what came of it.
+-- The triggers refuse any change to a row once it's written.
+CREATE TABLE audit_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	run_id INTEGER NOT NULL DEFAULT 0,
+	bucket TEXT NOT NULL,
+	key TEXT NOT NULL,
+	version_id TEXT NOT NULL DEFAULT '',
+	etag TEXT NOT NULL DEFAULT '',
+	size INTEGER NOT NULL DEFAULT 0,
+	sha256 TEXT NOT NULL DEFAULT '',
+	downloaded_at TIMESTAMP,
+	finished_at TIMESTAMP NOT NULL,
+	status TEXT NOT NULL,
+	error TEXT NOT NULL DEFAULT '',
+	records INTEGER NOT NULL DEFAULT 0,
+	written INTEGER NOT NULL DEFAULT 0,
+	duplicate INTEGER NOT NULL DEFAULT 0,
+	filtered INTEGER NOT NULL DEFAULT 0,
+	invalid INTEGER NOT NULL DEFAULT 0,
+	outputs TEXT NOT NULL DEFAULT '[]'
+);
+
+CREATE INDEX audit_log_object ON audit_log (bucket, key);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log
+BEGIN
+	SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
@@ -0,0 +1,3 @@
+-- the latest S3 LastModified listed for each bucket/account/region, so a
+-- run can spot log files delivered behind its checkpoint after the fact
+ALTER TABLE state ADD COLUMN last_modified TIMESTAMP;
@@ -0,0 +1,5 @@
+-- the version of each recorded object, so a run can skip downloading one
+-- it already processed in the same version
+ALTER TABLE objects ADD COLUMN etag TEXT NOT NULL DEFAULT '';
+ALTER TABLE objects ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE objects ADD COLUMN last_modified TIMESTAMP;
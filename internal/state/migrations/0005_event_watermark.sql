@@ -0,0 +1,3 @@
+-- the event time up to which each bucket/account/region's events have all
+-- been written, as of the latest run that listed it
+ALTER TABLE state ADD COLUMN event_watermark TIMESTAMP;
@@ -0,0 +1,4 @@
+-- the process that recorded each run, so a later run can tell one that
+-- died from one still going
+ALTER TABLE runs ADD COLUMN pid INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE runs ADD COLUMN host TEXT NOT NULL DEFAULT '';
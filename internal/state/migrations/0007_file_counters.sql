@@ -0,0 +1,9 @@
+-- the lowest events_NNNNN number to write next in a partition, for
+-- partitions whose files aren't all on this host, e.g. after importing a
+-- state bundle without the output
+CREATE TABLE file_counters (
+	events_dir TEXT NOT NULL,
+	partition TEXT NOT NULL,
+	next_file INTEGER NOT NULL,
+	PRIMARY KEY (events_dir, partition)
+);
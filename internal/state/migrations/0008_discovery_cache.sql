@@ -0,0 +1,11 @@
+-- the account/region pairs last discovered under each trail's log prefix,
+-- reused by runs within the cache TTL instead of listing them again
+CREATE TABLE discovery_cache (
+	bucket TEXT NOT NULL,
+	prefix TEXT NOT NULL,
+	-- the settings discovery ran with; a different scope is a cache miss
+	scope TEXT NOT NULL,
+	pairs TEXT NOT NULL,
+	discovered_at TIMESTAMP NOT NULL,
+	PRIMARY KEY (bucket, prefix)
+);
@@ -0,0 +1,83 @@
+package state
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Object statuses
+const (
+	ObjectDone   = "done"
+	ObjectFailed = "failed"
+)
+
+// ObjectRecord is how processing one source object went
+type ObjectRecord struct {
+	Bucket    string    `json:"bucket"`
+	Key       string    `json:"key"`
+	AccountID string    `json:"account_id"`
+	Region    string    `json:"region"`
+	Status    string    `json:"status"`
+	Events    int64     `json:"events"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// the object's version as processed, empty or zero when unknown
+	ETag         string    `json:"etag,omitempty"`
+	Size         int64     `json:"size,omitempty"`
+	LastModified time.Time `json:"last_modified,omitzero"`
+}
+
+// RecordObjects saves object outcomes. A done object stays done, so a later
+// failed retry of it doesn't hide that it was processed.
+func (d *DB) RecordObjects(records []ObjectRecord) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, r := range records {
+		_, err := tx.Exec(`
+			INSERT INTO objects (bucket, key, account_id, region, status, events, error, updated_at, etag, size, last_modified)
+			VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?)
+			ON CONFLICT(bucket, key) DO UPDATE SET
+				status = excluded.status,
+				events = excluded.events,
+				error = excluded.error,
+				updated_at = excluded.updated_at,
+				etag = excluded.etag,
+				size = excluded.size,
+				last_modified = excluded.last_modified
+			WHERE objects.status != 'done' OR excluded.status = 'done'
+		`, r.Bucket, r.Key, r.AccountID, r.Region, r.Status, r.Events, r.Error, r.ETag, r.Size, nullTime(r.LastModified))
+		if err != nil {
+			return fmt.Errorf("record object: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit objects: %w", err)
+	}
+	return nil
+}
+
+// GetObject returns the recorded outcome for an object, or nil if there is
+// none
+func (d *DB) GetObject(bucket, key string) (*ObjectRecord, error) {
+	r := &ObjectRecord{Bucket: bucket, Key: key}
+	var lastModified sql.NullTime
+	err := d.db.QueryRow(`
+		SELECT account_id, region, status, events, error, updated_at, etag, size, last_modified
+		FROM objects WHERE bucket = ? AND key = ?
+	`, bucket, key).Scan(&r.AccountID, &r.Region, &r.Status, &r.Events, &r.Error, &r.UpdatedAt,
+		&r.ETag, &r.Size, &lastModified)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("query object: %w", err)
+	}
+	r.LastModified = lastModified.Time
+	return r, nil
+}
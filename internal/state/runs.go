@@ -0,0 +1,150 @@
+package state
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// run statuses
+const (
+	RunRunning     = "running"
+	RunCompleted   = "completed"
+	RunFailed      = "failed"
+	RunInterrupted = "interrupted"
+	// the process died without recording how the run ended
+	RunCrashed = "crashed"
+)
+
+// Run is one recorded run. A run whose process died stays RunRunning until
+// a later run marks it RunCrashed.
+type Run struct {
+	ID      int64  `json:"id"`
+	Command string `json:"command"`
+	// SHA-256 of the effective config
+	ConfigHash string `json:"config_hash"`
+	// event time range the run was limited to, zero when unbounded
+	RangeStart time.Time `json:"range_start,omitzero"`
+	RangeEnd   time.Time `json:"range_end,omitzero"`
+	StartedAt  time.Time `json:"started_at"`
+	EndedAt    time.Time `json:"ended_at,omitzero"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	// trails and log groups the run processed
+	Trails []string         `json:"trails"`
+	Totals map[string]int64 `json:"totals"`
+	// process that recorded the run, zero for runs recorded before they
+	// were kept
+	PID  int    `json:"pid,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// StartRun records a run as running and returns its ID
+func (d *DB) StartRun(r Run) (int64, error) {
+	res, err := d.db.Exec(`
+		INSERT INTO runs (command, config_hash, range_start, range_end, started_at, status, pid, host)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Command, r.ConfigHash, nullTime(r.RangeStart), nullTime(r.RangeEnd), r.StartedAt.UTC(), RunRunning, r.PID, r.Host)
+	if err != nil {
+		return 0, fmt.Errorf("start run: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("start run: %w", err)
+	}
+	return id, nil
+}
+
+// FinishRun records how a run ended
+func (d *DB) FinishRun(r Run) error {
+	trails, err := json.Marshal(r.Trails)
+	if err != nil {
+		return fmt.Errorf("encode run trails: %w", err)
+	}
+	totals, err := json.Marshal(r.Totals)
+	if err != nil {
+		return fmt.Errorf("encode run totals: %w", err)
+	}
+	_, err = d.db.Exec(`
+		UPDATE runs SET ended_at = ?, status = ?, error = ?, trails = ?, totals = ?
+		WHERE id = ?
+	`, r.EndedAt.UTC(), r.Status, r.Error, string(trails), string(totals), r.ID)
+	if err != nil {
+		return fmt.Errorf("finish run: %w", err)
+	}
+	return nil
+}
+
+// MarkRunCrashed records that a run's process died before it ended
+func (d *DB) MarkRunCrashed(id int64, at time.Time) error {
+	_, err := d.db.Exec(`
+		UPDATE runs SET ended_at = ?, status = ?, error = ?
+		WHERE id = ? AND status = ?
+	`, at.UTC(), RunCrashed, "process exited without recording the end of the run", id, RunRunning)
+	if err != nil {
+		return fmt.Errorf("mark run %d crashed: %w", id, err)
+	}
+	return nil
+}
+
+// ListRuns returns the most recent runs first, at most limit of them (0 =
+// all)
+func (d *DB) ListRuns(limit int) ([]Run, error) {
+	query := `
+		SELECT id, command, config_hash, range_start, range_end, started_at, ended_at, status, error, trails, totals, pid, host
+		FROM runs
+		ORDER BY id DESC
+	`
+	var args []any
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	return d.queryRuns(query, args...)
+}
+
+// UnfinishedRuns returns the runs still recorded as running, oldest first
+func (d *DB) UnfinishedRuns() ([]Run, error) {
+	return d.queryRuns(`
+		SELECT id, command, config_hash, range_start, range_end, started_at, ended_at, status, error, trails, totals, pid, host
+		FROM runs
+		WHERE status = ?
+		ORDER BY id
+	`, RunRunning)
+}
+
+func (d *DB) queryRuns(query string, args ...any) ([]Run, error) {
+	rows, err := d.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query runs: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var r Run
+		var rangeStart, rangeEnd, endedAt sql.NullTime
+		var trails, totals string
+		if err := rows.Scan(&r.ID, &r.Command, &r.ConfigHash, &rangeStart, &rangeEnd, &r.StartedAt, &endedAt,
+			&r.Status, &r.Error, &trails, &totals, &r.PID, &r.Host); err != nil {
+			return nil, fmt.Errorf("scan run: %w", err)
+		}
+		r.RangeStart, r.RangeEnd, r.EndedAt = rangeStart.Time, rangeEnd.Time, endedAt.Time
+		if err := json.Unmarshal([]byte(trails), &r.Trails); err != nil {
+			return nil, fmt.Errorf("decode run %d trails: %w", r.ID, err)
+		}
+		if err := json.Unmarshal([]byte(totals), &r.Totals); err != nil {
+			return nil, fmt.Errorf("decode run %d totals: %w", r.ID, err)
+		}
+		runs = append(runs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate runs: %w", err)
+	}
+	return runs, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t.UTC(), Valid: !t.IsZero()}
+}
@@ -4,20 +4,39 @@ import (
 	"database/sql"
 	"fmt"
 	"log/slog"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-const createTableSQL = `
-CREATE TABLE IF NOT EXISTS state (
-	bucket TEXT NOT NULL,
-	account_id TEXT NOT NULL,
-	region TEXT NOT NULL,
-	last_processed_key TEXT,
-	processed_count INTEGER DEFAULT 0,
-	last_updated TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-	PRIMARY KEY (bucket, account_id, region)
-)`
+// Checkpoint is the saved listing position for one bucket/account/region
+type Checkpoint struct {
+	Bucket           string    `json:"bucket"`
+	AccountID        string    `json:"account_id"`
+	Region           string    `json:"region"`
+	LastProcessedKey string    `json:"last_processed_key"`
+	ProcessedCount   int64     `json:"processed_count"`
+	LastUpdated      time.Time `json:"last_updated"`
+	// latest S3 LastModified of the objects listed so far
+	LastModified time.Time `json:"last_modified,omitzero"`
+	// every event up to this time has been written
+	EventWatermark time.Time   `json:"event_watermark,omitzero"`
+	LastRun        *RunMetrics `json:"last_run,omitempty"`
+}
+
+// RunMetrics are the event counts for one bucket/account/region from the
+// most recent run that read it
+type RunMetrics struct {
+	Bucket    string    `json:"-"`
+	AccountID string    `json:"-"`
+	Region    string    `json:"-"`
+	Events    int64     `json:"events"`
+	Written   int64     `json:"written"`
+	Duplicate int64     `json:"duplicate"`
+	Invalid   int64     `json:"invalid"`
+	Filtered  int64     `json:"filtered"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
 
 type DB struct {
 	db     *sql.DB
@@ -30,9 +49,9 @@ func Open(path string, logger *slog.Logger) (*DB, error) {
 		return nil, fmt.Errorf("open database: %w", err)
 	}
 
-	if _, err = db.Exec(createTableSQL); err != nil {
+	if err := migrate(db, logger); err != nil {
 		db.Close()
-		return nil, fmt.Errorf("create table: %w", err)
+		return nil, err
 	}
 
 	logger.Info("initialized state database", slog.String("path", path))
@@ -79,3 +98,126 @@ func (d *DB) UpdateLastProcessedKey(bucket, accountID, region, key string) error
 
 	return nil
 }
+
+// GetLastModified returns the latest S3 LastModified recorded for a
+// bucket/account/region, zero when there is none
+func (d *DB) GetLastModified(bucket, accountID, region string) (time.Time, error) {
+	var lastModified sql.NullTime
+	err := d.db.QueryRow(
+		"SELECT last_modified FROM state WHERE bucket = ? AND account_id = ? AND region = ?",
+		bucket, accountID, region,
+	).Scan(&lastModified)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("query last modified: %w", err)
+	}
+	return lastModified.Time, nil
+}
+
+// UpdateLastModified records t as the latest S3 LastModified listed for a
+// bucket/account/region
+func (d *DB) UpdateLastModified(bucket, accountID, region string, t time.Time) error {
+	_, err := d.db.Exec(`
+		INSERT INTO state (bucket, account_id, region, last_modified)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(bucket, account_id, region) DO UPDATE SET
+			last_modified = excluded.last_modified
+	`, bucket, accountID, region, t.UTC())
+	if err != nil {
+		return fmt.Errorf("update last modified: %w", err)
+	}
+	return nil
+}
+
+// UpdateEventWatermark advances the event time up to which every event of
+// the bucket/account/region has been written; it never moves back
+func (d *DB) UpdateEventWatermark(bucket, accountID, region string, t time.Time) error {
+	_, err := d.db.Exec(`
+		INSERT INTO state (bucket, account_id, region, event_watermark)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(bucket, account_id, region) DO UPDATE SET
+			event_watermark = excluded.event_watermark
+		WHERE state.event_watermark IS NULL OR state.event_watermark < excluded.event_watermark
+	`, bucket, accountID, region, t.UTC().Truncate(time.Second))
+	if err != nil {
+		return fmt.Errorf("update event watermark: %w", err)
+	}
+	return nil
+}
+
+// SaveRunMetrics replaces the stored last-run counts for each given
+// bucket/account/region
+func (d *DB) SaveRunMetrics(metrics []RunMetrics) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	for _, m := range metrics {
+		_, err := tx.Exec(`
+			INSERT INTO run_metrics (bucket, account_id, region, events, written, duplicate, invalid, filtered, last_updated)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+			ON CONFLICT(bucket, account_id, region) DO UPDATE SET
+				events = excluded.events,
+				written = excluded.written,
+				duplicate = excluded.duplicate,
+				invalid = excluded.invalid,
+				filtered = excluded.filtered,
+				last_updated = CURRENT_TIMESTAMP
+		`, m.Bucket, m.AccountID, m.Region, m.Events, m.Written, m.Duplicate, m.Invalid, m.Filtered)
+		if err != nil {
+			return fmt.Errorf("save run metrics: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit run metrics: %w", err)
+	}
+	return nil
+}
+
+func (d *DB) ListCheckpoints() ([]Checkpoint, error) {
+	rows, err := d.db.Query(`
+		SELECT s.bucket, s.account_id, s.region, COALESCE(s.last_processed_key, ''), s.processed_count, s.last_updated,
+			s.last_modified, s.event_watermark, m.events, m.written, m.duplicate, m.invalid, m.filtered, m.last_updated
+		FROM state s
+		LEFT JOIN run_metrics m USING (bucket, account_id, region)
+		ORDER BY s.bucket, s.account_id, s.region
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("query checkpoints: %w", err)
+	}
+	defer rows.Close()
+
+	var checkpoints []Checkpoint
+	for rows.Next() {
+		var cp Checkpoint
+		var events, written, duplicate, invalid, filtered sql.NullInt64
+		var lastModified, eventWatermark, metricsUpdated sql.NullTime
+		if err := rows.Scan(&cp.Bucket, &cp.AccountID, &cp.Region, &cp.LastProcessedKey, &cp.ProcessedCount, &cp.LastUpdated,
+			&lastModified, &eventWatermark, &events, &written, &duplicate, &invalid, &filtered, &metricsUpdated); err != nil {
+			return nil, fmt.Errorf("scan checkpoint: %w", err)
+		}
+		cp.LastModified = lastModified.Time
+		cp.EventWatermark = eventWatermark.Time
+		if events.Valid {
+			cp.LastRun = &RunMetrics{
+				Events:    events.Int64,
+				Written:   written.Int64,
+				Duplicate: duplicate.Int64,
+				Invalid:   invalid.Int64,
+				Filtered:  filtered.Int64,
+				UpdatedAt: metricsUpdated.Time,
+			}
+		}
+		checkpoints = append(checkpoints, cp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate checkpoints: %w", err)
+	}
+
+	return checkpoints, nil
+}
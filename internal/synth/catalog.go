@@ -0,0 +1,112 @@
+package synth
+
+// apiCall is one kind of event the generator emits
+type apiCall struct {
+	source   string
+	name     string
+	readOnly bool
+	// data events only: the resource the call touches
+	resourceType string
+}
+
+var managementCalls = []apiCall{
+	{source: "sts.amazonaws.com", name: "AssumeRole", readOnly: true},
+	{source: "sts.amazonaws.com", name: "GetCallerIdentity", readOnly: true},
+	{source: "signin.amazonaws.com", name: "ConsoleLogin"},
+	{source: "ec2.amazonaws.com", name: "DescribeInstances", readOnly: true},
+	{source: "ec2.amazonaws.com", name: "DescribeSecurityGroups", readOnly: true},
+	{source: "ec2.amazonaws.com", name: "RunInstances"},
+	{source: "ec2.amazonaws.com", name: "AuthorizeSecurityGroupIngress"},
+	{source: "iam.amazonaws.com", name: "ListRoles", readOnly: true},
+	{source: "iam.amazonaws.com", name: "CreateUser"},
+	{source: "iam.amazonaws.com", name: "CreateAccessKey"},
+	{source: "iam.amazonaws.com", name: "AttachRolePolicy"},
+	{source: "s3.amazonaws.com", name: "ListBuckets", readOnly: true},
+	{source: "s3.amazonaws.com", name: "GetBucketPolicy", readOnly: true},
+	{source: "s3.amazonaws.com", name: "PutBucketPolicy"},
+	{source: "kms.amazonaws.com", name: "Decrypt", readOnly: true},
+	{source: "kms.amazonaws.com", name: "GenerateDataKey", readOnly: true},
+	{source: "lambda.amazonaws.com", name: "ListFunctions20150331", readOnly: true},
+	{source: "cloudtrail.amazonaws.com", name: "DescribeTrails", readOnly: true},
+	{source: "cloudtrail.amazonaws.com", name: "StopLogging"},
+	{source: "guardduty.amazonaws.com", name: "ListDetectors", readOnly: true},
+}
+
+var dataCalls = []apiCall{
+	{source: "s3.amazonaws.com", name: "GetObject", readOnly: true, resourceType: "AWS::S3::Object"},
+	{source: "s3.amazonaws.com", name: "PutObject", resourceType: "AWS::S3::Object"},
+	{source: "s3.amazonaws.com", name: "DeleteObject", resourceType: "AWS::S3::Object"},
+	{source: "lambda.amazonaws.com", name: "Invoke", resourceType: "AWS::Lambda::Function"},
+	{source: "dynamodb.amazonaws.com", name: "GetItem", readOnly: true, resourceType: "AWS::DynamoDB::Table"},
+	{source: "dynamodb.amazonaws.com", name: "PutItem", resourceType: "AWS::DynamoDB::Table"},
+}
+
+// principal is who makes a call
+type principal struct {
+	kind string
+	name string
+}
+
+var principals = []principal{
+	{kind: "AssumedRole", name: "AdminRole"},
+	{kind: "AssumedRole", name: "DeployRole"},
+	{kind: "AssumedRole", name: "ReadOnly"},
+	{kind: "AssumedRole", name: "AWSReservedSSO_PowerUserAccess_0123456789abcdef"},
+	{kind: "IAMUser", name: "ci-bot"},
+	{kind: "IAMUser", name: "alice"},
+	{kind: "Root"},
+	{kind: "AWSService", name: "ec2.amazonaws.com"},
+	{kind: "AWSService", name: "lambda.amazonaws.com"},
+}
+
+var userAgents = []string{
+	"aws-cli/2.15.30 Python/3.11.8 Linux/6.1.0 exe/x86_64.amzn.2023",
+	"Boto3/1.34.69 md/Botocore#1.34.69 Python/3.12.2 Linux/5.10.0",
+	"aws-sdk-go-v2/1.26.1 os/linux lang/go#1.22.1",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4 Safari/605.1.15",
+	"Terraform/1.7.5 (+https://www.terraform.io) terraform-provider-aws/5.42.0",
+	"console.amazonaws.com",
+}
+
+var bucketNames = []string{"app-assets", "customer-uploads", "data-lake-raw", "backups"}
+
+// params returns plausible request parameters for the call
+func (c apiCall) params(g *generator, account, region string) map[string]any {
+	switch c.resourceType {
+	case "AWS::S3::Object":
+		return map[string]any{
+			"bucketName": bucketNames[g.rng.IntN(len(bucketNames))] + "-" + account,
+			"key":        "objects/" + g.token(12) + ".json",
+		}
+	case "AWS::Lambda::Function":
+		return map[string]any{"functionName": "arn:aws:lambda:" + region + ":" + account + ":function:processor"}
+	case "AWS::DynamoDB::Table":
+		return map[string]any{"tableName": "sessions"}
+	}
+	switch c.name {
+	case "AssumeRole":
+		return map[string]any{
+			"roleArn":         "arn:aws:iam::" + account + ":role/DeployRole",
+			"roleSessionName": "session-" + g.token(8),
+			"durationSeconds": 3600,
+		}
+	case "CreateUser", "CreateAccessKey":
+		return map[string]any{"userName": "svc-" + g.token(6)}
+	case "RunInstances":
+		return map[string]any{"instanceType": "t3.micro", "minCount": 1, "maxCount": 1}
+	case "PutBucketPolicy", "GetBucketPolicy":
+		return map[string]any{"bucketName": bucketNames[g.rng.IntN(len(bucketNames))] + "-" + account}
+	}
+	return nil
+}
+
+// resource returns the ARN of the resource a data event touches
+func (c apiCall) resource(g *generator, account, region string) string {
+	switch c.resourceType {
+	case "AWS::S3::Object":
+		return "arn:aws:s3:::" + bucketNames[g.rng.IntN(len(bucketNames))] + "-" + account + "/objects/" + g.token(12) + ".json"
+	case "AWS::Lambda::Function":
+		return "arn:aws:lambda:" + region + ":" + account + ":function:processor"
+	}
+	return "arn:aws:dynamodb:" + region + ":" + account + ":table/sessions"
+}
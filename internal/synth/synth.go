@@ -0,0 +1,441 @@
+// Package synth generates synthetic CloudTrail log files for load testing and
+// trying out configurations without touching production data
+package synth
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand/v2"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// event categories a mix can weight
+const (
+	CategoryManagement = "management"
+	CategoryData       = "data"
+	CategoryInsight    = "insight"
+)
+
+type Options struct {
+	// account IDs to generate logs for; AccountCount random ones when empty
+	Accounts     []string
+	AccountCount int
+	Regions      []string
+	// organization ID, for an organization trail's AWSLogs/<org>/<account>
+	// layout
+	OrgID string
+	// key prefix the trail delivers under
+	Prefix string
+	// events fall in [Start, End)
+	Start time.Time
+	End   time.Time
+	// log files per account, region and hour
+	FilesPerHour  int
+	EventsPerFile int
+	// relative weight of each category
+	Mix map[string]int
+	// fraction of events delivered again in a later file, as CloudTrail
+	// occasionally does
+	DuplicateRate float64
+	// fraction of records replaced with ones the processor rejects
+	MalformedRate float64
+	Seed          uint64
+}
+
+type Result struct {
+	Accounts   []string
+	Files      int
+	Events     int
+	Duplicates int
+	Malformed  int
+	Bytes      int64
+}
+
+// ParseMix parses category=weight pairs, e.g. management=90,data=9,insight=1
+func ParseMix(specs []string) (map[string]int, error) {
+	mix := make(map[string]int)
+	for _, spec := range specs {
+		name, value, ok := strings.Cut(spec, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case CategoryManagement, CategoryData, CategoryInsight:
+		default:
+			return nil, fmt.Errorf("mix %q: unknown category (want management, data or insight)", spec)
+		}
+		weight, err := strconv.Atoi(strings.TrimSpace(value))
+		if !ok || err != nil || weight < 0 {
+			return nil, fmt.Errorf("mix %q: want category=weight", spec)
+		}
+		mix[name] = weight
+	}
+	return mix, nil
+}
+
+// Validate checks the options describe something to generate
+func (o *Options) Validate() error {
+	switch {
+	case len(o.Accounts) == 0 && o.AccountCount <= 0:
+		return fmt.Errorf("need at least one account")
+	case len(o.Regions) == 0:
+		return fmt.Errorf("need at least one region")
+	case !o.End.After(o.Start):
+		return fmt.Errorf("end must be after start")
+	case o.FilesPerHour <= 0 || o.EventsPerFile <= 0:
+		return fmt.Errorf("files per hour and events per file must be positive")
+	case o.DuplicateRate < 0 || o.DuplicateRate > 1 || o.MalformedRate < 0 || o.MalformedRate > 1:
+		return fmt.Errorf("duplicate and malformed rates must be between 0 and 1")
+	}
+	for _, account := range o.Accounts {
+		if len(account) != 12 || strings.Trim(account, "0123456789") != "" {
+			return fmt.Errorf("account %q: want a 12 digit account ID", account)
+		}
+	}
+	total := 0
+	for _, weight := range o.Mix {
+		total += weight
+	}
+	if len(o.Mix) > 0 && total == 0 {
+		return fmt.Errorf("mix weights are all zero")
+	}
+	return nil
+}
+
+// Generate builds gzipped log files hour by hour and hands each one to put
+// under its CloudTrail object key
+func Generate(ctx context.Context, opts Options, put func(ctx context.Context, key string, data []byte) error) (Result, error) {
+	if err := opts.Validate(); err != nil {
+		return Result{}, err
+	}
+	g := &generator{
+		opts: opts,
+		rng:  rand.New(rand.NewPCG(opts.Seed, opts.Seed^0x9e3779b97f4a7c15)),
+	}
+	if len(opts.Mix) == 0 {
+		g.opts.Mix = map[string]int{CategoryManagement: 90, CategoryData: 10}
+	}
+	accounts := opts.Accounts
+	for len(accounts) < opts.AccountCount {
+		accounts = append(accounts, fmt.Sprintf("%012d", 100000000000+g.rng.Int64N(900000000000)))
+	}
+
+	res := Result{Accounts: accounts}
+	start := opts.Start.UTC().Truncate(time.Hour)
+	for hour := start; hour.Before(opts.End); hour = hour.Add(time.Hour) {
+		for _, account := range accounts {
+			for _, region := range opts.Regions {
+				for i := range opts.FilesPerHour {
+					if err := ctx.Err(); err != nil {
+						return res, err
+					}
+					// spread deliveries over the hour, each covering the few
+					// minutes before it
+					delivered := hour.Add(time.Duration(i+1) * time.Hour / time.Duration(opts.FilesPerHour+1)).Truncate(time.Minute)
+					records := g.file(account, region, delivered, &res)
+					if len(records) == 0 {
+						continue
+					}
+					data, err := encode(records)
+					if err != nil {
+						return res, err
+					}
+					key := g.key(account, region, delivered)
+					if err := put(ctx, key, data); err != nil {
+						return res, fmt.Errorf("put %s: %w", key, err)
+					}
+					res.Files++
+					res.Bytes += int64(len(data))
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+type generator struct {
+	opts Options
+	rng  *rand.Rand
+	// recent records, redelivered as duplicates
+	recent []json.RawMessage
+}
+
+// how far before its delivery a file's events can be
+const deliveryWindow = 15 * time.Minute
+
+// recent records kept for duplicates
+const recentSize = 1000
+
+func (g *generator) file(account, region string, delivered time.Time, res *Result) []json.RawMessage {
+	type timed struct {
+		at  time.Time
+		raw json.RawMessage
+	}
+	var events []timed
+	for range g.opts.EventsPerFile {
+		at := delivered.Add(-time.Duration(g.rng.Int64N(int64(deliveryWindow))))
+		if at.Before(g.opts.Start) || !at.Before(g.opts.End) {
+			continue
+		}
+		if g.rng.Float64() < g.opts.MalformedRate {
+			events = append(events, timed{at, g.malformed(account, region, at)})
+			res.Malformed++
+			continue
+		}
+		raw := g.event(account, region, at)
+		events = append(events, timed{at, raw})
+		res.Events++
+
+		if g.rng.Float64() < g.opts.DuplicateRate && len(g.recent) > 0 {
+			events = append(events, timed{at, g.recent[g.rng.IntN(len(g.recent))]})
+			res.Duplicates++
+		}
+		if len(g.recent) < recentSize {
+			g.recent = append(g.recent, raw)
+		} else {
+			g.recent[g.rng.IntN(recentSize)] = raw
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at.Before(events[j].at) })
+
+	records := make([]json.RawMessage, len(events))
+	for i, ev := range events {
+		records[i] = ev.raw
+	}
+	return records
+}
+
+// key returns the object key CloudTrail would deliver the file under
+func (g *generator) key(account, region string, delivered time.Time) string {
+	folder := "AWSLogs"
+	if g.opts.OrgID != "" {
+		folder += "/" + g.opts.OrgID
+	}
+	name := fmt.Sprintf("%s_CloudTrail_%s_%s_%s.json.gz",
+		account, region, delivered.Format("20060102T1504Z"), g.token(16))
+	key := path.Join(folder, account, "CloudTrail", region, delivered.Format("2006/01/02"), name)
+	if g.opts.Prefix != "" {
+		key = strings.TrimSuffix(g.opts.Prefix, "/") + "/" + key
+	}
+	return key
+}
+
+func (g *generator) category() string {
+	total := 0
+	for _, weight := range g.opts.Mix {
+		total += weight
+	}
+	n := g.rng.IntN(total)
+	for _, name := range []string{CategoryManagement, CategoryData, CategoryInsight} {
+		if n < g.opts.Mix[name] {
+			return name
+		}
+		n -= g.opts.Mix[name]
+	}
+	return CategoryManagement
+}
+
+func (g *generator) event(account, region string, at time.Time) json.RawMessage {
+	category := g.category()
+	var api apiCall
+	switch category {
+	case CategoryData:
+		api = dataCalls[g.rng.IntN(len(dataCalls))]
+	case CategoryInsight:
+		return g.insight(account, region, at)
+	default:
+		api = managementCalls[g.rng.IntN(len(managementCalls))]
+	}
+
+	principal := principals[g.rng.IntN(len(principals))]
+	ev := map[string]any{
+		"eventVersion":       "1.09",
+		"userIdentity":       g.identity(account, principal),
+		"eventTime":          at.Format(time.RFC3339),
+		"eventSource":        api.source,
+		"eventName":          api.name,
+		"awsRegion":          region,
+		"sourceIPAddress":    g.sourceIP(principal),
+		"userAgent":          userAgents[g.rng.IntN(len(userAgents))],
+		"requestParameters":  api.params(g, account, region),
+		"responseElements":   nil,
+		"requestID":          g.uuid(),
+		"eventID":            g.uuid(),
+		"readOnly":           api.readOnly,
+		"eventType":          "AwsApiCall",
+		"managementEvent":    category == CategoryManagement,
+		"recipientAccountId": account,
+		"eventCategory":      "Management",
+	}
+	if api.name == "ConsoleLogin" {
+		ev["eventType"] = "AwsConsoleSignIn"
+		ev["responseElements"] = map[string]any{"ConsoleLogin": "Success"}
+	}
+	if category == CategoryData {
+		ev["eventCategory"] = "Data"
+		ev["resources"] = []map[string]any{{
+			"type": api.resourceType,
+			"ARN":  api.resource(g, account, region),
+		}}
+	}
+	// a few calls fail, as real ones do
+	if g.rng.IntN(100) < 3 {
+		ev["errorCode"] = "AccessDenied"
+		ev["errorMessage"] = "User is not authorized to perform this operation"
+		ev["responseElements"] = nil
+	}
+	raw, _ := json.Marshal(ev)
+	return raw
+}
+
+func (g *generator) insight(account, region string, at time.Time) json.RawMessage {
+	api := managementCalls[g.rng.IntN(len(managementCalls))]
+	raw, _ := json.Marshal(map[string]any{
+		"eventVersion":       "1.08",
+		"eventTime":          at.Format(time.RFC3339),
+		"awsRegion":          region,
+		"eventID":            g.uuid(),
+		"eventType":          "AwsCloudTrailInsight",
+		"recipientAccountId": account,
+		"sharedEventID":      g.uuid(),
+		"eventSource":        api.source,
+		"eventName":          api.name,
+		"insightDetails": map[string]any{
+			"state":       "Start",
+			"eventSource": api.source,
+			"eventName":   api.name,
+			"insightType": "ApiCallRateInsight",
+			"insightContext": map[string]any{
+				"statistics": map[string]any{
+					"baseline":        map[string]any{"average": 0.05},
+					"insight":         map[string]any{"average": 2 + g.rng.Float64()*20},
+					"insightDuration": 1 + g.rng.IntN(10),
+				},
+			},
+		},
+		"eventCategory": "Insight",
+	})
+	return raw
+}
+
+// malformed returns a record the processor rejects as invalid
+func (g *generator) malformed(account, region string, at time.Time) json.RawMessage {
+	switch g.rng.IntN(4) {
+	case 0:
+		// not an object at all
+		return json.RawMessage(`"truncated record"`)
+	case 1:
+		raw, _ := json.Marshal(map[string]any{
+			"eventVersion": "1.09", "eventID": g.uuid(), "eventName": "GetCallerIdentity",
+			"eventSource": "sts.amazonaws.com", "awsRegion": region, "recipientAccountId": account,
+		})
+		return raw
+	case 2:
+		raw, _ := json.Marshal(map[string]any{
+			"eventVersion": "1.09", "eventID": g.uuid(), "eventTime": "not-a-time", "eventName": "GetCallerIdentity",
+			"eventSource": "sts.amazonaws.com", "awsRegion": region, "recipientAccountId": account,
+		})
+		return raw
+	default:
+		// no account to route it to
+		raw, _ := json.Marshal(map[string]any{
+			"eventVersion": "1.09", "eventID": g.uuid(), "eventTime": at.Format(time.RFC3339),
+			"eventName": "GetCallerIdentity", "eventSource": "sts.amazonaws.com", "awsRegion": region,
+		})
+		return raw
+	}
+}
+
+func (g *generator) identity(account string, p principal) map[string]any {
+	switch p.kind {
+	case "AWSService":
+		return map[string]any{"type": "AWSService", "invokedBy": p.name}
+	case "IAMUser":
+		return map[string]any{
+			"type":        "IAMUser",
+			"principalId": "AIDA" + g.token(17),
+			"arn":         "arn:aws:iam::" + account + ":user/" + p.name,
+			"accountId":   account,
+			"accessKeyId": "AKIA" + g.token(16),
+			"userName":    p.name,
+		}
+	case "Root":
+		return map[string]any{
+			"type":        "Root",
+			"principalId": account,
+			"arn":         "arn:aws:iam::" + account + ":root",
+			"accountId":   account,
+		}
+	}
+	session := "session-" + strings.ToLower(g.token(8))
+	roleID := "AROA" + g.token(17)
+	return map[string]any{
+		"type":        "AssumedRole",
+		"principalId": roleID + ":" + session,
+		"arn":         "arn:aws:sts::" + account + ":assumed-role/" + p.name + "/" + session,
+		"accountId":   account,
+		"accessKeyId": "ASIA" + g.token(16),
+		"sessionContext": map[string]any{
+			"sessionIssuer": map[string]any{
+				"type":        "Role",
+				"principalId": roleID,
+				"arn":         "arn:aws:iam::" + account + ":role/" + p.name,
+				"accountId":   account,
+				"userName":    p.name,
+			},
+		},
+	}
+}
+
+func (g *generator) sourceIP(p principal) string {
+	if p.kind == "AWSService" {
+		return p.name
+	}
+	// documentation ranges, so nothing real shows up in test data
+	prefixes := []string{"192.0.2.", "198.51.100.", "203.0.113."}
+	return prefixes[g.rng.IntN(len(prefixes))] + strconv.Itoa(1+g.rng.IntN(254))
+}
+
+func (g *generator) uuid() string {
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = byte(g.rng.UintN(256))
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+const tokenChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+func (g *generator) token(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = tokenChars[g.rng.IntN(len(tokenChars))]
+	}
+	return string(b)
+}
+
+// encode wraps records in a log file and gzips it
+func encode(records []json.RawMessage) ([]byte, error) {
+	body, err := json.Marshal(struct {
+		Records []json.RawMessage `json:"Records"`
+	}{records})
+	if err != nil {
+		return nil, fmt.Errorf("encode log file: %w", err)
+	}
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(body); err != nil {
+		return nil, fmt.Errorf("compress log file: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, fmt.Errorf("compress log file: %w", err)
+	}
+	return buf.Bytes(), nil
+}
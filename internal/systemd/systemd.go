@@ -0,0 +1,81 @@
+// Package systemd speaks the sd_notify protocol, so a run under a
+// Type=notify unit can report readiness, status and watchdog keepalives
+package systemd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier sends state updates to the service manager. A nil Notifier,
+// outside of a notify unit, ignores them.
+type Notifier struct {
+	addr *net.UnixAddr
+	// how often the unit expects keepalives, zero without WatchdogSec
+	WatchdogInterval time.Duration
+}
+
+// FromEnv returns a notifier for the socket systemd passes in NOTIFY_SOCKET,
+// nil when there is none
+func FromEnv() *Notifier {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	// a leading @ names an abstract socket, which net handles itself
+	n := &Notifier{addr: &net.UnixAddr{Name: socket, Net: "unixgram"}}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err == nil && usec > 0 {
+		// the watchdog may be meant for another process of the unit
+		if pid := os.Getenv("WATCHDOG_PID"); pid == "" || pid == strconv.Itoa(os.Getpid()) {
+			n.WatchdogInterval = time.Duration(usec) * time.Microsecond
+		}
+	}
+	return n
+}
+
+// Notify sends VAR=value assignments, e.g. READY=1, in one message
+func (n *Notifier) Notify(assignments ...string) error {
+	if n == nil {
+		return nil
+	}
+	conn, err := net.DialUnix("unixgram", nil, n.addr)
+	if err != nil {
+		return fmt.Errorf("connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(assignments, "\n"))); err != nil {
+		return fmt.Errorf("write to notify socket: %w", err)
+	}
+	return nil
+}
+
+// Ready reports that startup finished, with a status line
+func (n *Notifier) Ready(status string) error {
+	return n.Notify("READY=1", "STATUS="+status)
+}
+
+// Status updates the status line systemctl status shows
+func (n *Notifier) Status(status string) error {
+	return n.Notify("STATUS=" + status)
+}
+
+// Watchdog sends a keepalive, with a status line
+func (n *Notifier) Watchdog(status string) error {
+	return n.Notify("WATCHDOG=1", "STATUS="+status)
+}
+
+// Stopping reports that shutdown began
+func (n *Notifier) Stopping(status string) error {
+	return n.Notify("STOPPING=1", "STATUS="+status)
+}
+
+// ExtendTimeout asks for d more before the current start or stop timeout
+// runs out
+func (n *Notifier) ExtendTimeout(d time.Duration) error {
+	return n.Notify("EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(d.Microseconds(), 10))
+}
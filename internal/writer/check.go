@@ -0,0 +1,194 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+)
+
+// Damage is what CheckEventsFile found wrong with an output file
+type Damage struct {
+	Reason string
+	// length of the complete lines of a plain JSONL file before the damage,
+	// which can be kept by truncating the file; -1 when the file can't be
+	// repaired in place
+	Keep int64
+}
+
+// IsEventsFile reports whether name is an output file a writer produced
+func IsEventsFile(name string) bool {
+	if strings.HasSuffix(name, ".tmp") {
+		return false
+	}
+	if !strings.HasPrefix(name, "events_") && !strings.HasPrefix(name, SourceFilePrefix) {
+		return false
+	}
+	_, ok := FormatFromPath(name)
+	return ok
+}
+
+// CheckEventsFile reports whether an output file is incomplete, e.g. cut
+// short by a crash, and nil when it's whole. A file its partition's manifest
+// lists must match its checksum; any other file must read through to the
+// end, and a plain JSONL file must end with a complete line. Encrypted files
+// are read with c.
+func CheckEventsFile(path string, c crypt.Cipher) (*Damage, error) {
+	sum, listed, err := manifestEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	if listed {
+		actual, err := fileSHA256(path)
+		if err != nil {
+			return nil, err
+		}
+		if actual == sum {
+			return nil, nil
+		}
+		damage, err := checkStructure(path, c)
+		if err != nil || damage != nil {
+			return damage, err
+		}
+		// it reads fine but isn't what was written, so keep it out of the
+		// output rather than guess which part is wrong
+		return &Damage{Reason: "checksum does not match the manifest", Keep: -1}, nil
+	}
+	return checkStructure(path, c)
+}
+
+func checkStructure(path string, c crypt.Cipher) (*Damage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", path, err)
+	}
+	if info.Size() == 0 {
+		// writers never leave a file without events
+		return &Damage{Reason: "empty file", Keep: 0}, nil
+	}
+
+	format, _ := FormatFromPath(path)
+	_, ext := crypt.Split(path)
+	if ext == "" && format == FormatJSONL {
+		return checkJSONL(path, info.Size())
+	}
+	if ext != "" && (c == nil || c.Extension() != ext) {
+		// can't be read without the key, so only the manifest can vouch
+		// for it
+		return nil, nil
+	}
+	if format == FormatCSV {
+		return checkCSVFile(path, ext, c)
+	}
+	if _, err := ReadEventsFile(path, c); err != nil {
+		return &Damage{Reason: err.Error(), Keep: -1}, nil
+	}
+	return nil, nil
+}
+
+// checkCSVFile parses a csv file, which can't be read back as events
+func checkCSVFile(path, ext string, c crypt.Cipher) (*Damage, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path, err)
+	}
+	if ext != "" {
+		if data, err = c.Decrypt(data); err != nil {
+			return &Damage{Reason: err.Error(), Keep: -1}, nil
+		}
+	}
+	if err := checkCSV(bytes.NewReader(data)); err != nil {
+		return &Damage{Reason: err.Error(), Keep: -1}, nil
+	}
+	return nil, nil
+}
+
+// checkJSONL looks at the end of a plain JSONL file, where a write cut short
+// leaves a partial line
+func checkJSONL(path string, size int64) (*Damage, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	// find the last complete line, reading back from the end
+	const chunk = 64 << 10
+	var tail []byte
+	end := size
+	for end > 0 {
+		start := max(end-chunk, 0)
+		buf := make([]byte, end-start)
+		if _, err := f.ReadAt(buf, start); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("read %s: %w", path, err)
+		}
+		tail = append(buf, tail...)
+		end = start
+		if bytes.Count(tail, []byte{'\n'}) >= 2 || end == 0 {
+			break
+		}
+	}
+
+	if tail[len(tail)-1] != '\n' {
+		partial := len(tail) - 1 - bytes.LastIndexByte(tail, '\n')
+		return &Damage{Reason: "last line is incomplete", Keep: size - int64(partial)}, nil
+	}
+	// the final line must hold a whole event too
+	body := tail[:len(tail)-1]
+	last := body[bytes.LastIndexByte(body, '\n')+1:]
+	if len(bytes.TrimSpace(last)) > 0 && !json.Valid(last) {
+		return &Damage{Reason: "last line is not valid JSON", Keep: size - int64(len(last)) - 1}, nil
+	}
+	return nil, nil
+}
+
+// manifestEntry returns the checksum the manifest next to path records for
+// it, if any
+func manifestEntry(path string) (string, bool, error) {
+	f, err := os.Open(filepath.Join(filepath.Dir(path), ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("open manifest: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	name := filepath.Base(path)
+	var sum string
+	var listed bool
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// the latest entry wins, as with rewritten source files
+		if s, ok := strings.CutSuffix(sc.Text(), "  "+name); ok {
+			sum, listed = s, true
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", false, fmt.Errorf("read manifest: %w", err)
+	}
+	return sum, listed, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("open %s: %w", path, err)
+	}
+	defer func() { _ = f.Close() }()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
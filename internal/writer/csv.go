@@ -0,0 +1,102 @@
+package writer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// DefaultCSVColumns are the fields csv output flattens into columns when
+// none are configured
+var DefaultCSVColumns = []string{
+	"eventTime", "eventID", "eventName", "eventSource", "eventType", "eventCategory",
+	"awsRegion", "recipientAccountId", "sourceIPAddress", "userAgent",
+	"userIdentity.type", "userIdentity.arn", "userIdentity.accountId", "userIdentity.principalId",
+	"userIdentity.sessionContext.sessionIssuer.arn", "readOnly", "errorCode", "errorMessage",
+}
+
+// Columns returns the fields csv output flattens into columns, each a
+// dotted path into the event such as userIdentity.arn
+func (e Encoding) Columns() []string {
+	if len(e.CSVColumns) > 0 {
+		return e.CSVColumns
+	}
+	return DefaultCSVColumns
+}
+
+// encodeCSV writes a header row of the column paths and then a row per
+// event. Strings are written as they are, other scalars as their JSON text,
+// and objects and arrays as compact JSON; a missing field is empty.
+func encodeCSV(w io.Writer, columns []string, events []json.RawMessage) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(columns); err != nil {
+		return fmt.Errorf("write csv header: %w", err)
+	}
+	row := make([]string, len(columns))
+	for _, event := range events {
+		var ev map[string]json.RawMessage
+		_ = json.Unmarshal(event, &ev)
+		for i, column := range columns {
+			row[i] = csvValue(lookup(ev, column))
+		}
+		if err := cw.Write(row); err != nil {
+			return fmt.Errorf("write csv row: %w", err)
+		}
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		return fmt.Errorf("flush csv: %w", err)
+	}
+	return nil
+}
+
+// lookup follows a dotted path through nested objects
+func lookup(ev map[string]json.RawMessage, path string) json.RawMessage {
+	name, rest, nested := strings.Cut(path, ".")
+	value := ev[name]
+	if !nested || value == nil {
+		return value
+	}
+	var inner map[string]json.RawMessage
+	if json.Unmarshal(value, &inner) != nil {
+		return nil
+	}
+	return lookup(inner, rest)
+}
+
+func csvValue(value json.RawMessage) string {
+	value = bytes.TrimSpace(value)
+	switch {
+	case len(value) == 0 || string(value) == "null":
+		return ""
+	case value[0] == '"':
+		var s string
+		if json.Unmarshal(value, &s) == nil {
+			return s
+		}
+	case value[0] == '{' || value[0] == '[':
+		var buf bytes.Buffer
+		if json.Compact(&buf, value) == nil {
+			return buf.String()
+		}
+	}
+	return string(value)
+}
+
+// checkCSV reads a csv file through to the end, which a write cut short
+// fails with a row of the wrong length or an unterminated quote
+func checkCSV(r io.Reader) error {
+	cr := csv.NewReader(r)
+	for {
+		_, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read csv: %w", err)
+		}
+	}
+}
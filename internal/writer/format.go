@@ -0,0 +1,349 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
+)
+
+// Format identifies an on-disk encoding for event files
+type Format string
+
+const (
+	FormatJSONL     Format = "jsonl"
+	FormatJSONLGzip Format = "jsonl.gz"
+	FormatJSONLZstd Format = "jsonl.zst"
+	FormatParquet   Format = "parquet"
+	FormatCSV       Format = "csv"
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.TrimPrefix(s, ".")); f {
+	case FormatJSONL, FormatJSONLGzip, FormatJSONLZstd, FormatParquet, FormatCSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown output format %q (want jsonl, jsonl.gz, jsonl.zst, parquet or csv)", s)
+	}
+}
+
+// Extension returns the file suffix for the format, including the leading dot
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// TrimExtension strips an event file's format and encryption suffixes
+func TrimExtension(path string) string {
+	path, _ = crypt.Split(path)
+	if f, ok := FormatFromPath(path); ok {
+		return strings.TrimSuffix(path, f.Extension())
+	}
+	return path
+}
+
+// FormatFromPath detects the format of an event file from its name,
+// encrypted or not
+func FormatFromPath(path string) (Format, bool) {
+	path, _ = crypt.Split(path)
+	for _, f := range []Format{FormatJSONLGzip, FormatJSONLZstd, FormatJSONL, FormatParquet, FormatCSV} {
+		if strings.HasSuffix(path, f.Extension()) {
+			return f, true
+		}
+	}
+	return "", false
+}
+
+// parquetEvent is the columnar layout for parquet output. Commonly queried
+// fields are promoted to columns and the full record is kept in Raw so no
+// information is lost.
+type parquetEvent struct {
+	EventTime          string `parquet:"event_time,optional" json:"eventTime"`
+	EventID            string `parquet:"event_id,optional" json:"eventID"`
+	EventName          string `parquet:"event_name,optional" json:"eventName"`
+	EventSource        string `parquet:"event_source,optional" json:"eventSource"`
+	EventType          string `parquet:"event_type,optional" json:"eventType"`
+	EventCategory      string `parquet:"event_category,optional" json:"eventCategory"`
+	AWSRegion          string `parquet:"aws_region,optional" json:"awsRegion"`
+	SourceIPAddress    string `parquet:"source_ip_address,optional" json:"sourceIPAddress"`
+	UserAgent          string `parquet:"user_agent,optional" json:"userAgent"`
+	ErrorCode          string `parquet:"error_code,optional" json:"errorCode"`
+	ErrorMessage       string `parquet:"error_message,optional" json:"errorMessage"`
+	RecipientAccountID string `parquet:"recipient_account_id,optional" json:"recipientAccountId"`
+	UserIdentityType   string `parquet:"user_identity_type,optional" json:"-"`
+	UserIdentityARN    string `parquet:"user_identity_arn,optional" json:"-"`
+	UserIdentityAcct   string `parquet:"user_identity_account_id,optional" json:"-"`
+	UserIdentity       struct {
+		Type      string `json:"type"`
+		ARN       string `json:"arn"`
+		AccountID string `json:"accountId"`
+	} `parquet:"-" json:"userIdentity"`
+	Raw string `parquet:"raw" json:"-"`
+}
+
+// ParquetColumns returns the column names of parquet output, in order
+func ParquetColumns() []string {
+	var cols []string
+	for _, f := range parquet.SchemaOf(parquetEvent{}).Fields() {
+		cols = append(cols, f.Name())
+	}
+	return cols
+}
+
+func newParquetEvent(raw json.RawMessage) parquetEvent {
+	var ev parquetEvent
+	// a record that doesn't decode still round-trips through Raw
+	_ = json.Unmarshal(raw, &ev)
+	ev.UserIdentityType = ev.UserIdentity.Type
+	ev.UserIdentityARN = ev.UserIdentity.ARN
+	ev.UserIdentityAcct = ev.UserIdentity.AccountID
+	ev.Raw = string(raw)
+	return ev
+}
+
+// Encoding is how events are written to a file: its format, for csv the
+// fields flattened into columns, and the cipher that encrypts it
+type Encoding struct {
+	Format Format
+	// DefaultCSVColumns when empty
+	CSVColumns []string
+	// nil leaves files in plaintext
+	Cipher crypt.Cipher
+}
+
+// FileExtension returns the suffix for new files in the encoding, including
+// the encryption suffix when they're encrypted
+func (e Encoding) FileExtension() string {
+	if e.Cipher != nil {
+		return e.Format.Extension() + e.Cipher.Extension()
+	}
+	return e.Format.Extension()
+}
+
+// EncodeEvents writes events to w in the given encoding
+func EncodeEvents(w io.Writer, enc Encoding, events []json.RawMessage) error {
+	switch enc.Format {
+	case FormatJSONL:
+		return encodeJSONL(w, events)
+	case FormatJSONLGzip:
+		gw := gzip.NewWriter(w)
+		if err := encodeJSONL(gw, events); err != nil {
+			_ = gw.Close()
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return fmt.Errorf("close gzip: %w", err)
+		}
+		return nil
+	case FormatJSONLZstd:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return fmt.Errorf("open zstd: %w", err)
+		}
+		if err := encodeJSONL(zw, events); err != nil {
+			_ = zw.Close()
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("close zstd: %w", err)
+		}
+		return nil
+	case FormatCSV:
+		return encodeCSV(w, enc.Columns(), events)
+	case FormatParquet:
+		rows := make([]parquetEvent, len(events))
+		for i, event := range events {
+			rows[i] = newParquetEvent(event)
+		}
+		if err := parquet.Write(w, rows, parquet.Compression(&parquet.Zstd)); err != nil {
+			return fmt.Errorf("write parquet: %w", err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported format %q", enc.Format)
+	}
+}
+
+// encodeFile writes events to w as the contents of path, encrypting them
+// when path has an encryption suffix
+func encodeFile(w io.Writer, path string, enc Encoding, events []json.RawMessage) error {
+	if _, ext := crypt.Split(path); ext != "" {
+		if enc.Cipher == nil || enc.Cipher.Extension() != ext {
+			return fmt.Errorf("no %s encryption configured for %s", ext, path)
+		}
+		var buf bytes.Buffer
+		if err := EncodeEvents(&buf, enc, events); err != nil {
+			return err
+		}
+		data, err := enc.Cipher.Encrypt(buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return fmt.Errorf("write file: %w", err)
+		}
+		return nil
+	}
+	return EncodeEvents(w, enc, events)
+}
+
+func encodeJSONL(w io.Writer, events []json.RawMessage) error {
+	bw := bufio.NewWriter(w)
+	for _, event := range events {
+		if _, err := bw.Write(event); err != nil {
+			return fmt.Errorf("write event: %w", err)
+		}
+		if err := bw.WriteByte('\n'); err != nil {
+			return fmt.Errorf("write newline: %w", err)
+		}
+	}
+
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+// WriteEventsFile atomically replaces path with events in the given encoding
+func WriteEventsFile(path string, enc Encoding, events []json.RawMessage) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+
+	if err := encodeFile(f, path, enc, events); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("close file: %w", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("rename file: %w", err)
+	}
+	return UpdateManifest(path)
+}
+
+// ReadEventsFile loads every event from an output file, detecting the
+// format and encryption from the file name and decrypting with c. csv files
+// can't be read back, as they keep only some of each event's fields.
+func ReadEventsFile(path string, c crypt.Cipher) ([]json.RawMessage, error) {
+	format, ok := FormatFromPath(path)
+	if !ok {
+		return nil, fmt.Errorf("unrecognised event file %q", path)
+	}
+	if format == FormatCSV {
+		return nil, fmt.Errorf("%s: csv output keeps only its columns and can't be read back as events", path)
+	}
+	if _, ext := crypt.Split(path); ext != "" {
+		return readEncrypted(path, ext, format, c)
+	}
+
+	if format == FormatParquet {
+		rows, err := parquet.ReadFile[parquetEvent](path)
+		if err != nil {
+			return nil, fmt.Errorf("read parquet: %w", err)
+		}
+		events := make([]json.RawMessage, len(rows))
+		for i, row := range rows {
+			events[i] = json.RawMessage(row.Raw)
+		}
+		return events, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	return decodeStream(f, format)
+}
+
+// readEncrypted decrypts a whole file and decodes the plaintext
+func readEncrypted(path, ext string, format Format, c crypt.Cipher) ([]json.RawMessage, error) {
+	if c == nil || c.Extension() != ext {
+		return nil, fmt.Errorf("no %s encryption configured to read %s", ext, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	data, err = c.Decrypt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatParquet:
+		rows, err := parquet.Read[parquetEvent](bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, fmt.Errorf("read parquet: %w", err)
+		}
+		events := make([]json.RawMessage, len(rows))
+		for i, row := range rows {
+			events[i] = json.RawMessage(row.Raw)
+		}
+		return events, nil
+	default:
+		return decodeStream(bytes.NewReader(data), format)
+	}
+}
+
+// decodeStream reads any format but parquet, which needs random access, and
+// csv
+func decodeStream(r io.Reader, format Format) ([]json.RawMessage, error) {
+	switch format {
+	case FormatJSONLGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open gzip: %w", err)
+		}
+		defer func() { _ = gr.Close() }()
+		return decodeJSONL(gr)
+	case FormatJSONLZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("open zstd: %w", err)
+		}
+		defer zr.Close()
+		return decodeJSONL(zr)
+	default:
+		return decodeJSONL(r)
+	}
+}
+
+func decodeJSONL(r io.Reader) ([]json.RawMessage, error) {
+	var events []json.RawMessage
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			events = append(events, json.RawMessage(line))
+		}
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read line: %w", err)
+		}
+	}
+}
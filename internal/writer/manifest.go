@@ -0,0 +1,137 @@
+package writer
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// ManifestName is the checksum manifest kept in each partition dir, in
+	// the format sha256sum -c reads
+	ManifestName = "SHA256SUMS"
+	// SignatureExtension is added to the manifest name for its signature
+	SignatureExtension = ".sig"
+)
+
+// EnableChecksums records the SHA-256 of every file written from now on in
+// its partition's manifest
+func (w *JSONLWriter) EnableChecksums() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.changedManifests = make(map[string]bool)
+}
+
+// ChangedManifests returns the manifests appended to since the last call
+func (w *JSONLWriter) ChangedManifests() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	paths := make([]string, 0, len(w.changedManifests))
+	for path := range w.changedManifests {
+		paths = append(paths, path)
+		delete(w.changedManifests, path)
+	}
+	return paths
+}
+
+// appendManifest adds a file's checksum to the manifest next to it
+func appendManifest(path string, sum []byte) (string, error) {
+	manifest := filepath.Join(filepath.Dir(path), ManifestName)
+	f, err := os.OpenFile(manifest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("open manifest: %w", err)
+	}
+	if _, err := fmt.Fprintf(f, "%x  %s\n", sum, filepath.Base(path)); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("write manifest: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("close manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// UpdateManifest refreshes path's entry in its partition's manifest after
+// the file was rewritten or removed outside a run, and drops the manifest's
+// signature, which no longer matches. Partitions without a manifest are
+// left alone.
+func UpdateManifest(path string) error {
+	manifest := filepath.Join(filepath.Dir(path), ManifestName)
+	if _, err := os.Stat(manifest); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("stat manifest: %w", err)
+	}
+
+	var sum []byte
+	f, err := os.Open(path)
+	switch {
+	case err == nil:
+		hash := sha256.New()
+		_, err = io.Copy(hash, f)
+		_ = f.Close()
+		if err != nil {
+			return fmt.Errorf("hash %s: %w", path, err)
+		}
+		sum = hash.Sum(nil)
+	case !errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+
+	if _, err := setManifestEntry(path, sum); err != nil {
+		return err
+	}
+	if err := os.Remove(manifest + SignatureExtension); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove manifest signature: %w", err)
+	}
+	return nil
+}
+
+// setManifestEntry replaces path's line in the manifest next to it with sum,
+// or drops it when sum is nil, and returns the manifest's path
+func setManifestEntry(path string, sum []byte) (string, error) {
+	manifest := filepath.Join(filepath.Dir(path), ManifestName)
+	data, err := os.ReadFile(manifest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("read manifest: %w", err)
+	}
+
+	name := filepath.Base(path)
+	var out bytes.Buffer
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		if line := sc.Text(); line != "" && !strings.HasSuffix(line, "  "+name) {
+			out.WriteString(line + "\n")
+		}
+	}
+	if sum != nil {
+		fmt.Fprintf(&out, "%x  %s\n", sum, name)
+	}
+
+	if err := os.WriteFile(manifest+".tmp", out.Bytes(), 0o644); err != nil {
+		return "", fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(manifest+".tmp", manifest); err != nil {
+		return "", fmt.Errorf("rename manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// ManifestDigest returns the SHA-256 of a manifest's contents, which is
+// what its signature covers
+func ManifestDigest(manifest string) ([]byte, error) {
+	data, err := os.ReadFile(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
@@ -0,0 +1,142 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SourceFilePrefix starts the name of files written by WriteSource
+const SourceFilePrefix = "src_"
+
+// SourceEvent is one event of a source object, for WriteSource
+type SourceEvent struct {
+	AccountID   string
+	Region      string
+	EventSource string
+	Time        time.Time
+	Raw         json.RawMessage
+}
+
+// SourceFileName returns the file name WriteSource uses for a source object,
+// derived from a hash of its location
+func SourceFileName(source string, enc Encoding) string {
+	sum := sha256.Sum256([]byte(source))
+	return SourceFilePrefix + hex.EncodeToString(sum[:8]) + enc.FileExtension()
+}
+
+// WriteSource writes all of one source object's events straight to disk,
+// bypassing the buffers: one file per partition, named after the object, so
+// processing the same object again replaces its files instead of adding
+// duplicates next to them.
+func (w *JSONLWriter) WriteSource(source string, events []SourceEvent) error {
+	byKey := make(map[string][]json.RawMessage)
+	for _, ev := range events {
+		key := w.partitioning.PartitionKey(ev.AccountID, ev.Region, ev.EventSource, ev.Time)
+		byKey[key] = append(byKey[key], ev.Raw)
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	name := SourceFileName(source, w.encoding)
+	for _, key := range keys {
+		if err := w.writeSourceFile(key, name, byKey[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *JSONLWriter) writeSourceFile(key, name string, events []json.RawMessage) error {
+	dir := filepath.Join(w.eventsDir, key)
+	filePath := filepath.Join(dir, name)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if _, seen := w.appending[key]; !seen {
+		w.appending[key] = hasEventFiles(dir)
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+
+	// written under a temporary name and renamed, so a crash never leaves a
+	// half-written file in place of a complete one
+	tmpPath := filePath + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return fmt.Errorf("create file: %w", err)
+	}
+	var out io.Writer = f
+	hash := sha256.New()
+	if w.changedManifests != nil {
+		out = io.MultiWriter(f, hash)
+	}
+	if err := encodeFile(out, filePath, w.encoding, events); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("stat file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmpPath)
+		return fmt.Errorf("close file: %w", err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("rename file: %w", err)
+	}
+
+	if w.changedManifests != nil {
+		manifest, err := setManifestEntry(filePath, hash.Sum(nil))
+		if err != nil {
+			return err
+		}
+		w.changedManifests[manifest] = true
+	}
+	if w.onFile != nil {
+		w.onFile(w.eventsDir, filePath, info.Size())
+	}
+	if w.onAppend != nil && w.appending[key] {
+		w.onAppend(w.eventsDir, dir)
+	}
+
+	w.logger.Debug("wrote source file",
+		slog.String("key", key),
+		slog.Int("events", len(events)),
+		slog.String("file", filePath))
+	return nil
+}
+
+// hasEventFiles reports whether dir already holds output files
+func hasEventFiles(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if !e.IsDir() && (strings.HasPrefix(name, "events_") || strings.HasPrefix(name, SourceFilePrefix)) &&
+			!strings.HasSuffix(name, ".tmp") {
+			return true
+		}
+	}
+	return false
+}
@@ -1,12 +1,17 @@
 package writer
 
 import (
-	"bufio"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -16,26 +21,193 @@ type JSONLWriter struct {
 	buffers         map[string]*eventBuffer
 	eventsDir       string
 	eventsPerFile   int
+	encoding        Encoding
+	partitioning    Partitioning
 	nextFileCounter map[string]int
-	logger          *slog.Logger
+	// lowest file number per partition, for partitions with files elsewhere
+	fileFloor map[string]int
+	logger    *slog.Logger
+	// called with each file once it's written and closed
+	onFile func(eventsDir, path string, size int64)
+	// partitions that held files before this writer first wrote to them
+	appending map[string]bool
+	// called with each such partition after a file is added to it
+	onAppend func(eventsDir, dir string)
+	// manifests appended to since ChangedManifests, nil when checksums are
+	// off
+	changedManifests map[string]bool
 }
 
 type eventBuffer struct {
 	events []json.RawMessage
 }
 
-func New(eventsDir string, eventsPerFile int, logger *slog.Logger) *JSONLWriter {
+func New(eventsDir string, eventsPerFile int, enc Encoding, partitioning Partitioning, logger *slog.Logger) *JSONLWriter {
+	if enc.Format == "" {
+		enc.Format = FormatJSONL
+	}
 	return &JSONLWriter{
 		buffers:         make(map[string]*eventBuffer),
 		eventsDir:       eventsDir,
 		eventsPerFile:   eventsPerFile,
+		encoding:        enc,
+		partitioning:    partitioning,
 		nextFileCounter: make(map[string]int),
+		appending:       make(map[string]bool),
 		logger:          logger,
 	}
 }
 
-func (w *JSONLWriter) Write(accountID, region string, eventTime time.Time, rawEvent json.RawMessage) error {
-	key := fmt.Sprintf("%s/%s/%s", accountID, region, eventTime.Format("2006/01/02/15"))
+// Layout is how partition directories are arranged under an events dir
+type Layout string
+
+const (
+	// account/region/YYYY/MM/DD/HH
+	LayoutDefault Layout = "default"
+	// account/region/eventsource=<source>/YYYY/MM/DD/HH, for queries that
+	// pick a service before a time range
+	LayoutEventSource Layout = "event_source"
+)
+
+// EventSourcePrefix starts the event source folder of LayoutEventSource
+const EventSourcePrefix = "eventsource="
+
+func ParseLayout(s string) (Layout, error) {
+	switch Layout(s) {
+	case "", LayoutDefault:
+		return LayoutDefault, nil
+	case LayoutEventSource:
+		return LayoutEventSource, nil
+	}
+	return "", fmt.Errorf("unknown partition layout %q (want default or event_source)", s)
+}
+
+// Granularity is the span of time one partition directory covers
+type Granularity string
+
+const (
+	// YYYY/MM/DD/HH
+	GranularityHour Granularity = "hour"
+	// YYYY/MM/DD
+	GranularityDay Granularity = "day"
+	// YYYY/MM
+	GranularityMonth Granularity = "month"
+)
+
+func ParseGranularity(s string) (Granularity, error) {
+	switch Granularity(s) {
+	case "", GranularityHour:
+		return GranularityHour, nil
+	case GranularityDay, GranularityMonth:
+		return Granularity(s), nil
+	}
+	return "", fmt.Errorf("unknown partition granularity %q (want hour, day or month)", s)
+}
+
+// Depth returns how many date folders a partition path ends with
+func (g Granularity) Depth() int {
+	switch g {
+	case GranularityMonth:
+		return 2
+	case GranularityDay:
+		return 3
+	}
+	return 4
+}
+
+// Start returns when the partition closing at end opened
+func (g Granularity) Start(end time.Time) time.Time {
+	switch g {
+	case GranularityMonth:
+		return end.AddDate(0, -1, 0)
+	case GranularityDay:
+		return end.AddDate(0, 0, -1)
+	}
+	return end.Add(-time.Hour)
+}
+
+func (g Granularity) timeLayout() string {
+	switch g {
+	case GranularityMonth:
+		return "2006/01"
+	case GranularityDay:
+		return "2006/01/02"
+	}
+	return "2006/01/02/15"
+}
+
+// Partitioning is how events are split into directories under an events
+// dir; the zero value is the default layout with hourly partitions
+type Partitioning struct {
+	Layout      Layout
+	Granularity Granularity
+}
+
+// PartitionKey returns the directory, relative to the events dir, that an
+// event is written to
+func (p Partitioning) PartitionKey(accountID, region, eventSource string, eventTime time.Time) string {
+	date := eventTime.Format(p.Granularity.timeLayout())
+	if p.Layout == LayoutEventSource {
+		return fmt.Sprintf("%s/%s/%s%s/%s", accountID, region, EventSourcePrefix, sourceFolder(eventSource), date)
+	}
+	return fmt.Sprintf("%s/%s/%s", accountID, region, date)
+}
+
+// sourceFolder keeps an event source usable as one folder name
+func sourceFolder(eventSource string) string {
+	if eventSource == "" || eventSource == "." || eventSource == ".." {
+		return "unknown"
+	}
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(eventSource)
+}
+
+// PartitionEnd parses the date components at the end of a partition
+// directory (YYYY[/MM[/DD[/HH]]]) and returns when that period closes
+func PartitionEnd(dir string) (time.Time, bool) {
+	_, end, ok := PartitionRange(dir)
+	return end, ok
+}
+
+// PartitionRange parses the date components at the end of a partition
+// directory and returns when that period opens and closes
+func PartitionRange(dir string) (time.Time, time.Time, bool) {
+	parts := strings.Split(filepath.ToSlash(filepath.Clean(dir)), "/")
+
+	var nums []int
+	for i := len(parts) - 1; i >= 0 && len(nums) < 4; i-- {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			break
+		}
+		nums = append([]int{n}, nums...)
+	}
+	if len(nums) == 0 || nums[0] < 1000 {
+		return time.Time{}, time.Time{}, false
+	}
+
+	year := time.Date(nums[0], 1, 1, 0, 0, 0, 0, time.UTC)
+	switch len(nums) {
+	case 1:
+		return year, year.AddDate(1, 0, 0), true
+	case 2:
+		start := year.AddDate(0, nums[1]-1, 0)
+		return start, start.AddDate(0, 1, 0), true
+	case 3:
+		start := year.AddDate(0, nums[1]-1, nums[2]-1)
+		return start, start.AddDate(0, 0, 1), true
+	default:
+		start := year.AddDate(0, nums[1]-1, nums[2]-1).Add(time.Duration(nums[3]) * time.Hour)
+		return start, start.Add(time.Hour), true
+	}
+}
+
+// PartitionDir returns the directory an event is written to
+func (w *JSONLWriter) PartitionDir(accountID, region, eventSource string, eventTime time.Time) string {
+	return filepath.Join(w.eventsDir, w.partitioning.PartitionKey(accountID, region, eventSource, eventTime))
+}
+
+func (w *JSONLWriter) Write(accountID, region, eventSource string, eventTime time.Time, rawEvent json.RawMessage) error {
+	key := w.partitioning.PartitionKey(accountID, region, eventSource, eventTime)
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -62,12 +234,18 @@ func (w *JSONLWriter) flushBufferLocked(key string, buf *eventBuffer) error {
 		return nil
 	}
 
-	counter := w.nextFileCounter[key]
-	w.nextFileCounter[key]++
+	dir := filepath.Join(w.eventsDir, key)
+	counter, seen := w.nextFileCounter[key]
+	if !seen {
+		// continue after the files an earlier run left in the partition, so
+		// late events are appended instead of overwriting them
+		counter = max(nextFileNumber(dir), w.fileFloor[key])
+		w.appending[key] = counter > 0
+	}
+	w.nextFileCounter[key] = counter + 1
 
-	filePath := filepath.Join(w.eventsDir, key, fmt.Sprintf("events_%05d.jsonl", counter))
+	filePath := filepath.Join(dir, fmt.Sprintf("events_%05d%s", counter, w.encoding.FileExtension()))
 
-	dir := filepath.Dir(filePath)
 	if err := os.MkdirAll(dir, 0o755); err != nil {
 		return fmt.Errorf("mkdir: %w", err)
 	}
@@ -78,18 +256,35 @@ func (w *JSONLWriter) flushBufferLocked(key string, buf *eventBuffer) error {
 	}
 	defer func() { _ = f.Close() }()
 
-	writer := bufio.NewWriter(f)
-	for _, event := range buf.events {
-		if _, err := writer.Write(event); err != nil {
-			return fmt.Errorf("write event: %w", err)
-		}
-		if err := writer.WriteByte('\n'); err != nil {
-			return fmt.Errorf("write newline: %w", err)
+	var out io.Writer = f
+	hash := sha256.New()
+	if w.changedManifests != nil {
+		out = io.MultiWriter(f, hash)
+	}
+	if err := encodeFile(out, filePath, w.encoding, buf.events); err != nil {
+		return err
+	}
+	if w.changedManifests != nil {
+		manifest, err := appendManifest(filePath, hash.Sum(nil))
+		if err != nil {
+			return err
 		}
+		w.changedManifests[manifest] = true
 	}
-
-	if err := writer.Flush(); err != nil {
-		return fmt.Errorf("flush: %w", err)
+	if w.onFile != nil || w.onAppend != nil {
+		info, err := f.Stat()
+		if err != nil {
+			return fmt.Errorf("stat file: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("close file: %w", err)
+		}
+		if w.onFile != nil {
+			w.onFile(w.eventsDir, filePath, info.Size())
+		}
+		if w.onAppend != nil && w.appending[key] {
+			w.onAppend(w.eventsDir, dir)
+		}
 	}
 
 	w.logger.Debug("flushed buffer",
@@ -101,6 +296,84 @@ func (w *JSONLWriter) flushBufferLocked(key string, buf *eventBuffer) error {
 	return nil
 }
 
+// OnFile registers fn to be called with every file written from now on,
+// once it is complete
+func (w *JSONLWriter) OnFile(fn func(eventsDir, path string, size int64)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onFile = fn
+}
+
+// OnAppend registers fn to be called with a partition dir each time a file
+// is added to a partition that already held event files when this writer
+// first wrote to it
+func (w *JSONLWriter) OnAppend(fn func(eventsDir, dir string)) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.onAppend = fn
+}
+
+// FloorFileNumbers makes the writer number new files in each partition, keyed
+// by its path under the events dir, from at least the given number, so files
+// that went with another host or to S3 aren't named again
+func (w *JSONLWriter) FloorFileNumbers(floors map[string]int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fileFloor = floors
+}
+
+// NextFileNumbers returns, for every partition under eventsDir holding
+// events_NNNNN files, the number after its highest one
+func NextFileNumbers(eventsDir string) (map[string]int, error) {
+	next := make(map[string]int)
+	err := filepath.WalkDir(eventsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return err
+		}
+		if n := nextFileNumber(path); n > 0 {
+			rel, err := filepath.Rel(eventsDir, path)
+			if err != nil {
+				return err
+			}
+			next[rel] = n
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return next, nil
+	}
+	return next, err
+}
+
+// nextFileNumber returns the number after the highest events_NNNNN file in
+// dir, 0 when there are none
+func nextFileNumber(dir string) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0
+	}
+	next := 0
+	for _, e := range entries {
+		if n, ok := FileNumber(e.Name()); ok && !e.IsDir() && n >= next {
+			next = n + 1
+		}
+	}
+	return next
+}
+
+// FileNumber returns the number of an events_NNNNN file name
+func FileNumber(name string) (int, bool) {
+	digits, ok := strings.CutPrefix(name, "events_")
+	if !ok {
+		return 0, false
+	}
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		digits = digits[:i]
+	}
+	n, err := strconv.Atoi(digits)
+	return n, err == nil
+}
+
 func (w *JSONLWriter) FlushAll() error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
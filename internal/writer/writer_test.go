@@ -0,0 +1,102 @@
+package writer
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPartitionRange(t *testing.T) {
+	date := func(s string) time.Time {
+		tm, err := time.Parse("2006-01-02 15", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+
+	tests := []struct {
+		dir        string
+		start, end time.Time
+		ok         bool
+	}{
+		{"123456789012/us-east-1/2024", date("2024-01-01 00"), date("2025-01-01 00"), true},
+		{"123456789012/us-east-1/2024/02", date("2024-02-01 00"), date("2024-03-01 00"), true},
+		{"123456789012/us-east-1/2024/12", date("2024-12-01 00"), date("2025-01-01 00"), true},
+		{"123456789012/us-east-1/2024/02/29", date("2024-02-29 00"), date("2024-03-01 00"), true},
+		{"123456789012/us-east-1/2024/12/31/23", date("2024-12-31 23"), date("2025-01-01 00"), true},
+		// partitions are UTC, so there's no DST gap on 2024-03-10
+		{"123456789012/us-east-1/2024/03/10/02", date("2024-03-10 02"), date("2024-03-10 03"), true},
+		{"123456789012/us-east-1/eventsource=s3/2024/01/15/07", date("2024-01-15 07"), date("2024-01-15 08"), true},
+		{"events/123456789012/us-east-1/2024/01/15/", date("2024-01-15 00"), date("2024-01-16 00"), true},
+		{"123456789012/us-east-1", time.Time{}, time.Time{}, false},
+		{"123456789012/us-east-1/eventsource=s3", time.Time{}, time.Time{}, false},
+		{"123456789012/us-east-1/12", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		start, end, ok := PartitionRange(tt.dir)
+		if ok != tt.ok || !start.Equal(tt.start) || !end.Equal(tt.end) {
+			t.Errorf("PartitionRange(%q) = %v, %v, %v, want %v, %v, %v", tt.dir, start, end, ok, tt.start, tt.end, tt.ok)
+		}
+	}
+}
+
+func TestWriteSourceOverwrites(t *testing.T) {
+	dir := t.TempDir()
+	w := New(dir, 1000, Encoding{Format: FormatJSONL}, Partitioning{Layout: LayoutDefault, Granularity: GranularityHour}, slog.New(slog.DiscardHandler))
+	w.EnableChecksums()
+
+	first := time.Date(2024, 1, 15, 7, 10, 0, 0, time.UTC)
+	second := first.Add(time.Hour)
+	event := func(id string, tm time.Time) SourceEvent {
+		return SourceEvent{AccountID: "123456789012", Region: "us-east-1", Time: tm, Raw: json.RawMessage(`{"eventID":"` + id + `"}`)}
+	}
+	const source = "bucket/AWSLogs/123456789012/CloudTrail/us-east-1/2024/01/15/log.json.gz"
+	if err := w.WriteSource(source, []SourceEvent{event("a", first), event("b", first), event("c", second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteSource(source, []SourceEvent{event("d", first), event("e", second)}); err != nil {
+		t.Fatal(err)
+	}
+
+	name := SourceFileName(source, Encoding{Format: FormatJSONL})
+	want := map[string]string{
+		"123456789012/us-east-1/2024/01/15/07": `{"eventID":"d"}` + "\n",
+		"123456789012/us-east-1/2024/01/15/08": `{"eventID":"e"}` + "\n",
+	}
+	for key, contents := range want {
+		partition := filepath.Join(dir, key)
+		entries, err := os.ReadDir(partition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if len(names) != 2 || names[0] != ManifestName || names[1] != name {
+			t.Errorf("%s holds %v, want [%s %s]", key, names, ManifestName, name)
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(partition, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("%s/%s = %q, want %q", key, name, data, contents)
+		}
+		manifest, err := os.ReadFile(filepath.Join(partition, ManifestName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line := fmt.Sprintf("%x  %s\n", sha256.Sum256(data), name); string(manifest) != line {
+			t.Errorf("%s manifest = %q, want %q", key, manifest, line)
+		}
+	}
+}
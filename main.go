@@ -1,184 +1,376 @@
 package main
 
 import (
+	"cmp"
 	"context"
-	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/cloudtrail"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 
-	"github.com/deceptiq/gocloudtrail/internal/bloom"
 	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
-	"github.com/deceptiq/gocloudtrail/internal/processor"
-	"github.com/deceptiq/gocloudtrail/internal/state"
+	"github.com/deceptiq/gocloudtrail/internal/crypt"
 )
 
-func main() {
-	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
-	slog.SetDefault(logger)
+// app holds the global flags and shared state for every subcommand
+type app struct {
+	configPath string
+	logLevel   string
+	profile    string
+	region     string
+	// shared config and credentials files replacing the SDK's defaults
+	configFiles      []string
+	credentialsFiles []string
+	// where logs go, os.Stdout when nil
+	logOutput io.Writer
+	logger    *slog.Logger
+}
 
-	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+func main() {
+	a := &app{
+		logger: slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelInfo})),
 	}
+	slog.SetDefault(a.logger)
 
-	switch os.Args[1] {
-	case "generate-config":
-		runGenerateConfig(logger)
-	case "run":
-		runProcessor(logger)
-	default:
-		printUsage()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	root := newRootCmd(a)
+	root.SetArgs(normalizeArgs(root, os.Args[1:]))
+	if err := root.ExecuteContext(ctx); err != nil {
+		a.logger.Error("command failed", slog.String("error", err.Error()))
+		stop()
 		os.Exit(1)
 	}
 }
 
-func printUsage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "Commands:\n")
-	fmt.Fprintf(os.Stderr, "  generate-config <output-path>  Generate config.json from CloudTrail API\n")
-	fmt.Fprintf(os.Stderr, "  run -config <path>             Run the CloudTrail processor\n")
+func newRootCmd(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:           "gocloudtrail",
+		Short:         "Sync AWS CloudTrail logs from S3 to local files",
+		SilenceErrors: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			// flags parsed fine, so later failures shouldn't print usage
+			cmd.SilenceUsage = true
+			return a.setupLogger()
+		},
+	}
+
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.configPath, "config", "", "Path to config.json")
+	flags.StringVar(&a.logLevel, "log-level", "info", "Log level: debug, info, warn or error")
+	flags.StringVar(&a.profile, "profile", "", "AWS shared config profile (overrides aws_profile and environment)")
+	flags.StringVar(&a.region, "region", "", "AWS region (overrides aws_region and environment)")
+	flags.StringSliceVar(&a.configFiles, "aws-config-file", nil, "AWS shared config file, repeatable (overrides aws_config_files and environment)")
+	flags.StringSliceVar(&a.credentialsFiles, "aws-credentials-file", nil, "AWS shared credentials file, repeatable (overrides aws_credentials_files and environment)")
+
+	root.AddCommand(
+		newGenerateConfigCmd(a),
+		newGenerateTestdataCmd(a),
+		newRunCmd(a),
+		newConvertCmd(a),
+		newImportCmd(a),
+		newDedupeCmd(a),
+		newDedupServerCmd(a),
+		newSubscribeCmd(a),
+		newVerifyOutputCmd(a),
+		newCheckCompletenessCmd(a),
+		newStatsCmd(a),
+		newAuditCmd(a),
+		newReportCmd(a),
+		newReplayCmd(a),
+		newRedriveCmd(a),
+		newBackfillCmd(a),
+		newStateCmd(a),
+		newBenchmarkCmd(a),
+		newPruneCmd(a),
+		newVersionCmd(),
+	)
+
+	return root
 }
 
-func runGenerateConfig(logger *slog.Logger) {
-	if len(os.Args) < 3 {
-		fmt.Fprintf(os.Stderr, "Usage: %s generate-config <output-path>\n", os.Args[0])
-		os.Exit(1)
+func (a *app) setupLogger() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(a.logLevel)); err != nil {
+		return fmt.Errorf("invalid --log-level %q", a.logLevel)
 	}
-	if err := appConfig.Generate(os.Args[2], logger); err != nil {
-		logger.Error("failed to generate config", slog.String("error", err.Error()))
-		os.Exit(1)
+	out := a.logOutput
+	if out == nil {
+		out = os.Stdout
 	}
+	a.logger = slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: level}))
+	slog.SetDefault(a.logger)
+	return nil
 }
 
-func runProcessor(logger *slog.Logger) {
-	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
-	configPath := runCmd.String("config", "", "Path to config.json (required)")
-	runCmd.Parse(os.Args[2:])
-
-	if *configPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: -config flag is required\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s run -config <path>\n", os.Args[0])
-		os.Exit(1)
+// loadConfig reads the file given by --config, which the caller requires
+func (a *app) loadConfig() (*appConfig.Config, error) {
+	if a.configPath == "" {
+		return nil, fmt.Errorf("--config is required")
 	}
 
-	appCfg, err := appConfig.Load(*configPath)
+	appCfg, err := appConfig.Load(a.configPath)
 	if err != nil {
-		logger.Error("failed to load config file", slog.String("error", err.Error()))
-		os.Exit(1)
+		return nil, fmt.Errorf("load config file: %w", err)
 	}
-	logger.Info("loaded config from file", slog.String("path", *configPath))
-
-	ctx := context.Background()
-	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
-	defer stop()
+	a.logger.Info("loaded config from file", slog.String("path", a.configPath))
+	return appCfg, nil
+}
 
-	httpClient := createHTTPClient(appCfg)
-	cfg, err := config.LoadDefaultConfig(ctx, config.WithHTTPClient(httpClient))
-	if err != nil {
-		logger.Error("failed to load AWS config", slog.String("error", err.Error()))
-		os.Exit(1)
+// outputCipher builds the cipher that encrypts output files, and reads
+// encrypted ones, from the config's encryption settings; nil when output
+// isn't encrypted
+func (a *app) outputCipher(appCfg *appConfig.Config) (crypt.Cipher, error) {
+	enc := appCfg.Encryption
+	var c crypt.Cipher
+	switch enc.Mode {
+	case "":
+		return nil, nil
+	case "age":
+		age, err := crypt.NewAge(enc.Recipients, enc.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		c = age
+	case "aes-gcm":
+		switch {
+		case enc.KeyFile != "" && enc.KMSKeyID != "":
+			return nil, fmt.Errorf("encryption: set key_file or kms_key_id, not both")
+		case enc.KeyFile != "":
+			gcm, err := crypt.NewGCMKeyFile(enc.KeyFile)
+			if err != nil {
+				return nil, err
+			}
+			c = gcm
+		case enc.KMSKeyID != "":
+			cfg, err := a.awsConfig(context.Background(), appCfg)
+			if err != nil {
+				return nil, err
+			}
+			c = crypt.NewGCMKMS(kms.NewFromConfig(cfg), enc.KMSKeyID)
+		default:
+			return nil, fmt.Errorf("encryption: aes-gcm needs key_file or kms_key_id")
+		}
+	default:
+		return nil, fmt.Errorf("encryption: unknown mode %q (want age or aes-gcm)", enc.Mode)
 	}
 
-	stsClient := sts.NewFromConfig(cfg)
-	identity, err := stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
-	if err != nil {
-		logger.Error("failed to get caller identity", slog.String("error", err.Error()))
-		os.Exit(1)
-	}
-	logger.Info("authenticated with AWS", slog.String("account", aws.ToString(identity.Account)))
+	a.logger.Info("output encryption enabled", slog.String("mode", enc.Mode))
+	return c, nil
+}
 
-	if err := os.MkdirAll(appCfg.EventsDir, 0o755); err != nil {
-		logger.Error("failed to create events directory", slog.String("error", err.Error()))
-		os.Exit(1)
+// awsConfig loads the AWS SDK config, applying the global overrides and, when
+// appCfg is set, its HTTP client tuning
+func (a *app) awsConfig(ctx context.Context, appCfg *appConfig.Config) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if appCfg != nil {
+		opts = append(opts, config.WithHTTPClient(createHTTPClient(appCfg)))
+		retryer, err := newRetryer(appCfg.AWSRetry)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		if retryer != nil {
+			opts = append(opts, config.WithRetryer(retryer))
+		}
+		credentialOpts, err := credentialOptions(appCfg.AWSCredentials)
+		if err != nil {
+			return aws.Config{}, err
+		}
+		opts = append(opts, credentialOpts...)
 	}
 
-	numCPU := runtime.NumCPU()
-	processConcurrency := numCPU * 2
-	if appCfg.ProcessWorkers > 0 {
-		processConcurrency = appCfg.ProcessWorkers
+	// flags, then the config file, then the environment
+	profile, region := a.profile, a.region
+	configFiles, credentialsFiles := a.configFiles, a.credentialsFiles
+	if appCfg != nil {
+		profile = cmp.Or(profile, appCfg.AWSProfile)
+		region = cmp.Or(region, appCfg.AWSRegion)
+		if len(configFiles) == 0 {
+			configFiles = appCfg.AWSConfigFiles
+		}
+		if len(credentialsFiles) == 0 {
+			credentialsFiles = appCfg.AWSCredentialsFiles
+		}
+	}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	if region != "" {
+		opts = append(opts, config.WithRegion(region))
+	}
+	if len(configFiles) > 0 {
+		opts = append(opts, config.WithSharedConfigFiles(configFiles))
+	}
+	if len(credentialsFiles) > 0 {
+		opts = append(opts, config.WithSharedCredentialsFiles(credentialsFiles))
 	}
 
-	logger.Info("system configuration",
-		slog.Int("cpu_cores", numCPU),
-		slog.Int("download_workers", appCfg.DownloadWorkers),
-		slog.Int("process_workers", processConcurrency))
-
-	stateDB, err := state.Open(appCfg.StateDB, logger)
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
 	if err != nil {
-		logger.Error("failed to open state database", slog.String("error", err.Error()))
-		os.Exit(1)
+		return aws.Config{}, fmt.Errorf("load AWS config: %w", err)
 	}
+	return cfg, nil
+}
 
-	bloomFilter, err := bloom.Load(appCfg.BloomFile, uint(appCfg.BloomExpectedItems), appCfg.BloomFalsePositive, logger)
+// newRetryer returns the retryer for the configured retry settings, or nil
+// to keep the SDK's
+func newRetryer(r appConfig.AWSRetry) (func() aws.Retryer, error) {
+	mode, err := aws.ParseRetryMode(cmp.Or(r.Mode, string(aws.RetryModeStandard)))
 	if err != nil {
-		logger.Error("failed to load bloom filter", slog.String("error", err.Error()))
-		os.Exit(1)
+		return nil, fmt.Errorf("aws_retry: %w", err)
+	}
+	if r.MaxAttempts < 0 || r.MaxBackoff < 0 || r.GetObjectMaxAttempts < 0 || r.ListObjectsMaxAttempts < 0 {
+		return nil, fmt.Errorf("aws_retry: attempts and backoff can't be negative")
+	}
+	if r.Mode == "" && r.MaxAttempts == 0 && r.MaxBackoff == 0 {
+		return nil, nil
 	}
 
-	proc := processor.New(
-		s3.NewFromConfig(cfg),
-		cloudtrail.NewFromConfig(cfg),
-		stateDB,
-		bloomFilter,
-		processor.Config{
-			DownloadWorkers:   appCfg.DownloadWorkers,
-			ProcessWorkers:    processConcurrency,
-			DownloadQueueSize: appCfg.DownloadQueueSize,
-			ProcessQueueSize:  appCfg.ProcessQueueSize,
-			ListBatchSize:     appCfg.ListBatchSize,
-			EventsPerFile:     appCfg.EventsPerFile,
-			EventsDir:         appCfg.EventsDir,
-			Trails:            appCfg.Trails,
-		},
-		logger,
-	)
+	standard := func(o *retry.StandardOptions) {
+		if r.MaxAttempts > 0 {
+			o.MaxAttempts = r.MaxAttempts
+		}
+		if r.MaxBackoff > 0 {
+			o.MaxBackoff = time.Duration(r.MaxBackoff) * time.Second
+		}
+	}
+	return func() aws.Retryer {
+		if mode == aws.RetryModeAdaptive {
+			return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, standard)
+			})
+		}
+		return retry.NewStandard(standard)
+	}, nil
+}
 
-	progressInterval := time.Duration(appCfg.ProgressInterval) * time.Second
-	jsonlFlushInterval := time.Duration(appCfg.JSONLFlushInterval) * time.Second
-	stateSaveInterval := time.Duration(appCfg.StateSaveInterval) * time.Second
+// credentialOptions sets how long assumed-role sessions last and how early
+// cached credentials are renewed
+func credentialOptions(c appConfig.AWSCredentials) ([]func(*config.LoadOptions) error, error) {
+	if c.SessionDuration != 0 && (c.SessionDuration < 900 || c.SessionDuration > 43200) {
+		return nil, fmt.Errorf("aws_credentials: session_duration must be between 900 and 43200 seconds")
+	}
+	if c.RefreshBefore < 0 {
+		return nil, fmt.Errorf("aws_credentials: refresh_before can't be negative")
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithCredentialsCacheOptions(func(o *aws.CredentialsCacheOptions) {
+			o.ExpiryWindow = time.Duration(c.RefreshBefore) * time.Second
+		}),
+	}
+	if c.SessionDuration > 0 {
+		duration := time.Duration(c.SessionDuration) * time.Second
+		opts = append(opts,
+			config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+				o.Duration = duration
+			}),
+			config.WithWebIdentityRoleCredentialOptions(func(o *stscreds.WebIdentityRoleOptions) {
+				o.Duration = duration
+			}))
+	}
+	return opts, nil
+}
 
-	if err := proc.Run(ctx, progressInterval, jsonlFlushInterval, stateSaveInterval); err != nil {
-		if err == context.Canceled {
-			logger.Info("received interrupt signal, shutting down gracefully")
-		} else {
-			logger.Error("processing failed", slog.String("error", err.Error()))
-			os.Exit(1)
+// normalizeArgs rewrites Go-style single-dash long flags (-config) to the
+// double-dash form so existing scripts keep working. Only names registered
+// as long flags of the command being run are rewritten, and a flag's value
+// is passed through as is, however it looks.
+func normalizeArgs(root *cobra.Command, args []string) []string {
+	cmd := root
+	out := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return append(out, args[i:]...)
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			// positional; the leading ones pick the subcommand
+			if sub := subcommand(cmd, arg); sub != nil {
+				cmd = sub
+			}
+			out = append(out, arg)
+			continue
+		}
+
+		name, _, inline := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		var found, takesValue bool
+		switch {
+		case strings.HasPrefix(arg, "--"):
+			found, takesValue = lookupFlag(cmd, name, false)
+		case len(name) > 1:
+			if found, takesValue = lookupFlag(cmd, name, false); found {
+				arg = "-" + arg
+			}
+		default:
+			found, takesValue = lookupFlag(cmd, name, true)
+		}
+		out = append(out, arg)
+		if found && takesValue && !inline && i+1 < len(args) {
+			i++
+			out = append(out, args[i])
 		}
 	}
+	return out
+}
 
-	proc.Stats().PrintProgress(logger)
-	logger.Info("processing complete")
+// subcommand returns cmd's subcommand called name, nil when there's none
+func subcommand(cmd *cobra.Command, name string) *cobra.Command {
+	for _, sub := range cmd.Commands() {
+		if sub.Name() == name || sub.HasAlias(name) {
+			return sub
+		}
+	}
+	return nil
 }
 
-func createHTTPClient(cfg *appConfig.Config) *http.Client {
-	return &http.Client{
-		Transport: &http.Transport{
-			MaxIdleConns:        cfg.MaxIdleConns,
-			MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
-			MaxConnsPerHost:     cfg.MaxConnsPerHost,
-			IdleConnTimeout:     time.Duration(cfg.IdleConnTimeout) * time.Second,
-			DisableCompression:  true,
-			ForceAttemptHTTP2:   true,
-			DialContext: (&net.Dialer{
-				Timeout:   time.Duration(cfg.DialTimeout) * time.Second,
-				KeepAlive: time.Duration(cfg.KeepAlive) * time.Second,
-			}).DialContext,
-		},
-		Timeout: time.Duration(cfg.ClientTimeout) * time.Second,
+// lookupFlag reports whether name is a flag of cmd, its own or inherited,
+// by its long name or its one-letter shorthand, and whether it takes a
+// value
+func lookupFlag(cmd *cobra.Command, name string, shorthand bool) (found, takesValue bool) {
+	for _, flags := range []*pflag.FlagSet{cmd.Flags(), cmd.InheritedFlags()} {
+		f := flags.Lookup(name)
+		if shorthand {
+			f = flags.ShorthandLookup(name)
+		}
+		if f != nil {
+			return true, f.NoOptDefVal == ""
+		}
 	}
+	return false, false
+}
+
+// createHTTPClient returns the SDK's buildable client with the pool and
+// timeout settings, which the SDK can still add a custom CA bundle to
+func createHTTPClient(cfg *appConfig.Config) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithTransportOptions(func(t *http.Transport) {
+			t.MaxIdleConns = cfg.MaxIdleConns
+			t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+			t.MaxConnsPerHost = cfg.MaxConnsPerHost
+			t.IdleConnTimeout = time.Duration(cfg.IdleConnTimeout) * time.Second
+			t.DisableCompression = true
+			t.ForceAttemptHTTP2 = true
+		}).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = time.Duration(cfg.DialTimeout) * time.Second
+			d.KeepAlive = time.Duration(cfg.KeepAlive) * time.Second
+		}).
+		WithTimeout(time.Duration(cfg.ClientTimeout) * time.Second)
 }
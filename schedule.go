@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	appConfig "github.com/deceptiq/gocloudtrail/internal/config"
+	"github.com/deceptiq/gocloudtrail/internal/cron"
+	"github.com/deceptiq/gocloudtrail/internal/systemd"
+)
+
+// scheduledPass is a schedule pass, or a trail's own schedule, with its next
+// firing
+type scheduledPass struct {
+	appConfig.SchedulePass
+	cron *cron.Schedule
+	loc  *time.Location
+	next time.Time
+}
+
+// schedulePasses parses the schedule's passes and the trails' own schedules,
+// nil when nothing is scheduled
+func schedulePasses(appCfg *appConfig.Config) ([]*scheduledPass, error) {
+	passes := slices.Clone(appCfg.Schedule.Passes)
+	ownSchedule := make(map[string]bool)
+	for _, t := range appCfg.Trails {
+		if t.Schedule == "" {
+			continue
+		}
+		ownSchedule[t.Name] = true
+		passes = append(passes, appConfig.SchedulePass{
+			Name:   "trail " + t.Name,
+			Cron:   t.Schedule,
+			Trails: []string{t.Name},
+		})
+	}
+	if len(passes) == 0 {
+		return nil, nil
+	}
+
+	loc := time.Local
+	if appCfg.Schedule.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(appCfg.Schedule.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid schedule.timezone: %w", err)
+		}
+	}
+
+	trails := make(map[string]bool, len(appCfg.Trails))
+	for _, t := range appCfg.Trails {
+		trails[t.Name] = true
+	}
+	now := time.Now().In(loc)
+	scheduled := make([]*scheduledPass, 0, len(passes))
+	for _, pass := range passes {
+		if pass.Name == "" {
+			pass.Name = pass.Cron
+		}
+		sched, err := cron.Parse(pass.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule pass %q: %w", pass.Name, err)
+		}
+		next := sched.Next(now)
+		if next.IsZero() {
+			return nil, fmt.Errorf("schedule pass %q: cron %q never fires", pass.Name, pass.Cron)
+		}
+		for _, name := range pass.Trails {
+			if !trails[name] {
+				return nil, fmt.Errorf("schedule pass %q: no trail named %q in trails", pass.Name, name)
+			}
+		}
+		if len(pass.Trails) == 0 && len(appCfg.Trails) > 0 && len(ownSchedule) == len(trails) && len(appCfg.LogGroups) == 0 {
+			return nil, fmt.Errorf("schedule pass %q has nothing to run: every trail has its own schedule", pass.Name)
+		}
+		scheduled = append(scheduled, &scheduledPass{SchedulePass: pass, cron: sched, loc: loc, next: next})
+	}
+	return scheduled, nil
+}
+
+// passConfig narrows the config to what the pass covers
+func passConfig(appCfg *appConfig.Config, pass appConfig.SchedulePass) *appConfig.Config {
+	cfg := *appCfg
+	cfg.Trails = nil
+	for _, t := range appCfg.Trails {
+		if len(pass.Trails) > 0 && slices.Contains(pass.Trails, t.Name) ||
+			len(pass.Trails) == 0 && t.Schedule == "" {
+			cfg.Trails = append(cfg.Trails, t)
+		}
+	}
+	if len(pass.Trails) > 0 {
+		cfg.LogGroups = nil
+	}
+	if pass.LateDeliveries != "" {
+		cfg.LateDeliveries = pass.LateDeliveries
+	}
+	if pass.LateDeliveryDays > 0 {
+		cfg.LateDeliveryDays = pass.LateDeliveryDays
+	}
+	return &cfg
+}
+
+// runSchedule runs passes as they come due until ctx is done. Passes run one
+// at a time; one that comes due while another runs starts after it, once,
+// however many times it came due meanwhile. A failed pass is logged and the
+// schedule carries on.
+func (a *app) runSchedule(ctx context.Context, appCfg *appConfig.Config, opts runOptions, passes []*scheduledPass) error {
+	notifier := systemd.FromEnv()
+	idleTick := time.Minute
+	if notifier != nil && notifier.WatchdogInterval > 0 {
+		idleTick = min(idleTick, notifier.WatchdogInterval/2)
+	}
+	notifyService := func(err error) {
+		if err != nil {
+			a.logger.Warn("failed to notify systemd", slog.String("error", err.Error()))
+		}
+	}
+	notifyService(notifier.Ready("waiting for the next scheduled pass"))
+
+	for _, pass := range passes {
+		a.logger.Info("scheduled pass",
+			slog.String("pass", pass.Name),
+			slog.String("cron", pass.Cron),
+			slog.Time("next", pass.next))
+	}
+
+	var due []*scheduledPass
+	for {
+		now := time.Now()
+		for _, pass := range passes {
+			if pass.next.After(now) {
+				continue
+			}
+			if !slices.Contains(due, pass) {
+				due = append(due, pass)
+			}
+			pass.next = pass.cron.Next(now.In(pass.loc))
+		}
+
+		if len(due) > 0 {
+			pass := due[0]
+			due = due[1:]
+			a.logger.Info("starting scheduled pass",
+				slog.String("pass", pass.Name),
+				slog.Any("trails", pass.Trails))
+			err := a.runPass(ctx, passConfig(appCfg, pass.SchedulePass), opts)
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err != nil {
+				a.logger.Error("scheduled pass failed",
+					slog.String("pass", pass.Name),
+					slog.String("error", err.Error()))
+			}
+			continue
+		}
+
+		next := passes[0]
+		for _, pass := range passes[1:] {
+			if pass.next.Before(next.next) {
+				next = pass
+			}
+		}
+		status := fmt.Sprintf("idle, next pass %q at %s", next.Name, next.next.Format(time.RFC3339))
+		if notifier != nil && notifier.WatchdogInterval > 0 {
+			notifyService(notifier.Watchdog(status))
+		} else {
+			notifyService(notifier.Status(status))
+		}
+
+		timer := time.NewTimer(min(time.Until(next.next), idleTick))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			notifyService(notifier.Stopping("stopping"))
+			a.logger.Info("received interrupt signal, stopping the schedule")
+			return nil
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import (
+	"context"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+// handleControlSignals does nothing where SIGUSR1 and SIGUSR2 don't exist
+func (a *app) handleControlSignals(ctx context.Context, proc *processor.Processor) {}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/deceptiq/gocloudtrail/internal/processor"
+)
+
+// handleControlSignals toggles pause on SIGUSR1 and logs a full stats
+// snapshot on SIGUSR2, for hosts where the admin API port isn't allowed
+func (a *app) handleControlSignals(ctx context.Context, proc *processor.Processor) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		defer signal.Stop(signals)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signals:
+				if sig == syscall.SIGUSR2 {
+					a.logStatus(proc)
+					continue
+				}
+				if proc.Control().Paused {
+					proc.Resume()
+					a.logger.Info("resumed on SIGUSR1")
+				} else {
+					proc.Pause()
+					a.logger.Info("paused on SIGUSR1, send it again to resume")
+				}
+			}
+		}
+	}()
+}
+
+func (a *app) logStatus(proc *processor.Processor) {
+	a.logger.Info("status snapshot",
+		slog.Any("control", proc.Control()),
+		slog.Any("stats", proc.Stats().Snapshot()),
+		slog.Any("trails", proc.Trails()))
+}